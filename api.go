@@ -6,22 +6,40 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"math"
 	"os"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/xirelogy/go-flux/internal/ast"
 	_ "github.com/xirelogy/go-flux/internal/builtins"
+	"github.com/xirelogy/go-flux/internal/bytecode"
 	"github.com/xirelogy/go-flux/internal/compiler"
+	"github.com/xirelogy/go-flux/internal/format"
+	"github.com/xirelogy/go-flux/internal/inspect"
 	"github.com/xirelogy/go-flux/internal/lexer"
+	"github.com/xirelogy/go-flux/internal/lint"
 	"github.com/xirelogy/go-flux/internal/parser"
+	"github.com/xirelogy/go-flux/internal/runtime"
 	"github.com/xirelogy/go-flux/internal/vm"
 )
 
 var (
-	errorType = reflect.TypeOf((*error)(nil)).Elem()
+	errorType     = reflect.TypeOf((*error)(nil)).Elem()
+	marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
 )
 
+// ErrVMBusy is returned (possibly wrapped) when an operation needs the VM while a call is running.
+var ErrVMBusy = errors.New("VM is busy")
+
+// ErrInterrupted is the Cause of the RuntimeError returned by a call stopped with Interrupt.
+var ErrInterrupted = vm.ErrInterrupted
+
 // VmValue is a marshaled value that is compatible with go-flux types.
 // It wraps the internal vm.Value representation.
 type VmValue struct {
@@ -34,14 +52,23 @@ type ArgError struct {
 	Name string
 	Want string
 	Got  string
+	Path string // location of the mismatch inside a nested value (e.g. "User.Roles[2]"); empty at the top level
 }
 
 func (e ArgError) Error() string {
+	loc := ""
+	if e.Path != "" {
+		loc = " at " + e.Path
+	}
 	switch {
 	case e.Name != "" && e.Want != "" && e.Got != "":
-		return fmt.Sprintf("argument %q: want %s, got %s", e.Name, e.Want, e.Got)
+		return fmt.Sprintf("argument %q%s: want %s, got %s", e.Name, loc, e.Want, e.Got)
 	case e.Name != "" && e.Want != "":
-		return fmt.Sprintf("argument %q: want %s", e.Name, e.Want)
+		return fmt.Sprintf("argument %q%s: want %s", e.Name, loc, e.Want)
+	case e.Path != "" && e.Want != "" && e.Got != "":
+		return fmt.Sprintf("at %s: want %s, got %s", e.Path, e.Want, e.Got)
+	case e.Want != "" && e.Got != "":
+		return fmt.Sprintf("want %s, got %s", e.Want, e.Got)
 	default:
 		return "argument error"
 	}
@@ -57,9 +84,14 @@ type Unmarshaler interface {
 	UnmarshalFlux(VmValue) error
 }
 
+// DefaultMarshalMaxDepth is the container nesting limit used when MarshalOptions.MaxDepth is zero.
+const DefaultMarshalMaxDepth = 1000
+
 // MarshalOptions tunes Go→flux marshaling behavior.
 type MarshalOptions struct {
-	ReadOnly bool // mark array/object containers as read-only inside the VM
+	ReadOnly         bool // mark array/object containers as read-only inside the VM
+	MaxDepth         int  // maximum array/object nesting (0 selects DefaultMarshalMaxDepth)
+	StringerAsString bool // marshal fmt.Stringer values (e.g. time.Duration) as their String() form
 }
 
 // ValueKind mirrors the flux runtime kinds for convenient inspection.
@@ -119,18 +151,48 @@ func (e *RuntimeError) Unwrap() error {
 	return e.Cause
 }
 
-// TraceInfo captures execution steps for debug hooks.
-type TraceInfo struct {
-	Op       byte
-	Function string
+// Severity classifies a diagnostic.
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	// SeverityWarning marks advisory findings (such as unreachable code) that do not stop a load.
+	SeverityWarning
+)
+
+// Diagnostic describes a parse or compile problem found in source text.
+type Diagnostic struct {
 	Source   string
 	Line     int
-	IP       int
+	Column   int
+	Message  string
+	Severity Severity
+	Code     string // stable identifier for the kind of problem, e.g. "syntax" or "unreachable-code"
+}
+
+func (d Diagnostic) String() string {
+	if d.Column > 0 {
+		return fmt.Sprintf("%s:%d:%d: %s", d.Source, d.Line, d.Column, d.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s", d.Source, d.Line, d.Message)
+}
+
+// TraceInfo captures execution steps for debug hooks.
+type TraceInfo struct {
+	Op         byte
+	Function   string
+	Source     string
+	Line       int
+	IP         int
+	StackDepth int // operand stack size before the instruction runs
 }
 
 // TraceHook observes instruction dispatch for debugging/profiling.
 type TraceHook func(TraceInfo)
 
+// ValueTraceHook observes instruction dispatch plus the current frame's top-of-stack value (null when empty).
+type ValueTraceHook func(info TraceInfo, stackTop VmValue)
+
 func convertRuntimeError(err error) error {
 	if err == nil {
 		return nil
@@ -168,14 +230,45 @@ func stackTraceFromVM(stack []vm.FrameInfo) []FrameTrace {
 
 // HostArgs provides typed accessors for host function arguments.
 type HostArgs struct {
-	args map[string]VmValue
+	args    map[string]VmValue
+	ordered []VmValue
+	rest    []VmValue
 }
 
 // NewHostArgs wraps the raw argument map for typed access.
+// A bare map carries no ordering, so positional access falls back to the auto-generated
+// names arg0, arg1, ...; use Context.Args to get arguments in call order.
 func NewHostArgs(args map[string]VmValue) HostArgs {
 	return HostArgs{args: args}
 }
 
+// Count returns the number of arguments available for positional access: every argument of the
+// call, including those passed beyond the declared parameters.
+func (a HostArgs) Count() int {
+	if a.ordered != nil {
+		return len(a.ordered)
+	}
+	return len(a.args)
+}
+
+// Rest returns the trailing arguments passed beyond the declared parameters, in call order.
+func (a HostArgs) Rest() []VmValue {
+	return a.rest
+}
+
+// Index returns the argument at position i in call order; positions past the declared
+// parameters reach the extra arguments also returned by Rest.
+func (a HostArgs) Index(i int) (VmValue, bool) {
+	if a.ordered != nil {
+		if i < 0 || i >= len(a.ordered) {
+			return VmValue{}, false
+		}
+		return a.ordered[i], true
+	}
+	v, ok := a.args[fmt.Sprintf("arg%d", i)]
+	return v, ok
+}
+
 // Value returns the raw VmValue for a named argument.
 func (a HostArgs) Value(name string) (VmValue, error) {
 	v, ok := a.args[name]
@@ -245,6 +338,55 @@ func (a HostArgs) Object(name string) (map[string]VmValue, error) {
 	return nil, ArgError{Name: name, Want: "object", Got: kindName(v.Kind())}
 }
 
+// optional returns the named argument and whether it carries a value (present and not null).
+func (a HostArgs) optional(name string) (VmValue, bool) {
+	v, ok := a.args[name]
+	if !ok || v.Kind() == ValueNull {
+		return VmValue{}, false
+	}
+	return v, true
+}
+
+// NumberOr returns the numeric argument, or def when it is absent or null. A present value of another kind is an error.
+func (a HostArgs) NumberOr(name string, def float64) (float64, error) {
+	if _, ok := a.optional(name); !ok {
+		return def, nil
+	}
+	return a.Number(name)
+}
+
+// StringOr returns the string argument, or def when it is absent or null. A present value of another kind is an error.
+func (a HostArgs) StringOr(name string, def string) (string, error) {
+	if _, ok := a.optional(name); !ok {
+		return def, nil
+	}
+	return a.String(name)
+}
+
+// BoolOr returns the boolean argument, or def when it is absent or null. A present value of another kind is an error.
+func (a HostArgs) BoolOr(name string, def bool) (bool, error) {
+	if _, ok := a.optional(name); !ok {
+		return def, nil
+	}
+	return a.Bool(name)
+}
+
+// ArrayOr returns the array argument, or def when it is absent or null. A present value of another kind is an error.
+func (a HostArgs) ArrayOr(name string, def []VmValue) ([]VmValue, error) {
+	if _, ok := a.optional(name); !ok {
+		return def, nil
+	}
+	return a.Array(name)
+}
+
+// ObjectOr returns the object argument, or def when it is absent or null. A present value of another kind is an error.
+func (a HostArgs) ObjectOr(name string, def map[string]VmValue) (map[string]VmValue, error) {
+	if _, ok := a.optional(name); !ok {
+		return def, nil
+	}
+	return a.Object(name)
+}
+
 // NewValue marshals a Go value into a go-flux-compatible VmValue.
 func NewValue(val any) (VmValue, error) {
 	return NewValueWithOptions(val, MarshalOptions{})
@@ -252,7 +394,7 @@ func NewValue(val any) (VmValue, error) {
 
 // NewValueWithOptions marshals a Go value with extra controls such as read-only marking.
 func NewValueWithOptions(val any, opts MarshalOptions) (VmValue, error) {
-	v, err := marshalGoValueWithOpts(val, marshalOptions{readOnly: opts.ReadOnly})
+	v, err := marshalGoValueWithOpts(val, marshalOptions{readOnly: opts.ReadOnly, maxDepth: opts.MaxDepth, stringer: opts.StringerAsString})
 	if err != nil {
 		return VmValue{}, err
 	}
@@ -278,6 +420,18 @@ func MustValueReadOnly(val any) VmValue {
 	return MustValueWithOptions(val, MarshalOptions{ReadOnly: true})
 }
 
+// NewIteratorValue wraps a Go pull function as a lazy iterator that scripts consume with for-in.
+// next is called once per loop step and reports ok=false when the stream is exhausted.
+func NewIteratorValue(next func() (key string, v VmValue, ok bool)) VmValue {
+	if next == nil {
+		next = func() (string, VmValue, bool) { return "", VmValue{}, false }
+	}
+	return VmValue{v: vm.IteratorVal(vm.NewFuncIterator(func() (string, vm.Value, bool) {
+		key, val, ok := next()
+		return key, val.v, ok
+	}))}
+}
+
 // MarshalFunctionMap converts a map of Go functions into a read-only flux object of callable functions.
 // Supported signatures:
 //
@@ -312,11 +466,23 @@ func MustMarshalFunctionMap(funcs map[string]any) VmValue {
 }
 
 // Raw returns a Go representation of the value.
-// Functions and iterators are not convertible and will return an error.
+// Functions, iterators, and self-referential arrays/objects are not convertible and will return an error.
 func (v VmValue) Raw() (any, error) {
 	return v.raw()
 }
 
+// KeyValue is one object entry in the ordered form produced by RawOrdered.
+type KeyValue struct {
+	Key   string
+	Value any
+}
+
+// RawOrdered is Raw with objects converted to []KeyValue, listing keys in the order the script first set them.
+// Objects built from Go maps have no recorded order; their keys are sorted.
+func (v VmValue) RawOrdered() (any, error) {
+	return unmarshalToGoOrdered(v.v)
+}
+
 // MustRaw returns Raw() or panics on error (convenience).
 func (v VmValue) MustRaw() any {
 	val, err := v.raw()
@@ -386,6 +552,16 @@ func (v VmValue) IsNull() bool {
 	return v.v.Kind == vm.KindNull
 }
 
+// IsTruthy applies the script's truthiness rules: null and false are falsy, every other value
+// (including 0, "", and empty arrays/objects) is truthy. Values returned by a VM configured with
+// SetEmptyCollectionsFalsy follow that VM's rules instead.
+func (v VmValue) IsTruthy() bool {
+	if v.owner != nil {
+		return v.owner.Truthy(v.v)
+	}
+	return vm.Truthy(v.v)
+}
+
 // Bool returns the boolean value when the kind matches.
 func (v VmValue) Bool() (bool, bool) {
 	if v.v.Kind != vm.KindBool {
@@ -402,6 +578,19 @@ func (v VmValue) Number() (float64, bool) {
 	return v.v.Num, true
 }
 
+// Int returns the numeric value as an int64 when it is a number with no fractional part that
+// fits in int64; fractional, non-finite, and non-number values report false.
+func (v VmValue) Int() (int64, bool) {
+	if v.v.Kind != vm.KindNumber {
+		return 0, false
+	}
+	n := v.v.Num
+	if n != math.Trunc(n) || n < math.MinInt64 || n >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(n), true
+}
+
 // String returns the string value when the kind matches.
 func (v VmValue) String() (string, bool) {
 	if v.v.Kind != vm.KindString {
@@ -410,6 +599,15 @@ func (v VmValue) String() (string, bool) {
 	return v.v.Str, true
 }
 
+// Bytes returns the string value's bytes when the kind matches. The slice is a fresh copy, so
+// callers may modify it without affecting the script value.
+func (v VmValue) Bytes() ([]byte, bool) {
+	if v.v.Kind != vm.KindString {
+		return nil, false
+	}
+	return []byte(v.v.Str), true
+}
+
 // ErrorString returns the error string when the kind matches.
 func (v VmValue) ErrorString() (string, bool) {
 	if v.v.Kind != vm.KindError {
@@ -442,6 +640,31 @@ func (v VmValue) Object() (map[string]VmValue, bool) {
 	return out, true
 }
 
+// Path walks a dotted path such as "user.address.city" through nested objects, reporting false
+// when any segment is missing. A numeric segment indexes into an array ("items.0.name").
+func (v VmValue) Path(path string) (VmValue, bool) {
+	cur := v.v
+	for _, seg := range strings.Split(path, ".") {
+		switch cur.Kind {
+		case vm.KindObject:
+			next, ok := cur.Obj[seg]
+			if !ok {
+				return VmValue{}, false
+			}
+			cur = next
+		case vm.KindArray:
+			idx, err := strconv.Atoi(seg)
+			if err != nil || idx < 0 || idx >= len(cur.Arr) {
+				return VmValue{}, false
+			}
+			cur = cur.Arr[idx]
+		default:
+			return VmValue{}, false
+		}
+	}
+	return VmValue{v: cur, owner: v.owner}, true
+}
+
 // AttachFunction assigns a marshaled function to a key on an object value.
 func (v *VmValue) AttachFunction(key string, fn *VmFunction) error {
 	if v == nil {
@@ -453,12 +676,103 @@ func (v *VmValue) AttachFunction(key string, fn *VmFunction) error {
 	if v.v.Obj == nil {
 		v.v.Obj = make(map[string]vm.Value)
 	}
-	v.v.Obj[key] = fn.toVMValueWithName(key)
+	v.v.SetKey(key, fn.toVMValueWithName(key))
+	return nil
+}
+
+// Append adds an element (including function values) to an array VmValue in place.
+// Errors on non-array or read-only values. Copies of v made before the call keep their previous length.
+func (v *VmValue) Append(elem VmValue) error {
+	if v == nil {
+		return errors.New("nil VmValue")
+	}
+	if v.v.Kind != vm.KindArray {
+		return errors.New("Append requires array VmValue")
+	}
+	if v.v.ReadOnly {
+		return errors.New("cannot append to read-only array")
+	}
+	v.v.Arr = append(v.v.Arr, elem.v)
 	return nil
 }
 
+// ObjectBuilder assembles a flux object directly in VM representation.
+type ObjectBuilder struct {
+	obj  map[string]vm.Value
+	keys []string
+}
+
+// NewObjectBuilder starts an empty object.
+func NewObjectBuilder() *ObjectBuilder {
+	return &ObjectBuilder{obj: make(map[string]vm.Value)}
+}
+
+// Set stores val under key, replacing any previous value, and returns the builder for chaining.
+func (b *ObjectBuilder) Set(key string, val VmValue) *ObjectBuilder {
+	if _, exists := b.obj[key]; !exists {
+		b.keys = append(b.keys, key)
+	}
+	b.obj[key] = val.v
+	return b
+}
+
+// Build returns the assembled object and resets the builder, so later calls start a new object.
+func (b *ObjectBuilder) Build() VmValue {
+	out := VmValue{v: vm.OrderedObject(b.obj, b.keys)}
+	b.obj = make(map[string]vm.Value)
+	b.keys = nil
+	return out
+}
+
+// BuildReadOnly is Build with the object marked read-only inside the VM (nested values keep their own flags).
+func (b *ObjectBuilder) BuildReadOnly() VmValue {
+	out := b.Build()
+	out.v.ReadOnly = true
+	return out
+}
+
+// ArrayBuilder assembles a flux array directly in VM representation.
+type ArrayBuilder struct {
+	arr []vm.Value
+}
+
+// NewArrayBuilder starts an empty array.
+func NewArrayBuilder() *ArrayBuilder {
+	return &ArrayBuilder{arr: make([]vm.Value, 0)}
+}
+
+// Append adds val to the end of the array and returns the builder for chaining.
+func (b *ArrayBuilder) Append(val VmValue) *ArrayBuilder {
+	b.arr = append(b.arr, val.v)
+	return b
+}
+
+// Build returns the assembled array and resets the builder, so later calls start a new array.
+func (b *ArrayBuilder) Build() VmValue {
+	out := VmValue{v: vm.Array(b.arr)}
+	b.arr = make([]vm.Value, 0)
+	return out
+}
+
+// BuildReadOnly is Build with the array marked read-only inside the VM (nested values keep their own flags).
+func (b *ArrayBuilder) BuildReadOnly() VmValue {
+	out := b.Build()
+	out.v.ReadOnly = true
+	return out
+}
+
 // Context is the execution context provided to host functions.
-type Context struct{}
+type Context struct {
+	args HostArgs
+}
+
+// Args returns the declared arguments of the current call, in call order.
+func (c *Context) Args() HostArgs {
+	if c == nil {
+		return HostArgs{}
+	}
+	return c.args
+}
 
 // FunctionHandler is the Go-side implementation of a flux function.
 // Arguments are provided by name after validation against the declared parameter list.
@@ -496,6 +810,50 @@ func (h *VmFunctionHandle) Call(ctx context.Context, args ...VmValue) (VmValue,
 	return VmValue{v: res, owner: h.owner}, nil
 }
 
+// Arity returns the number of parameters the function declares, or -1 for host functions.
+func (h *VmFunctionHandle) Arity() int {
+	if h == nil || h.fn == nil || h.fn.Proto == nil {
+		return -1
+	}
+	return h.fn.Proto.NumParams
+}
+
+// ParamNames returns the function's declared parameter names (without the `$` sigil).
+// Host functions return nil.
+func (h *VmFunctionHandle) ParamNames() []string {
+	if h == nil || h.fn == nil || h.fn.Proto == nil {
+		return nil
+	}
+	return append([]string(nil), h.fn.Proto.Params...)
+}
+
+// CallNamed invokes the function handle, placing each argument in the slot of the parameter with the same name.
+// Names may be given with or without the `$` sigil; omitted parameters receive null. Unknown names are an error.
+func (h *VmFunctionHandle) CallNamed(ctx context.Context, args map[string]VmValue) (VmValue, error) {
+	if h == nil || h.fn == nil {
+		return VmValue{}, errors.New("nil function handle")
+	}
+	if h.fn.Proto == nil {
+		return VmValue{}, errors.New("function does not declare named parameters")
+	}
+	params := h.fn.Proto.Params
+	positional := make([]VmValue, len(params))
+	for name, val := range args {
+		idx := -1
+		for i, p := range params {
+			if p == strings.TrimPrefix(name, "$") {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			return VmValue{}, fmt.Errorf("unknown parameter %q", name)
+		}
+		positional[idx] = val
+	}
+	return h.Call(ctx, positional...)
+}
+
 // NewFunction creates a marshaled function from a parameter list and handler.
 func NewFunction(params []string, handler FunctionHandler) *VmFunction {
 	return &VmFunction{
@@ -510,13 +868,37 @@ type VmIteratorHandle struct {
 	it    *vm.Iterator
 }
 
-// Next advances the iterator and returns key/value.
+// Next advances the iterator and returns key/value. A generator resumes on the VM that returned
+// it, running like a call: it fails with ErrVMBusy while that VM is running another call, and
+// instruction limits and interrupts apply to each step. A runtime error inside the generator is
+// returned and ends the iteration.
 func (h *VmIteratorHandle) Next() (string, VmValue, bool, error) {
 	if h == nil || h.it == nil {
 		return "", VmValue{}, false, errors.New("nil iterator handle")
 	}
-	key, val, ok := h.it.Next()
-	return key, VmValue{v: val, owner: h.owner}, ok, nil
+	if h.owner == nil {
+		key, val, ok := h.it.Next()
+		return key, VmValue{v: val}, ok, h.it.Err()
+	}
+	vmc, _ := h.owner.Host().(*VM)
+	if vmc == nil {
+		return "", VmValue{}, false, errors.New("iterator handle missing VM owner")
+	}
+	if !vmc.acquire() {
+		return "", VmValue{}, false, fmt.Errorf("%w; cannot advance iterator while running", ErrVMBusy)
+	}
+	defer vmc.release()
+	var key string
+	var ok bool
+	val, err := vmc.run(context.Background(), func() (vm.Value, error) {
+		k, v, more, err := h.owner.IterNext(h.it)
+		key, ok = k, more
+		return v, err
+	})
+	if err != nil {
+		return "", VmValue{}, false, err
+	}
+	return key, val, ok, nil
 }
 
 func (fn *VmFunction) toVMValueWithName(name string) vm.Value {
@@ -528,10 +910,18 @@ func (fn *VmFunction) toVMValueWithName(name string) vm.Value {
 			return vm.ErrorVal("argument count mismatch"), fmt.Errorf("expected at least %d args, got %d", len(fn.Params), len(args))
 		}
 		argMap := make(map[string]VmValue, len(fn.Params))
+		ordered := make([]VmValue, len(args))
+		for i, arg := range args {
+			ordered[i] = VmValue{v: arg, owner: runtimeVM}
+		}
 		for i, name := range fn.Params {
-			argMap[name] = VmValue{v: args[i], owner: runtimeVM}
+			argMap[name] = ordered[i]
+		}
+		var rest []VmValue
+		if len(args) > len(fn.Params) {
+			rest = ordered[len(fn.Params):]
 		}
-		res, err := fn.Handler(&Context{}, argMap)
+		res, err := fn.Handler(&Context{args: HostArgs{args: argMap, ordered: ordered, rest: rest}}, argMap)
 		if err != nil {
 			return vm.ErrorVal(err.Error()), err
 		}
@@ -586,7 +976,7 @@ func vmFunctionFromFunc(name string, fn any) (*VmFunction, error) {
 			if !ok {
 				return VmValue{}, ArgError{Name: paramNames[i], Want: "present"}
 			}
-			val, err := convertVmValue(arg.v, rt.In(i))
+			val, err := convertVmValue(arg, rt.In(i))
 			if err != nil {
 				return VmValue{}, fmt.Errorf("argument %s: %w", paramNames[i], err)
 			}
@@ -617,15 +1007,60 @@ func vmFunctionFromFunc(name string, fn any) (*VmFunction, error) {
 type VM struct {
 	core            *vm.VM
 	propagateErrors bool
+	checkArity      bool
+	resolver        func(name string) (string, error)
+	maxSourceBytes  int
+	valueTraceHook  ValueTraceHook // rebound by Duplicate so values name the copy as owner
+	logSink         func(VmValue)  // rebound by Duplicate, like valueTraceHook
+	lastStats       CallStats
 	mu              sync.Mutex
 	busy            bool
 }
 
 // NewVM constructs a new VM configurator instance.
 func NewVM() *VM {
-	return &VM{
+	vmc := &VM{
 		core: vm.New(),
 	}
+	vmc.core.SetHost(vmc)
+	return vmc
+}
+
+// GlobalsSnapshot is a deep copy of a VM's globals taken by Snapshot.
+type GlobalsSnapshot struct {
+	globals map[string]vm.Value
+}
+
+// Snapshot deep-copies the VM's globals (including script functions and host bindings) for a later Restore.
+// Returns nil on nil or busy VM.
+func (vmc *VM) Snapshot() *GlobalsSnapshot {
+	if vmc == nil || vmc.core == nil {
+		return nil
+	}
+	vmc.mu.Lock()
+	defer vmc.mu.Unlock()
+	if vmc.busy {
+		return nil
+	}
+	return &GlobalsSnapshot{globals: vmc.core.SnapshotGlobals()}
+}
+
+// Restore replaces the VM's globals with the snapshot's contents, discarding mutations made since.
+// A snapshot may be restored any number of times, and into VMs other than the one it came from.
+func (vmc *VM) Restore(snap *GlobalsSnapshot) error {
+	if vmc == nil || vmc.core == nil {
+		return errors.New("nil VM")
+	}
+	if snap == nil {
+		return errors.New("nil snapshot")
+	}
+	vmc.mu.Lock()
+	defer vmc.mu.Unlock()
+	if vmc.busy {
+		return fmt.Errorf("%w; cannot restore while running", ErrVMBusy)
+	}
+	vmc.core.RestoreGlobals(snap.globals)
+	return nil
 }
 
 // Disassemble dumps compiled bytecode as a readable assembly-style listing.
@@ -639,7 +1074,7 @@ func (vmc *VM) Disassemble(w io.Writer) error {
 	vmc.mu.Lock()
 	if vmc.busy {
 		vmc.mu.Unlock()
-		return errors.New("VM is busy; cannot disassemble while running")
+		return fmt.Errorf("%w; cannot disassemble while running", ErrVMBusy)
 	}
 	vmc.busy = true
 	vmc.mu.Unlock()
@@ -651,6 +1086,22 @@ func (vmc *VM) Disassemble(w io.Writer) error {
 	return vmc.core.Disassemble(w)
 }
 
+// DisassembleFunction writes the bytecode of one compiled function (and its nested closures) to w.
+// It errors if name is not a compiled script function.
+func (vmc *VM) DisassembleFunction(name string, w io.Writer) error {
+	if vmc == nil || vmc.core == nil {
+		return errors.New("nil VM")
+	}
+	if w == nil {
+		return errors.New("nil writer")
+	}
+	if !vmc.acquire() {
+		return fmt.Errorf("%w; cannot disassemble while running", ErrVMBusy)
+	}
+	defer vmc.release()
+	return vmc.core.DisassembleFunction(name, w)
+}
+
 // Duplicate clones the VM configuration and global state into a new instance.
 // The duplicate has independent memory and no in-flight execution state.
 func (vmc *VM) Duplicate() (*VM, error) {
@@ -660,7 +1111,7 @@ func (vmc *VM) Duplicate() (*VM, error) {
 	vmc.mu.Lock()
 	if vmc.busy {
 		vmc.mu.Unlock()
-		return nil, errors.New("VM is busy; cannot duplicate while running")
+		return nil, fmt.Errorf("%w; cannot duplicate while running", ErrVMBusy)
 	}
 	vmc.busy = true
 	vmc.mu.Unlock()
@@ -674,13 +1125,21 @@ func (vmc *VM) Duplicate() (*VM, error) {
 	if core == nil {
 		return nil, errors.New("VM duplicate failed")
 	}
-	return &VM{
+	dup := &VM{
 		core:            core,
 		propagateErrors: vmc.propagateErrors,
-	}, nil
+		checkArity:      vmc.checkArity,
+		resolver:        vmc.resolver,
+		maxSourceBytes:  vmc.maxSourceBytes,
+	}
+	core.SetHost(dup)
+	dup.SetValueTraceHook(vmc.valueTraceHook)
+	dup.SetLogSink(vmc.logSink)
+	return dup, nil
 }
 
 // SetGlobalFunction binds a marshaled function to a global name (equivalent to a function declaration).
+// Like a declaration, it may not take a builtin's name: direct calls by that name always reach the builtin.
 func (vmc *VM) SetGlobalFunction(name string, fn *VmFunction) error {
 	if vmc == nil || vmc.core == nil {
 		return errors.New("nil VM")
@@ -688,10 +1147,42 @@ func (vmc *VM) SetGlobalFunction(name string, fn *VmFunction) error {
 	if fn == nil {
 		return errors.New("nil function")
 	}
+	if _, exists := runtime.LookupByName(name); exists {
+		return fmt.Errorf("global function %s shadows builtin %s", name, name)
+	}
 	vmc.core.DefineGlobal(name, fn.toVMValueWithName(name))
 	return nil
 }
 
+// SetEmitSink defines a global emit(value) function that hands each emitted value to sink as the
+// script runs, so long scripts can stream results instead of building one large return value.
+// emit returns null to the script; a nil sink discards emitted values.
+func (vmc *VM) SetEmitSink(sink func(VmValue)) error {
+	return vmc.SetGlobalFunction("emit", NewFunction([]string{"value"}, func(_ *Context, args map[string]VmValue) (VmValue, error) {
+		if sink != nil {
+			sink(args["value"])
+		}
+		return VmValue{v: vm.Null()}, nil
+	}))
+}
+
+// SetLogSink routes values passed to the log(value) builtin to sink while scripts run, so scripts
+// can print debug output without a host function. log returns null; a nil sink discards values.
+func (vmc *VM) SetLogSink(sink func(VmValue)) {
+	if vmc == nil || vmc.core == nil {
+		return
+	}
+	vmc.logSink = sink
+	if sink == nil {
+		vmc.core.SetLogSink(nil)
+		return
+	}
+	owner := vmc.core
+	vmc.core.SetLogSink(func(v vm.Value) {
+		sink(VmValue{v: v, owner: owner})
+	})
+}
+
 // HasFunction reports whether a global function exists with the given name.
 func (vmc *VM) HasFunction(name string) bool {
 	if vmc == nil || vmc.core == nil {
@@ -700,8 +1191,50 @@ func (vmc *VM) HasFunction(name string) bool {
 	return vmc.core.HasFunction(name)
 }
 
+// FunctionInfo describes a global function bound on a VM.
+type FunctionInfo struct {
+	Name      string // global name the function is bound to
+	Source    string // source name the function was compiled from; empty for host functions
+	IsNative  bool   // host function rather than compiled script
+	NumParams int    // declared parameter count, or -1 for host functions
+}
+
+// Functions lists the VM's global functions, compiled and host-bound, sorted by name.
+// Returns nil on a nil or busy VM.
+func (vmc *VM) Functions() []FunctionInfo {
+	if vmc == nil || vmc.core == nil {
+		return nil
+	}
+	vmc.mu.Lock()
+	defer vmc.mu.Unlock()
+	if vmc.busy {
+		return nil
+	}
+	funcs := vmc.core.Functions()
+	out := make([]FunctionInfo, 0, len(funcs))
+	for name, fn := range funcs {
+		info := FunctionInfo{Name: name, IsNative: fn.Proto == nil, NumParams: -1}
+		if fn.Proto != nil {
+			info.Source = fn.Proto.Source
+			info.NumParams = fn.Proto.NumParams
+		}
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
 // LoadFile loads and compiles a script from a filesystem path.
 func (vmc *VM) LoadFile(path string) error {
+	if vmc != nil && vmc.maxSourceBytes > 0 {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if err := vmc.checkSourceSize(path, info.Size()); err != nil {
+			return err
+		}
+	}
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return err
@@ -709,81 +1242,555 @@ func (vmc *VM) LoadFile(path string) error {
 	return vmc.LoadSource(path, string(data))
 }
 
+// LoadFileFS is LoadFile reading through fsys, such as an embed.FS or fstest.MapFS.
+// path follows io/fs rules: slash-separated and unrooted.
+func (vmc *VM) LoadFileFS(fsys fs.FS, path string) error {
+	if vmc != nil && vmc.maxSourceBytes > 0 {
+		info, err := fs.Stat(fsys, path)
+		if err != nil {
+			return err
+		}
+		if err := vmc.checkSourceSize(path, info.Size()); err != nil {
+			return err
+		}
+	}
+	data, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return err
+	}
+	return vmc.LoadSource(path, string(data))
+}
+
 // LoadSource loads and compiles a script from raw source text.
 // The name is used in diagnostics (e.g., "inline" or a synthetic filename).
 func (vmc *VM) LoadSource(name string, src string) error {
 	if vmc == nil || vmc.core == nil {
 		return errors.New("nil VM")
 	}
-	p := parser.New(lexer.New(src))
-	prog := p.ParseProgram()
-	if errs := p.Errors(); len(errs) > 0 {
-		return fmt.Errorf("parse errors: %v", errs)
+	if err := vmc.checkSourceSize(name, int64(len(src))); err != nil {
+		return err
 	}
-	mod, err := compiler.Compile(prog, name)
+	mod, _, err := compileSource(name, src, compiler.CompileOptions{CheckArity: vmc.checkArity})
 	if err != nil {
-		return fmt.Errorf("compile error: %w", err)
+		return err
 	}
-	vmc.core.LoadModule(mod)
-	_ = name // reserved for diagnostics later
-	return nil
+	return vmc.loadModule(name, mod)
 }
 
-// SetErrorResultAsError configures whether script-returned error values should also surface as Go errors from CallAsync/Await.
-// When enabled, a function that returns an `error(...)` value will produce a VmCallResult with both Value set (KindError) and Err set.
-func (vmc *VM) SetErrorResultAsError(enable bool) {
-	if vmc == nil {
-		return
+// LoadSourceStrict is LoadSource for CI-style gating: any warning (such as an unused variable or
+// unreachable code) fails the load, and nothing is loaded. The error lists every warning.
+func (vmc *VM) LoadSourceStrict(name string, src string) error {
+	if vmc == nil || vmc.core == nil {
+		return errors.New("nil VM")
 	}
-	vmc.propagateErrors = enable
+	if err := vmc.checkSourceSize(name, int64(len(src))); err != nil {
+		return err
+	}
+	mod, warnings, err := compileSource(name, src, compiler.CompileOptions{CheckArity: vmc.checkArity})
+	if err != nil {
+		return err
+	}
+	if len(warnings) > 0 {
+		return fmt.Errorf("warnings: %v", warnings)
+	}
+	return vmc.loadModule(name, mod)
 }
 
-// SetInstructionLimit caps the number of instructions a single CallAsync may execute (0 for unlimited).
-func (vmc *VM) SetInstructionLimit(limit int) {
-	if vmc == nil || vmc.core == nil {
+// SetMaxSourceBytes caps the size of each script accepted by LoadSource, LoadSourceStrict, LoadFile,
+// and the module resolver. Oversized sources are rejected before lexing (LoadFile checks the file
+// size before reading it). 0 removes the limit, which is the default.
+func (vmc *VM) SetMaxSourceBytes(n int) {
+	if vmc == nil {
 		return
 	}
-	if limit < 0 {
-		limit = 0
+	if n < 0 {
+		n = 0
 	}
-	vmc.core.SetInstructionLimit(limit)
+	vmc.maxSourceBytes = n
 }
 
-// SetTraceHook attaches a debug hook that observes instruction dispatch.
-func (vmc *VM) SetTraceHook(h TraceHook) {
-	if vmc == nil || vmc.core == nil {
-		return
+func (vmc *VM) checkSourceSize(name string, size int64) error {
+	if vmc.maxSourceBytes > 0 && size > int64(vmc.maxSourceBytes) {
+		return fmt.Errorf("source %s is %d bytes, exceeding the limit of %d", name, size, vmc.maxSourceBytes)
 	}
-	if h == nil {
-		vmc.core.SetTraceHook(nil)
+	return nil
+}
+
+// SetModuleResolver supplies the source of modules named by `import "name"` statements. Imports are
+// resolved, compiled, and loaded (before the importing script) on each load; a module imported
+// several times in one load is fetched once. Pass nil to make imports fail.
+func (vmc *VM) SetModuleResolver(resolver func(name string) (string, error)) {
+	if vmc == nil {
+		return
+	}
+	vmc.resolver = resolver
+}
+
+// loadModule loads mod's imports and then mod itself. Nothing is loaded unless every import
+// resolves and compiles.
+func (vmc *VM) loadModule(name string, mod *bytecode.Module) error {
+	var deps []*bytecode.Module
+	if err := vmc.resolveImports(mod, []string{name}, map[string]bool{}, &deps); err != nil {
+		return err
+	}
+	for _, dep := range deps {
+		vmc.core.LoadModule(dep)
+	}
+	vmc.core.LoadModule(mod)
+	return nil
+}
+
+// resolveImports appends mod's imports to deps depth-first, dependencies first. chain holds the
+// modules being resolved, so an import of one of them is reported as a cycle.
+func (vmc *VM) resolveImports(mod *bytecode.Module, chain []string, done map[string]bool, deps *[]*bytecode.Module) error {
+	for _, imp := range mod.Imports {
+		for _, name := range chain {
+			if name == imp {
+				return fmt.Errorf("import cycle: %s", strings.Join(append(chain, imp), " -> "))
+			}
+		}
+		if done[imp] {
+			continue
+		}
+		if vmc.resolver == nil {
+			return fmt.Errorf("import %q: no module resolver set", imp)
+		}
+		src, err := vmc.resolver(imp)
+		if err != nil {
+			return fmt.Errorf("import %q: %w", imp, err)
+		}
+		if err := vmc.checkSourceSize(imp, int64(len(src))); err != nil {
+			return fmt.Errorf("import %q: %w", imp, err)
+		}
+		dep, _, err := compileSource(imp, src, compiler.CompileOptions{CheckArity: vmc.checkArity})
+		if err != nil {
+			return fmt.Errorf("import %q: %w", imp, err)
+		}
+		next := append(chain[:len(chain):len(chain)], imp)
+		if err := vmc.resolveImports(dep, next, done, deps); err != nil {
+			return err
+		}
+		done[imp] = true
+		*deps = append(*deps, dep)
+	}
+	return nil
+}
+
+// compileSource parses, compiles, and verifies src. Warnings never cause an error.
+func compileSource(name string, src string, opts compiler.CompileOptions) (*bytecode.Module, []Diagnostic, error) {
+	p := parser.New(lexer.New(src))
+	prog := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		return nil, nil, fmt.Errorf("parse errors: %v", errs)
+	}
+	mod, err := compiler.CompileWithOptions(prog, name, opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("compile error: %w", err)
+	}
+	if err := bytecode.Verify(mod); err != nil {
+		return nil, nil, fmt.Errorf("compile error: %w", err)
+	}
+	return mod, lintDiagnostics(name, prog), nil
+}
+
+func lintDiagnostics(name string, prog *ast.Program) []Diagnostic {
+	warnings := lint.Program(prog)
+	if len(warnings) == 0 {
+		return nil
+	}
+	diags := make([]Diagnostic, len(warnings))
+	for i, w := range warnings {
+		diags[i] = Diagnostic{
+			Source:   name,
+			Line:     w.Line,
+			Column:   w.Column,
+			Message:  w.Message,
+			Severity: SeverityWarning,
+			Code:     w.Code,
+		}
+	}
+	return diags
+}
+
+// Program is compiled bytecode for a script, exposed for debuggers and other tooling.
+type Program struct {
+	mod      *bytecode.Module
+	warnings []Diagnostic
+}
+
+// Compile parses and compiles source into a Program without loading it into a VM.
+// The name is used as the source name, matching LoadSource.
+func Compile(name string, src string) (*Program, error) {
+	mod, warnings, err := compileSource(name, src, compiler.CompileOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &Program{mod: mod, warnings: warnings}, nil
+}
+
+// Warnings returns the advisory diagnostics found while compiling, such as unreachable code.
+// They are kept apart from errors: a program with warnings still loads and runs.
+func (p *Program) Warnings() []Diagnostic {
+	if p == nil {
+		return nil
+	}
+	return p.warnings
+}
+
+// SourcePosition maps an instruction offset in the named function (such as RuntimeError's Frame.IP)
+// back to its 1-based source line and column. ok is false for an unknown function or offset.
+func (p *Program) SourcePosition(function string, ip int) (line, column int, ok bool) {
+	if p == nil || p.mod == nil {
+		return 0, 0, false
+	}
+	proto, found := p.mod.Functions[function]
+	if !found || proto == nil {
+		return 0, 0, false
+	}
+	return proto.Chunk.PositionForOffset(ip)
+}
+
+// Instruction is one decoded bytecode instruction of a Program, for tools such as editor bytecode views.
+type Instruction struct {
+	Function string // owning function; nested closures are labelled as in Disassemble
+	Offset   int
+	Op       string
+	Operands []int
+	Line     int // 0 when no line was recorded
+}
+
+// Disassemble returns the program's instructions, function by function in name order,
+// with each function followed by the closures nested inside it.
+func (p *Program) Disassemble() []Instruction {
+	if p == nil || p.mod == nil {
+		return nil
+	}
+	names := make([]string, 0, len(p.mod.Functions))
+	for name := range p.mod.Functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var out []Instruction
+	visited := make(map[*bytecode.Prototype]bool)
+	var walk func(label string, proto *bytecode.Prototype)
+	walk = func(label string, proto *bytecode.Prototype) {
+		if proto == nil || proto.Chunk == nil || visited[proto] {
+			return
+		}
+		visited[proto] = true
+		insts, _ := bytecode.Decode(proto.Chunk)
+		for _, inst := range insts {
+			out = append(out, Instruction{
+				Function: label,
+				Offset:   inst.Offset,
+				Op:       inst.Name,
+				Operands: inst.Operands,
+				Line:     inst.Line,
+			})
+		}
+		for idx, c := range proto.Chunk.Consts {
+			child, ok := c.(*bytecode.Prototype)
+			if !ok {
+				continue
+			}
+			childName := child.Name
+			if childName == "" {
+				childName = fmt.Sprintf("<closure@const:%d>", idx)
+			}
+			walk(childName, child)
+		}
+	}
+	for _, name := range names {
+		walk(name, p.mod.Functions[name])
+	}
+	return out
+}
+
+// Check parses and compiles source without loading it into any VM.
+// It returns structured diagnostics (nil when the source is clean), which suits editor/tooling checks.
+// Errors are reported alone; warnings are reported only for source that compiles.
+func Check(name string, src string) []Diagnostic {
+	p := parser.New(lexer.New(src))
+	prog := p.ParseProgram()
+	if errs := p.ErrorList(); len(errs) > 0 {
+		diags := make([]Diagnostic, len(errs))
+		for i, e := range errs {
+			diags[i] = Diagnostic{
+				Source:   name,
+				Line:     e.Pos.Line,
+				Column:   e.Pos.Column,
+				Message:  e.Message,
+				Severity: SeverityError,
+				Code:     "syntax",
+			}
+		}
+		return diags
+	}
+	if _, err := compiler.Compile(prog, name); err != nil {
+		diag := Diagnostic{Source: name, Message: err.Error(), Severity: SeverityError, Code: "compile"}
+		var cerr *compiler.Error
+		if errors.As(err, &cerr) {
+			diag.Line, diag.Column = cerr.Line, cerr.Column
+		}
+		return []Diagnostic{diag}
+	}
+	return lintDiagnostics(name, prog)
+}
+
+// Format parses source and re-emits it in canonical form (tab indentation, normalized spacing and newlines).
+// Comments are not preserved. Formatting already-formatted output is a no-op.
+func Format(src string) (string, error) {
+	p := parser.New(lexer.New(src))
+	prog := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		return "", fmt.Errorf("parse errors: %v", errs)
+	}
+	return format.Program(prog), nil
+}
+
+// ProgramInfo summarizes what a script declares and depends on, for review before loading.
+type ProgramInfo struct {
+	Functions []string // top-level functions the script declares
+	Globals   []string // globals the script reads or writes but does not declare (e.g., host-provided functions)
+	Builtins  []string // builtins the script invokes or uses as values
+}
+
+// Inspect parses source without compiling or loading it and reports its declared functions,
+// referenced globals, and referenced builtins. Each list is sorted and de-duplicated.
+func Inspect(src string) (ProgramInfo, error) {
+	p := parser.New(lexer.New(src))
+	prog := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		return ProgramInfo{}, fmt.Errorf("parse errors: %v", errs)
+	}
+	info := inspect.Program(prog)
+	return ProgramInfo{
+		Functions: info.Functions,
+		Globals:   info.Globals,
+		Builtins:  info.Builtins,
+	}, nil
+}
+
+// SetErrorResultAsError configures whether script-returned error values should also surface as Go errors from CallAsync/Await.
+// When enabled, a function that returns an `error(...)` value will produce a VmCallResult with both Value set (KindError) and Err set.
+func (vmc *VM) SetErrorResultAsError(enable bool) {
+	if vmc == nil {
+		return
+	}
+	vmc.propagateErrors = enable
+}
+
+// SetEmptyCollectionsFalsy makes script conditions, !, && and || treat empty strings, arrays,
+// objects, and the number 0 as false, in addition to null and false. Off by default.
+func (vmc *VM) SetEmptyCollectionsFalsy(enable bool) {
+	if vmc == nil || vmc.core == nil {
+		return
+	}
+	vmc.core.SetEmptyCollectionsFalsy(enable)
+}
+
+// SetNullPropagation makes property and index reads on null (`$cfg.a.b` with a null `$cfg.a`) yield null
+// instead of failing with a runtime error, for forgiving traversal of optional data. Off by default.
+func (vmc *VM) SetNullPropagation(enable bool) {
+	if vmc == nil || vmc.core == nil {
+		return
+	}
+	vmc.core.SetNullPropagation(enable)
+}
+
+// SetArityCheck configures whether later LoadSource/LoadFile/Eval calls reject calls to a top-level script
+// function, by name, with the wrong number of arguments. Calls through variables, properties, or
+// nested declarations are not checked.
+func (vmc *VM) SetArityCheck(enable bool) {
+	if vmc == nil {
+		return
+	}
+	vmc.checkArity = enable
+}
+
+// SetInstructionLimit caps the number of instructions a single CallAsync may execute (0 for unlimited).
+func (vmc *VM) SetInstructionLimit(limit int) {
+	if vmc == nil || vmc.core == nil {
+		return
+	}
+	if limit < 0 {
+		limit = 0
+	}
+	vmc.core.SetInstructionLimit(limit)
+}
+
+// DisableBuiltin forbids a builtin on this VM; scripts invoking it fail at run time with a clear error.
+// Other VMs are unaffected. Errors on nil VM or an unknown builtin name.
+func (vmc *VM) DisableBuiltin(name string) error {
+	return vmc.setBuiltinEnabled(name, false)
+}
+
+// EnableBuiltin re-allows a builtin previously disabled with DisableBuiltin.
+func (vmc *VM) EnableBuiltin(name string) error {
+	return vmc.setBuiltinEnabled(name, true)
+}
+
+func (vmc *VM) setBuiltinEnabled(name string, enabled bool) error {
+	if vmc == nil || vmc.core == nil {
+		return errors.New("nil VM")
+	}
+	vmc.mu.Lock()
+	defer vmc.mu.Unlock()
+	if vmc.busy {
+		return fmt.Errorf("%w; cannot change builtins while running", ErrVMBusy)
+	}
+	if !vmc.core.SetBuiltinEnabled(name, enabled) {
+		return fmt.Errorf("unknown builtin %q", name)
+	}
+	return nil
+}
+
+// EnableCoverage starts recording executed source lines across subsequent calls, discarding earlier data.
+// It has no effect while the VM is busy.
+func (vmc *VM) EnableCoverage() {
+	if vmc == nil || vmc.core == nil {
+		return
+	}
+	vmc.mu.Lock()
+	defer vmc.mu.Unlock()
+	if vmc.busy {
+		return
+	}
+	vmc.core.EnableCoverage()
+}
+
+// Coverage reports the lines executed since EnableCoverage, keyed by source name with lines sorted ascending.
+// Returns nil when coverage is not enabled or the VM is busy.
+func (vmc *VM) Coverage() map[string][]int {
+	if vmc == nil || vmc.core == nil {
+		return nil
+	}
+	vmc.mu.Lock()
+	defer vmc.mu.Unlock()
+	if vmc.busy {
+		return nil
+	}
+	return vmc.core.Coverage()
+}
+
+// ProfileStat is the accumulated cost of one script function while profiling.
+// Instructions counts only the function's own instructions; Time is wall time including callees.
+type ProfileStat struct {
+	Calls        int
+	Instructions int
+	Time         time.Duration
+}
+
+// EnableProfiling starts accumulating per-function call, instruction, and time statistics across
+// subsequent calls, discarding earlier data. It has no effect while the VM is busy.
+func (vmc *VM) EnableProfiling() {
+	if vmc == nil || vmc.core == nil {
+		return
+	}
+	vmc.mu.Lock()
+	defer vmc.mu.Unlock()
+	if vmc.busy {
+		return
+	}
+	vmc.core.EnableProfiling()
+}
+
+// Profile reports statistics collected since EnableProfiling, keyed by function name (anonymous functions as "<anon>").
+// Returns nil when profiling is not enabled or the VM is busy.
+func (vmc *VM) Profile() map[string]ProfileStat {
+	if vmc == nil || vmc.core == nil {
+		return nil
+	}
+	vmc.mu.Lock()
+	defer vmc.mu.Unlock()
+	if vmc.busy {
+		return nil
+	}
+	stats := vmc.core.Profile()
+	if stats == nil {
+		return nil
+	}
+	out := make(map[string]ProfileStat, len(stats))
+	for name, st := range stats {
+		out[name] = ProfileStat{Calls: st.Calls, Instructions: st.Instructions, Time: st.Time}
+	}
+	return out
+}
+
+// SetTraceHook attaches a debug hook that observes instruction dispatch.
+func (vmc *VM) SetTraceHook(h TraceHook) {
+	if vmc == nil || vmc.core == nil {
+		return
+	}
+	if h == nil {
+		vmc.core.SetTraceHook(nil)
 		return
 	}
 	vmc.core.SetTraceHook(func(info vm.TraceInfo) {
-		h(TraceInfo{
-			Op:       info.Op,
-			Function: info.Function,
-			Source:   info.Source,
-			Line:     info.Line,
-			IP:       info.IP,
-		})
+		h(convertTraceInfo(info))
+	})
+}
+
+// SetValueTraceHook attaches a debug hook that also sees the top-of-stack value before each instruction,
+// so a debugger can follow values being pushed and consumed. It costs more than SetTraceHook; pass nil to clear.
+func (vmc *VM) SetValueTraceHook(h ValueTraceHook) {
+	if vmc == nil || vmc.core == nil {
+		return
+	}
+	vmc.valueTraceHook = h
+	if h == nil {
+		vmc.core.SetValueTraceHook(nil)
+		return
+	}
+	owner := vmc.core
+	vmc.core.SetValueTraceHook(func(info vm.TraceInfo, top vm.Value) {
+		h(convertTraceInfo(info), VmValue{v: top, owner: owner})
 	})
 }
 
+func convertTraceInfo(info vm.TraceInfo) TraceInfo {
+	return TraceInfo{
+		Op:         info.Op,
+		Function:   info.Function,
+		Source:     info.Source,
+		Line:       info.Line,
+		IP:         info.IP,
+		StackDepth: info.StackDepth,
+	}
+}
+
 // VmCallFuture represents an in-flight VM call.
 type VmCallFuture struct {
-	ch <-chan VmCallResult
+	ch     <-chan VmCallResult
+	cancel context.CancelFunc
 }
 
 // VmCallResult is the outcome of a VM call.
 type VmCallResult struct {
 	Value VmValue
 	Err   error
+	Stats CallStats
+}
+
+// CallStats reports what a single call cost, for capacity planning without a profiling mode.
+type CallStats struct {
+	Instructions int           // bytecode instructions executed, including nested script calls
+	PeakStack    int           // deepest value stack reached
+	PeakFrames   int           // deepest call nesting (1 for a call that makes no script calls)
+	Duration     time.Duration // wall-clock time spent running the call
 }
 
-// Await waits for completion or context cancellation.
+// Await waits for completion or context cancellation. On cancellation it stops the script and
+// waits for it to unwind, so the VM is free again on return. That wait is not bounded by ctx: a
+// script blocked in a host function stops only once the function returns.
 func (f VmCallFuture) Await(ctx context.Context) (VmValue, error) {
 	select {
 	case <-ctx.Done():
+		// Stop the running script and wait for it so the VM is no longer busy on return.
+		if f.cancel != nil {
+			f.cancel()
+			<-f.ch
+		}
 		return VmValue{}, ctx.Err()
 	case res := <-f.ch:
 		return res.Value, res.Err
@@ -792,54 +1799,206 @@ func (f VmCallFuture) Await(ctx context.Context) (VmValue, error) {
 
 // CallAsync resolves a function by name, marshals arguments, and executes it on the VM asynchronously.
 func (vmc *VM) CallAsync(ctx context.Context, name string, args []VmValue) VmCallFuture {
-	vmc.mu.Lock()
-	if vmc.busy {
-		vmc.mu.Unlock()
+	if !vmc.acquire() {
 		ch := make(chan VmCallResult, 1)
-		ch <- VmCallResult{Err: errors.New("VM is busy; concurrent CallAsync not allowed")}
+		ch <- VmCallResult{Err: fmt.Errorf("%w; concurrent CallAsync not allowed", ErrVMBusy)}
 		close(ch)
 		return VmCallFuture{ch: ch}
 	}
-	vmc.busy = true
-	vmc.mu.Unlock()
 
+	runCtx, cancel := context.WithCancel(ctx)
 	ch := make(chan VmCallResult, 1)
 	go func() {
 		defer close(ch)
-		defer func() {
-			vmc.mu.Lock()
-			vmc.busy = false
-			vmc.mu.Unlock()
-		}()
-		select {
-		case <-ctx.Done():
-			ch <- VmCallResult{Err: ctx.Err()}
-			return
-		default:
+		defer cancel()
+		defer vmc.release()
+		res, err := vmc.call(runCtx, name, args)
+		ch <- VmCallResult{Value: res, Err: err, Stats: vmc.LastCallStats()}
+	}()
+	return VmCallFuture{ch: ch, cancel: cancel}
+}
+
+// Call resolves a function by name and executes it synchronously on the calling goroutine.
+// It follows the same busy guard and error-result promotion as CallAsync.
+func (vmc *VM) Call(ctx context.Context, name string, args ...VmValue) (VmValue, error) {
+	if !vmc.acquire() {
+		return VmValue{}, fmt.Errorf("%w; concurrent Call not allowed", ErrVMBusy)
+	}
+	defer vmc.release()
+	return vmc.call(ctx, name, args)
+}
+
+// Interrupt stops the running call from another goroutine: it aborts before its next instruction with a
+// RuntimeError whose Cause is ErrInterrupted. A host function that is blocked is not preempted; the call
+// stops once it returns. Interrupting an idle VM has no effect on later calls.
+func (vmc *VM) Interrupt() {
+	if vmc == nil || vmc.core == nil {
+		return
+	}
+	vmc.core.Interrupt()
+}
+
+// LastCallStats returns the counters of the most recently completed Call, CallAsync, CallAll entry, or Eval.
+// CallAll and CallAsync results also carry them in VmCallResult.Stats.
+func (vmc *VM) LastCallStats() CallStats {
+	if vmc == nil {
+		return CallStats{}
+	}
+	vmc.mu.Lock()
+	defer vmc.mu.Unlock()
+	return vmc.lastStats
+}
+
+// Call names a function and its arguments for CallAll.
+type Call struct {
+	Name string
+	Args []VmValue
+}
+
+// CallAllOptions controls batch execution in CallAllWithOptions.
+type CallAllOptions struct {
+	// ContinueOnError runs the remaining calls after a failure instead of stopping.
+	ContinueOnError bool
+}
+
+// CallAll runs calls sequentially under a single busy acquisition, stopping at the first error.
+// It returns the results of the calls that ran, in order, and the first error encountered.
+func (vmc *VM) CallAll(ctx context.Context, calls []Call) ([]VmCallResult, error) {
+	return vmc.CallAllWithOptions(ctx, calls, CallAllOptions{})
+}
+
+// CallAllWithOptions is CallAll with configurable error handling.
+// With ContinueOnError every call runs and the returned error is still the first one encountered.
+func (vmc *VM) CallAllWithOptions(ctx context.Context, calls []Call, opts CallAllOptions) ([]VmCallResult, error) {
+	if !vmc.acquire() {
+		return nil, fmt.Errorf("%w; concurrent CallAll not allowed", ErrVMBusy)
+	}
+	defer vmc.release()
+	results := make([]VmCallResult, 0, len(calls))
+	var firstErr error
+	for _, c := range calls {
+		res, err := vmc.call(ctx, c.Name, c.Args)
+		results = append(results, VmCallResult{Value: res, Err: err, Stats: vmc.LastCallStats()})
+		if err == nil {
+			continue
 		}
-		argVals := make([]vm.Value, len(args))
-		for i, a := range args {
-			argVals[i] = a.v
+		if firstErr == nil {
+			firstErr = err
 		}
-		res, err := vmc.core.Call(name, argVals)
-		err = convertRuntimeError(err)
-		if err != nil {
-			ch <- VmCallResult{Err: err}
-			return
+		if !opts.ContinueOnError {
+			break
 		}
-		outVal := VmValue{v: res, owner: vmc.core}
-		if vmc.propagateErrors && res.Kind == vm.KindError {
-			ch <- VmCallResult{Value: outVal, Err: errors.New(res.Err)}
-			return
+	}
+	return results, firstErr
+}
+
+// Eval compiles src as a list of statements and runs it as an anonymous function, returning the
+// value of the final expression statement (null otherwise). Script functions loaded into the VM
+// and host globals are visible; variables assigned in src are local to this evaluation. src goes
+// through the same checks as LoadSource (size limit, bytecode verification, and arity checks
+// against the loaded functions when enabled). Function declarations are a compile error: load
+// them with LoadSource instead.
+func (vmc *VM) Eval(ctx context.Context, src string) (VmValue, error) {
+	if vmc == nil || vmc.core == nil {
+		return VmValue{}, errors.New("nil VM")
+	}
+	if err := vmc.checkSourceSize("eval", int64(len(src))); err != nil {
+		return VmValue{}, err
+	}
+	if !vmc.acquire() {
+		return VmValue{}, fmt.Errorf("%w; concurrent Eval not allowed", ErrVMBusy)
+	}
+	defer vmc.release()
+	p := parser.New(lexer.New(src))
+	prog := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		return VmValue{}, fmt.Errorf("parse errors: %v", errs)
+	}
+	opts := compiler.CompileOptions{CheckArity: vmc.checkArity}
+	if vmc.checkArity {
+		opts.Arity = make(map[string]int)
+		for name, fn := range vmc.core.Functions() {
+			if fn.Native == nil && fn.Proto != nil {
+				opts.Arity[name] = fn.Proto.NumParams
+			}
 		}
-		ch <- VmCallResult{Value: outVal}
-	}()
-	return VmCallFuture{ch: ch}
+	}
+	proto, err := compiler.CompileEval(prog, "eval", opts)
+	if err != nil {
+		return VmValue{}, fmt.Errorf("compile error: %w", err)
+	}
+	if err := bytecode.Verify(&bytecode.Module{Functions: map[string]*bytecode.Prototype{"<eval>": proto}}); err != nil {
+		return VmValue{}, fmt.Errorf("compile error: %w", err)
+	}
+	fn := vm.NewScriptFunction("<eval>", proto)
+	return vmc.run(ctx, func() (vm.Value, error) {
+		return vmc.core.Run(fn, nil)
+	})
+}
+
+// acquire marks the VM busy, reporting false when another call is already running.
+func (vmc *VM) acquire() bool {
+	vmc.mu.Lock()
+	defer vmc.mu.Unlock()
+	if vmc.busy {
+		return false
+	}
+	vmc.busy = true
+	return true
+}
+
+func (vmc *VM) release() {
+	vmc.mu.Lock()
+	vmc.busy = false
+	vmc.mu.Unlock()
 }
 
-func convertVmValue(src vm.Value, targetType reflect.Type) (reflect.Value, error) {
+// call runs a named function on a VM the caller has already acquired.
+func (vmc *VM) call(ctx context.Context, name string, args []VmValue) (VmValue, error) {
+	argVals := make([]vm.Value, len(args))
+	for i, a := range args {
+		argVals[i] = a.v
+	}
+	return vmc.run(ctx, func() (vm.Value, error) {
+		return vmc.core.Call(name, argVals)
+	})
+}
+
+// run executes fn on the acquired VM with ctx attached, converting errors and promoting
+// error results as configured.
+func (vmc *VM) run(ctx context.Context, fn func() (vm.Value, error)) (VmValue, error) {
+	select {
+	case <-ctx.Done():
+		return VmValue{}, ctx.Err()
+	default:
+	}
+	vmc.core.SetContext(ctx)
+	// Reset even if fn panics, so a stale context never leaks into the next call.
+	defer vmc.core.SetContext(nil)
+	start := time.Now()
+	res, err := fn()
+	elapsed := time.Since(start)
+	stats := vmc.core.Stats()
+	vmc.mu.Lock()
+	vmc.lastStats = CallStats{Instructions: stats.Instructions, PeakStack: stats.PeakStack, PeakFrames: stats.PeakFrames, Duration: elapsed}
+	vmc.mu.Unlock()
+	if ctxErr := ctx.Err(); ctxErr != nil && errors.Is(err, ctxErr) {
+		return VmValue{}, ctxErr
+	}
+	err = convertRuntimeError(err)
+	if err != nil {
+		return VmValue{}, err
+	}
+	outVal := VmValue{v: res, owner: vmc.core}
+	if vmc.propagateErrors && res.Kind == vm.KindError {
+		return outVal, errors.New(res.Err)
+	}
+	return outVal, nil
+}
+
+func convertVmValue(src VmValue, targetType reflect.Type) (reflect.Value, error) {
 	ptr := reflect.New(targetType)
-	if err := assignValue(src, ptr.Elem()); err != nil {
+	if err := assignValue(src.v, ptr.Elem(), "", unmarshalOptions{owner: src.owner}); err != nil {
 		return reflect.Value{}, err
 	}
 	return ptr.Elem(), nil
@@ -847,6 +2006,57 @@ func convertVmValue(src vm.Value, targetType reflect.Type) (reflect.Value, error
 
 type marshalOptions struct {
 	readOnly bool
+	maxDepth int
+	stringer bool // prefer String() for fmt.Stringer values
+	depth    int
+	visiting map[uintptr]bool // maps/slices/pointers on the current path, for cycle detection
+}
+
+// nest returns options for marshaling one container level deeper, erroring past the depth limit.
+func (o marshalOptions) nest() (marshalOptions, error) {
+	limit := o.maxDepth
+	if limit <= 0 {
+		limit = DefaultMarshalMaxDepth
+	}
+	if o.depth >= limit {
+		return o, fmt.Errorf("marshal depth exceeds limit of %d", limit)
+	}
+	o.depth++
+	return o, nil
+}
+
+// track marks a reference as being marshaled; seeing it again before release means the value is cyclic.
+func (o marshalOptions) track(rv reflect.Value) (func(), error) {
+	var ref uintptr
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Map:
+		ref = rv.Pointer()
+	case reflect.Slice:
+		if rv.Len() > 0 {
+			ref = rv.Pointer()
+		}
+	}
+	if ref == 0 {
+		return func() {}, nil
+	}
+	if o.visiting[ref] {
+		return nil, fmt.Errorf("cannot marshal cyclic value of type %s", rv.Type())
+	}
+	o.visiting[ref] = true
+	return func() { delete(o.visiting, ref) }, nil
+}
+
+// enter combines nest and track for a container value.
+func (o marshalOptions) enter(rv reflect.Value) (marshalOptions, func(), error) {
+	child, err := o.nest()
+	if err != nil {
+		return o, nil, err
+	}
+	release, err := o.track(rv)
+	if err != nil {
+		return o, nil, err
+	}
+	return child, release, nil
 }
 
 // marshalGoValue converts common Go types into vm.Value.
@@ -855,13 +2065,25 @@ func marshalGoValue(val any) (vm.Value, error) {
 }
 
 func marshalGoValueWithOpts(val any, opts marshalOptions) (vm.Value, error) {
-	if m, ok := val.(Marshaler); ok {
+	if opts.visiting == nil {
+		opts.visiting = make(map[uintptr]bool)
+	}
+	m, ok := val.(Marshaler)
+	if !ok {
+		m, ok = pointerMarshaler(val)
+	}
+	if ok {
 		custom, err := m.MarshalFlux()
 		if err != nil {
 			return vm.Value{}, err
 		}
 		return applyReadOnly(custom.v, opts), nil
 	}
+	if s, ok := val.(fmt.Stringer); ok && opts.stringer {
+		if rv := reflect.ValueOf(val); rv.Kind() != reflect.Pointer || !rv.IsNil() {
+			return vm.String(s.String()), nil
+		}
+	}
 	switch v := val.(type) {
 	case VmValue:
 		return applyReadOnly(v.v, opts), nil
@@ -886,9 +2108,14 @@ func marshalGoValueWithOpts(val any, opts marshalOptions) (vm.Value, error) {
 		}
 		return vm.Number(n), nil
 	case []any:
+		child, release, err := opts.enter(reflect.ValueOf(v))
+		if err != nil {
+			return vm.Value{}, err
+		}
+		defer release()
 		out := make([]vm.Value, len(v))
 		for i, el := range v {
-			mv, err := marshalGoValueWithOpts(el, opts)
+			mv, err := marshalGoValueWithOpts(el, child)
 			if err != nil {
 				return vm.Value{}, err
 			}
@@ -902,9 +2129,14 @@ func marshalGoValueWithOpts(val any, opts marshalOptions) (vm.Value, error) {
 		}
 		return applyReadOnly(vm.Array(out), opts), nil
 	case map[string]any:
+		child, release, err := opts.enter(reflect.ValueOf(v))
+		if err != nil {
+			return vm.Value{}, err
+		}
+		defer release()
 		out := make(map[string]vm.Value, len(v))
 		for k, el := range v {
-			mv, err := marshalGoValueWithOpts(el, opts)
+			mv, err := marshalGoValueWithOpts(el, child)
 			if err != nil {
 				return vm.Value{}, err
 			}
@@ -942,6 +2174,11 @@ func marshalGoValueWithOpts(val any, opts marshalOptions) (vm.Value, error) {
 			if rv.IsNil() {
 				return vm.Null(), nil
 			}
+			release, err := opts.track(rv)
+			if err != nil {
+				return vm.Value{}, err
+			}
+			defer release()
 			return marshalGoValueWithOpts(rv.Elem().Interface(), opts)
 		}
 		if rv.Kind() == reflect.Interface && !rv.IsNil() {
@@ -959,9 +2196,14 @@ func marshalGoValueWithOpts(val any, opts marshalOptions) (vm.Value, error) {
 		case reflect.String:
 			return vm.String(rv.String()), nil
 		case reflect.Slice, reflect.Array:
+			child, release, err := opts.enter(rv)
+			if err != nil {
+				return vm.Value{}, err
+			}
+			defer release()
 			out := make([]vm.Value, rv.Len())
 			for i := 0; i < rv.Len(); i++ {
-				mv, err := marshalGoValueWithOpts(rv.Index(i).Interface(), opts)
+				mv, err := marshalGoValueWithOpts(rv.Index(i).Interface(), child)
 				if err != nil {
 					return vm.Value{}, err
 				}
@@ -969,6 +2211,11 @@ func marshalGoValueWithOpts(val any, opts marshalOptions) (vm.Value, error) {
 			}
 			return applyReadOnly(vm.Array(out), opts), nil
 		case reflect.Map:
+			child, release, err := opts.enter(rv)
+			if err != nil {
+				return vm.Value{}, err
+			}
+			defer release()
 			out := make(map[string]vm.Value, rv.Len())
 			iter := rv.MapRange()
 			for iter.Next() {
@@ -982,7 +2229,7 @@ func marshalGoValueWithOpts(val any, opts marshalOptions) (vm.Value, error) {
 				default:
 					keyStr = fmt.Sprint(k)
 				}
-				mv, err := marshalGoValueWithOpts(iter.Value().Interface(), opts)
+				mv, err := marshalGoValueWithOpts(iter.Value().Interface(), child)
 				if err != nil {
 					return vm.Value{}, err
 				}
@@ -990,25 +2237,42 @@ func marshalGoValueWithOpts(val any, opts marshalOptions) (vm.Value, error) {
 			}
 			return applyReadOnly(vm.Object(out), opts), nil
 		case reflect.Struct:
+			child, err := opts.nest()
+			if err != nil {
+				return vm.Value{}, err
+			}
 			out := make(map[string]vm.Value, rv.NumField())
-			rt := rv.Type()
-			for i := 0; i < rv.NumField(); i++ {
-				field := rt.Field(i)
-				if field.PkgPath != "" { // unexported
+			var keys []string
+			for _, field := range structFields(rv.Type()) {
+				fv, ok := fieldByIndex(rv, field.index)
+				if !ok { // promoted through a nil embedded pointer
 					continue
 				}
-				mv, err := marshalGoValueWithOpts(rv.Field(i).Interface(), opts)
+				mv, err := marshalGoValueWithOpts(fv.Interface(), child)
 				if err != nil {
 					return vm.Value{}, err
 				}
-				out[field.Name] = mv
+				out[field.name] = mv
+				keys = append(keys, field.name)
 			}
-			return applyReadOnly(vm.Object(out), opts), nil
+			return applyReadOnly(vm.OrderedObject(out, keys), opts), nil
 		}
 		return vm.Value{}, fmt.Errorf("unsupported value type %T", val)
 	}
 }
 
+// pointerMarshaler handles values whose MarshalFlux has a pointer receiver. Struct fields, slice items, and map
+// values reach marshaling as non-addressable copies, so the method is invoked on a pointer to a fresh copy.
+func pointerMarshaler(val any) (Marshaler, bool) {
+	t := reflect.TypeOf(val)
+	if t == nil || t.Kind() == reflect.Pointer || !reflect.PointerTo(t).Implements(marshalerType) {
+		return nil, false
+	}
+	p := reflect.New(t)
+	p.Elem().Set(reflect.ValueOf(val))
+	return p.Interface().(Marshaler), true
+}
+
 func applyReadOnly(v vm.Value, opts marshalOptions) vm.Value {
 	if !opts.readOnly {
 		return v
@@ -1030,6 +2294,17 @@ func applyReadOnly(v vm.Value, opts marshalOptions) vm.Value {
 
 // unmarshalToGo converts a vm.Value into a Go value for RawStrict().
 func unmarshalToGo(v vm.Value) (any, error) {
+	return unmarshalToGoVisit(v, map[uintptr]bool{}, false)
+}
+
+// unmarshalToGoOrdered is unmarshalToGo with objects converted to []KeyValue in insertion order.
+func unmarshalToGoOrdered(v vm.Value) (any, error) {
+	return unmarshalToGoVisit(v, map[uintptr]bool{}, true)
+}
+
+// unmarshalToGoVisit tracks the containers on the current path so self-referential values error instead of looping.
+// Containers shared without forming a cycle are converted once per occurrence.
+func unmarshalToGoVisit(v vm.Value, visiting map[uintptr]bool, ordered bool) (any, error) {
 	switch v.Kind {
 	case vm.KindNull:
 		return nil, nil
@@ -1040,9 +2315,18 @@ func unmarshalToGo(v vm.Value) (any, error) {
 	case vm.KindString:
 		return v.Str, nil
 	case vm.KindArray:
+		var key uintptr
+		if len(v.Arr) > 0 {
+			key = reflect.ValueOf(v.Arr).Pointer()
+			if visiting[key] {
+				return nil, errors.New("cannot convert cyclic array to Go")
+			}
+			visiting[key] = true
+			defer delete(visiting, key)
+		}
 		out := make([]any, len(v.Arr))
 		for i, el := range v.Arr {
-			val, err := unmarshalToGo(el)
+			val, err := unmarshalToGoVisit(el, visiting, ordered)
 			if err != nil {
 				return nil, err
 			}
@@ -1050,9 +2334,29 @@ func unmarshalToGo(v vm.Value) (any, error) {
 		}
 		return out, nil
 	case vm.KindObject:
+		if v.Obj != nil {
+			key := reflect.ValueOf(v.Obj).Pointer()
+			if visiting[key] {
+				return nil, errors.New("cannot convert cyclic object to Go")
+			}
+			visiting[key] = true
+			defer delete(visiting, key)
+		}
+		if ordered {
+			keys := v.OrderedKeys()
+			out := make([]KeyValue, len(keys))
+			for i, k := range keys {
+				val, err := unmarshalToGoVisit(v.Obj[k], visiting, ordered)
+				if err != nil {
+					return nil, err
+				}
+				out[i] = KeyValue{Key: k, Value: val}
+			}
+			return out, nil
+		}
 		out := make(map[string]any, len(v.Obj))
 		for k, el := range v.Obj {
-			val, err := unmarshalToGo(el)
+			val, err := unmarshalToGoVisit(el, visiting, ordered)
 			if err != nil {
 				return nil, err
 			}
@@ -1070,9 +2374,31 @@ func unmarshalToGo(v vm.Value) (any, error) {
 	}
 }
 
+// UnmarshalOptions tunes flux→Go unmarshaling behavior.
+type UnmarshalOptions struct {
+	// Coerce converts between scalar kinds instead of failing: numbers and booleans to strings,
+	// numeric strings and booleans (true=1) to numbers, and 0/1 or "true"/"false" to booleans.
+	Coerce bool
+	// DisallowUnknownFields rejects objects with keys that match no exported field of the target struct.
+	DisallowUnknownFields bool
+}
+
+type unmarshalOptions struct {
+	coerce          bool
+	disallowUnknown bool
+	owner           *vm.VM // VM the source value came from, passed on to nested Unmarshalers
+}
+
 // Unmarshal assigns a flux VmValue into a Go target using reflection.
-// Supports primitives, slices, maps (string keys), structs, and Unmarshaler.
+// Supports primitives, slices, maps (string keys), structs, pointers (null → nil), and Unmarshaler
+// at any nesting level.
+// Kinds must match exactly; see UnmarshalWithOptions for lenient conversion.
 func Unmarshal(val VmValue, target any) error {
+	return UnmarshalWithOptions(val, target, UnmarshalOptions{})
+}
+
+// UnmarshalWithOptions is Unmarshal with extra controls such as scalar coercion.
+func UnmarshalWithOptions(val VmValue, target any, opts UnmarshalOptions) error {
 	if target == nil {
 		return errors.New("nil target")
 	}
@@ -1083,18 +2409,25 @@ func Unmarshal(val VmValue, target any) error {
 	if rv.Kind() != reflect.Pointer || rv.IsNil() {
 		return errors.New("target must be non-nil pointer")
 	}
-	return assignValue(val.v, rv.Elem())
+	return assignValue(val.v, rv.Elem(), "", unmarshalOptions{coerce: opts.Coerce, disallowUnknown: opts.DisallowUnknownFields, owner: val.owner})
 }
 
-func assignValue(src vm.Value, dst reflect.Value) error {
+// assignValue converts src into dst. path names the position inside the outermost target and is
+// attached to errors so nested mismatches can be located; it is empty for the target itself.
+func assignValue(src vm.Value, dst reflect.Value, path string, opts unmarshalOptions) error {
 	if !dst.CanSet() {
-		return errors.New("cannot set target")
+		return atPath(path, errors.New("cannot set target"))
+	}
+	if dst.CanAddr() {
+		if u, ok := dst.Addr().Interface().(Unmarshaler); ok {
+			return atPath(path, u.UnmarshalFlux(VmValue{v: src, owner: opts.owner}))
+		}
 	}
 	switch dst.Kind() {
 	case reflect.Interface:
 		raw, err := unmarshalToGo(src)
 		if err != nil {
-			return err
+			return atPath(path, err)
 		}
 		if raw == nil {
 			dst.Set(reflect.Zero(dst.Type()))
@@ -1103,72 +2436,77 @@ func assignValue(src vm.Value, dst reflect.Value) error {
 		dst.Set(reflect.ValueOf(raw))
 		return nil
 	case reflect.Bool:
+		src = opts.convert(src, vm.KindBool)
 		if src.Kind != vm.KindBool {
-			return ArgError{Want: "boolean", Got: kindName(ValueKind(src.Kind))}
+			return ArgError{Want: "boolean", Got: kindName(ValueKind(src.Kind)), Path: path}
 		}
 		dst.SetBool(src.B)
 		return nil
 	case reflect.String:
+		src = opts.convert(src, vm.KindString)
 		if src.Kind != vm.KindString {
-			return ArgError{Want: "string", Got: kindName(ValueKind(src.Kind))}
+			return ArgError{Want: "string", Got: kindName(ValueKind(src.Kind)), Path: path}
 		}
 		dst.SetString(src.Str)
 		return nil
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		src = opts.convert(src, vm.KindNumber)
 		if src.Kind != vm.KindNumber {
-			return ArgError{Want: "number", Got: kindName(ValueKind(src.Kind))}
+			return ArgError{Want: "number", Got: kindName(ValueKind(src.Kind)), Path: path}
 		}
 		dst.SetInt(int64(src.Num))
 		return nil
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		src = opts.convert(src, vm.KindNumber)
 		if src.Kind != vm.KindNumber {
-			return ArgError{Want: "number", Got: kindName(ValueKind(src.Kind))}
+			return ArgError{Want: "number", Got: kindName(ValueKind(src.Kind)), Path: path}
 		}
 		dst.SetUint(uint64(src.Num))
 		return nil
 	case reflect.Float32, reflect.Float64:
+		src = opts.convert(src, vm.KindNumber)
 		if src.Kind != vm.KindNumber {
-			return ArgError{Want: "number", Got: kindName(ValueKind(src.Kind))}
+			return ArgError{Want: "number", Got: kindName(ValueKind(src.Kind)), Path: path}
 		}
 		dst.SetFloat(src.Num)
 		return nil
 	case reflect.Slice:
 		if src.Kind != vm.KindArray {
-			return ArgError{Want: "array", Got: kindName(ValueKind(src.Kind))}
+			return ArgError{Want: "array", Got: kindName(ValueKind(src.Kind)), Path: path}
 		}
 		l := len(src.Arr)
 		dst.Set(reflect.MakeSlice(dst.Type(), l, l))
 		for i := 0; i < l; i++ {
-			if err := assignValue(src.Arr[i], dst.Index(i)); err != nil {
+			if err := assignValue(src.Arr[i], dst.Index(i), fmt.Sprintf("%s[%d]", path, i), opts); err != nil {
 				return err
 			}
 		}
 		return nil
 	case reflect.Array:
 		if src.Kind != vm.KindArray {
-			return ArgError{Want: "array", Got: kindName(ValueKind(src.Kind))}
+			return ArgError{Want: "array", Got: kindName(ValueKind(src.Kind)), Path: path}
 		}
 		l := len(src.Arr)
 		if l != dst.Len() {
-			return fmt.Errorf("array length mismatch: have %d want %d", l, dst.Len())
+			return atPath(path, fmt.Errorf("array length mismatch: have %d want %d", l, dst.Len()))
 		}
 		for i := 0; i < l; i++ {
-			if err := assignValue(src.Arr[i], dst.Index(i)); err != nil {
+			if err := assignValue(src.Arr[i], dst.Index(i), fmt.Sprintf("%s[%d]", path, i), opts); err != nil {
 				return err
 			}
 		}
 		return nil
 	case reflect.Map:
 		if src.Kind != vm.KindObject {
-			return ArgError{Want: "object", Got: kindName(ValueKind(src.Kind))}
+			return ArgError{Want: "object", Got: kindName(ValueKind(src.Kind)), Path: path}
 		}
 		if dst.Type().Key().Kind() != reflect.String {
-			return fmt.Errorf("map keys must be string")
+			return atPath(path, errors.New("map keys must be string"))
 		}
 		dst.Set(reflect.MakeMapWithSize(dst.Type(), len(src.Obj)))
 		for k, v := range src.Obj {
 			elem := reflect.New(dst.Type().Elem()).Elem()
-			if err := assignValue(v, elem); err != nil {
+			if err := assignValue(v, elem, fmt.Sprintf("%s[%q]", path, k), opts); err != nil {
 				return err
 			}
 			dst.SetMapIndex(reflect.ValueOf(k), elem)
@@ -1176,23 +2514,204 @@ func assignValue(src vm.Value, dst reflect.Value) error {
 		return nil
 	case reflect.Struct:
 		if src.Kind != vm.KindObject {
-			return ArgError{Want: "object", Got: kindName(ValueKind(src.Kind))}
+			return ArgError{Want: "object", Got: kindName(ValueKind(src.Kind)), Path: path}
 		}
-		rt := dst.Type()
-		for i := 0; i < rt.NumField(); i++ {
-			field := rt.Field(i)
-			if field.PkgPath != "" { // unexported
-				continue
-			}
-			name := field.Name
-			if val, ok := src.Obj[name]; ok {
-				if err := assignValue(val, dst.Field(i)); err != nil {
+		fields := structFields(dst.Type())
+		matched := 0
+		for _, field := range fields {
+			if val, ok := src.Obj[field.name]; ok {
+				matched++
+				fv, err := fieldByIndexAlloc(dst, field.index)
+				if err != nil {
+					return atPath(fieldPath(path, field.name), err)
+				}
+				if err := assignValue(val, fv, fieldPath(path, field.name), opts); err != nil {
 					return err
 				}
 			}
 		}
+		if opts.disallowUnknown && matched < len(src.Obj) {
+			return unknownFieldError(src.Obj, fields, dst.Type(), path)
+		}
+		return nil
+	case reflect.Pointer:
+		if src.Kind == vm.KindNull {
+			dst.Set(reflect.Zero(dst.Type()))
+			return nil
+		}
+		elem := reflect.New(dst.Type().Elem())
+		if err := assignValue(src, elem.Elem(), path, opts); err != nil {
+			return err
+		}
+		dst.Set(elem)
 		return nil
 	default:
-		return fmt.Errorf("unsupported unmarshal target kind %s", dst.Kind())
+		return atPath(path, fmt.Errorf("unsupported unmarshal target kind %s", dst.Kind()))
+	}
+}
+
+// convert coerces a scalar to the wanted kind when coercion is enabled and the value converts cleanly.
+// Otherwise src is returned unchanged and the caller reports the mismatch.
+func (o unmarshalOptions) convert(src vm.Value, want vm.Kind) vm.Value {
+	if !o.coerce || src.Kind == want {
+		return src
+	}
+	switch want {
+	case vm.KindString:
+		switch src.Kind {
+		case vm.KindNumber:
+			return vm.String(strconv.FormatFloat(src.Num, 'f', -1, 64))
+		case vm.KindBool:
+			return vm.String(strconv.FormatBool(src.B))
+		}
+	case vm.KindNumber:
+		switch src.Kind {
+		case vm.KindString:
+			if n, err := strconv.ParseFloat(strings.TrimSpace(src.Str), 64); err == nil {
+				return vm.Number(n)
+			}
+		case vm.KindBool:
+			if src.B {
+				return vm.Number(1)
+			}
+			return vm.Number(0)
+		}
+	case vm.KindBool:
+		switch src.Kind {
+		case vm.KindNumber:
+			if src.Num == 0 || src.Num == 1 {
+				return vm.Bool(src.Num == 1)
+			}
+		case vm.KindString:
+			if b, err := strconv.ParseBool(src.Str); err == nil {
+				return vm.Bool(b)
+			}
+		}
+	}
+	return src
+}
+
+// structField is an object key exposed by a struct: an exported field, possibly promoted from an embedded struct.
+type structField struct {
+	name  string
+	index []int
+}
+
+// structFields lists the keys a struct type marshals to. Fields of anonymous struct (or pointer-to-struct)
+// members are promoted into the parent like encoding/json: a shallower field shadows deeper ones with the
+// same name, and same-depth conflicts are dropped.
+func structFields(rt reflect.Type) []structField {
+	type candidate struct {
+		field structField
+		depth int
+		dup   bool
+	}
+	byName := make(map[string]*candidate)
+	var order []string
+	var walk func(t reflect.Type, index []int, depth int, visiting map[reflect.Type]bool)
+	walk = func(t reflect.Type, index []int, depth int, visiting map[reflect.Type]bool) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			idx := append(append([]int(nil), index...), i)
+			if f.Anonymous {
+				ft := f.Type
+				if ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct {
+					if !visiting[ft] {
+						visiting[ft] = true
+						walk(ft, idx, depth+1, visiting)
+						delete(visiting, ft)
+					}
+					continue
+				}
+			}
+			if f.PkgPath != "" { // unexported
+				continue
+			}
+			c := byName[f.Name]
+			switch {
+			case c == nil:
+				byName[f.Name] = &candidate{field: structField{name: f.Name, index: idx}, depth: depth}
+				order = append(order, f.Name)
+			case depth < c.depth:
+				*c = candidate{field: structField{name: f.Name, index: idx}, depth: depth}
+			case depth == c.depth:
+				c.dup = true
+			}
+		}
+	}
+	walk(rt, nil, 0, map[reflect.Type]bool{rt: true})
+	out := make([]structField, 0, len(order))
+	for _, name := range order {
+		if c := byName[name]; !c.dup {
+			out = append(out, c.field)
+		}
+	}
+	return out
+}
+
+// fieldByIndex reads a possibly promoted field, reporting false when a nil embedded pointer is in the way.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// fieldByIndexAlloc returns a settable promoted field, allocating nil embedded pointers on the way.
+// A nil embedded pointer to an unexported type cannot be allocated and is reported as an error.
+func fieldByIndexAlloc(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, fmt.Errorf("cannot set embedded pointer to unexported struct %s", v.Type().Elem())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, nil
+}
+
+// unknownFieldError reports the first (alphabetically) object key with no matching field.
+func unknownFieldError(obj map[string]vm.Value, fields []structField, rt reflect.Type, path string) error {
+	known := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		known[field.name] = true
+	}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		if !known[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return atPath(path, fmt.Errorf("unknown field %q for %s", keys[0], rt))
+}
+
+// fieldPath appends a struct field name to an unmarshal path.
+func fieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// atPath prefixes err with the location of the failing value; top-level and nil errors are returned unchanged.
+func atPath(path string, err error) error {
+	if path == "" || err == nil {
+		return err
 	}
+	return fmt.Errorf("%s: %w", path, err)
 }
@@ -5,9 +5,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"testing/fstest"
 	"time"
 )
 
@@ -21,6 +26,12 @@ func (c testCustomMarshaler) MarshalFlux() (VmValue, error) {
 	return NewValue(map[string]any{"v": c.V})
 }
 
+type testPtrMarshaler struct{ V string }
+
+func (c *testPtrMarshaler) MarshalFlux() (VmValue, error) {
+	return NewValue("ptr:" + c.V)
+}
+
 func (c *testCustomUnmarshaler) UnmarshalFlux(v VmValue) error {
 	obj, ok := v.Object()
 	if !ok {
@@ -99,6 +110,33 @@ func TestAPIHasFunction(t *testing.T) {
 	}
 }
 
+func TestAPIFunctions(t *testing.T) {
+	vm := NewVM()
+	if err := vm.LoadSource("math.flux", "func add($a, $b) { return $a + $b }\nfunc neg($x) { return -$x }"); err != nil {
+		t.Fatalf("load math: %v", err)
+	}
+	if err := vm.LoadSource("greet.flux", `func hello() { return "hi" }`); err != nil {
+		t.Fatalf("load greet: %v", err)
+	}
+	if err := vm.SetGlobalFunction("now", NewFunction(nil, func(_ *Context, _ map[string]VmValue) (VmValue, error) {
+		return NewValue(0)
+	})); err != nil {
+		t.Fatalf("set global: %v", err)
+	}
+	want := []FunctionInfo{
+		{Name: "add", Source: "math.flux", NumParams: 2},
+		{Name: "hello", Source: "greet.flux", NumParams: 0},
+		{Name: "neg", Source: "math.flux", NumParams: 1},
+		{Name: "now", IsNative: true, NumParams: -1},
+	}
+	if got := vm.Functions(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected listing %+v", got)
+	}
+	if got := NewVM().Functions(); len(got) != 0 {
+		t.Fatalf("expected no functions on a fresh VM, got %+v", got)
+	}
+}
+
 func TestAPIVMDuplicateIsolation(t *testing.T) {
 	base := NewVM()
 	err := base.LoadSource("inline", `
@@ -144,6 +182,46 @@ func bump() {
 	}
 }
 
+func TestAPISnapshotRestore(t *testing.T) {
+	vm := NewVM()
+	err := vm.LoadSource("inline", `
+func init() {
+  $state = { count: 0 }
+}
+func bump() {
+  $state.count = $state.count + 1
+  return $state.count
+}
+`)
+	if err != nil {
+		t.Fatalf("load source: %v", err)
+	}
+	if _, err := vm.CallAsync(context.Background(), "init", nil).Await(context.Background()); err != nil {
+		t.Fatalf("init call: %v", err)
+	}
+	snap := vm.Snapshot()
+	if snap == nil {
+		t.Fatalf("expected snapshot")
+	}
+	for round := 0; round < 2; round++ {
+		for i := 1; i <= 2; i++ {
+			res, err := vm.CallAsync(context.Background(), "bump", nil).Await(context.Background())
+			if err != nil {
+				t.Fatalf("bump: %v", err)
+			}
+			if v, ok := res.MustRaw().(float64); !ok || v != float64(i) {
+				t.Fatalf("round %d: expected %d, got %#v", round, i, res.MustRaw())
+			}
+		}
+		if err := vm.Restore(snap); err != nil {
+			t.Fatalf("restore: %v", err)
+		}
+	}
+	if err := vm.Restore(nil); err == nil {
+		t.Fatalf("expected nil snapshot error")
+	}
+}
+
 func TestAPILanguageCoverage(t *testing.T) {
 	run := func(t *testing.T, src, entry string, args []any) (any, error) {
 		t.Helper()
@@ -401,6 +479,25 @@ func demo() {
 				}
 			},
 		},
+		{
+			name:  "const declaration",
+			entry: "area",
+			args:  []any{2},
+			src: `
+func area($r) {
+  const $PI := 3
+  return $PI * $r * $r
+}
+`,
+			check: func(t *testing.T, res any, err error) {
+				if err != nil {
+					t.Fatalf("call error: %v", err)
+				}
+				if res.(float64) != 12 {
+					t.Fatalf("expected 12, got %#v", res)
+				}
+			},
+		},
 		{
 			name:  "default null return",
 			entry: "demo",
@@ -509,6 +606,172 @@ func callHost($fn) {
 	}
 }
 
+func TestAPIRawCyclicValue(t *testing.T) {
+	vm := NewVM()
+	err := vm.LoadSource("inline", `
+func cyclic() {
+  $o := { name: "loop" }
+  $o.self = $o
+  return $o
+}
+func shared() {
+  $leaf := { v: 1 }
+  return { a: $leaf, b: [$leaf, $leaf] }
+}`)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	res, err := vm.CallAsync(context.Background(), "cyclic", nil).Await(context.Background())
+	if err != nil {
+		t.Fatalf("call: %v", err)
+	}
+	if _, err := res.Raw(); err == nil || !strings.Contains(err.Error(), "cyclic") {
+		t.Fatalf("expected cyclic error, got %v", err)
+	}
+	res, err = vm.CallAsync(context.Background(), "shared", nil).Await(context.Background())
+	if err != nil {
+		t.Fatalf("call: %v", err)
+	}
+	raw, err := res.Raw()
+	if err != nil {
+		t.Fatalf("expected shared (acyclic) value to convert, got %v", err)
+	}
+	leaf := map[string]any{"v": float64(1)}
+	want := map[string]any{"a": leaf, "b": []any{leaf, leaf}}
+	if !reflect.DeepEqual(raw, want) {
+		t.Fatalf("expected %#v, got %#v", want, raw)
+	}
+}
+
+func TestAPIRawOrdered(t *testing.T) {
+	vm := NewVM()
+	src := `
+func build() {
+	$o := { zeta: 1, alpha: { y: 1, x: 2 } }
+	$o.mid = 3
+	$o["beta"] = 4
+	$o.zeta = 5
+	return $o
+}
+`
+	if err := vm.LoadSource("ordered", src); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	res, err := vm.Call(context.Background(), "build")
+	if err != nil {
+		t.Fatalf("call: %v", err)
+	}
+	got, err := res.RawOrdered()
+	if err != nil {
+		t.Fatalf("raw ordered: %v", err)
+	}
+	want := []KeyValue{
+		{Key: "zeta", Value: float64(5)},
+		{Key: "alpha", Value: []KeyValue{{Key: "y", Value: float64(1)}, {Key: "x", Value: float64(2)}}},
+		{Key: "mid", Value: float64(3)},
+		{Key: "beta", Value: float64(4)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %#v, got %#v", want, got)
+	}
+	if _, ok := res.MustRaw().(map[string]any); !ok {
+		t.Fatalf("expected Raw to keep returning maps, got %T", res.MustRaw())
+	}
+
+	built := NewObjectBuilder().Set("b", MustValue(1)).Set("a", MustValue(2)).Build()
+	if got, _ := built.RawOrdered(); !reflect.DeepEqual(got, []KeyValue{{Key: "b", Value: float64(1)}, {Key: "a", Value: float64(2)}}) {
+		t.Fatalf("expected builder order, got %v", got)
+	}
+	type pair struct{ B, A int }
+	if got, _ := MustValue(pair{B: 1, A: 2}).RawOrdered(); !reflect.DeepEqual(got, []KeyValue{{Key: "B", Value: float64(1)}, {Key: "A", Value: float64(2)}}) {
+		t.Fatalf("expected struct field order, got %v", got)
+	}
+}
+
+func TestAPIMarshalDepthLimit(t *testing.T) {
+	var deep any = "leaf"
+	for i := 0; i < 20; i++ {
+		deep = []any{deep}
+	}
+	if _, err := NewValueWithOptions(deep, MarshalOptions{MaxDepth: 10}); err == nil || !strings.Contains(err.Error(), "depth exceeds limit of 10") {
+		t.Fatalf("expected depth limit error, got %v", err)
+	}
+	if _, err := NewValueWithOptions(deep, MarshalOptions{MaxDepth: 20}); err != nil {
+		t.Fatalf("expected depth 20 to fit, got %v", err)
+	}
+	var tooDeep any = map[string]any{}
+	for i := 0; i < DefaultMarshalMaxDepth+1; i++ {
+		tooDeep = map[string]any{"next": tooDeep}
+	}
+	if _, err := NewValue(tooDeep); err == nil {
+		t.Fatalf("expected default depth limit error")
+	}
+}
+
+type testCyclicNode struct {
+	Name string
+	Next *testCyclicNode
+}
+
+func TestAPIMarshalCycles(t *testing.T) {
+	node := &testCyclicNode{Name: "self"}
+	node.Next = node
+	if _, err := NewValue(node); err == nil || !strings.Contains(err.Error(), "cyclic") {
+		t.Fatalf("expected cyclic pointer error, got %v", err)
+	}
+
+	loop := map[string]any{}
+	loop["self"] = loop
+	if _, err := NewValue(loop); err == nil || !strings.Contains(err.Error(), "cyclic") {
+		t.Fatalf("expected cyclic map error, got %v", err)
+	}
+
+	shared := &testCyclicNode{Name: "leaf"}
+	v, err := NewValue([]any{shared, shared})
+	if err != nil {
+		t.Fatalf("expected shared acyclic pointers to marshal, got %v", err)
+	}
+	want := []any{
+		map[string]any{"Name": "leaf", "Next": nil},
+		map[string]any{"Name": "leaf", "Next": nil},
+	}
+	if !reflect.DeepEqual(v.MustRaw(), want) {
+		t.Fatalf("expected %#v, got %#v", want, v.MustRaw())
+	}
+}
+
+func TestAPIMarshalStringerAsString(t *testing.T) {
+	type job struct {
+		Name    string
+		Timeout time.Duration
+	}
+	in := job{Name: "sync", Timeout: 1500 * time.Millisecond}
+
+	v, err := NewValue(in)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	want := map[string]any{"Name": "sync", "Timeout": float64(1500 * time.Millisecond)}
+	if !reflect.DeepEqual(v.MustRaw(), want) {
+		t.Fatalf("expected numeric duration by default, got %#v", v.MustRaw())
+	}
+
+	v, err = NewValueWithOptions(in, MarshalOptions{StringerAsString: true})
+	if err != nil {
+		t.Fatalf("marshal with StringerAsString: %v", err)
+	}
+	want = map[string]any{"Name": "sync", "Timeout": "1.5s"}
+	if !reflect.DeepEqual(v.MustRaw(), want) {
+		t.Fatalf("expected string duration, got %#v", v.MustRaw())
+	}
+
+	var nilBuf *strings.Builder
+	v = MustValueWithOptions(map[string]any{"b": nilBuf}, MarshalOptions{StringerAsString: true})
+	if got := v.MustRaw(); !reflect.DeepEqual(got, map[string]any{"b": nil}) {
+		t.Fatalf("expected nil Stringer pointer to marshal as null, got %#v", got)
+	}
+}
+
 func TestAPIReadonlyMarshaledValues(t *testing.T) {
 	vm := NewVM()
 	script := `
@@ -576,256 +839,2568 @@ func TestAPIBuiltinsAutoRegistered(t *testing.T) {
 	}
 }
 
-func TestAPIFunctionMapMarshal(t *testing.T) {
+func TestAPIDisableBuiltin(t *testing.T) {
 	vm := NewVM()
-	script := `
-func call($ns) {
-  $a := $ns.add(2, 3)
-  $b := $ns.greet("hi")
-  $r := readonly($ns)
-  return [$a, $b, $r]
-}
-func boom($ns) { return $ns.fail() }`
-	if err := vm.LoadSource("rpc", script); err != nil {
+	if err := vm.LoadSource("inline", `func fail() { return error("boom") }`); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if err := vm.DisableBuiltin("error"); err != nil {
+		t.Fatalf("disable: %v", err)
+	}
+	dup, err := vm.Duplicate()
+	if err != nil {
+		t.Fatalf("duplicate: %v", err)
+	}
+	for _, target := range []*VM{vm, dup} {
+		_, err := target.CallAsync(context.Background(), "fail", nil).Await(context.Background())
+		rte, ok := err.(*RuntimeError)
+		if !ok {
+			t.Fatalf("expected RuntimeError, got %T (%v)", err, err)
+		}
+		if rte.Message != "builtin error is disabled" {
+			t.Fatalf("unexpected message %q", rte.Message)
+		}
+	}
+
+	other := NewVM()
+	if err := other.LoadSource("inline", `func kind() { return typeof(error("boom")) }`); err != nil {
 		t.Fatalf("load: %v", err)
 	}
+	res, err := other.CallAsync(context.Background(), "kind", nil).Await(context.Background())
+	if err != nil || res.MustRaw() != "error" {
+		t.Fatalf("expected other VM unaffected, got %v, %v", res.MustRaw(), err)
+	}
 
-	funcs := map[string]any{
-		"add": func(a int, b int) int { return a + b },
-		"greet": func(s string) (string, error) {
-			return s + "!", nil
-		},
-		"fail": func() error {
-			return fmt.Errorf("nope")
-		},
+	if err := vm.EnableBuiltin("error"); err != nil {
+		t.Fatalf("enable: %v", err)
 	}
-	ns := MustMarshalFunctionMap(funcs)
-	if !ns.IsReadOnly() {
-		t.Fatalf("expected namespace to be read-only")
+	res, err = vm.CallAsync(context.Background(), "fail", nil).Await(context.Background())
+	if err != nil || res.Kind() != ValueError {
+		t.Fatalf("expected error value after re-enable, got %v, %v", res, err)
+	}
+	if err := vm.DisableBuiltin("nope"); err == nil {
+		t.Fatalf("expected unknown builtin error")
 	}
+}
 
-	callRes, err := vm.CallAsync(context.Background(), "call", []VmValue{ns}).Await(context.Background())
+func TestAPIArityCheck(t *testing.T) {
+	src := `
+func add($a, $b) { return $a + $b }
+func under() { return add(1) }
+`
+	loose := NewVM()
+	if err := loose.LoadSource("inline", src); err != nil {
+		t.Fatalf("arity check should be off by default: %v", err)
+	}
+
+	strict := NewVM()
+	strict.SetArityCheck(true)
+	if err := strict.LoadSource("inline", src); err == nil || !strings.Contains(err.Error(), "function add expects 2 args, got 1") {
+		t.Fatalf("expected under-supply compile error, got %v", err)
+	}
+	if err := strict.LoadSource("inline", "func add($a) { return $a }\nfunc over() {\n  return add(1,\n    2)\n}"); err == nil || !strings.Contains(err.Error(), "function add expects 1 args, got 2") {
+		t.Fatalf("expected over-supply compile error, got %v", err)
+	}
+
+	dynamic := `
+func add($a, $b) { return $a + $b }
+func run() {
+  $f := add
+  $o := {add: func ($x) { return $x }}
+  return [$f(1, 2), $o.add(3), add(4, 5), host(1, 2, 3)]
+}
+`
+	dup, err := strict.Duplicate()
 	if err != nil {
-		t.Fatalf("call error: %v", err)
+		t.Fatalf("duplicate: %v", err)
 	}
-	arr, ok := callRes.Array()
-	if !ok || len(arr) != 3 {
-		t.Fatalf("unexpected call result %#v", callRes)
+	if err := dup.LoadSource("dup", src); err == nil {
+		t.Fatalf("expected duplicate to keep arity checking")
 	}
-	if n, _ := arr[0].Number(); n != 5 {
-		t.Fatalf("expected add to return 5, got %#v", arr[0])
+	host := NewFunction(nil, func(_ *Context, _ map[string]VmValue) (VmValue, error) { return MustValue("host"), nil })
+	if err := strict.SetGlobalFunction("host", host); err != nil {
+		t.Fatalf("set host: %v", err)
 	}
-	if s, _ := arr[1].String(); s != "hi!" {
-		t.Fatalf("expected greet result hi!, got %#v", arr[1])
+	if err := strict.LoadSource("dynamic", dynamic); err != nil {
+		t.Fatalf("dynamic calls should stay unchecked: %v", err)
 	}
-	if arr[2].Kind() != ValueBool || arr[2].MustRaw().(bool) != true {
-		t.Fatalf("expected readonly($ns) to return true, got %#v", arr[2])
+	res, err := strict.Call(context.Background(), "run")
+	if err != nil {
+		t.Fatalf("run: %v", err)
 	}
-
-	if _, err := vm.CallAsync(context.Background(), "boom", []VmValue{ns}).Await(context.Background()); err == nil || !strings.Contains(err.Error(), "nope") {
-		t.Fatalf("expected boom to propagate error nope, got %v", err)
+	if got := res.MustRaw(); !reflect.DeepEqual(got, []any{3.0, 3.0, 9.0, "host"}) {
+		t.Fatalf("unexpected results %#v", got)
 	}
 }
 
-func TestAPIHostFunctionNullInterfaceArg(t *testing.T) {
+func TestAPIDiscardVariable(t *testing.T) {
 	vm := NewVM()
-	script := `func run($ns) { return $ns.get("key", null) }`
-	if err := vm.LoadSource("inline", script); err != nil {
+	src := `
+func sum() {
+  $t := 0
+  for ([$_, $v] in {a: 1, b: 2, c: 3}) { $t = $t + $v }
+  for ([$_, $_] in [10, 20]) { $t = $t + 100 }
+  for ($_ in [1, 2]) { $t = $t + 1000 }
+  $_ := sum2(1, 2)
+  $_ = sum2(3, 4)
+  return $t
+}
+func sum2($_, $b) { return $b }
+`
+	if err := vm.LoadSource("inline", src); err != nil {
 		t.Fatalf("load: %v", err)
 	}
-	ns := MustMarshalFunctionMap(map[string]any{
-		"get": func(key string, fallback any) any {
-			if key != "key" {
-				return "bad"
-			}
-			return fallback
-		},
-	})
-	res, err := vm.CallAsync(context.Background(), "run", []VmValue{ns}).Await(context.Background())
+	res, err := vm.Call(context.Background(), "sum")
 	if err != nil {
-		t.Fatalf("call error: %v", err)
+		t.Fatalf("sum: %v", err)
 	}
-	if !res.IsNull() {
-		t.Fatalf("expected null result, got %#v", res)
+	if res.MustRaw() != 2206.0 {
+		t.Fatalf("expected 2206, got %#v", res.MustRaw())
+	}
+	if err := NewVM().LoadSource("read", "func f() {\n  $_ := 1\n  return $_\n}"); err == nil || !strings.Contains(err.Error(), "cannot read discard variable $_") {
+		t.Fatalf("expected read-discard compile error, got %v", err)
 	}
 }
 
-func TestAPIScriptErrorPromotion(t *testing.T) {
-	script := `func boom() { return error("boom") }`
-
-	// Default: error value returned, Go error nil.
+func TestAPIDestructuringMultipleReturn(t *testing.T) {
 	vm := NewVM()
-	if err := vm.LoadSource("inline", script); err != nil {
+	src := `
+func divmod($a, $b) {
+  $q := 0
+  while ($a >= $b) {
+    $a = $a - $b
+    $q = $q + 1
+  }
+  return [$q, $a]
+}
+func run() {
+  [$q, $r] := divmod(17, 5)
+  [$_, $only] := divmod(9, 4)
+  $x := 1
+  $y := 2
+  [$x, $y] = [$y, $x]
+  return [$q, $r, $only, $x, $y]
+}
+func short() {
+  [$a, $b, $c] := divmod(1, 2)
+  return $c
+}
+`
+	if err := vm.LoadSource("inline", src); err != nil {
 		t.Fatalf("load: %v", err)
 	}
-	val, err := vm.CallAsync(context.Background(), "boom", nil).Await(context.Background())
+	res, err := vm.Call(context.Background(), "run")
 	if err != nil {
-		t.Fatalf("unexpected Go error: %v", err)
-	}
-	if val.Kind() != ValueError {
-		t.Fatalf("expected ValueError, got %v", val.Kind())
+		t.Fatalf("run: %v", err)
 	}
-	if msg, ok := val.ErrorString(); !ok || msg != "boom" {
-		t.Fatalf("unexpected error string %q ok=%v", msg, ok)
+	if want := []any{3.0, 2.0, 1.0, 2.0, 1.0}; !reflect.DeepEqual(res.MustRaw(), want) {
+		t.Fatalf("unexpected result %#v", res.MustRaw())
 	}
-
-	// Promotion: both Value and Err set.
-	vm2 := NewVM()
-	if err := vm2.LoadSource("inline", script); err != nil {
-		t.Fatalf("load: %v", err)
+	if _, err := vm.Call(context.Background(), "short"); err == nil {
+		t.Fatalf("expected error destructuring a too-short array")
 	}
-	vm2.SetErrorResultAsError(true)
-	val2, err := vm2.CallAsync(context.Background(), "boom", nil).Await(context.Background())
-	if err == nil || err.Error() != "boom" {
-		t.Fatalf("expected propagated Go error 'boom', got %v", err)
+	if err := NewVM().LoadSource("bad", `func f() { [$a, $b.c] := [1, 2] }`); err == nil || !strings.Contains(err.Error(), "destructuring target must be a variable") {
+		t.Fatalf("expected destructuring target error, got %v", err)
 	}
-	if val2.Kind() != ValueError {
-		t.Fatalf("expected ValueError, got %v", val2.Kind())
+	info, err := Inspect(src)
+	if err != nil || len(info.Globals) != 0 {
+		t.Fatalf("destructured names should be locals, got globals %v (%v)", info.Globals, err)
 	}
 }
 
-func TestAPIRuntimeErrorDiagnostics(t *testing.T) {
+func TestAPIBuiltinHash(t *testing.T) {
 	vm := NewVM()
-	src := `func inner($arr) {
-  return $arr[5]
+	src := `
+func same() {
+  return [
+    hash({a: 1, b: [1, 2], c: {x: "y"}}) == hash({c: {x: "y"}, b: [1, 2], a: 1}),
+    hash([1, "a", null, true]) == hash([1, "a", null, true]),
+    hash(0) == hash(-0)
+  ]
 }
-
-func outer() {
-  return inner([1, 2, 3])
-}`
-	if err := vm.LoadSource("diag", src); err != nil {
+func differ() {
+  return [
+    hash([1, 2]) == hash([2, 1]),
+    hash({a: 1}) == hash({a: "1"}),
+    hash("1") == hash(1),
+    hash(["ab", "c"]) == hash(["a", "bc"]),
+    hash(null) == hash(false)
+  ]
+}
+func lookup() {
+  $m := {}
+  $m[hash([1, 2])] = "pair"
+  return $m[hash([1, 2])]
+}
+func bad() { return hash(func() {}) }
+func shared() {
+  $x := {a: [1]}
+  return hash([$x, $x]) == hash([{a: [1]}, {a: [1]}])
+}
+func cyclicObject() {
+  $o := {}
+  $o.self = $o
+  return hash($o)
+}
+func cyclicArray() {
+  $a := [1, 2]
+  $a[1] = [$a]
+  return hash($a)
+}
+`
+	if err := vm.LoadSource("inline", src); err != nil {
 		t.Fatalf("load: %v", err)
 	}
-	_, err := vm.CallAsync(context.Background(), "outer", nil).Await(context.Background())
-	if err == nil {
-		t.Fatalf("expected runtime error")
+	ctx := context.Background()
+	res, err := vm.Call(ctx, "same")
+	if err != nil {
+		t.Fatalf("same: %v", err)
 	}
-	rte, ok := err.(*RuntimeError)
-	if !ok {
-		t.Fatalf("expected RuntimeError, got %T", err)
+	if got := res.MustRaw(); !reflect.DeepEqual(got, []any{true, true, true}) {
+		t.Fatalf("expected equal values to hash equal, got %#v", got)
 	}
-	if rte.Frame.Function != "inner" {
-		t.Fatalf("expected top frame inner, got %q", rte.Frame.Function)
+	res, err = vm.Call(ctx, "differ")
+	if err != nil {
+		t.Fatalf("differ: %v", err)
 	}
-	if rte.Frame.Source != "diag" {
-		t.Fatalf("expected source diag, got %q", rte.Frame.Source)
+	if got := res.MustRaw(); !reflect.DeepEqual(got, []any{false, false, false, false, false}) {
+		t.Fatalf("expected different values to hash differently, got %#v", got)
 	}
-	if rte.Frame.Line != 2 {
-		t.Fatalf("expected line 2, got %d", rte.Frame.Line)
+	res, err = vm.Call(ctx, "lookup")
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
 	}
-	if len(rte.Stack) < 2 {
-		t.Fatalf("expected at least 2 frames, got %d", len(rte.Stack))
+	if res.MustRaw() != "pair" {
+		t.Fatalf("expected lookup by hash, got %#v", res.MustRaw())
 	}
-	if rte.Stack[1].Function != "outer" {
+	if _, err := vm.Call(ctx, "bad"); err == nil || !strings.Contains(err.Error(), "hash expects data value") {
+		t.Fatalf("expected error hashing function, got %v", err)
+	}
+	// A value referenced twice is not a cycle; one that contains itself is an error, not a crash.
+	if res, err := vm.Call(ctx, "shared"); err != nil || res.MustRaw() != true {
+		t.Fatalf("expected shared references to hash like copies, got %v %v", res, err)
+	}
+	for _, name := range []string{"cyclicObject", "cyclicArray"} {
+		if _, err := vm.Call(ctx, name); err == nil || !strings.Contains(err.Error(), "hash: cyclic value") {
+			t.Fatalf("%s: expected cyclic value error, got %v", name, err)
+		}
+	}
+}
+
+func TestAPIBuiltinBind(t *testing.T) {
+	vm := NewVM()
+	src := `
+func add($a, $b) { return $a + $b }
+func run() {
+  $f := bind(add, 10)
+  $digits := bind(bind(func ($a, $b, $c) { return $a * 100 + $b * 10 + $c }, 1), 2)
+  return [$f(5), $f(-10), $digits(3), typeof($f)]
+}
+func bad() { return bind(1, 2) }
+`
+	if err := vm.LoadSource("inline", src); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	res, err := vm.Call(context.Background(), "run")
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if got := res.MustRaw(); !reflect.DeepEqual(got, []any{15.0, 0.0, 123.0, "function"}) {
+		t.Fatalf("unexpected bind results %#v", got)
+	}
+	if _, err := vm.Call(context.Background(), "bad"); err == nil || !strings.Contains(err.Error(), "bind expects function, got number") {
+		t.Fatalf("expected bind type error, got %v", err)
+	}
+}
+
+func TestAPIBuiltinPipe(t *testing.T) {
+	vm := NewVM()
+	src := `
+func double($x) { return $x * 2 }
+func inc($x) { return $x + 1 }
+func run() {
+  $di := pipe(double, inc)
+  $id := pipe(inc, double)
+  $all := pipe($di, pipe(bind(func ($a, $b) { return $a - $b }, 100), double))
+  return [$di(5), $id(5), $all(5)]
+}
+func failing() {
+  $f := pipe(func ($x) { return $x + "s" }, inc)
+  return $f(1)
+}
+func bad() { return pipe(double, 1) }
+`
+	if err := vm.LoadSource("inline", src); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	res, err := vm.Call(context.Background(), "run")
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if got := res.MustRaw(); !reflect.DeepEqual(got, []any{11.0, 12.0, 178.0}) {
+		t.Fatalf("unexpected pipe results %#v", got)
+	}
+	if _, err := vm.Call(context.Background(), "failing"); err == nil {
+		t.Fatalf("expected stage error to propagate")
+	}
+	if _, err := vm.Call(context.Background(), "bad"); err == nil || !strings.Contains(err.Error(), "pipe expects functions, got number") {
+		t.Fatalf("expected pipe type error, got %v", err)
+	}
+}
+
+func TestAPIBuiltinChunkedWindows(t *testing.T) {
+	vm := NewVM()
+	src := `
+func run() {
+  $a := [1, 2, 3, 4, 5, 6]
+  $b := [1, 2, 3, 4, 5]
+  return [chunked($a, 2), chunked($b, 2), chunked($b, 9), chunked([], 3), windows($b, 3), windows($b, 9), $b]
+}
+func isolated() {
+  $b := [1, 2, 3]
+  $c := chunked($b, 2)
+  $c[0][0] = 99
+  $w := windows($b, 2)
+  $w[1][0] = 98
+  return $b
+}
+func zero() { return chunked([1], 0) }
+func frac() { return windows([1], 1.5) }
+func huge() { return windows([0 .. 20000], 10000) }
+func notArray() { return chunked("abc", 1) }
+`
+	if err := vm.LoadSource("inline", src); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	res, err := vm.Call(context.Background(), "run")
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	want := []any{
+		[]any{[]any{1.0, 2.0}, []any{3.0, 4.0}, []any{5.0, 6.0}},
+		[]any{[]any{1.0, 2.0}, []any{3.0, 4.0}, []any{5.0}},
+		[]any{[]any{1.0, 2.0, 3.0, 4.0, 5.0}},
+		[]any{},
+		[]any{[]any{1.0, 2.0, 3.0}, []any{2.0, 3.0, 4.0}, []any{3.0, 4.0, 5.0}},
+		[]any{},
+		[]any{1.0, 2.0, 3.0, 4.0, 5.0},
+	}
+	if got := res.MustRaw(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected results\n got %#v\nwant %#v", got, want)
+	}
+	res, err = vm.Call(context.Background(), "isolated")
+	if err != nil {
+		t.Fatalf("isolated: %v", err)
+	}
+	if got := res.MustRaw(); !reflect.DeepEqual(got, []any{1.0, 2.0, 3.0}) {
+		t.Fatalf("expected input untouched, got %#v", got)
+	}
+	for name, msg := range map[string]string{
+		"zero":     "chunked expects positive integer size",
+		"frac":     "windows expects positive integer size",
+		"huge":     "windows result too large",
+		"notArray": "chunked expects array",
+	} {
+		if _, err := vm.Call(context.Background(), name); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Fatalf("%s: expected %q, got %v", name, msg, err)
+		}
+	}
+}
+
+func TestAPIBuiltinIndexOf(t *testing.T) {
+	vm := NewVM()
+	src := `
+func run() {
+  $a := [1, "x", 2, "x", null]
+  return [
+    indexOf($a, "x"), lastIndexOf($a, "x"), indexOf($a, null), indexOf($a, 3), lastIndexOf($a, "1"),
+    indexOf("banana", "an"), lastIndexOf("banana", "an"), indexOf("banana", "z"), indexOf("abc", ""), lastIndexOf("abc", "")
+  ]
+}
+func badKind() { return indexOf({a: 1}, 1) }
+func badNeedle() { return lastIndexOf("abc", 1) }
+`
+	if err := vm.LoadSource("inline", src); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	res, err := vm.Call(context.Background(), "run")
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	want := []any{1.0, 3.0, 4.0, -1.0, -1.0, 1.0, 3.0, -1.0, 0.0, 3.0}
+	if got := res.MustRaw(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected positions %#v", got)
+	}
+	if _, err := vm.Call(context.Background(), "badKind"); err == nil || !strings.Contains(err.Error(), "indexOf expects array or string, got object") {
+		t.Fatalf("expected kind error, got %v", err)
+	}
+	if _, err := vm.Call(context.Background(), "badNeedle"); err == nil || !strings.Contains(err.Error(), "lastIndexOf on string expects string value") {
+		t.Fatalf("expected needle error, got %v", err)
+	}
+}
+
+func TestAPIBuiltinRepeat(t *testing.T) {
+	vm := NewVM()
+	src := `
+func run() {
+  return [repeat("ab", 3), repeat("-", 0), repeat("", 1000000000), repeat([1, [2]], 2), repeat([1], 0)]
+}
+func negative() { return repeat("a", -1) }
+func huge() { return repeat("abc", 100000000) }
+func badKind() { return repeat(5, 2) }
+`
+	if err := vm.LoadSource("inline", src); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	res, err := vm.Call(context.Background(), "run")
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	want := []any{"ababab", "", "", []any{1.0, []any{2.0}, 1.0, []any{2.0}}, []any{}}
+	if got := res.MustRaw(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected results %#v", got)
+	}
+	for name, msg := range map[string]string{
+		"negative": "repeat expects non-negative integer count",
+		"huge":     "repeat result too large",
+		"badKind":  "repeat expects string or array, got number",
+	} {
+		if _, err := vm.Call(context.Background(), name); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Fatalf("%s: expected %q, got %v", name, msg, err)
+		}
+	}
+}
+
+func TestAPIBuiltinSortKeys(t *testing.T) {
+	vm := NewVM()
+	src := `
+func run() {
+  $o := {b: 2, "a c": [1], a: 1, 10: "ten", B: null}
+  return [sortKeys($o), sortKeys({})]
+}
+func entries() {
+  $keys := ""
+  for ([$i, $pair] in sortKeys({z: 1, y: 2, x: 3})) {
+    $keys = [$keys, $pair[0]]
+  }
+  return $keys
+}
+func bad() { return sortKeys([1]) }
+`
+	if err := vm.LoadSource("inline", src); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	res, err := vm.Call(context.Background(), "run")
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	want := []any{
+		[]any{[]any{"10", "ten"}, []any{"B", nil}, []any{"a", 1.0}, []any{"a c", []any{1.0}}, []any{"b", 2.0}},
+		[]any{},
+	}
+	if got := res.MustRaw(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected sorted entries %#v", got)
+	}
+	res, err = vm.Call(context.Background(), "entries")
+	if err != nil {
+		t.Fatalf("entries: %v", err)
+	}
+	if got := res.MustRaw(); !reflect.DeepEqual(got, []any{[]any{[]any{"", "x"}, "y"}, "z"}) {
+		t.Fatalf("unexpected iteration order %#v", got)
+	}
+	if _, err := vm.Call(context.Background(), "bad"); err == nil || !strings.Contains(err.Error(), "sortKeys expects object, got array") {
+		t.Fatalf("expected type error, got %v", err)
+	}
+}
+
+func TestAPIBuiltinProp(t *testing.T) {
+	vm := NewVM()
+	src := `
+func run() {
+  $o := {name: "flux", empty: null}
+  return [prop($o, "name", "dflt"), prop($o, "missing", "dflt"), prop($o, "empty", "dflt"), prop({}, "x", [1])]
+}
+func notObject() { return prop([1, 2], "0", null) }
+func nullTarget() { return prop(null, "x", 1) }
+func badKey() { return prop({a: 1}, 1, null) }
+`
+	if err := vm.LoadSource("inline", src); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	res, err := vm.Call(context.Background(), "run")
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	// A key present with a null value is still present, so the default is not used.
+	if got := res.MustRaw(); !reflect.DeepEqual(got, []any{"flux", "dflt", nil, []any{1.0}}) {
+		t.Fatalf("unexpected prop results %#v", got)
+	}
+	for name, msg := range map[string]string{
+		"notObject":  "prop expects object, got array",
+		"nullTarget": "prop expects object, got null",
+		"badKey":     "prop expects string key, got number",
+	} {
+		if _, err := vm.Call(context.Background(), name); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Fatalf("%s: expected %q, got %v", name, msg, err)
+		}
+	}
+}
+
+func TestAPIBuiltinLen(t *testing.T) {
+	vm := NewVM()
+	src := `
+func run() {
+  $s := "héllo"
+  return [len([1, [2, 3], null]), len([]), len({a: 1, b: 2}), len({}), len($s), len($s) == $s.length, len("")]
+}
+func badNumber() { return len(3) }
+func badNull() { return len(null) }
+`
+	if err := vm.LoadSource("inline", src); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	res, err := vm.Call(context.Background(), "run")
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	// Strings count bytes, matching .length: é is two bytes in UTF-8.
+	if got := res.MustRaw(); !reflect.DeepEqual(got, []any{3.0, 0.0, 2.0, 0.0, 6.0, true, 0.0}) {
+		t.Fatalf("unexpected len results %#v", got)
+	}
+	for name, msg := range map[string]string{
+		"badNumber": "len expects array, object, or string, got number",
+		"badNull":   "len expects array, object, or string, got null",
+	} {
+		if _, err := vm.Call(context.Background(), name); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Fatalf("%s: expected %q, got %v", name, msg, err)
+		}
+	}
+}
+
+func TestAPIBuiltinScan(t *testing.T) {
+	vm := NewVM()
+	src := `
+func add($a, $b) { return $a + $b }
+func run() {
+  $pairs := scan(["a", "b"], func ($acc, $s) { return [$acc, $s] }, null)
+  return [scan([1, 2, 3], add, 0), scan([], add, 5), $pairs]
+}
+func failing() { return scan([1, "x"], add, 0) }
+func badFn() { return scan([1], 1, 0) }
+func badArr() { return scan({a: 1}, add, 0) }
+`
+	if err := vm.LoadSource("inline", src); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	res, err := vm.Call(context.Background(), "run")
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	want := []any{[]any{1.0, 3.0, 6.0}, []any{}, []any{[]any{nil, "a"}, []any{[]any{nil, "a"}, "b"}}}
+	if got := res.MustRaw(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected scan results %#v", got)
+	}
+	if _, err := vm.Call(context.Background(), "failing"); err == nil {
+		t.Fatalf("expected callback error to propagate")
+	}
+	for name, msg := range map[string]string{"badFn": "scan expects function, got number", "badArr": "scan expects array, got object"} {
+		if _, err := vm.Call(context.Background(), name); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Fatalf("%s: expected %q, got %v", name, msg, err)
+		}
+	}
+}
+
+func TestAPIBuiltinTryCall(t *testing.T) {
+	vm := NewVM()
+	src := `
+func at($arr, $i) { return $arr[$i] }
+func outOfBounds() { return tryCall(at, [[1, 2], 5]) }
+func ok() { return tryCall(at, [[1, 2], 1]) }
+func recover() {
+  $r := tryCall(func () { return [1][3] }, [])
+  if (typeof($r) == "error") { return "recovered" }
+  return $r
+}
+func badFn() { return tryCall(1, []) }
+func badArgs() { return tryCall(at, 1) }
+`
+	if err := vm.LoadSource("inline", src); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	ctx := context.Background()
+	res, err := vm.Call(ctx, "outOfBounds")
+	if err != nil {
+		t.Fatalf("expected tryCall to absorb the runtime error, got %v", err)
+	}
+	msg, ok := res.ErrorString()
+	if !ok || !strings.Contains(msg, "out of") {
+		t.Fatalf("expected out-of-bounds error value, got %v (%v)", res.Kind(), msg)
+	}
+	if strings.Contains(msg, "inline:") {
+		t.Fatalf("expected bare message without location, got %q", msg)
+	}
+	if res, err := vm.Call(ctx, "ok"); err != nil || res.MustRaw() != 2.0 {
+		t.Fatalf("expected 2, got %v (%v)", res, err)
+	}
+	if res, err := vm.Call(ctx, "recover"); err != nil || res.MustRaw() != "recovered" {
+		t.Fatalf("expected recovered, got %v (%v)", res, err)
+	}
+	for name, want := range map[string]string{"badFn": "tryCall expects function, got number", "badArgs": "tryCall expects array of arguments, got number"} {
+		if _, err := vm.Call(ctx, name); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("%s: expected %q, got %v", name, want, err)
+		}
+	}
+}
+
+func TestAPIBuiltinAsValue(t *testing.T) {
+	vm := NewVM()
+	src := `
+func apply($f, $v) { return $f($v) }
+func run() {
+  $t := typeof
+  return [apply(typeof, 1), $t("s"), apply(typeof, [1]), typeof(typeof)]
+}
+func guarded() { return [tryCall(indexRead, [[1, 2], 5, "dflt"]), tryCall(error, ["boom"])] }
+func wrongArity() { $t := typeof; return $t(1, 2) }
+func failing() { return apply(error, 1) }
+func hashed() { $h := hash; return $h([1]) == hash([1]) }
+`
+	if err := vm.LoadSource("inline", src); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	ctx := context.Background()
+	if err := vm.SetGlobalFunction("typeof", NewFunction([]string{"v"}, func(*Context, map[string]VmValue) (VmValue, error) {
+		return MustValue("host"), nil
+	})); err == nil || !strings.Contains(err.Error(), "shadows builtin typeof") {
+		t.Fatalf("expected builtin shadow error, got %v", err)
+	}
+	res, err := vm.Call(ctx, "run")
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if got := res.MustRaw(); !reflect.DeepEqual(got, []any{"number", "string", "array", "function"}) {
+		t.Fatalf("run: unexpected %v", got)
+	}
+	res, err = vm.Call(ctx, "guarded")
+	if err != nil {
+		t.Fatalf("guarded: %v", err)
+	}
+	if got := res.MustRaw(); !reflect.DeepEqual(got, []any{"dflt", errors.New("boom")}) {
+		t.Fatalf("guarded: unexpected %#v", got)
+	}
+	if res, err := vm.Call(ctx, "hashed"); err != nil || res.MustRaw() != true {
+		t.Fatalf("hashed: %v (%v)", res, err)
+	}
+	if _, err := vm.Call(ctx, "wrongArity"); err == nil || !strings.Contains(err.Error(), "builtin typeof expects 1 args, got 2") {
+		t.Fatalf("expected arity error, got %v", err)
+	}
+	if _, err := vm.Call(ctx, "failing"); err == nil || !strings.Contains(err.Error(), "error expects string") {
+		t.Fatalf("expected builtin error to propagate, got %v", err)
+	}
+
+	if err := vm.DisableBuiltin("typeof"); err != nil {
+		t.Fatalf("disable: %v", err)
+	}
+	if _, err := vm.Call(ctx, "run"); err == nil || !strings.Contains(err.Error(), "builtin typeof is disabled") {
+		t.Fatalf("expected disabled builtin error through a value, got %v", err)
+	}
+
+	info, err := Inspect(src)
+	if err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	if len(info.Globals) != 0 || !reflect.DeepEqual(info.Builtins, []string{"error", "hash", "indexRead", "tryCall", "typeof"}) {
+		t.Fatalf("unexpected inspect info %+v", info)
+	}
+}
+
+func TestAPIModuloOperator(t *testing.T) {
+	vm := NewVM()
+	src := `
+func mods() { return [7 % 3, -7 % 3, 7 % -3, 7.5 % 2, 2 + 7 % 4 * 2] }
+func even($n) { return $n % 2 == 0 }
+func byZero($n) { return $n % 0 }
+func badOperand() { return "7" % 2 }
+`
+	if err := vm.LoadSource("inline", src); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	ctx := context.Background()
+	res, err := vm.Call(ctx, "mods")
+	if err != nil {
+		t.Fatalf("mods: %v", err)
+	}
+	want := []any{1.0, -1.0, 1.0, 1.5, 8.0}
+	if got := res.MustRaw(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for n, want := range map[float64]bool{4: true, 5: false, -2: true} {
+		res, err := vm.Call(ctx, "even", MustValue(n))
+		if err != nil || res.MustRaw() != want {
+			t.Fatalf("even(%v): expected %v, got %v (%v)", n, want, res, err)
+		}
+	}
+	if _, err := vm.Call(ctx, "byZero", MustValue(5)); err == nil || !strings.Contains(err.Error(), "modulo by zero") {
+		t.Fatalf("expected modulo by zero error, got %v", err)
+	}
+	if _, err := vm.Call(ctx, "badOperand"); err == nil || !strings.Contains(err.Error(), "operands must be numbers") {
+		t.Fatalf("expected operand type error, got %v", err)
+	}
+}
+
+func TestAPICompoundAssignment(t *testing.T) {
+	vm := NewVM()
+	src := `
+func locals() {
+  $x := 1
+  $x += 2
+  $x *= 5
+  $x -= 3
+  $x /= 4
+  $x %= 2
+  return $x
+}
+func targets() {
+  $n := 0
+  $o := {count: 1, items: [10, 20]}
+  $get := func () { $n += 1; return $o }
+  $at := func ($i) { $n += 10; return $i }
+  $get().count += 5
+  $get().items[$at(1)] -= 5
+  $o.items[0] *= 3
+  return [$o.count, $o.items, $n]
+}
+func bump($cfg) { $cfg.count += 1 }
+func bumpIndex($cfg) { $cfg.items[0] += 1 }
+`
+	if err := vm.LoadSource("inline", src); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	ctx := context.Background()
+	res, err := vm.Call(ctx, "locals")
+	if err != nil || res.MustRaw() != 1.0 {
+		t.Fatalf("locals: expected 1, got %v (%v)", res, err)
+	}
+	res, err = vm.Call(ctx, "targets")
+	if err != nil {
+		t.Fatalf("targets: %v", err)
+	}
+	// Each target expression runs once: two $get() calls and one $at() call.
+	want := []any{6.0, []any{30.0, 15.0}, 12.0}
+	if got := res.MustRaw(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("targets: expected %v, got %v", want, got)
+	}
+
+	cfg := MustValueReadOnly(map[string]any{"count": 1, "items": []any{1}})
+	for _, name := range []string{"bump", "bumpIndex"} {
+		if _, err := vm.Call(ctx, name, cfg); err == nil || !strings.Contains(err.Error(), "cannot modify read-only value") {
+			t.Fatalf("%s: expected read-only error, got %v", name, err)
+		}
+	}
+
+	for snippet, msg := range map[string]string{
+		"func f() { const $c := 1\n$c += 1 }": "cannot assign to constant $c",
+		"func f() { $_ += 1 }":                "cannot read discard variable $_",
+		"func f() { [$a, $b] += 1 }":          "invalid compound assignment target",
+	} {
+		if err := NewVM().LoadSource("bad", snippet); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Fatalf("%q: expected %q, got %v", snippet, msg, err)
+		}
+	}
+}
+
+func TestAPIBreakContinue(t *testing.T) {
+	vm := NewVM()
+	src := `
+func firstOver($arr, $limit) {
+  $found := null
+  for ($v in $arr) {
+    if ($v > $limit) {
+      $found = $v
+      break
+    }
+  }
+  return $found
+}
+func oddSum($n) {
+  $i := 0
+  $sum := 0
+  while ($i < $n) {
+    $i += 1
+    if ($i % 2 == 0) { continue }
+    $sum += $i
+  }
+  return $sum
+}
+func nested() {
+  $sum := 0
+  $rounds := 0
+  while (true) {
+    $rounds += 1
+    if ($rounds > 200) { break }
+    for ($row in [[1, 2, 3], [4, 5, 6]]) {
+      for ($v in $row) {
+        if ($v == 2) { continue }
+        if ($v == 5) { break }
+        $sum += $v
+      }
+    }
+  }
+  return [$rounds, $sum]
+}
+`
+	if err := vm.LoadSource("inline", src); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	ctx := context.Background()
+	res, err := vm.Call(ctx, "firstOver", MustValue([]any{1, 5, 9, 12}), MustValue(6))
+	if err != nil || res.MustRaw() != 9.0 {
+		t.Fatalf("firstOver: expected 9, got %v (%v)", res, err)
+	}
+	res, err = vm.Call(ctx, "oddSum", MustValue(10))
+	if err != nil || res.MustRaw() != 25.0 {
+		t.Fatalf("oddSum: expected 25, got %v (%v)", res, err)
+	}
+	res, err = vm.Call(ctx, "nested")
+	if err != nil {
+		t.Fatalf("nested: %v", err)
+	}
+	if got := res.MustRaw(); !reflect.DeepEqual(got, []any{201.0, 1600.0}) {
+		t.Fatalf("nested: expected [201 1600], got %v", got)
+	}
+	// Breaking out of a for loop pops its iterator, so 200 rounds do not grow the stack.
+	if peak := vm.LastCallStats().PeakStack; peak > 10 {
+		t.Fatalf("expected a balanced stack, peak was %d", peak)
+	}
+
+	for snippet, msg := range map[string]string{
+		"func f() {\n  break\n}": "break outside loop",
+		"func f() {\n  $g := func () { continue }\n  while (true) { $g() }\n}": "continue outside loop",
+	} {
+		if err := NewVM().LoadSource("bad", snippet); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Fatalf("%q: expected %q, got %v", snippet, msg, err)
+		}
+	}
+}
+
+func TestAPIGenerators(t *testing.T) {
+	vm := NewVM()
+	var closed []float64
+	if err := vm.SetGlobalFunction("closed", NewFunction([]string{"n"}, func(_ *Context, args map[string]VmValue) (VmValue, error) {
+		n, _ := args["n"].Number()
+		closed = append(closed, n)
+		return NewValue(nil)
+	})); err != nil {
+		t.Fatalf("set global: %v", err)
+	}
+	src := `
+func count($n) {
+  defer closed($n)
+  $i := 0
+  while ($i < $n) {
+    yield $i
+    $i += 1
+  }
+  return "ignored"
+}
+func naturals() {
+  $i := 0
+  while (true) {
+    yield $i
+    $i += 1
+  }
+}
+func counter() {
+  $n := 0
+  yield func () { $n += 10 }
+  yield $n
+  $n += 10
+  yield $n
+}
+func bad() {
+  yield 1
+  yield 1 / "x"
+}
+func selfish($box) {
+  yield 1
+  for ($v in $box.g) { }
+}
+func doubled() {
+  $out := {}
+  for ([$k, $v] in count(3)) { $out[$k] = $v * 2 }
+  return $out
+}
+func firstOver($limit) {
+  for ($v in naturals()) {
+    if ($v > $limit) { return $v }
+  }
+}
+func total() {
+  $acc := {sum: 0}
+  iterate count(5) using func ($v) { $acc.sum += $v }
+  $bag := {items: [1, 2, 3], each: func $self() { for ($v in $self.items) { yield $v * 100 } }}
+  iterate $bag.each() using func ($v) { $acc.sum += $v }
+  iterate [1000] using func ($v) { $acc.sum += $v }
+  return $acc.sum
+}
+func shared() {
+  $out := {}
+  for ([$k, $v] in counter()) {
+    if ($k == "0") { $v() } else { $out[$k] = $v }
+  }
+  return $out
+}
+func consumeBad() { for ($v in bad()) { } }
+func reenter() {
+  $box := {}
+  $box.g = selfish($box)
+  for ($v in $box.g) { }
+}
+`
+	if err := vm.LoadSource("gen", src); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	ctx := context.Background()
+
+	res, err := vm.Call(ctx, "doubled")
+	if err != nil {
+		t.Fatalf("doubled: %v", err)
+	}
+	if got := res.MustRaw(); !reflect.DeepEqual(got, map[string]any{"0": 0.0, "1": 2.0, "2": 4.0}) {
+		t.Fatalf("doubled: unexpected %v", got)
+	}
+	// Deferred calls run when the generator body returns, not at each yield.
+	if !reflect.DeepEqual(closed, []float64{3}) {
+		t.Fatalf("expected count(3) to close once, got %v", closed)
+	}
+
+	// Values are produced on demand, so an endless generator can be left early.
+	if res, err := vm.Call(ctx, "firstOver", MustValue(5)); err != nil || res.MustRaw() != 6.0 {
+		t.Fatalf("firstOver: %v (%v)", res, err)
+	}
+	if res, err := vm.Call(ctx, "total"); err != nil || res.MustRaw() != 1610.0 {
+		t.Fatalf("total: %v (%v)", res, err)
+	}
+	// A closure made inside the generator shares its locals across suspensions.
+	res, err = vm.Call(ctx, "shared")
+	if err != nil {
+		t.Fatalf("shared: %v", err)
+	}
+	if got := res.MustRaw(); !reflect.DeepEqual(got, map[string]any{"1": 10.0, "2": 20.0}) {
+		t.Fatalf("shared: unexpected %v", got)
+	}
+
+	_, err = vm.Call(ctx, "consumeBad")
+	var rte *RuntimeError
+	if !errors.As(err, &rte) || rte.Message != "operands must be numbers" || rte.Frame.Function != "bad" {
+		t.Fatalf("expected error raised inside bad, got %v", err)
+	}
+	if _, err := vm.Call(ctx, "reenter"); err == nil || !strings.Contains(err.Error(), "generator is already running") {
+		t.Fatalf("expected re-entry error, got %v", err)
+	}
+
+	// Hosts drive a generator returned from a call through its iterator handle.
+	closed = nil
+	res, err = vm.Call(ctx, "count", MustValue(2))
+	if err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	it, ok := res.AsIterator()
+	if !ok {
+		t.Fatalf("expected iterator, got %v", res)
+	}
+	var keys []string
+	var values []any
+	for {
+		key, val, ok, err := it.Next()
+		if err != nil {
+			t.Fatalf("next: %v", err)
+		}
+		if !ok {
+			break
+		}
+		keys = append(keys, key)
+		values = append(values, val.MustRaw())
+	}
+	if !reflect.DeepEqual(keys, []string{"0", "1"}) || !reflect.DeepEqual(values, []any{0.0, 1.0}) || !reflect.DeepEqual(closed, []float64{2}) {
+		t.Fatalf("host iteration: keys %v values %v closed %v", keys, values, closed)
+	}
+	if _, _, ok, err := it.Next(); ok || err != nil {
+		t.Fatalf("expected finished generator to stay finished, got ok=%v err=%v", ok, err)
+	}
+
+	// Advancing a generator runs its VM, so it is refused while a call is in progress there.
+	res, err = vm.Call(ctx, "count", MustValue(2))
+	if err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	pending, _ := res.AsIterator()
+	var busyErr error
+	advance := NewFunction(nil, func(*Context, map[string]VmValue) (VmValue, error) {
+		_, _, _, busyErr = pending.Next()
+		return NewValue(nil)
+	})
+	if err := vm.SetGlobalFunction("advance", advance); err != nil {
+		t.Fatalf("bind: %v", err)
+	}
+	if _, err := vm.Eval(ctx, "advance()"); err != nil {
+		t.Fatalf("eval: %v", err)
+	}
+	if !errors.Is(busyErr, ErrVMBusy) {
+		t.Fatalf("expected ErrVMBusy advancing during a call, got %v", busyErr)
+	}
+	if key, val, ok, err := pending.Next(); err != nil || !ok || key != "0" || val.MustRaw() != 0.0 {
+		t.Fatalf("expected generator to resume once idle, got %q %v %v %v", key, val, ok, err)
+	}
+
+	if err := NewVM().LoadSource("bad", "func f() {\n  yield\n}"); err == nil || !strings.Contains(err.Error(), "yield requires a value") {
+		t.Fatalf("expected bare yield parse error, got %v", err)
+	}
+}
+
+func TestAPILengthPseudoProperty(t *testing.T) {
+	vm := NewVM()
+	src := `
+func lengths() {
+  $arr := [1, 2, 3]
+  return [[1, 2, 3].length, "abc".length, $arr.length, "".length, [].length]
+}
+func objLength() { return {length: 7}.length }
+func objMissing() { return {}.length }
+func other() { return [1].size }
+`
+	if err := vm.LoadSource("inline", src); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	ctx := context.Background()
+	res, err := vm.Call(ctx, "lengths")
+	if err != nil {
+		t.Fatalf("lengths: %v", err)
+	}
+	if got := res.MustRaw(); !reflect.DeepEqual(got, []any{3.0, 3.0, 3.0, 0.0, 0.0}) {
+		t.Fatalf("unexpected lengths %#v", got)
+	}
+	res, err = vm.Call(ctx, "objLength")
+	if err != nil {
+		t.Fatalf("objLength: %v", err)
+	}
+	if res.MustRaw() != 7.0 {
+		t.Fatalf("expected object length key 7, got %#v", res.MustRaw())
+	}
+	if _, err := vm.Call(ctx, "objMissing"); err == nil || !strings.Contains(err.Error(), "missing property length") {
+		t.Fatalf("expected missing property error, got %v", err)
+	}
+	if _, err := vm.Call(ctx, "other"); err == nil || !strings.Contains(err.Error(), "property access on non-object") {
+		t.Fatalf("expected non-object error, got %v", err)
+	}
+}
+
+func TestAPIMethodReceiver(t *testing.T) {
+	vm := NewVM()
+	src := `
+func run() {
+  $o := {
+    base: 10,
+    add: func $self($n) { return $self.base + $n },
+    plain: func ($a, $b) { return $a - $b },
+  }
+  $o.scale = func $me($k) { return $me.add(1) * $k }
+  $detached := $o.add
+  return [$o.add(5), $o.plain(5, 3), $o.scale(2), typeof($detached)]
+}
+func detached() {
+  $f := func $self() { return $self }
+  return $f()
+}
+`
+	if err := vm.LoadSource("inline", src); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	res, err := vm.Call(context.Background(), "run")
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if got := res.MustRaw(); !reflect.DeepEqual(got, []any{15.0, 2.0, 22.0, "function"}) {
+		t.Fatalf("unexpected method results %#v", got)
+	}
+	res, err = vm.Call(context.Background(), "detached")
+	if err != nil {
+		t.Fatalf("detached: %v", err)
+	}
+	if res.MustRaw() != nil {
+		t.Fatalf("expected null receiver outside a member call, got %#v", res.MustRaw())
+	}
+}
+
+func TestAPIFunctionHandleCallNamed(t *testing.T) {
+	vm := NewVM()
+	if err := vm.LoadSource("inline", `func makeDiv() { return func($num, $den) { return $num / $den } }`); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	res, err := vm.CallAsync(context.Background(), "makeDiv", nil).Await(context.Background())
+	if err != nil {
+		t.Fatalf("call: %v", err)
+	}
+	fn, ok := res.AsFunction()
+	if !ok {
+		t.Fatalf("expected function, got %#v", res.MustRaw())
+	}
+	if fn.Arity() != 2 {
+		t.Fatalf("expected arity 2, got %d", fn.Arity())
+	}
+	if want := []string{"num", "den"}; !reflect.DeepEqual(fn.ParamNames(), want) {
+		t.Fatalf("expected params %v, got %v", want, fn.ParamNames())
+	}
+	out, err := fn.CallNamed(context.Background(), map[string]VmValue{"den": MustValue(4), "$num": MustValue(10)})
+	if err != nil {
+		t.Fatalf("call named: %v", err)
+	}
+	if v, ok := out.MustRaw().(float64); !ok || v != 2.5 {
+		t.Fatalf("expected 2.5, got %#v", out.MustRaw())
+	}
+	if _, err := fn.CallNamed(context.Background(), map[string]VmValue{"nope": MustValue(1)}); err == nil {
+		t.Fatalf("expected unknown parameter error")
+	}
+}
+
+func TestAPIFunctionMapMarshal(t *testing.T) {
+	vm := NewVM()
+	script := `
+func call($ns) {
+  $a := $ns.add(2, 3)
+  $b := $ns.greet("hi")
+  $r := readonly($ns)
+  return [$a, $b, $r]
+}
+func boom($ns) { return $ns.fail() }`
+	if err := vm.LoadSource("rpc", script); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	funcs := map[string]any{
+		"add": func(a int, b int) int { return a + b },
+		"greet": func(s string) (string, error) {
+			return s + "!", nil
+		},
+		"fail": func() error {
+			return fmt.Errorf("nope")
+		},
+	}
+	ns := MustMarshalFunctionMap(funcs)
+	if !ns.IsReadOnly() {
+		t.Fatalf("expected namespace to be read-only")
+	}
+
+	callRes, err := vm.CallAsync(context.Background(), "call", []VmValue{ns}).Await(context.Background())
+	if err != nil {
+		t.Fatalf("call error: %v", err)
+	}
+	arr, ok := callRes.Array()
+	if !ok || len(arr) != 3 {
+		t.Fatalf("unexpected call result %#v", callRes)
+	}
+	if n, _ := arr[0].Number(); n != 5 {
+		t.Fatalf("expected add to return 5, got %#v", arr[0])
+	}
+	if s, _ := arr[1].String(); s != "hi!" {
+		t.Fatalf("expected greet result hi!, got %#v", arr[1])
+	}
+	if arr[2].Kind() != ValueBool || arr[2].MustRaw().(bool) != true {
+		t.Fatalf("expected readonly($ns) to return true, got %#v", arr[2])
+	}
+
+	if _, err := vm.CallAsync(context.Background(), "boom", []VmValue{ns}).Await(context.Background()); err == nil || !strings.Contains(err.Error(), "nope") {
+		t.Fatalf("expected boom to propagate error nope, got %v", err)
+	}
+}
+
+func TestAPIHostFunctionNullInterfaceArg(t *testing.T) {
+	vm := NewVM()
+	script := `func run($ns) { return $ns.get("key", null) }`
+	if err := vm.LoadSource("inline", script); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	ns := MustMarshalFunctionMap(map[string]any{
+		"get": func(key string, fallback any) any {
+			if key != "key" {
+				return "bad"
+			}
+			return fallback
+		},
+	})
+	res, err := vm.CallAsync(context.Background(), "run", []VmValue{ns}).Await(context.Background())
+	if err != nil {
+		t.Fatalf("call error: %v", err)
+	}
+	if !res.IsNull() {
+		t.Fatalf("expected null result, got %#v", res)
+	}
+}
+
+func TestAPIScriptErrorPromotion(t *testing.T) {
+	script := `func boom() { return error("boom") }`
+
+	// Default: error value returned, Go error nil.
+	vm := NewVM()
+	if err := vm.LoadSource("inline", script); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	val, err := vm.CallAsync(context.Background(), "boom", nil).Await(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected Go error: %v", err)
+	}
+	if val.Kind() != ValueError {
+		t.Fatalf("expected ValueError, got %v", val.Kind())
+	}
+	if msg, ok := val.ErrorString(); !ok || msg != "boom" {
+		t.Fatalf("unexpected error string %q ok=%v", msg, ok)
+	}
+
+	// Promotion: both Value and Err set.
+	vm2 := NewVM()
+	if err := vm2.LoadSource("inline", script); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	vm2.SetErrorResultAsError(true)
+	val2, err := vm2.CallAsync(context.Background(), "boom", nil).Await(context.Background())
+	if err == nil || err.Error() != "boom" {
+		t.Fatalf("expected propagated Go error 'boom', got %v", err)
+	}
+	if val2.Kind() != ValueError {
+		t.Fatalf("expected ValueError, got %v", val2.Kind())
+	}
+}
+
+func TestAPIRuntimeErrorDiagnostics(t *testing.T) {
+	vm := NewVM()
+	src := `func inner($arr) {
+  return $arr[5]
+}
+
+func outer() {
+  return inner([1, 2, 3])
+}`
+	if err := vm.LoadSource("diag", src); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	_, err := vm.CallAsync(context.Background(), "outer", nil).Await(context.Background())
+	if err == nil {
+		t.Fatalf("expected runtime error")
+	}
+	rte, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("expected RuntimeError, got %T", err)
+	}
+	if rte.Frame.Function != "inner" {
+		t.Fatalf("expected top frame inner, got %q", rte.Frame.Function)
+	}
+	if rte.Frame.Source != "diag" {
+		t.Fatalf("expected source diag, got %q", rte.Frame.Source)
+	}
+	if rte.Frame.Line != 2 {
+		t.Fatalf("expected line 2, got %d", rte.Frame.Line)
+	}
+	if len(rte.Stack) < 2 {
+		t.Fatalf("expected at least 2 frames, got %d", len(rte.Stack))
+	}
+	if rte.Stack[1].Function != "outer" {
 		t.Fatalf("expected caller outer, got %q", rte.Stack[1].Function)
 	}
 }
 
-func TestAPITraceHook(t *testing.T) {
+func TestAPICallNonFunctionGlobal(t *testing.T) {
+	vm := NewVM()
+	if err := vm.LoadSource("inline", `func init() { $foo = 42 }`); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if _, err := vm.CallAsync(context.Background(), "init", nil).Await(context.Background()); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	_, err := vm.CallAsync(context.Background(), "foo", nil).Await(context.Background())
+	rte, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("expected RuntimeError, got %T (%v)", err, err)
+	}
+	if want := `global "foo" is not callable (it is a number)`; rte.Message != want {
+		t.Fatalf("expected %q, got %q", want, rte.Message)
+	}
+}
+
+func TestAPITraceHook(t *testing.T) {
+	vm := NewVM()
+	var traces []TraceInfo
+	vm.SetTraceHook(func(info TraceInfo) {
+		traces = append(traces, info)
+	})
+	if err := vm.LoadSource("trace", `func demo() { return 1 + 2 }`); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if _, err := vm.CallAsync(context.Background(), "demo", nil).Await(context.Background()); err != nil {
+		t.Fatalf("call: %v", err)
+	}
+	if len(traces) == 0 {
+		t.Fatalf("expected trace events")
+	}
+	for _, tr := range traces {
+		if tr.Function != "demo" {
+			t.Fatalf("expected function demo in trace, got %q", tr.Function)
+		}
+		if tr.Source != "trace" {
+			t.Fatalf("expected trace source, got %q", tr.Source)
+		}
+		if tr.Line == 0 {
+			t.Fatalf("expected line info in trace")
+		}
+	}
+}
+
+func TestAPIValueTraceHook(t *testing.T) {
+	vm := NewVM()
+	var tops []any
+	vm.SetValueTraceHook(func(info TraceInfo, top VmValue) {
+		tops = append(tops, top.MustRaw())
+	})
+	if err := vm.LoadSource("trace", `func demo() { return 1 + 2 }`); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if _, err := vm.CallAsync(context.Background(), "demo", nil).Await(context.Background()); err != nil {
+		t.Fatalf("call: %v", err)
+	}
+	// before: CONST 1 (empty), CONST 2 (1), ADD (2), RETURN (3)
+	want := []any{nil, float64(1), float64(2), float64(3)}
+	if !reflect.DeepEqual(tops, want) {
+		t.Fatalf("expected traced tops %v, got %v", want, tops)
+	}
+}
+
+func TestAPIValueTraceHookDuplicateOwner(t *testing.T) {
+	vm := NewVM()
+	var last VmValue
+	vm.SetValueTraceHook(func(info TraceInfo, top VmValue) { last = top })
+	if err := vm.LoadSource("trace", `func empty() { return [] }`); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	dup, err := vm.Duplicate()
+	if err != nil {
+		t.Fatalf("duplicate: %v", err)
+	}
+	// Only the copy treats empty collections as falsy, so truthiness reveals the owner.
+	dup.SetEmptyCollectionsFalsy(true)
+	if _, err := dup.Call(context.Background(), "empty"); err != nil {
+		t.Fatalf("call: %v", err)
+	}
+	if last.IsTruthy() {
+		t.Fatalf("expected traced value to be owned by the duplicate")
+	}
+}
+
+func TestAPICoverage(t *testing.T) {
+	vm := NewVM()
+	if cov := vm.Coverage(); cov != nil {
+		t.Fatalf("expected nil coverage before enabling, got %v", cov)
+	}
+	vm.EnableCoverage()
+	src := "func pick($x) {\n\tif ($x > 0) {\n\t\treturn \"pos\"\n\t}\n\treturn \"neg\"\n}\n"
+	if err := vm.LoadSource("rules", src); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	call := func(x int) {
+		if _, err := vm.CallAsync(context.Background(), "pick", []VmValue{MustValue(x)}).Await(context.Background()); err != nil {
+			t.Fatalf("call: %v", err)
+		}
+	}
+	call(1)
+	if want := map[string][]int{"rules": {2, 3}}; !reflect.DeepEqual(vm.Coverage(), want) {
+		t.Fatalf("expected coverage %v, got %v", want, vm.Coverage())
+	}
+	call(-1)
+	if want := map[string][]int{"rules": {2, 3, 5}}; !reflect.DeepEqual(vm.Coverage(), want) {
+		t.Fatalf("expected coverage %v, got %v", want, vm.Coverage())
+	}
+}
+
+func TestAPIProfile(t *testing.T) {
+	vm := NewVM()
+	vm.EnableProfiling()
+	err := vm.LoadSource("prof", `
+func hot($x) {
+	$y := $x * 2
+	$y = $y + 1
+	return $y - $x
+}
+func main() {
+	$sum := 0
+	for ($i in [0 .. 50]) {
+		$sum = $sum + hot($i)
+	}
+	return $sum
+}`)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if _, err := vm.CallAsync(context.Background(), "main", nil).Await(context.Background()); err != nil {
+		t.Fatalf("call: %v", err)
+	}
+	prof := vm.Profile()
+	hot, main := prof["hot"], prof["main"]
+	if main.Calls != 1 || hot.Calls != 51 {
+		t.Fatalf("unexpected call counts: main=%d hot=%d", main.Calls, hot.Calls)
+	}
+	if hot.Instructions <= main.Instructions {
+		t.Fatalf("expected hot to dominate instructions: hot=%d main=%d", hot.Instructions, main.Instructions)
+	}
+	if main.Time < hot.Time {
+		t.Fatalf("expected inclusive main time >= hot time: main=%v hot=%v", main.Time, hot.Time)
+	}
+}
+
+func TestAPIInstructionLimit(t *testing.T) {
+	vm := NewVM()
+	vm.SetInstructionLimit(50)
+	if err := vm.LoadSource("limit", `func spin() { while (true) { } }`); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	_, err := vm.CallAsync(context.Background(), "spin", nil).Await(context.Background())
+	if err == nil {
+		t.Fatalf("expected instruction limit error")
+	}
+	rte, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("expected RuntimeError, got %T", err)
+	}
+	if rte.Message != "instruction limit exceeded" {
+		t.Fatalf("unexpected message %q", rte.Message)
+	}
+	if rte.Frame.Function != "spin" {
+		t.Fatalf("expected frame spin, got %q", rte.Frame.Function)
+	}
+}
+
+func TestAPIRecursionOverflow(t *testing.T) {
+	vm := NewVM()
+	if err := vm.LoadSource("rec", `func loop() { return loop() }`); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	_, err := vm.CallAsync(context.Background(), "loop", nil).Await(context.Background())
+	rte, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("expected RuntimeError, got %T (%v)", err, err)
+	}
+	if want := "call stack overflow calling loop at depth 256 (loop recurses into itself)"; rte.Message != want {
+		t.Fatalf("expected %q, got %q", want, rte.Message)
+	}
+	if rte.Frame.Function != "loop" {
+		t.Fatalf("expected frame loop, got %q", rte.Frame.Function)
+	}
+}
+
+func TestAPIHostArgHelpersAndExtraArgs(t *testing.T) {
+	vm := NewVM()
+	script := `func run($a, $b, $c) { return host($a, $b, $c) }`
+	if err := vm.LoadSource("inline", script); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	host := NewFunction([]string{"x", "y"}, func(_ *Context, args map[string]VmValue) (VmValue, error) {
+		h := NewHostArgs(args)
+		x, err := h.Number("x")
+		if err != nil {
+			return VmValue{}, err
+		}
+		y, err := h.String("y")
+		if err != nil {
+			return VmValue{}, err
+		}
+		return NewValue(fmt.Sprintf("%g:%s", x, y))
+	})
+	if err := vm.SetGlobalFunction("host", host); err != nil {
+		t.Fatalf("bind: %v", err)
+	}
+
+	val, err := vm.CallAsync(context.Background(), "run", []VmValue{
+		MustValue(1),
+		MustValue("two"),
+		MustValue(true), // extra arg is not part of the named args
+	}).Await(context.Background())
+	if err != nil {
+		t.Fatalf("call error: %v", err)
+	}
+	if val.MustRaw() != "1:two" {
+		t.Fatalf("unexpected result %#v", val.MustRaw())
+	}
+
+	// Type mismatch yields ArgError surfaced to VM and Go caller.
+	badHost := NewFunction([]string{"n"}, func(_ *Context, args map[string]VmValue) (VmValue, error) {
+		h := NewHostArgs(args)
+		_, err := h.Number("n")
+		return VmValue{}, err
+	})
+	if err := vm.SetGlobalFunction("bad", badHost); err != nil {
+		t.Fatalf("bind bad: %v", err)
+	}
+	_, err = vm.CallAsync(context.Background(), "run", []VmValue{
+		MustValue("oops"), // for x
+		MustValue("two"),
+		MustValue(true),
+	}).Await(context.Background())
+	if err == nil {
+		t.Fatalf("expected error from bad host arg")
+	}
+	var argErr ArgError
+	if !errors.As(err, &argErr) {
+		t.Fatalf("expected ArgError, got %T", err)
+	}
+}
+
+func TestAPIUnmarshalErrorPath(t *testing.T) {
+	type role struct{ Name string }
+	type user struct {
+		Name  string
+		Roles []role
+		Tags  map[string][]int
+	}
+	type payload struct{ User user }
+
+	src := MustValue(map[string]any{
+		"User": map[string]any{
+			"Name":  "ann",
+			"Roles": []any{map[string]any{"Name": "a"}, map[string]any{"Name": "b"}, map[string]any{"Name": 3}},
+		},
+	})
+	var out payload
+	err := Unmarshal(src, &out)
+	var argErr ArgError
+	if !errors.As(err, &argErr) {
+		t.Fatalf("expected ArgError, got %T (%v)", err, err)
+	}
+	if argErr.Path != "User.Roles[2].Name" {
+		t.Fatalf("expected path User.Roles[2].Name, got %q", argErr.Path)
+	}
+	if want := "at User.Roles[2].Name: want string, got number"; err.Error() != want {
+		t.Fatalf("expected %q, got %q", want, err.Error())
+	}
+
+	src = MustValue(map[string]any{"User": map[string]any{"Tags": map[string]any{"x": []any{1, "two"}}}})
+	err = Unmarshal(src, &out)
+	if !errors.As(err, &argErr) || argErr.Path != `User.Tags["x"][1]` {
+		t.Fatalf("expected path User.Tags[\"x\"][1], got %v", err)
+	}
+
+	var n int
+	err = Unmarshal(MustValue("nope"), &n)
+	if !errors.As(err, &argErr) || argErr.Path != "" || err.Error() != "want number, got string" {
+		t.Fatalf("expected top-level mismatch without path, got %v", err)
+	}
+}
+
+func TestAPIUnmarshalCoerce(t *testing.T) {
+	type record struct {
+		ID     string
+		Active bool
+		Score  float64
+		Count  int
+	}
+	src := MustValue(map[string]any{"ID": 42, "Active": 1, "Score": "9.5", "Count": true})
+
+	var strict record
+	if err := Unmarshal(src, &strict); err == nil {
+		t.Fatalf("expected strict mode to reject mismatched kinds")
+	}
+
+	var loose record
+	if err := UnmarshalWithOptions(src, &loose, UnmarshalOptions{Coerce: true}); err != nil {
+		t.Fatalf("coerce: %v", err)
+	}
+	want := record{ID: "42", Active: true, Score: 9.5, Count: 1}
+	if loose != want {
+		t.Fatalf("expected %+v, got %+v", want, loose)
+	}
+
+	var b bool
+	if err := UnmarshalWithOptions(MustValue(2), &b, UnmarshalOptions{Coerce: true}); err == nil {
+		t.Fatalf("expected 2 not to coerce to bool")
+	}
+	var n float64
+	if err := UnmarshalWithOptions(MustValue("abc"), &n, UnmarshalOptions{Coerce: true}); err == nil {
+		t.Fatalf("expected non-numeric string not to coerce to number")
+	}
+}
+
+func TestAPIUnmarshalDisallowUnknownFields(t *testing.T) {
+	type inner struct{ A int }
+	type outer struct {
+		Name  string
+		Inner inner
+	}
+	src := MustValue(map[string]any{"Name": "x", "Inner": map[string]any{"A": 1, "extra": true}})
+
+	var lenient outer
+	if err := Unmarshal(src, &lenient); err != nil {
+		t.Fatalf("expected unknown keys to be ignored by default, got %v", err)
+	}
+	if lenient.Name != "x" || lenient.Inner.A != 1 {
+		t.Fatalf("unexpected result %+v", lenient)
+	}
+
+	var strict outer
+	err := UnmarshalWithOptions(src, &strict, UnmarshalOptions{DisallowUnknownFields: true})
+	if err == nil || !strings.Contains(err.Error(), `Inner: unknown field "extra"`) {
+		t.Fatalf("expected unknown field error, got %v", err)
+	}
+
+	clean := MustValue(map[string]any{"Name": "x", "Inner": map[string]any{"A": 1}})
+	if err := UnmarshalWithOptions(clean, &strict, UnmarshalOptions{DisallowUnknownFields: true}); err != nil {
+		t.Fatalf("expected exact keys to pass, got %v", err)
+	}
+}
+
+type testEmbeddedBase struct {
+	ID   int
+	Kind string
+}
+
+type TestEmbeddedMeta struct {
+	Tags []string
+}
+
+type testEmbeddedRecord struct {
+	testEmbeddedBase
+	*TestEmbeddedMeta
+	Kind string // shadows testEmbeddedBase.Kind
+	Name string
+}
+
+func TestAPIEmbeddedStructFields(t *testing.T) {
+	rec := testEmbeddedRecord{
+		testEmbeddedBase: testEmbeddedBase{ID: 7, Kind: "base"},
+		Kind:             "record",
+		Name:             "n",
+	}
+	v, err := NewValue(rec)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	want := map[string]any{"ID": float64(7), "Kind": "record", "Name": "n"}
+	if !reflect.DeepEqual(v.MustRaw(), want) {
+		t.Fatalf("expected promoted fields %#v, got %#v", want, v.MustRaw())
+	}
+
+	src := MustValue(map[string]any{"ID": 9, "Kind": "k", "Name": "m", "Tags": []any{"a"}})
+	var out testEmbeddedRecord
+	if err := UnmarshalWithOptions(src, &out, UnmarshalOptions{DisallowUnknownFields: true}); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if out.ID != 9 || out.Kind != "k" || out.testEmbeddedBase.Kind != "" || out.Name != "m" {
+		t.Fatalf("unexpected result %+v", out)
+	}
+	if out.TestEmbeddedMeta == nil || !reflect.DeepEqual(out.Tags, []string{"a"}) {
+		t.Fatalf("expected embedded pointer to be allocated, got %+v", out.TestEmbeddedMeta)
+	}
+}
+
+func TestAPICustomMarshalersPerElement(t *testing.T) {
+	v, err := NewValue([]testCustomMarshaler{{V: "a"}, {V: "b"}})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	want := []any{map[string]any{"v": "a"}, map[string]any{"v": "b"}}
+	if !reflect.DeepEqual(v.MustRaw(), want) {
+		t.Fatalf("expected each element via MarshalFlux %#v, got %#v", want, v.MustRaw())
+	}
+	v, err = NewValue(map[string]testCustomMarshaler{"k": {V: "c"}})
+	if err != nil {
+		t.Fatalf("marshal map: %v", err)
+	}
+	if !reflect.DeepEqual(v.MustRaw(), map[string]any{"k": map[string]any{"v": "c"}}) {
+		t.Fatalf("expected map value via MarshalFlux, got %#v", v.MustRaw())
+	}
+
+	var list []testCustomUnmarshaler
+	elems := MustValue([]any{map[string]any{"v": "x"}, map[string]any{"v": "y"}})
+	if err := Unmarshal(elems, &list); err != nil {
+		t.Fatalf("unmarshal slice: %v", err)
+	}
+	if len(list) != 2 || list[0].V != "x" || list[1].V != "y" {
+		t.Fatalf("expected each element via UnmarshalFlux, got %+v", list)
+	}
+	var byKey map[string]testCustomUnmarshaler
+	if err := Unmarshal(MustValue(map[string]any{"k": map[string]any{"v": "z"}}), &byKey); err != nil {
+		t.Fatalf("unmarshal map: %v", err)
+	}
+	if byKey["k"].V != "z" {
+		t.Fatalf("expected map value via UnmarshalFlux, got %+v", byKey)
+	}
+	err = Unmarshal(MustValue([]any{map[string]any{"v": "ok"}, "bad"}), &list)
+	if err == nil || err.Error() != "[1]: expected object" {
+		t.Fatalf("expected element error with path, got %v", err)
+	}
+}
+
+func TestAPIUnmarshalerStructField(t *testing.T) {
+	type holder struct {
+		Name   string
+		Custom testCustomUnmarshaler
+		Ptr    *testCustomUnmarshaler
+		Absent *testCustomUnmarshaler
+	}
+	src := MustValue(map[string]any{
+		"Name":   "h",
+		"Custom": map[string]any{"v": "field"},
+		"Ptr":    map[string]any{"v": "pointer"},
+		"Absent": nil,
+	})
+	out := holder{Absent: &testCustomUnmarshaler{V: "stale"}}
+	if err := Unmarshal(src, &out); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if out.Name != "h" || out.Custom.V != "field" {
+		t.Fatalf("expected custom field via UnmarshalFlux, got %+v", out)
+	}
+	if out.Ptr == nil || out.Ptr.V != "pointer" {
+		t.Fatalf("expected pointer field via UnmarshalFlux, got %+v", out.Ptr)
+	}
+	if out.Absent != nil {
+		t.Fatalf("expected null to clear pointer field, got %+v", out.Absent)
+	}
+	err := Unmarshal(MustValue(map[string]any{"Custom": "oops"}), &out)
+	if err == nil || err.Error() != "Custom: expected object" {
+		t.Fatalf("expected field error from UnmarshalFlux, got %v", err)
+	}
+}
+
+// testCallingUnmarshaler calls the function value it is given, which needs the VM that produced it.
+type testCallingUnmarshaler struct{ Result float64 }
+
+func (c *testCallingUnmarshaler) UnmarshalFlux(v VmValue) error {
+	fn, ok := v.AsFunction()
+	if !ok {
+		return fmt.Errorf("expected function")
+	}
+	res, err := fn.Call(context.Background(), MustValue(20))
+	if err != nil {
+		return err
+	}
+	c.Result, _ = res.MustRaw().(float64)
+	return nil
+}
+
+// testTruthUnmarshaler records the truthiness of the value it is given.
+type testTruthUnmarshaler struct{ Truthy bool }
+
+func (c *testTruthUnmarshaler) UnmarshalFlux(v VmValue) error {
+	c.Truthy = v.IsTruthy()
+	return nil
+}
+
+func TestAPIUnmarshalerNestedOwner(t *testing.T) {
+	vm := NewVM()
+	if err := vm.LoadSource("inline", `func make() { return {Inc: func($x) { return $x + 1 }, Empty: []} }`); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	vm.SetEmptyCollectionsFalsy(true)
+	res, err := vm.Call(context.Background(), "make")
+	if err != nil {
+		t.Fatalf("call: %v", err)
+	}
+	var out struct {
+		Inc   testCallingUnmarshaler
+		Empty testTruthUnmarshaler
+	}
+	if err := Unmarshal(res, &out); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if out.Inc.Result != 21 {
+		t.Fatalf("expected nested function call to return 21, got %v", out.Inc.Result)
+	}
+	if out.Empty.Truthy {
+		t.Fatalf("expected nested value to use the VM's truthiness")
+	}
+}
+
+func TestAPIMarshalerStructFieldsAndMapValues(t *testing.T) {
+	type holder struct {
+		Value   testCustomMarshaler
+		Pointer testPtrMarshaler
+		Ref     *testPtrMarshaler
+	}
+	v, err := NewValue(holder{Value: testCustomMarshaler{V: "a"}, Pointer: testPtrMarshaler{V: "b"}, Ref: &testPtrMarshaler{V: "c"}})
+	if err != nil {
+		t.Fatalf("marshal struct: %v", err)
+	}
+	want := map[string]any{"Value": map[string]any{"v": "a"}, "Pointer": "ptr:b", "Ref": "ptr:c"}
+	if !reflect.DeepEqual(v.MustRaw(), want) {
+		t.Fatalf("expected %#v, got %#v", want, v.MustRaw())
+	}
+
+	v, err = NewValue(map[string]testPtrMarshaler{"k": {V: "d"}})
+	if err != nil {
+		t.Fatalf("marshal map: %v", err)
+	}
+	if !reflect.DeepEqual(v.MustRaw(), map[string]any{"k": "ptr:d"}) {
+		t.Fatalf("expected pointer-receiver map value via MarshalFlux, got %#v", v.MustRaw())
+	}
+	v, err = NewValue(map[string]testCustomMarshaler{"k": {V: "e"}})
+	if err != nil {
+		t.Fatalf("marshal map: %v", err)
+	}
+	if !reflect.DeepEqual(v.MustRaw(), map[string]any{"k": map[string]any{"v": "e"}}) {
+		t.Fatalf("expected value-receiver map value via MarshalFlux, got %#v", v.MustRaw())
+	}
+}
+
+func TestAPIValueBuilders(t *testing.T) {
+	tags := NewArrayBuilder().Append(MustValue("a")).Append(MustValue("b")).Build()
+	user := NewObjectBuilder().
+		Set("name", MustValue("ann")).
+		Set("tags", tags).
+		Set("limits", NewObjectBuilder().Set("max", MustValue(3)).BuildReadOnly()).
+		Build()
+
+	vm := NewVM()
+	err := vm.LoadSource("inline", `
+func describe($u) {
+  $u.seen = true
+  return [$u.name, $u.tags[1], $u.limits.max, readonly($u.limits), readonly($u)]
+}
+func mutateLimits($u) {
+  $u.limits.max = 4
+}`)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	res, err := vm.CallAsync(context.Background(), "describe", []VmValue{user}).Await(context.Background())
+	if err != nil {
+		t.Fatalf("call: %v", err)
+	}
+	if want := []any{"ann", "b", float64(3), true, false}; !reflect.DeepEqual(res.MustRaw(), want) {
+		t.Fatalf("unexpected result %#v", res.MustRaw())
+	}
+	if _, err := vm.CallAsync(context.Background(), "mutateLimits", []VmValue{user}).Await(context.Background()); err == nil {
+		t.Fatalf("expected read-only nested object to reject mutation")
+	}
+
+	b := NewArrayBuilder().Append(MustValue(1))
+	first := b.Build()
+	second := b.Append(MustValue(2)).Build()
+	if !reflect.DeepEqual(first.MustRaw(), []any{float64(1)}) || !reflect.DeepEqual(second.MustRaw(), []any{float64(2)}) {
+		t.Fatalf("expected Build to reset the builder, got %#v and %#v", first.MustRaw(), second.MustRaw())
+	}
+}
+
+func TestAPIValueAppend(t *testing.T) {
+	arr := MustValue([]any{1})
+	if err := arr.Append(MustValue("two")); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	double := NewFunction([]string{"x"}, func(_ *Context, args map[string]VmValue) (VmValue, error) {
+		n, err := NewHostArgs(args).Number("x")
+		if err != nil {
+			return VmValue{}, err
+		}
+		return NewValue(n * 2)
+	})
+	fnVal, err := NewValue(double)
+	if err != nil {
+		t.Fatalf("function value: %v", err)
+	}
+	if err := arr.Append(fnVal); err != nil {
+		t.Fatalf("append function: %v", err)
+	}
+
+	vm := NewVM()
+	if err := vm.LoadSource("inline", `func use($a) { return [$a[0], $a[1], $a[2](21)] }`); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	res, err := vm.CallAsync(context.Background(), "use", []VmValue{arr}).Await(context.Background())
+	if err != nil {
+		t.Fatalf("call: %v", err)
+	}
+	if want := []any{float64(1), "two", float64(42)}; !reflect.DeepEqual(res.MustRaw(), want) {
+		t.Fatalf("expected %#v, got %#v", want, res.MustRaw())
+	}
+
+	obj := MustValue(map[string]any{})
+	if err := obj.Append(MustValue(1)); err == nil {
+		t.Fatalf("expected error appending to object")
+	}
+	ro := MustValueReadOnly([]any{1})
+	if err := ro.Append(MustValue(2)); err == nil {
+		t.Fatalf("expected error appending to read-only array")
+	}
+}
+
+func TestAPIValuePath(t *testing.T) {
+	val := MustValue(map[string]any{
+		"user": map[string]any{
+			"address": map[string]any{"city": "Oslo"},
+			"tags":    []any{"a", map[string]any{"name": "b"}},
+		},
+	})
+	if city, ok := val.Path("user.address.city"); !ok || city.MustRaw() != "Oslo" {
+		t.Fatalf("unexpected deep path result %#v ok=%v", city, ok)
+	}
+	if _, ok := val.Path("user.address.zip"); ok {
+		t.Fatalf("expected missing segment to report false")
+	}
+	if _, ok := val.Path("user.address.city.name"); ok {
+		t.Fatalf("expected walking into a string to report false")
+	}
+	if name, ok := val.Path("user.tags.1.name"); !ok || name.MustRaw() != "b" {
+		t.Fatalf("unexpected array index path result %#v ok=%v", name, ok)
+	}
+	if _, ok := val.Path("user.tags.5"); ok {
+		t.Fatalf("expected out-of-range index to report false")
+	}
+}
+
+func TestAPIHostArgDefaults(t *testing.T) {
+	h := NewHostArgs(map[string]VmValue{
+		"n":    MustValue(5),
+		"s":    MustValue("set"),
+		"b":    MustValue(false),
+		"null": MustValue(nil),
+	})
+	if n, err := h.NumberOr("n", 1); err != nil || n != 5 {
+		t.Fatalf("present number: got %v, %v", n, err)
+	}
+	if n, err := h.NumberOr("missing", 1); err != nil || n != 1 {
+		t.Fatalf("absent number: got %v, %v", n, err)
+	}
+	if n, err := h.NumberOr("null", 1); err != nil || n != 1 {
+		t.Fatalf("null number: got %v, %v", n, err)
+	}
+	if s, err := h.StringOr("s", "def"); err != nil || s != "set" {
+		t.Fatalf("present string: got %v, %v", s, err)
+	}
+	if s, err := h.StringOr("missing", "def"); err != nil || s != "def" {
+		t.Fatalf("absent string: got %v, %v", s, err)
+	}
+	if b, err := h.BoolOr("b", true); err != nil || b {
+		t.Fatalf("present bool: got %v, %v", b, err)
+	}
+	if b, err := h.BoolOr("null", true); err != nil || !b {
+		t.Fatalf("null bool: got %v, %v", b, err)
+	}
+	if arr, err := h.ArrayOr("missing", nil); err != nil || arr != nil {
+		t.Fatalf("absent array: got %v, %v", arr, err)
+	}
+	if obj, err := h.ObjectOr("null", nil); err != nil || obj != nil {
+		t.Fatalf("null object: got %v, %v", obj, err)
+	}
+
+	var argErr ArgError
+	if _, err := h.NumberOr("s", 1); !errors.As(err, &argErr) || argErr.Name != "s" || argErr.Want != "number" {
+		t.Fatalf("expected wrong-type ArgError, got %v", err)
+	}
+	if _, err := h.StringOr("n", "def"); !errors.As(err, &argErr) || argErr.Want != "string" {
+		t.Fatalf("expected wrong-type ArgError, got %v", err)
+	}
+}
+
+func TestAPIHostArgPositional(t *testing.T) {
 	vm := NewVM()
-	var traces []TraceInfo
-	vm.SetTraceHook(func(info TraceInfo) {
-		traces = append(traces, info)
-	})
-	if err := vm.LoadSource("trace", `func demo() { return 1 + 2 }`); err != nil {
+	if err := vm.LoadSource("inline", `func run() { return host(10, "b", true, 99) }`); err != nil {
 		t.Fatalf("load: %v", err)
 	}
-	if _, err := vm.CallAsync(context.Background(), "demo", nil).Await(context.Background()); err != nil {
+	var count int
+	var got []any
+	var outOfRange bool
+	host := NewFunction([]string{"a", "b", "c"}, func(ctx *Context, _ map[string]VmValue) (VmValue, error) {
+		h := ctx.Args()
+		count = h.Count()
+		for i := 0; i < h.Count(); i++ {
+			v, ok := h.Index(i)
+			if !ok {
+				return VmValue{}, fmt.Errorf("missing arg %d", i)
+			}
+			got = append(got, v.MustRaw())
+		}
+		_, ok := h.Index(h.Count())
+		outOfRange = !ok
+		return NewValue(nil)
+	})
+	if err := vm.SetGlobalFunction("host", host); err != nil {
+		t.Fatalf("bind: %v", err)
+	}
+	if _, err := vm.CallAsync(context.Background(), "run", nil).Await(context.Background()); err != nil {
 		t.Fatalf("call: %v", err)
 	}
-	if len(traces) == 0 {
-		t.Fatalf("expected trace events")
+	// Positional access covers the extra argument beyond the three declared parameters.
+	if count != 4 || !reflect.DeepEqual(got, []any{float64(10), "b", true, float64(99)}) || !outOfRange {
+		t.Fatalf("unexpected positional args: count=%d got=%#v outOfRange=%v", count, got, outOfRange)
 	}
-	for _, tr := range traces {
-		if tr.Function != "demo" {
-			t.Fatalf("expected function demo in trace, got %q", tr.Function)
+
+	// A bare map falls back to auto-generated argN names.
+	h := NewHostArgs(map[string]VmValue{"arg0": MustValue(1), "arg1": MustValue(2)})
+	if v, ok := h.Index(1); !ok || v.MustRaw() != float64(2) || h.Count() != 2 {
+		t.Fatalf("unexpected fallback positional access: %#v %v", v, ok)
+	}
+}
+
+func TestAPIHostVariadicRest(t *testing.T) {
+	vm := NewVM()
+	if err := vm.LoadSource("inline", `func run() { return [sum(1), sum(1, 2, 3, 4), sum(5, 0.5)] }`); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	sum := NewFunction([]string{"first"}, func(ctx *Context, args map[string]VmValue) (VmValue, error) {
+		if len(args) != 1 {
+			return VmValue{}, fmt.Errorf("named args should only hold declared params, got %d", len(args))
 		}
-		if tr.Source != "trace" {
-			t.Fatalf("expected trace source, got %q", tr.Source)
+		total, err := NewHostArgs(args).Number("first")
+		if err != nil {
+			return VmValue{}, err
 		}
-		if tr.Line == 0 {
-			t.Fatalf("expected line info in trace")
+		for _, v := range ctx.Args().Rest() {
+			n, ok := v.MustRaw().(float64)
+			if !ok {
+				return VmValue{}, fmt.Errorf("non-number rest arg %#v", v.MustRaw())
+			}
+			total += n
 		}
+		return NewValue(total)
+	})
+	if err := vm.SetGlobalFunction("sum", sum); err != nil {
+		t.Fatalf("bind: %v", err)
+	}
+	val, err := vm.CallAsync(context.Background(), "run", nil).Await(context.Background())
+	if err != nil {
+		t.Fatalf("call: %v", err)
+	}
+	if !reflect.DeepEqual(val.MustRaw(), []any{float64(1), float64(10), 5.5}) {
+		t.Fatalf("unexpected sums %#v", val.MustRaw())
 	}
 }
 
-func TestAPIInstructionLimit(t *testing.T) {
+func TestAPIHostIteratorValue(t *testing.T) {
 	vm := NewVM()
-	vm.SetInstructionLimit(50)
-	if err := vm.LoadSource("limit", `func spin() { while (true) { } }`); err != nil {
+	script := `
+func run() {
+  $sum = 0
+  $n = 0
+  for ($v in hostStream()) {
+    $sum = $sum + $v
+    $n = $n + 1
+  }
+  return [$sum, $n]
+}
+`
+	if err := vm.LoadSource("inline", script); err != nil {
 		t.Fatalf("load: %v", err)
 	}
-	_, err := vm.CallAsync(context.Background(), "spin", nil).Await(context.Background())
-	if err == nil {
-		t.Fatalf("expected instruction limit error")
+	pulled := 0
+	stream := NewFunction(nil, func(*Context, map[string]VmValue) (VmValue, error) {
+		return NewIteratorValue(func() (string, VmValue, bool) {
+			if pulled >= 3 {
+				return "", VmValue{}, false
+			}
+			pulled++
+			return fmt.Sprint(pulled - 1), MustValue(pulled * 10), true
+		}), nil
+	})
+	if err := vm.SetGlobalFunction("hostStream", stream); err != nil {
+		t.Fatalf("bind: %v", err)
 	}
-	rte, ok := err.(*RuntimeError)
-	if !ok {
-		t.Fatalf("expected RuntimeError, got %T", err)
+	val, err := vm.CallAsync(context.Background(), "run", nil).Await(context.Background())
+	if err != nil {
+		t.Fatalf("call: %v", err)
 	}
-	if rte.Message != "instruction limit exceeded" {
-		t.Fatalf("unexpected message %q", rte.Message)
+	if !reflect.DeepEqual(val.MustRaw(), []any{float64(60), float64(3)}) || pulled != 3 {
+		t.Fatalf("unexpected stream result %#v (pulled %d)", val.MustRaw(), pulled)
 	}
-	if rte.Frame.Function != "spin" {
-		t.Fatalf("expected frame spin, got %q", rte.Frame.Function)
+}
+
+func TestAPIEmitSink(t *testing.T) {
+	vm := NewVM()
+	script := `
+func run($n) {
+  $i = 0
+  while ($i < $n) {
+    emit({"i": $i})
+    $i = $i + 1
+  }
+  return $i
+}
+`
+	if err := vm.LoadSource("inline", script); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	var got []any
+	if err := vm.SetEmitSink(func(v VmValue) { got = append(got, v.MustRaw()) }); err != nil {
+		t.Fatalf("sink: %v", err)
+	}
+	val, err := vm.CallAsync(context.Background(), "run", []VmValue{MustValue(3)}).Await(context.Background())
+	if err != nil {
+		t.Fatalf("call: %v", err)
+	}
+	if val.MustRaw() != float64(3) {
+		t.Fatalf("unexpected result %#v", val.MustRaw())
+	}
+	want := []any{
+		map[string]any{"i": float64(0)},
+		map[string]any{"i": float64(1)},
+		map[string]any{"i": float64(2)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected emitted values %#v", got)
 	}
 }
 
-func TestAPIHostArgHelpersAndExtraArgs(t *testing.T) {
+func TestAPILogSink(t *testing.T) {
 	vm := NewVM()
-	script := `func run($a, $b, $c) { return host($a, $b, $c) }`
+	if err := vm.SetGlobalFunction("log", NewFunction([]string{"v"}, func(*Context, map[string]VmValue) (VmValue, error) {
+		return MustValue(nil), nil
+	})); err == nil || !strings.Contains(err.Error(), "shadows builtin log") {
+		t.Fatalf("expected a host log function to be rejected, got %v", err)
+	}
+	script := `
+func run($items) {
+  for ($v in $items) {
+    $r := log([$v, $v * 2])
+    if ($r != null) { return error("log must return null") }
+  }
+  return "done"
+}
+`
 	if err := vm.LoadSource("inline", script); err != nil {
 		t.Fatalf("load: %v", err)
 	}
+	if res, err := vm.Call(context.Background(), "run", MustValue([]any{1, 2})); err != nil || res.MustRaw() != "done" {
+		t.Fatalf("run without sink: %v %v", res, err)
+	}
 
-	host := NewFunction([]string{"x", "y"}, func(_ *Context, args map[string]VmValue) (VmValue, error) {
-		h := NewHostArgs(args)
-		x, err := h.Number("x")
+	var got []any
+	vm.SetLogSink(func(v VmValue) { got = append(got, v.MustRaw()) })
+	if _, err := vm.Call(context.Background(), "run", MustValue([]any{1, 2, 3})); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	want := []any{
+		[]any{float64(1), float64(2)},
+		[]any{float64(2), float64(4)},
+		[]any{float64(3), float64(6)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected logged values %#v", got)
+	}
+
+	vm.SetLogSink(nil)
+	if _, err := vm.Call(context.Background(), "run", MustValue([]any{4})); err != nil || len(got) != 3 {
+		t.Fatalf("expected cleared sink to discard, got %v (logged %d)", err, len(got))
+	}
+}
+
+func TestAPILogSinkDuplicateOwner(t *testing.T) {
+	vm := NewVM()
+	var logged []VmValue
+	vm.SetLogSink(func(v VmValue) { logged = append(logged, v) })
+	if err := vm.LoadSource("inline", `func run() { log([]) }`); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	dup, err := vm.Duplicate()
+	if err != nil {
+		t.Fatalf("duplicate: %v", err)
+	}
+	// Only the copy treats empty collections as falsy, so truthiness reveals the owner.
+	dup.SetEmptyCollectionsFalsy(true)
+	if _, err := dup.Call(context.Background(), "run"); err != nil {
+		t.Fatalf("call: %v", err)
+	}
+	if len(logged) != 1 || logged[0].IsTruthy() {
+		t.Fatalf("expected one logged value owned by the duplicate, got %v", logged)
+	}
+}
+
+func TestAPIDefer(t *testing.T) {
+	vm := NewVM()
+	src := `
+func work($fail) {
+  $n := 1
+  defer log(["first", $n])
+  defer log("second")
+  $n = 2
+  if ($fail) {
+    $arr := []
+    return $arr[3]
+  }
+  return "ok"
+}
+func outer() {
+  defer log("outer")
+  return work(true)
+}
+func cleanupFails() {
+  defer error("ignored")
+  defer missing()
+  defer log("still runs")
+  return 1
+}
+`
+	if err := vm.LoadSource("inline", src); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	var got []any
+	vm.SetLogSink(func(v VmValue) { got = append(got, v.MustRaw()) })
+
+	res, err := vm.Call(context.Background(), "work", MustValue(false))
+	if err != nil || res.MustRaw() != "ok" {
+		t.Fatalf("work: %v %v", res, err)
+	}
+	// Deferred calls run last-in first-out and see variables as they are at exit.
+	if want := []any{"second", []any{"first", 2.0}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("normal exit: unexpected log %#v", got)
+	}
+
+	got = nil
+	if _, err := vm.Call(context.Background(), "outer"); err == nil || !strings.Contains(err.Error(), "out of bounds") {
+		t.Fatalf("expected index error, got %v", err)
+	}
+	if want := []any{"second", []any{"first", 2.0}, "outer"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("error exit: unexpected log %#v", got)
+	}
+
+	got = nil
+	if _, err := vm.Call(context.Background(), "cleanupFails"); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Fatalf("expected failing deferred call to surface, got %v", err)
+	}
+	if want := []any{"still runs"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("failing cleanup: unexpected log %#v", got)
+	}
+}
+
+func TestAPIDeferAfterAbort(t *testing.T) {
+	vm := NewVM()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := vm.SetGlobalFunction("stop", NewFunction(nil, func(_ *Context, _ map[string]VmValue) (VmValue, error) {
+		cancel()
+		return MustValue(nil), nil
+	})); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	src := `
+func spin() {
+  defer log("spin cleanup")
+  while (true) { }
+}
+func cancelled() {
+  defer log("cancel cleanup")
+  stop()
+  while (true) { }
+}
+`
+	if err := vm.LoadSource("inline", src); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	var got []any
+	vm.SetLogSink(func(v VmValue) { got = append(got, v.MustRaw()) })
+
+	// Deferred calls get a small budget past the limit and ignore the cancelled context.
+	vm.SetInstructionLimit(50)
+	if _, err := vm.Call(context.Background(), "spin"); err == nil || !strings.Contains(err.Error(), "instruction limit exceeded") {
+		t.Fatalf("expected instruction limit error, got %v", err)
+	}
+	vm.SetInstructionLimit(0)
+	if _, err := vm.Call(ctx, "cancelled"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+	if want := []any{"spin cleanup", "cancel cleanup"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected log %#v", got)
+	}
+}
+
+func TestAPICallSync(t *testing.T) {
+	vm := NewVM()
+	if err := vm.LoadSource("inline", `func add($a, $b) { return $a + $b }
+func fail() { return error("boom") }`); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	val, err := vm.Call(context.Background(), "add", MustValue(2), MustValue(3))
+	if err != nil {
+		t.Fatalf("call: %v", err)
+	}
+	if val.MustRaw() != float64(5) {
+		t.Fatalf("unexpected result %#v", val.MustRaw())
+	}
+
+	vm.SetErrorResultAsError(true)
+	if _, err := vm.Call(context.Background(), "fail"); err == nil || err.Error() != "boom" {
+		t.Fatalf("expected promoted error, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := vm.Call(ctx, "add", MustValue(1), MustValue(1)); err != context.Canceled {
+		t.Fatalf("expected context error, got %v", err)
+	}
+
+	// A host function re-entering the VM through Call sees the busy guard.
+	var inner error
+	_ = vm.SetGlobalFunction("reenter", NewFunction(nil, func(*Context, map[string]VmValue) (VmValue, error) {
+		_, inner = vm.Call(context.Background(), "add", MustValue(1), MustValue(1))
+		return NewValue(nil)
+	}))
+	if err := vm.LoadSource("inline2", `func outer() { return reenter() }`); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if _, err := vm.Call(context.Background(), "outer"); err != nil {
+		t.Fatalf("outer: %v", err)
+	}
+	if inner == nil || !strings.Contains(inner.Error(), "busy") {
+		t.Fatalf("expected busy error, got %v", inner)
+	}
+}
+
+func TestAPIHostFunctionPanicRecovered(t *testing.T) {
+	vm := NewVM()
+	if err := vm.LoadSource("inline", "func run() {\n  return explode()\n}\nfunc ok() { return 1 }"); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	explode := NewFunction(nil, func(*Context, map[string]VmValue) (VmValue, error) {
+		var m map[string]int
+		m["boom"] = 1 // panics: assignment to entry in nil map
+		return NewValue(nil)
+	})
+	if err := vm.SetGlobalFunction("explode", explode); err != nil {
+		t.Fatalf("bind: %v", err)
+	}
+	_, err := vm.Call(context.Background(), "run")
+	var rte *RuntimeError
+	if !errors.As(err, &rte) {
+		t.Fatalf("expected RuntimeError, got %T %v", err, err)
+	}
+	if !strings.Contains(rte.Message, "panic in host function explode") || !strings.Contains(rte.Message, "nil map") {
+		t.Fatalf("unexpected message %q", rte.Message)
+	}
+	if rte.Frame.Function != "run" || rte.Frame.Line != 2 {
+		t.Fatalf("unexpected frame %+v", rte.Frame)
+	}
+
+	// The VM stays usable after the recovered panic.
+	val, err := vm.Call(context.Background(), "ok")
+	if err != nil || val.MustRaw() != float64(1) {
+		t.Fatalf("expected VM to remain usable, got %v %v", val, err)
+	}
+}
+
+func TestAPIEval(t *testing.T) {
+	vm := NewVM()
+	if err := vm.LoadSource("inline", `func double($n) { return $n * 2 }`); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	cases := []struct {
+		src  string
+		want any
+	}{
+		{`1 + 2 * 3`, float64(7)},
+		{`typeof("x")`, "string"},
+		{`$a := 4; $b := double($a); $b + 1`, float64(9)},
+		{`$a := 1`, nil},
+		{``, nil},
+	}
+	for _, tc := range cases {
+		val, err := vm.Eval(context.Background(), tc.src)
 		if err != nil {
-			return VmValue{}, err
+			t.Fatalf("eval %q: %v", tc.src, err)
 		}
-		y, err := h.String("y")
-		if err != nil {
-			return VmValue{}, err
+		if got := val.MustRaw(); !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("eval %q: expected %#v, got %#v", tc.src, tc.want, got)
 		}
-		return NewValue(fmt.Sprintf("%g:%s", x, y))
+	}
+	if _, err := vm.Eval(context.Background(), `1 +`); err == nil {
+		t.Fatalf("expected parse error")
+	}
+	if _, err := vm.Eval(context.Background(), `missing()`); err == nil || !strings.Contains(err.Error(), "global missing not found") {
+		t.Fatalf("expected runtime error, got %v", err)
+	}
+
+	// Declarations would be dropped with the eval body, so they are rejected rather than ignored.
+	if _, err := vm.Eval(context.Background(), "1\nfunc foo() { return 1 }"); err == nil || !strings.Contains(err.Error(), "function declarations are not allowed in eval: func foo") {
+		t.Fatalf("expected declaration error, got %v", err)
+	}
+	if vm.HasFunction("foo") {
+		t.Fatalf("expected foo to stay undefined")
+	}
+
+	// Eval applies the same load-time checks as LoadSource.
+	vm.SetArityCheck(true)
+	if _, err := vm.Eval(context.Background(), `double(1, 2)`); err == nil || !strings.Contains(err.Error(), "function double expects 1 args, got 2") {
+		t.Fatalf("expected arity error, got %v", err)
+	}
+	if val, err := vm.Eval(context.Background(), `double(5)`); err != nil || val.MustRaw() != float64(10) {
+		t.Fatalf("expected checked call to run, got %v %v", val, err)
+	}
+	vm.SetMaxSourceBytes(4)
+	if _, err := vm.Eval(context.Background(), `1 + 2 + 3`); err == nil || !strings.Contains(err.Error(), "exceeding the limit of 4") {
+		t.Fatalf("expected size limit error, got %v", err)
+	}
+}
+
+func TestAPIAwaitCancelStopsScript(t *testing.T) {
+	vm := NewVM()
+	if err := vm.LoadSource("inline", `func spin() { while (true) { } }
+func add($a, $b) { return $a + $b }`); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := vm.CallAsync(context.Background(), "spin", nil).Await(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+
+	// The spinning goroutine was stopped, so the VM is no longer busy.
+	val, err := vm.Call(context.Background(), "add", MustValue(1), MustValue(2))
+	if err != nil {
+		t.Fatalf("call after cancel: %v", err)
+	}
+	if val.MustRaw() != float64(3) {
+		t.Fatalf("unexpected result %#v", val.MustRaw())
+	}
+
+	// Cancelling the call context itself also interrupts execution.
+	callCtx, callCancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer callCancel()
+	if _, err := vm.Call(callCtx, "spin"); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline error from sync call, got %v", err)
+	}
+}
+
+func TestAPIInterrupt(t *testing.T) {
+	vm := NewVM()
+	started := make(chan struct{}, 1)
+	if err := vm.SetGlobalFunction("started", NewFunction(nil, func(_ *Context, _ map[string]VmValue) (VmValue, error) {
+		started <- struct{}{}
+		return NewValue(nil)
+	})); err != nil {
+		t.Fatalf("set global: %v", err)
+	}
+	src := `
+func spin() { started(); while (true) { } }
+func guarded() { return tryCall(spin, []) }
+func add($a, $b) { return $a + $b }
+`
+	if err := vm.LoadSource("inline", src); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	for _, name := range []string{"spin", "guarded"} {
+		done := make(chan error, 1)
+		go func() {
+			_, err := vm.Call(context.Background(), name)
+			done <- err
+		}()
+		<-started
+		vm.Interrupt()
+		select {
+		case err := <-done:
+			var rte *RuntimeError
+			if !errors.As(err, &rte) || rte.Message != "interrupted" || !errors.Is(err, ErrInterrupted) {
+				t.Fatalf("%s: expected interrupted runtime error, got %v", name, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: interrupt did not stop the script", name)
+		}
+	}
+
+	// The interrupt is consumed, so the VM keeps working.
+	res, err := vm.Call(context.Background(), "add", MustValue(1), MustValue(2))
+	if err != nil || res.MustRaw() != 3.0 {
+		t.Fatalf("call after interrupt: %v (%v)", res, err)
+	}
+	vm.Interrupt()
+	if _, err := vm.Call(context.Background(), "add", MustValue(1), MustValue(2)); err != nil {
+		t.Fatalf("expected idle interrupt to be discarded, got %v", err)
+	}
+}
+
+func TestAPICallAll(t *testing.T) {
+	vm := NewVM()
+	script := `
+func step($name, $n) { return record($name, $n) }
+func fail() { return missing() }
+`
+	if err := vm.LoadSource("inline", script); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	var order []string
+	record := NewFunction([]string{"name", "n"}, func(_ *Context, args map[string]VmValue) (VmValue, error) {
+		h := NewHostArgs(args)
+		name, _ := h.String("name")
+		n, _ := h.Number("n")
+		order = append(order, name)
+		return NewValue(n * 2)
 	})
-	if err := vm.SetGlobalFunction("host", host); err != nil {
+	if err := vm.SetGlobalFunction("record", record); err != nil {
 		t.Fatalf("bind: %v", err)
 	}
 
-	val, err := vm.CallAsync(context.Background(), "run", []VmValue{
-		MustValue(1),
-		MustValue("two"),
-		MustValue(true), // extra arg should be ignored by host binding
-	}).Await(context.Background())
-	if err != nil {
-		t.Fatalf("call error: %v", err)
+	results, err := vm.CallAll(context.Background(), []Call{
+		{Name: "step", Args: []VmValue{MustValue("a"), MustValue(1)}},
+		{Name: "step", Args: []VmValue{MustValue("b"), MustValue(2)}},
+		{Name: "step", Args: []VmValue{MustValue("c"), MustValue(3)}},
+	})
+	if err != nil {
+		t.Fatalf("call all: %v", err)
+	}
+	if !reflect.DeepEqual(order, []string{"a", "b", "c"}) {
+		t.Fatalf("unexpected call order %v", order)
+	}
+	var got []any
+	for _, r := range results {
+		got = append(got, r.Value.MustRaw())
+	}
+	if !reflect.DeepEqual(got, []any{float64(2), float64(4), float64(6)}) {
+		t.Fatalf("unexpected results %#v", got)
+	}
+
+	order = nil
+	batch := []Call{
+		{Name: "step", Args: []VmValue{MustValue("a"), MustValue(1)}},
+		{Name: "fail"},
+		{Name: "step", Args: []VmValue{MustValue("c"), MustValue(3)}},
+	}
+	results, err = vm.CallAll(context.Background(), batch)
+	if err == nil || len(results) != 2 || results[1].Err == nil || !reflect.DeepEqual(order, []string{"a"}) {
+		t.Fatalf("expected stop at first error: err=%v results=%d order=%v", err, len(results), order)
+	}
+
+	order = nil
+	results, err = vm.CallAllWithOptions(context.Background(), batch, CallAllOptions{ContinueOnError: true})
+	if err == nil || len(results) != 3 || results[2].Err != nil || !reflect.DeepEqual(order, []string{"a", "c"}) {
+		t.Fatalf("expected to continue past error: err=%v results=%d order=%v", err, len(results), order)
+	}
+}
+
+func TestAPICallStats(t *testing.T) {
+	vm := NewVM()
+	src := `
+func add($a, $b) { return $a + $b }
+func outer() { return add(1, 2) }
+`
+	if err := vm.LoadSource("inline", src); err != nil {
+		t.Fatalf("load: %v", err)
 	}
-	if val.MustRaw() != "1:two" {
-		t.Fatalf("unexpected result %#v", val.MustRaw())
+	ctx := context.Background()
+	if _, err := vm.Call(ctx, "add", MustValue(1), MustValue(2)); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	// GET_LOCAL, GET_LOCAL, ADD, RETURN
+	stats := vm.LastCallStats()
+	if stats.Instructions != 4 || stats.PeakStack != 2 || stats.PeakFrames != 1 {
+		t.Fatalf("unexpected add stats %+v", stats)
 	}
 
-	// Type mismatch yields ArgError surfaced to VM and Go caller.
-	badHost := NewFunction([]string{"n"}, func(_ *Context, args map[string]VmValue) (VmValue, error) {
-		h := NewHostArgs(args)
-		_, err := h.Number("n")
-		return VmValue{}, err
-	})
-	if err := vm.SetGlobalFunction("bad", badHost); err != nil {
-		t.Fatalf("bind bad: %v", err)
+	results, err := vm.CallAll(ctx, []Call{{Name: "outer"}, {Name: "add", Args: []VmValue{MustValue(1), MustValue(2)}}})
+	if err != nil {
+		t.Fatalf("call all: %v", err)
 	}
-	_, err = vm.CallAsync(context.Background(), "run", []VmValue{
-		MustValue("oops"), // for x
-		MustValue("two"),
-		MustValue(true),
-	}).Await(context.Background())
-	if err == nil {
-		t.Fatalf("expected error from bad host arg")
+	// GET_GLOBAL, CONST, CONST, CALL, RETURN plus the four of add.
+	if got := results[0].Stats; got.Instructions != 9 || got.PeakFrames != 2 {
+		t.Fatalf("unexpected outer stats %+v", got)
 	}
-	var argErr ArgError
-	if !errors.As(err, &argErr) {
-		t.Fatalf("expected ArgError, got %T", err)
+	if got := results[1].Stats; got.Instructions != 4 || got.PeakFrames != 1 {
+		t.Fatalf("expected counters to reset between calls, got %+v", got)
+	}
+	if vm.LastCallStats() != results[1].Stats {
+		t.Fatalf("expected LastCallStats to match the final CallAll entry")
 	}
 }
 
@@ -877,8 +3452,14 @@ func TestAPICallAsyncBusyProtection(t *testing.T) {
 	fut2 := vm.CallAsync(context.Background(), "slow", nil)
 
 	_, err := fut2.Await(context.Background())
-	if err == nil {
-		t.Fatalf("expected busy error on concurrent CallAsync")
+	if !errors.Is(err, ErrVMBusy) {
+		t.Fatalf("expected ErrVMBusy on concurrent CallAsync, got %v", err)
+	}
+	if _, err := vm.Call(context.Background(), "slow"); !errors.Is(err, ErrVMBusy) {
+		t.Fatalf("expected ErrVMBusy on concurrent Call, got %v", err)
+	}
+	if _, err := vm.Duplicate(); !errors.Is(err, ErrVMBusy) {
+		t.Fatalf("expected ErrVMBusy on Duplicate while running, got %v", err)
 	}
 
 	val, err := fut1.Await(context.Background())
@@ -1037,3 +3618,596 @@ func TestAPIBroaderMarshalingAndAccessors(t *testing.T) {
 		}
 	})
 }
+
+func TestAPIIsTruthy(t *testing.T) {
+	vm := NewVM()
+	if err := vm.LoadSource("inline", `func run() { return [error("e"), func() { return 1 }] }`); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	res, err := vm.Call(context.Background(), "run")
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	scripted, _ := res.Array()
+	cases := []struct {
+		name string
+		val  VmValue
+		want bool
+	}{
+		{"null", MustValue(nil), false},
+		{"false", MustValue(false), false},
+		{"true", MustValue(true), true},
+		{"zero", MustValue(0), true},
+		{"empty string", MustValue(""), true},
+		{"empty array", MustValue([]any{}), true},
+		{"empty object", MustValue(map[string]any{}), true},
+		{"error", scripted[0], true},
+		{"function", scripted[1], true},
+	}
+	for _, c := range cases {
+		if got := c.val.IsTruthy(); got != c.want {
+			t.Fatalf("%s: expected %v, got %v", c.name, c.want, got)
+		}
+	}
+}
+
+func TestAPIValueInt(t *testing.T) {
+	cases := []struct {
+		val  VmValue
+		want int64
+		ok   bool
+	}{
+		{MustValue(42), 42, true},
+		{MustValue(-7.0), -7, true},
+		{MustValue(math.Copysign(0, -1)), 0, true},
+		{MustValue(1 << 53), 1 << 53, true},
+		{MustValue(2.5), 0, false},
+		{MustValue(math.Inf(1)), 0, false},
+		{MustValue(math.NaN()), 0, false},
+		{MustValue(math.Pow(2, 63)), 0, false},
+		{MustValue("3"), 0, false},
+		{MustValue(nil), 0, false},
+	}
+	for i, c := range cases {
+		got, ok := c.val.Int()
+		if got != c.want || ok != c.ok {
+			t.Fatalf("case %d: expected (%d, %v), got (%d, %v)", i, c.want, c.ok, got, ok)
+		}
+	}
+}
+
+func TestAPIValueBytes(t *testing.T) {
+	vm := NewVM()
+	if err := vm.LoadSource("inline", `func run() { return "héllo" }`); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	res, err := vm.Call(context.Background(), "run")
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	b, ok := res.Bytes()
+	if !ok || string(b) != "héllo" || len(b) != 6 {
+		t.Fatalf("unexpected bytes %q ok=%v", b, ok)
+	}
+	b[0] = 'H'
+	if s, _ := res.String(); s != "héllo" {
+		t.Fatalf("modifying the returned bytes changed the value: %q", s)
+	}
+	if b, ok := MustValue(3).Bytes(); ok || b != nil {
+		t.Fatalf("expected no bytes for a number, got %q", b)
+	}
+}
+
+func TestAPIEmptyCollectionsFalsy(t *testing.T) {
+	src := `
+func check($v) {
+  $r := []
+  if ($v) { $r = [true] } else { $r = [false] }
+  return [$r[0], !$v, !!($v && true), !!($v || false)]
+}
+`
+	inputs := []any{"", []any{}, map[string]any{}, 0, "x", []any{1}, map[string]any{"a": 1}, 2, nil, false, true}
+	lenient := NewVM()
+	strictFalsy := NewVM()
+	strictFalsy.SetEmptyCollectionsFalsy(true)
+	for _, vm := range []*VM{lenient, strictFalsy} {
+		if err := vm.LoadSource("inline", src); err != nil {
+			t.Fatalf("load: %v", err)
+		}
+	}
+	for i, in := range inputs {
+		empty := i < 4
+		for _, mode := range []struct {
+			vm   *VM
+			want bool
+		}{
+			{lenient, in != nil && in != false},
+			{strictFalsy, in != nil && in != false && !empty},
+		} {
+			res, err := mode.vm.Call(context.Background(), "check", MustValue(in))
+			if err != nil {
+				t.Fatalf("check %#v: %v", in, err)
+			}
+			want := []any{mode.want, !mode.want, mode.want, mode.want}
+			if got := res.MustRaw(); !reflect.DeepEqual(got, want) {
+				t.Fatalf("check %#v (empty falsy %v): expected %v, got %v", in, mode.vm == strictFalsy, want, got)
+			}
+		}
+	}
+
+	res, err := strictFalsy.Call(context.Background(), "check", MustValue([]any{}))
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if arr, _ := res.Array(); arr[0].IsTruthy() || !MustValue(0).IsTruthy() {
+		t.Fatalf("IsTruthy should follow the owning VM's rules")
+	}
+	dup, err := strictFalsy.Duplicate()
+	if err != nil {
+		t.Fatalf("duplicate: %v", err)
+	}
+	if res, err := dup.Call(context.Background(), "check", MustValue("")); err != nil || res.MustRaw().([]any)[0] != false {
+		t.Fatalf("duplicate should keep empty-falsy mode, got %v %v", res, err)
+	}
+}
+
+func TestAPINullPropagation(t *testing.T) {
+	src := `
+func direct() { return null.x }
+func chain($cfg) { return $cfg.a.b }
+func index($v) { return $v[0] }
+`
+	strict := NewVM()
+	lenient := NewVM()
+	lenient.SetNullPropagation(true)
+	for _, vm := range []*VM{strict, lenient} {
+		if err := vm.LoadSource("inline", src); err != nil {
+			t.Fatalf("load: %v", err)
+		}
+	}
+	ctx := context.Background()
+	cfg := MustValue(map[string]any{"a": nil})
+
+	if _, err := strict.Call(ctx, "direct"); err == nil || !strings.Contains(err.Error(), "property access on non-object") {
+		t.Fatalf("expected strict null.x to error, got %v", err)
+	}
+	if _, err := strict.Call(ctx, "chain", cfg); err == nil {
+		t.Fatalf("expected strict chain through null to error")
+	}
+
+	for _, call := range []struct {
+		name string
+		args []VmValue
+	}{
+		{"direct", nil},
+		{"chain", []VmValue{cfg}},
+		{"index", []VmValue{MustValue(nil)}},
+	} {
+		res, err := lenient.Call(ctx, call.name, call.args...)
+		if err != nil {
+			t.Fatalf("%s: %v", call.name, err)
+		}
+		if !res.IsNull() {
+			t.Fatalf("%s: expected null, got %v", call.name, res.MustRaw())
+		}
+	}
+	if _, err := lenient.Call(ctx, "chain", MustValue(map[string]any{"a": map[string]any{}})); err == nil || !strings.Contains(err.Error(), "missing property b") {
+		t.Fatalf("expected missing property on an object to still error, got %v", err)
+	}
+	if _, err := lenient.Call(ctx, "chain", MustValue(map[string]any{"a": 1})); err == nil {
+		t.Fatalf("expected property access on a number to still error")
+	}
+}
+
+func TestAPICheck(t *testing.T) {
+	if diags := Check("clean", `func add($a, $b) { return $a + $b }`); len(diags) != 0 {
+		t.Fatalf("expected no diagnostics, got %v", diags)
+	}
+
+	diags := Check("broken", "func bad($c) {\n  inc(1, 2\n}")
+	if len(diags) == 0 {
+		t.Fatalf("expected parse diagnostics")
+	}
+	if diags[0].Source != "broken" || diags[0].Line == 0 || diags[0].Severity != SeverityError || diags[0].Code != "syntax" {
+		t.Fatalf("unexpected diagnostic %+v", diags[0])
+	}
+
+	diags = Check("compile", "func demo() {\n  const $x := 1\n  $x = 2\n}")
+	if len(diags) != 1 {
+		t.Fatalf("expected one compile diagnostic, got %v", diags)
+	}
+	if diags[0].Line != 3 || diags[0].Message != "cannot assign to constant $x" {
+		t.Fatalf("unexpected diagnostic %+v", diags[0])
+	}
+
+	params := make([]string, 256)
+	for i := range params {
+		params[i] = fmt.Sprintf("$p%d", i)
+	}
+	diags = Check("wide", "func ok() { return 1 }\n  func wide("+strings.Join(params, ", ")+") { return 1 }")
+	if len(diags) != 1 || diags[0].Line != 2 || diags[0].Column != 3 || !strings.HasPrefix(diags[0].Message, "too many parameters: function wide") {
+		t.Fatalf("expected positioned parameter-limit diagnostic, got %v", diags)
+	}
+}
+
+func TestAPIFunctionShadowsBuiltin(t *testing.T) {
+	diags := Check("shadow", "func helper() { return 1 }\nfunc error($msg) {\n  return $msg\n}")
+	if len(diags) != 1 {
+		t.Fatalf("expected one compile diagnostic, got %v", diags)
+	}
+	if diags[0].Line != 2 || diags[0].Message != "function error shadows builtin error" || diags[0].Severity != SeverityError {
+		t.Fatalf("unexpected diagnostic %+v", diags[0])
+	}
+
+	if err := NewVM().LoadSource("nested", "func run() {\n  func typeof($x) { return $x }\n  return typeof(1)\n}"); err == nil || !strings.Contains(err.Error(), "function typeof shadows builtin typeof") {
+		t.Fatalf("expected nested shadowing compile error, got %v", err)
+	}
+}
+
+func TestAPICompileWarnings(t *testing.T) {
+	src := "func pick($x) {\n  if ($x) {\n    return 1\n    $x = 2\n  }\n  return 0\n}\n"
+	prog, err := Compile("warn", src)
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	want := []Diagnostic{{Source: "warn", Line: 4, Column: 5, Message: "unreachable code after return", Severity: SeverityWarning, Code: "unreachable-code"}}
+	if got := prog.Warnings(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected warnings %+v", got)
+	}
+	if got := Check("warn", src); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected check diagnostics %+v", got)
+	}
+
+	vm := NewVM()
+	if err := vm.LoadSource("warn", src); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	res, err := vm.Call(context.Background(), "pick", MustValue(true))
+	if err != nil || res.MustRaw() != float64(1) {
+		t.Fatalf("unexpected result %v %v", res, err)
+	}
+
+	if prog, err := Compile("clean", `func f() { return 1 }`); err != nil || prog.Warnings() != nil {
+		t.Fatalf("expected clean program, got %v %v", prog.Warnings(), err)
+	}
+}
+
+func TestAPILoadSourceStrict(t *testing.T) {
+	src := "func total($items) {\n  $sum := 0\n  $unused := 1\n  for ($v in $items) {\n    $sum = $sum + $v\n  }\n  return $sum\n}\n"
+	lenient := NewVM()
+	if err := lenient.LoadSource("lenient", src); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if res, err := lenient.Call(context.Background(), "total", MustValue([]any{1, 2})); err != nil || res.MustRaw() != float64(3) {
+		t.Fatalf("unexpected result %v %v", res, err)
+	}
+
+	strict := NewVM()
+	err := strict.LoadSourceStrict("strict", src)
+	if err == nil || !strings.Contains(err.Error(), "strict:3:3: variable $unused is declared but never read") {
+		t.Fatalf("expected unused-variable failure, got %v", err)
+	}
+	if strict.HasFunction("total") {
+		t.Fatalf("strict load must not load a program with warnings")
+	}
+
+	clean := "func f($x) {\n  $y := $x\n  $g := func() { return $y }\n  for ([$_, $v] in $x) { }\n  return $g()\n}\n"
+	if err := strict.LoadSourceStrict("clean", clean); err != nil {
+		t.Fatalf("strict load of clean source: %v", err)
+	}
+	if diags := Check("unused", src); len(diags) != 1 || diags[0].Code != "unused-variable" || diags[0].Severity != SeverityWarning {
+		t.Fatalf("unexpected diagnostics %+v", diags)
+	}
+}
+
+func TestAPIModuleResolver(t *testing.T) {
+	modules := map[string]string{
+		"math":  "import \"util\"\nfunc double($x) { return twice($x) }",
+		"util":  `func twice($x) { return $x * 2 }`,
+		"a":     "import \"b\"\nfunc fa() { return 1 }",
+		"b":     "import \"a\"\nfunc fb() { return 2 }",
+		"bad":   `func broken( {`,
+		"other": "import \"util\"\nfunc quad($x) { return twice(twice($x)) }",
+	}
+	fetched := map[string]int{}
+	vm := NewVM()
+	if err := vm.LoadSource("main", "import \"math\"\nfunc run() { return double(21) }"); err == nil || !strings.Contains(err.Error(), "no module resolver") {
+		t.Fatalf("expected missing resolver error, got %v", err)
+	}
+	vm.SetModuleResolver(func(name string) (string, error) {
+		fetched[name]++
+		src, ok := modules[name]
+		if !ok {
+			return "", fmt.Errorf("module %s not found", name)
+		}
+		return src, nil
+	})
+
+	src := "import \"math\"\nimport \"other\"\n\nfunc run() { return [double(21), quad(1)] }"
+	if err := vm.LoadSource("main", src); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	res, err := vm.Call(context.Background(), "run")
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if !reflect.DeepEqual(res.MustRaw(), []any{42.0, 4.0}) {
+		t.Fatalf("unexpected result %#v", res.MustRaw())
+	}
+	if fetched["util"] != 1 {
+		t.Fatalf("expected shared import to be fetched once, got %d", fetched["util"])
+	}
+
+	err = vm.LoadSource("cyclic", "import \"a\"\nfunc run2() { return fa() }")
+	if err == nil || !strings.Contains(err.Error(), "import cycle: cyclic -> a -> b -> a") {
+		t.Fatalf("expected cycle error, got %v", err)
+	}
+	if vm.HasFunction("fa") || vm.HasFunction("run2") {
+		t.Fatalf("failed import must not load anything")
+	}
+	for name, want := range map[string]string{"missing": `import "missing": module missing not found`, "bad": `import "bad": parse errors`} {
+		if err := vm.LoadSource("m", "import \""+name+"\""); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("%s: expected %q, got %v", name, want, err)
+		}
+	}
+	if err := vm.LoadSource("nested", `func f() { import "util" }`); err == nil || !strings.Contains(err.Error(), "import is only allowed at top level") {
+		t.Fatalf("expected nested import error, got %v", err)
+	}
+}
+
+func TestAPIMaxSourceBytes(t *testing.T) {
+	src := `func f() { return 1 }`
+	vm := NewVM()
+	vm.SetMaxSourceBytes(len(src))
+	if err := vm.LoadSource("exact", src); err != nil {
+		t.Fatalf("source at the limit should load: %v", err)
+	}
+	over := src + "\n"
+	if err := vm.LoadSource("over", over); err == nil || err.Error() != fmt.Sprintf("source over is %d bytes, exceeding the limit of %d", len(over), len(src)) {
+		t.Fatalf("expected size limit error, got %v", err)
+	}
+	if err := vm.LoadSourceStrict("over", over); err == nil || !strings.Contains(err.Error(), "exceeding the limit") {
+		t.Fatalf("expected strict size limit error, got %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "big.flux")
+	if err := os.WriteFile(path, []byte(over), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := vm.LoadFile(path); err == nil || !strings.Contains(err.Error(), "exceeding the limit") {
+		t.Fatalf("expected file size limit error, got %v", err)
+	}
+
+	vm.SetModuleResolver(func(string) (string, error) { return over, nil })
+	if err := vm.LoadSource("main", `import "big"`); err == nil || !strings.Contains(err.Error(), `import "big": source big is`) {
+		t.Fatalf("expected import size limit error, got %v", err)
+	}
+
+	vm.SetMaxSourceBytes(0)
+	if err := vm.LoadFile(path); err != nil {
+		t.Fatalf("unlimited load: %v", err)
+	}
+}
+
+func TestAPILoadFileFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"scripts/main.flux": {Data: []byte("import \"lib\"\nfunc run() { return helper(2) }")},
+		"scripts/lib.flux":  {Data: []byte(`func helper($x) { return $x + 40 }`)},
+	}
+	vm := NewVM()
+	vm.SetModuleResolver(func(name string) (string, error) {
+		data, err := fs.ReadFile(fsys, "scripts/"+name+".flux")
+		return string(data), err
+	})
+	if err := vm.LoadFileFS(fsys, "scripts/main.flux"); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	res, err := vm.Call(context.Background(), "run")
+	if err != nil || res.MustRaw() != float64(42) {
+		t.Fatalf("unexpected result %v %v", res, err)
+	}
+	if err := vm.LoadFileFS(fsys, "scripts/missing.flux"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected not-exist error, got %v", err)
+	}
+	vm.SetMaxSourceBytes(8)
+	if err := vm.LoadFileFS(fsys, "scripts/lib.flux"); err == nil || !strings.Contains(err.Error(), "source scripts/lib.flux is") {
+		t.Fatalf("expected size limit error, got %v", err)
+	}
+}
+
+func TestAPIFormat(t *testing.T) {
+	programs := map[string]string{
+		"functions": `
+// helper
+func add($a,$b){return $a+$b}
+func demo( $x ) {
+  $o := {a:1, "b c":[1,2], 3: null}
+  $o.a = ($x + 1) * 2 - -$x
+  if ($x>1&&!($x==3)) { return typeof($x) } elseif ($x < 0) { return "neg" }
+  else { $o["k"] = "say \"hi\"\n" }
+  for ([$k,$v] in $o) { }
+  for ($i in [0 .. $x]) { $x = $x - (1 - $i) }
+  while (false) {}
+  const $c := func($y) { return $y / 2 }
+  return $c(add(1, 2))
+}`,
+		"nested": `func outer() {
+	func inner($v) {
+		return sort($v, func($a, $b) {
+			return $a < $b
+		})
+	}
+	return inner([3, 1, 2])
+}
+`,
+		"methods": `func make() {
+  return {n: 1, get: func $self() { return $self.n }}
+}`,
+		"imports": `import  "util"
+func run($h) {
+  return twice($h)
+}`,
+		"modulo":   `func even($n){return $n%2==0&&-$n % 3*2 != 1}`,
+		"slices":   `func parts($a,$s){return [$a[1 : 3], $a[:2], $a[2:], $s[ : ], {k: 1}.k]}`,
+		"compound": `func bump($o,$i){$o.n+=1;$o.items[$i]*=2 ; $t:=0;$t -= $i%2;$t/=2;$t%=3;return $t}`,
+		"loops": `func total($a){$n:=0;for($v in $a){if($v<0){continue};if($v>9){break};$n+=$v}
+while(true){break}
+return $n}`,
+		"generators": `func evens($n){$i:=0;while($i<$n){yield $i*2;$i+=1}}
+func run(){iterate evens(3) using func($v){log($v)}}`,
+		"defer": `func run($h) {
+  defer $h.close( 1 )
+  return 0
+}`,
+	}
+	for name, src := range programs {
+		t.Run(name, func(t *testing.T) {
+			once, err := Format(src)
+			if err != nil {
+				t.Fatalf("format: %v", err)
+			}
+			if diags := Check("formatted", once); len(diags) != 0 {
+				t.Fatalf("formatted output does not compile: %v\n%s", diags, once)
+			}
+			twice, err := Format(once)
+			if err != nil {
+				t.Fatalf("format formatted: %v", err)
+			}
+			if once != twice {
+				t.Fatalf("format not idempotent:\n%s\n---\n%s", once, twice)
+			}
+		})
+	}
+
+	out, err := Format("func add($a,$b){\n  return $a+$b*2\n}")
+	if err != nil {
+		t.Fatalf("format: %v", err)
+	}
+	want := "func add($a, $b) {\n\treturn $a + $b * 2\n}\n"
+	if out != want {
+		t.Fatalf("expected %q, got %q", want, out)
+	}
+
+	if _, err := Format("func bad( {"); err == nil {
+		t.Fatalf("expected parse error")
+	}
+}
+
+func TestAPIInspect(t *testing.T) {
+	src := `
+func helper($x) {
+	return $x * 2
+}
+
+func main($input) {
+	$total := 0
+	for ($v in $input) {
+		$total = $total + helper($v)
+	}
+	$counter = $counter + 1
+	log(typeof($total))
+	$f := func($y) { return $total + $y + $limit }
+	return sort([$f(1), fetch("url")], null)
+}`
+	info, err := Inspect(src)
+	if err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	if want := []string{"helper", "main"}; !reflect.DeepEqual(info.Functions, want) {
+		t.Fatalf("functions: expected %v, got %v", want, info.Functions)
+	}
+	if want := []string{"counter", "fetch", "limit"}; !reflect.DeepEqual(info.Globals, want) {
+		t.Fatalf("globals: expected %v, got %v", want, info.Globals)
+	}
+	if want := []string{"log", "sort", "typeof"}; !reflect.DeepEqual(info.Builtins, want) {
+		t.Fatalf("builtins: expected %v, got %v", want, info.Builtins)
+	}
+
+	if _, err := Inspect("func bad( {"); err == nil {
+		t.Fatalf("expected parse error")
+	}
+}
+
+func TestAPIProgramSourcePosition(t *testing.T) {
+	src := "func f($x) {\n  $y = 1\n  return $x.missing.deeper\n}\n"
+	prog, err := Compile("inline", src)
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	// The first instruction loads the literal 1 on line 2, column 8.
+	if line, col, ok := prog.SourcePosition("f", 0); !ok || line != 2 || col != 8 {
+		t.Fatalf("unexpected position for ip 0: line=%d col=%d ok=%v", line, col, ok)
+	}
+
+	vm := NewVM()
+	if err := vm.LoadSource("inline", src); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	_, err = vm.Call(context.Background(), "f", MustValue(map[string]any{}))
+	var rte *RuntimeError
+	if !errors.As(err, &rte) {
+		t.Fatalf("expected runtime error, got %v", err)
+	}
+	line, _, ok := prog.SourcePosition(rte.Frame.Function, rte.Frame.IP)
+	if !ok || line != rte.Frame.Line || line != 3 {
+		t.Fatalf("expected IP %d to map to line 3, got line=%d ok=%v (frame line %d)", rte.Frame.IP, line, ok, rte.Frame.Line)
+	}
+
+	if _, _, ok := prog.SourcePosition("nope", 0); ok {
+		t.Fatalf("expected unknown function to report false")
+	}
+	if _, _, ok := prog.SourcePosition("f", 10000); ok {
+		t.Fatalf("expected out-of-range ip to report false")
+	}
+}
+
+func TestAPIProgramDisassemble(t *testing.T) {
+	prog, err := Compile("inline", "func add($a, $b) {\n  return $a + $b\n}\n")
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	want := []Instruction{
+		{Function: "add", Offset: 0, Op: "OP_GET_LOCAL", Operands: []int{0}, Line: 2},
+		{Function: "add", Offset: 2, Op: "OP_GET_LOCAL", Operands: []int{1}, Line: 2},
+		{Function: "add", Offset: 4, Op: "OP_ADD", Line: 2},
+		{Function: "add", Offset: 5, Op: "OP_RETURN", Line: 2},
+	}
+	if got := prog.Disassemble(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected instructions:\n got %#v\nwant %#v", got, want)
+	}
+}
+
+func TestAPIDisassembleFunction(t *testing.T) {
+	vm := NewVM()
+	script := `
+func outer($x) {
+  $inner = func($y) { return $x + $y }
+  return $inner(1)
+}
+func other() { return 2 }
+`
+	if err := vm.LoadSource("inline", script); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	_ = vm.SetGlobalFunction("host", NewFunction(nil, func(*Context, map[string]VmValue) (VmValue, error) {
+		return NewValue(nil)
+	}))
+	var buf strings.Builder
+	if err := vm.DisassembleFunction("outer", &buf); err != nil {
+		t.Fatalf("disassemble: %v", err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "func outer (params=1") || !strings.Contains(out, "<closure@const:") {
+		t.Fatalf("expected outer and its closure, got:\n%s", out)
+	}
+	if strings.Contains(out, "func other") {
+		t.Fatalf("unexpected other function in output:\n%s", out)
+	}
+
+	if err := vm.DisassembleFunction("missing", &buf); err == nil {
+		t.Fatalf("expected error for unknown function")
+	}
+	if err := vm.DisassembleFunction("host", &buf); err == nil || !strings.Contains(err.Error(), "not a compiled function") {
+		t.Fatalf("expected error for host function, got %v", err)
+	}
+}
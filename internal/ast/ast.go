@@ -66,6 +66,71 @@ func (r *ReturnStmt) Pos() token.Position { return r.Return }
 func (r *ReturnStmt) Span() token.Span    { return r.StmtSpan }
 func (r *ReturnStmt) stmtNode()           {}
 
+// DeferStmt schedules Call to run when the enclosing function exits, normally or by error.
+type DeferStmt struct {
+	Defer    token.Position
+	Call     *CallExpr
+	StmtSpan token.Span
+}
+
+func (d *DeferStmt) Pos() token.Position { return d.Defer }
+func (d *DeferStmt) Span() token.Span    { return d.StmtSpan }
+func (d *DeferStmt) stmtNode()           {}
+
+// BreakStmt leaves the innermost enclosing loop.
+type BreakStmt struct {
+	Break    token.Position
+	StmtSpan token.Span
+}
+
+func (b *BreakStmt) Pos() token.Position { return b.Break }
+func (b *BreakStmt) Span() token.Span    { return b.StmtSpan }
+func (b *BreakStmt) stmtNode()           {}
+
+// ContinueStmt skips to the next iteration of the innermost enclosing loop.
+type ContinueStmt struct {
+	Continue token.Position
+	StmtSpan token.Span
+}
+
+func (c *ContinueStmt) Pos() token.Position { return c.Continue }
+func (c *ContinueStmt) Span() token.Span    { return c.StmtSpan }
+func (c *ContinueStmt) stmtNode()           {}
+
+// YieldStmt suspends the enclosing generator function, producing Value for its consumer.
+type YieldStmt struct {
+	Yield    token.Position
+	Value    Expression
+	StmtSpan token.Span
+}
+
+func (y *YieldStmt) Pos() token.Position { return y.Yield }
+func (y *YieldStmt) Span() token.Span    { return y.StmtSpan }
+func (y *YieldStmt) stmtNode()           {}
+
+// IterateStmt calls Callback with each value produced by Iterable.
+type IterateStmt struct {
+	Iterate  token.Position
+	Iterable Expression
+	Callback Expression
+	StmtSpan token.Span
+}
+
+func (i *IterateStmt) Pos() token.Position { return i.Iterate }
+func (i *IterateStmt) Span() token.Span    { return i.StmtSpan }
+func (i *IterateStmt) stmtNode()           {}
+
+// ImportStmt names a module whose functions are loaded alongside the program.
+type ImportStmt struct {
+	Import   token.Position
+	Name     string
+	StmtSpan token.Span
+}
+
+func (i *ImportStmt) Pos() token.Position { return i.Import }
+func (i *ImportStmt) Span() token.Span    { return i.StmtSpan }
+func (i *ImportStmt) stmtNode()           {}
+
 type IfStmt struct {
 	IfPos     token.Position
 	Condition Expression
@@ -244,6 +309,19 @@ func (i *IndexExpr) Pos() token.Position { return i.PosT }
 func (i *IndexExpr) Span() token.Span    { return i.Sp }
 func (i *IndexExpr) exprNode()           {}
 
+// SliceExpr is `left[low:high]`; Low and High are nil when omitted.
+type SliceExpr struct {
+	Left Expression
+	Low  Expression
+	High Expression
+	PosT token.Position
+	Sp   token.Span
+}
+
+func (s *SliceExpr) Pos() token.Position { return s.PosT }
+func (s *SliceExpr) Span() token.Span    { return s.Sp }
+func (s *SliceExpr) exprNode()           {}
+
 type MemberExpr struct {
 	Left     Expression
 	Property string
@@ -270,6 +348,7 @@ type AssignExpr struct {
 	Left     Expression
 	Value    Expression
 	Operator token.Type
+	Const    bool // declared via `const`; the binding may not be reassigned
 	PosT     token.Position
 	Sp       token.Span
 }
@@ -303,9 +382,11 @@ func (u *UnaryExpr) exprNode()           {}
 
 type FuncExpr struct {
 	FuncPos token.Position
-	Params  []Param
-	Body    *BlockStmt
-	Sp      token.Span
+	// Receiver is set for methods declared as func $self(...); member calls bind it to the object.
+	Receiver *Param
+	Params   []Param
+	Body     *BlockStmt
+	Sp       token.Span
 }
 
 func (f *FuncExpr) Pos() token.Position { return f.FuncPos }
@@ -0,0 +1,39 @@
+package bind
+
+import (
+	"github.com/xirelogy/go-flux/internal/runtime"
+	"github.com/xirelogy/go-flux/internal/vm"
+)
+
+const opcode byte = 0x88
+
+func init() {
+	runtime.Register(runtime.Spec{
+		Name:    "bind",
+		Opcode:  opcode,
+		Arity:   2,
+		Handler: runBind,
+	})
+}
+
+// runBind returns a function that calls fn with arg prepended to its own arguments.
+func runBind(rt *vm.VM) (vm.Value, error) {
+	arg := rt.Pop()
+	fn := rt.Pop()
+	if fn.Kind != vm.KindFunction || fn.Func == nil {
+		return vm.RuntimeErrorf(rt, "bind expects function, got %s", vm.TypeName(fn))
+	}
+	name := fn.Func.Name
+	if name == "" {
+		name = "<anon>"
+	}
+	rt.Push(vm.NativeClosure("bind("+name+")", []vm.Value{fn, arg}, func(captured []vm.Value) vm.NativeFunc {
+		return func(rt *vm.VM, args []vm.Value) (vm.Value, error) {
+			full := make([]vm.Value, 0, len(args)+1)
+			full = append(full, captured[1])
+			full = append(full, args...)
+			return rt.CallFunction(captured[0], full)
+		}
+	}))
+	return vm.Value{}, nil
+}
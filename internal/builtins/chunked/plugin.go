@@ -0,0 +1,82 @@
+package chunked
+
+import (
+	"math"
+
+	"github.com/xirelogy/go-flux/internal/runtime"
+	"github.com/xirelogy/go-flux/internal/vm"
+)
+
+const (
+	chunkedOpcode byte = 0x8A
+	windowsOpcode byte = 0x8B
+)
+
+// maxResultLen caps the elements a single windows call may copy into its result, which grows with
+// both the array length and n, so a script cannot exhaust host memory with one call.
+const maxResultLen = 1 << 24
+
+func init() {
+	runtime.Register(runtime.Spec{
+		Name:    "chunked",
+		Opcode:  chunkedOpcode,
+		Arity:   2,
+		Handler: runChunked,
+	})
+	runtime.Register(runtime.Spec{
+		Name:    "windows",
+		Opcode:  windowsOpcode,
+		Arity:   2,
+		Handler: runWindows,
+	})
+}
+
+// runChunked splits an array into consecutive sub-arrays of length n; the last may be shorter.
+func runChunked(rt *vm.VM) (vm.Value, error) {
+	arr, n, err := popArgs(rt, "chunked")
+	if err != nil {
+		return vm.Value{}, err
+	}
+	out := make([]vm.Value, 0, (len(arr)+n-1)/n)
+	for start := 0; start < len(arr); start += n {
+		end := start + n
+		if end > len(arr) {
+			end = len(arr)
+		}
+		out = append(out, vm.Array(append([]vm.Value(nil), arr[start:end]...)))
+	}
+	rt.Push(vm.Array(out))
+	return vm.Value{}, nil
+}
+
+// runWindows returns every run of n consecutive elements, advancing one element at a time.
+// An array shorter than n has no windows.
+func runWindows(rt *vm.VM) (vm.Value, error) {
+	arr, n, err := popArgs(rt, "windows")
+	if err != nil {
+		return vm.Value{}, err
+	}
+	if count := len(arr) - n + 1; count > 0 && count > maxResultLen/n {
+		return vm.RuntimeErrorf(rt, "windows result too large (limit %d)", maxResultLen)
+	}
+	out := []vm.Value{}
+	for start := 0; start+n <= len(arr); start++ {
+		out = append(out, vm.Array(append([]vm.Value(nil), arr[start:start+n]...)))
+	}
+	rt.Push(vm.Array(out))
+	return vm.Value{}, nil
+}
+
+func popArgs(rt *vm.VM, name string) ([]vm.Value, int, error) {
+	size := rt.Pop()
+	arr := rt.Pop()
+	if arr.Kind != vm.KindArray {
+		_, err := vm.RuntimeErrorf(rt, "%s expects array", name)
+		return nil, 0, err
+	}
+	if size.Kind != vm.KindNumber || size.Num != math.Trunc(size.Num) || size.Num < 1 || size.Num > math.MaxInt32 {
+		_, err := vm.RuntimeErrorf(rt, "%s expects positive integer size", name)
+		return nil, 0, err
+	}
+	return arr.Arr, int(size.Num), nil
+}
@@ -0,0 +1,148 @@
+package hash
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xirelogy/go-flux/internal/runtime"
+	"github.com/xirelogy/go-flux/internal/vm"
+)
+
+const opcode byte = 0x87
+
+func init() {
+	runtime.Register(runtime.Spec{
+		Name:    "hash",
+		Opcode:  opcode,
+		Arity:   1,
+		Handler: runHash,
+	})
+}
+
+var (
+	errNotData = errors.New("not a data value")
+	errCyclic  = errors.New("cyclic value")
+)
+
+// runHash returns a hex SHA-256 digest of a canonical encoding of the value.
+// Arrays hash in order; object keys are sorted first, so objects with the same
+// entries hash equal regardless of insertion order. Functions and iterators
+// have no stable content and raise a runtime error, as do values containing themselves.
+func runHash(rt *vm.VM) (vm.Value, error) {
+	v := rt.Pop()
+	e := encoder{visiting: make(map[uintptr]bool)}
+	if err := e.encode(v); errors.Is(err, errCyclic) {
+		return vm.RuntimeErrorf(rt, "hash: cyclic value")
+	} else if err != nil {
+		return vm.RuntimeErrorf(rt, "hash expects data value, got %s", vm.TypeName(v))
+	}
+	sum := sha256.Sum256([]byte(e.b.String()))
+	rt.Push(vm.String(hex.EncodeToString(sum[:])))
+	return vm.Value{}, nil
+}
+
+// encoder builds the canonical encoding. visiting holds the arrays and objects on the
+// current path, so a value reached again from inside itself is reported, not recursed into.
+type encoder struct {
+	b        strings.Builder
+	visiting map[uintptr]bool
+}
+
+// enter marks a container as being encoded; the returned func releases it. Shared but
+// acyclic references are fine: only a container that is still open counts as a cycle.
+func (e *encoder) enter(ref uintptr) (func(), error) {
+	if ref == 0 {
+		return func() {}, nil
+	}
+	if e.visiting[ref] {
+		return nil, errCyclic
+	}
+	e.visiting[ref] = true
+	return func() { delete(e.visiting, ref) }, nil
+}
+
+// encode writes a type-tagged, length-prefixed form of v so that distinct
+// values never share an encoding.
+func (e *encoder) encode(v vm.Value) error {
+	b := &e.b
+	switch v.Kind {
+	case vm.KindNull:
+		b.WriteByte('n')
+	case vm.KindBool:
+		if v.B {
+			b.WriteByte('t')
+		} else {
+			b.WriteByte('f')
+		}
+	case vm.KindNumber:
+		n := v.Num
+		if n == 0 {
+			n = 0 // fold -0 into 0, matching equality
+		}
+		if math.IsNaN(n) {
+			b.WriteString("dNaN;")
+			break
+		}
+		b.WriteByte('d')
+		b.WriteString(strconv.FormatFloat(n, 'g', -1, 64))
+		b.WriteByte(';')
+	case vm.KindString:
+		writeString(b, 's', v.Str)
+	case vm.KindError:
+		writeString(b, 'e', v.Err)
+	case vm.KindArray:
+		var ref uintptr
+		if len(v.Arr) > 0 {
+			ref = reflect.ValueOf(v.Arr).Pointer()
+		}
+		release, err := e.enter(ref)
+		if err != nil {
+			return err
+		}
+		defer release()
+		b.WriteByte('a')
+		b.WriteString(strconv.Itoa(len(v.Arr)))
+		b.WriteByte(':')
+		for _, el := range v.Arr {
+			if err := e.encode(el); err != nil {
+				return err
+			}
+		}
+	case vm.KindObject:
+		release, err := e.enter(reflect.ValueOf(v.Obj).Pointer())
+		if err != nil {
+			return err
+		}
+		defer release()
+		keys := make([]string, 0, len(v.Obj))
+		for k := range v.Obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b.WriteByte('o')
+		b.WriteString(strconv.Itoa(len(keys)))
+		b.WriteByte(':')
+		for _, k := range keys {
+			writeString(b, 'k', k)
+			if err := e.encode(v.Obj[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return errNotData
+	}
+	return nil
+}
+
+func writeString(b *strings.Builder, tag byte, s string) {
+	b.WriteByte(tag)
+	b.WriteString(strconv.Itoa(len(s)))
+	b.WriteByte(':')
+	b.WriteString(s)
+}
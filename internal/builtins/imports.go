@@ -1,10 +1,23 @@
 package builtins
 
 import (
+	_ "github.com/xirelogy/go-flux/internal/builtins/bind"
+	_ "github.com/xirelogy/go-flux/internal/builtins/chunked"
 	_ "github.com/xirelogy/go-flux/internal/builtins/error"
+	_ "github.com/xirelogy/go-flux/internal/builtins/hash"
 	_ "github.com/xirelogy/go-flux/internal/builtins/index_exist"
+	_ "github.com/xirelogy/go-flux/internal/builtins/index_of"
 	_ "github.com/xirelogy/go-flux/internal/builtins/index_read"
+	_ "github.com/xirelogy/go-flux/internal/builtins/len"
+	_ "github.com/xirelogy/go-flux/internal/builtins/log"
+	_ "github.com/xirelogy/go-flux/internal/builtins/pipe"
+	_ "github.com/xirelogy/go-flux/internal/builtins/prop"
 	_ "github.com/xirelogy/go-flux/internal/builtins/readonly"
+	_ "github.com/xirelogy/go-flux/internal/builtins/repeat"
+	_ "github.com/xirelogy/go-flux/internal/builtins/scan"
+	_ "github.com/xirelogy/go-flux/internal/builtins/sort"
+	_ "github.com/xirelogy/go-flux/internal/builtins/sort_keys"
+	_ "github.com/xirelogy/go-flux/internal/builtins/try_call"
 	_ "github.com/xirelogy/go-flux/internal/builtins/typeof"
 	_ "github.com/xirelogy/go-flux/internal/builtins/value_exist"
 )
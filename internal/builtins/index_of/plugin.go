@@ -0,0 +1,70 @@
+package index_of
+
+import (
+	"strings"
+
+	"github.com/xirelogy/go-flux/internal/runtime"
+	"github.com/xirelogy/go-flux/internal/vm"
+)
+
+const (
+	indexOfOpcode     byte = 0x8C
+	lastIndexOfOpcode byte = 0x8D
+)
+
+func init() {
+	runtime.Register(runtime.Spec{
+		Name:    "indexOf",
+		Opcode:  indexOfOpcode,
+		Arity:   2,
+		Handler: runIndexOf,
+	})
+	runtime.Register(runtime.Spec{
+		Name:    "lastIndexOf",
+		Opcode:  lastIndexOfOpcode,
+		Arity:   2,
+		Handler: runLastIndexOf,
+	})
+}
+
+func runIndexOf(rt *vm.VM) (vm.Value, error) {
+	return search(rt, "indexOf", false)
+}
+
+func runLastIndexOf(rt *vm.VM) (vm.Value, error) {
+	return search(rt, "lastIndexOf", true)
+}
+
+// search pushes the first (or last) position of val in an array, compared with the standard
+// equality rules, or of a substring in a string (a byte offset). Missing values yield -1.
+func search(rt *vm.VM, name string, last bool) (vm.Value, error) {
+	val := rt.Pop()
+	coll := rt.Pop()
+	idx := -1
+	switch coll.Kind {
+	case vm.KindArray:
+		for i := range coll.Arr {
+			pos := i
+			if last {
+				pos = len(coll.Arr) - 1 - i
+			}
+			if vm.Equal(coll.Arr[pos], val) {
+				idx = pos
+				break
+			}
+		}
+	case vm.KindString:
+		if val.Kind != vm.KindString {
+			return vm.RuntimeErrorf(rt, "%s on string expects string value, got %s", name, vm.TypeName(val))
+		}
+		if last {
+			idx = strings.LastIndex(coll.Str, val.Str)
+		} else {
+			idx = strings.Index(coll.Str, val.Str)
+		}
+	default:
+		return vm.RuntimeErrorf(rt, "%s expects array or string, got %s", name, vm.TypeName(coll))
+	}
+	rt.Push(vm.Number(float64(idx)))
+	return vm.Value{}, nil
+}
@@ -0,0 +1,36 @@
+package len
+
+import (
+	"github.com/xirelogy/go-flux/internal/runtime"
+	"github.com/xirelogy/go-flux/internal/vm"
+)
+
+const opcode byte = 0x94
+
+func init() {
+	runtime.Register(runtime.Spec{
+		Name:    "len",
+		Opcode:  opcode,
+		Arity:   1,
+		Handler: runLen,
+	})
+}
+
+// runLen counts the elements of an array, the keys of an object, or the bytes of a string,
+// so len($s) agrees with $s.length and with slice bounds.
+func runLen(rt *vm.VM) (vm.Value, error) {
+	val := rt.Pop()
+	var n int
+	switch val.Kind {
+	case vm.KindArray:
+		n = len(val.Arr)
+	case vm.KindObject:
+		n = len(val.Obj)
+	case vm.KindString:
+		n = len(val.Str)
+	default:
+		return vm.RuntimeErrorf(rt, "len expects array, object, or string, got %s", vm.TypeName(val))
+	}
+	rt.Push(vm.Number(float64(n)))
+	return vm.Value{}, nil
+}
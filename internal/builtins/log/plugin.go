@@ -0,0 +1,24 @@
+package log
+
+import (
+	"github.com/xirelogy/go-flux/internal/runtime"
+	"github.com/xirelogy/go-flux/internal/vm"
+)
+
+const opcode byte = 0x91
+
+func init() {
+	runtime.Register(runtime.Spec{
+		Name:   "log",
+		Opcode: opcode,
+		Arity:  1,
+		Handler: func(rt *vm.VM) (vm.Value, error) {
+			v := rt.Pop()
+			if sink := rt.LogSink(); sink != nil {
+				sink(v)
+			}
+			rt.Push(vm.Null())
+			return vm.Value{}, nil
+		},
+	})
+}
@@ -0,0 +1,39 @@
+package pipe
+
+import (
+	"github.com/xirelogy/go-flux/internal/runtime"
+	"github.com/xirelogy/go-flux/internal/vm"
+)
+
+const opcode byte = 0x89
+
+func init() {
+	runtime.Register(runtime.Spec{
+		Name:    "pipe",
+		Opcode:  opcode,
+		Arity:   2,
+		Handler: runPipe,
+	})
+}
+
+// runPipe returns a function equivalent to func($x) { return g(f($x)) }. Arguments are passed
+// through to f; a runtime error in either stage stops the pipeline and propagates.
+func runPipe(rt *vm.VM) (vm.Value, error) {
+	g := rt.Pop()
+	f := rt.Pop()
+	for _, fn := range []vm.Value{f, g} {
+		if fn.Kind != vm.KindFunction || fn.Func == nil {
+			return vm.RuntimeErrorf(rt, "pipe expects functions, got %s", vm.TypeName(fn))
+		}
+	}
+	rt.Push(vm.NativeClosure("pipe", []vm.Value{f, g}, func(captured []vm.Value) vm.NativeFunc {
+		return func(rt *vm.VM, args []vm.Value) (vm.Value, error) {
+			mid, err := rt.CallFunction(captured[0], args)
+			if err != nil {
+				return mid, err
+			}
+			return rt.CallFunction(captured[1], []vm.Value{mid})
+		}
+	}))
+	return vm.Value{}, nil
+}
@@ -0,0 +1,37 @@
+package prop
+
+import (
+	"github.com/xirelogy/go-flux/internal/runtime"
+	"github.com/xirelogy/go-flux/internal/vm"
+)
+
+const opcode byte = 0x93
+
+func init() {
+	runtime.Register(runtime.Spec{
+		Name:    "prop",
+		Opcode:  opcode,
+		Arity:   3,
+		Handler: runProp,
+	})
+}
+
+// runProp reads a property of an object, falling back to the default when the key is absent.
+// Unlike indexRead it only accepts objects and string keys, mirroring `$obj.key`.
+func runProp(rt *vm.VM) (vm.Value, error) {
+	def := rt.Pop()
+	key := rt.Pop()
+	obj := rt.Pop()
+	if obj.Kind != vm.KindObject {
+		return vm.RuntimeErrorf(rt, "prop expects object, got %s", vm.TypeName(obj))
+	}
+	if key.Kind != vm.KindString {
+		return vm.RuntimeErrorf(rt, "prop expects string key, got %s", vm.TypeName(key))
+	}
+	val, ok := obj.Obj[key.Str]
+	if !ok {
+		val = def
+	}
+	rt.Push(val)
+	return vm.Value{}, nil
+}
@@ -0,0 +1,59 @@
+package repeat
+
+import (
+	"math"
+	"strings"
+
+	"github.com/xirelogy/go-flux/internal/runtime"
+	"github.com/xirelogy/go-flux/internal/vm"
+)
+
+const opcode byte = 0x8E
+
+// maxResultLen caps the bytes (strings) or elements (arrays) a single repeat may produce,
+// so a script cannot exhaust host memory with one call.
+const maxResultLen = 1 << 24
+
+func init() {
+	runtime.Register(runtime.Spec{
+		Name:    "repeat",
+		Opcode:  opcode,
+		Arity:   2,
+		Handler: runRepeat,
+	})
+}
+
+// runRepeat returns a string or array holding n back-to-back copies of the input.
+func runRepeat(rt *vm.VM) (vm.Value, error) {
+	count := rt.Pop()
+	src := rt.Pop()
+	if count.Kind != vm.KindNumber || count.Num != math.Trunc(count.Num) || count.Num < 0 {
+		return vm.RuntimeErrorf(rt, "repeat expects non-negative integer count")
+	}
+	var unit int
+	switch src.Kind {
+	case vm.KindString:
+		unit = len(src.Str)
+	case vm.KindArray:
+		unit = len(src.Arr)
+	default:
+		return vm.RuntimeErrorf(rt, "repeat expects string or array, got %s", vm.TypeName(src))
+	}
+	if unit > 0 && count.Num > float64(maxResultLen/unit) {
+		return vm.RuntimeErrorf(rt, "repeat result too large (limit %d)", maxResultLen)
+	}
+	n := int(count.Num)
+	if unit == 0 {
+		n = 0
+	}
+	if src.Kind == vm.KindString {
+		rt.Push(vm.String(strings.Repeat(src.Str, n)))
+		return vm.Value{}, nil
+	}
+	out := make([]vm.Value, 0, unit*n)
+	for i := 0; i < n; i++ {
+		out = append(out, src.Arr...)
+	}
+	rt.Push(vm.Array(out))
+	return vm.Value{}, nil
+}
@@ -0,0 +1,43 @@
+package scan
+
+import (
+	"github.com/xirelogy/go-flux/internal/runtime"
+	"github.com/xirelogy/go-flux/internal/vm"
+)
+
+const opcode byte = 0x90
+
+func init() {
+	runtime.Register(runtime.Spec{
+		Name:    "scan",
+		Opcode:  opcode,
+		Arity:   3,
+		Handler: runScan,
+	})
+}
+
+// runScan folds an array with fn($acc, $el), starting from init, and returns every
+// intermediate accumulator: scan([1, 2, 3], add, 0) is [1, 3, 6].
+func runScan(rt *vm.VM) (vm.Value, error) {
+	acc := rt.Pop()
+	fn := rt.Pop()
+	arr := rt.Pop()
+	if arr.Kind != vm.KindArray {
+		return vm.RuntimeErrorf(rt, "scan expects array, got %s", vm.TypeName(arr))
+	}
+	if fn.Kind != vm.KindFunction {
+		return vm.RuntimeErrorf(rt, "scan expects function, got %s", vm.TypeName(fn))
+	}
+	items := append([]vm.Value(nil), arr.Arr...)
+	out := make([]vm.Value, 0, len(items))
+	for _, el := range items {
+		next, err := rt.CallFunction(fn, []vm.Value{acc, el})
+		if err != nil {
+			return vm.Value{}, err
+		}
+		acc = next
+		out = append(out, acc)
+	}
+	rt.Push(vm.Array(out))
+	return vm.Value{}, nil
+}
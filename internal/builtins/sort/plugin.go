@@ -0,0 +1,60 @@
+package sortbuiltin
+
+import (
+	"sort"
+
+	"github.com/xirelogy/go-flux/internal/runtime"
+	"github.com/xirelogy/go-flux/internal/vm"
+)
+
+const opcode byte = 0x86
+
+func init() {
+	runtime.Register(runtime.Spec{
+		Name:    "sort",
+		Opcode:  opcode,
+		Arity:   2,
+		Handler: runSort,
+	})
+}
+
+// runSort returns a stably sorted copy of an array. With a null comparator,
+// numbers and strings sort in natural ascending order; otherwise less($a, $b)
+// decides ordering. Equal elements keep their original relative order.
+func runSort(rt *vm.VM) (vm.Value, error) {
+	less := rt.Pop()
+	arr := rt.Pop()
+	if arr.Kind != vm.KindArray {
+		return vm.RuntimeErrorf(rt, "sort expects array")
+	}
+	if less.Kind != vm.KindNull && less.Kind != vm.KindFunction {
+		return vm.RuntimeErrorf(rt, "sort expects function or null comparator")
+	}
+	out := make([]vm.Value, len(arr.Arr))
+	copy(out, arr.Arr)
+	var sortErr error
+	sort.SliceStable(out, func(i, j int) bool {
+		if sortErr != nil {
+			return false
+		}
+		if less.Kind == vm.KindNull {
+			cmp, err := vm.Compare(out[i], out[j])
+			if err != nil {
+				sortErr = err
+				return false
+			}
+			return cmp < 0
+		}
+		res, err := rt.CallFunction(less, []vm.Value{out[i], out[j]})
+		if err != nil {
+			sortErr = err
+			return false
+		}
+		return rt.Truthy(res)
+	})
+	if sortErr != nil {
+		return vm.Value{}, sortErr
+	}
+	rt.Push(vm.Array(out))
+	return vm.Value{}, nil
+}
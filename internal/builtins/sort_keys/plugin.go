@@ -0,0 +1,39 @@
+package sort_keys
+
+import (
+	"sort"
+
+	"github.com/xirelogy/go-flux/internal/runtime"
+	"github.com/xirelogy/go-flux/internal/vm"
+)
+
+const opcode byte = 0x8F
+
+func init() {
+	runtime.Register(runtime.Spec{
+		Name:    "sortKeys",
+		Opcode:  opcode,
+		Arity:   1,
+		Handler: runSortKeys,
+	})
+}
+
+// runSortKeys returns an object's entries as [key, value] pairs in ascending key order.
+// Objects iterate in insertion order; this gives an order that does not depend on how the object was built.
+func runSortKeys(rt *vm.VM) (vm.Value, error) {
+	obj := rt.Pop()
+	if obj.Kind != vm.KindObject {
+		return vm.RuntimeErrorf(rt, "sortKeys expects object, got %s", vm.TypeName(obj))
+	}
+	keys := make([]string, 0, len(obj.Obj))
+	for k := range obj.Obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]vm.Value, len(keys))
+	for i, k := range keys {
+		out[i] = vm.Array([]vm.Value{vm.String(k), obj.Obj[k]})
+	}
+	rt.Push(vm.Array(out))
+	return vm.Value{}, nil
+}
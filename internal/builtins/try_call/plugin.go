@@ -0,0 +1,47 @@
+package try_call
+
+import (
+	"context"
+	"errors"
+
+	"github.com/xirelogy/go-flux/internal/runtime"
+	"github.com/xirelogy/go-flux/internal/vm"
+)
+
+const opcode byte = 0x92
+
+func init() {
+	runtime.Register(runtime.Spec{
+		Name:    "tryCall",
+		Opcode:  opcode,
+		Arity:   2,
+		Handler: runTryCall,
+	})
+}
+
+// runTryCall calls fn with the elements of args and returns its result, or an error value
+// carrying the message when the call fails at runtime. Cancellation and interruption still propagate.
+func runTryCall(rt *vm.VM) (vm.Value, error) {
+	args := rt.Pop()
+	fn := rt.Pop()
+	if fn.Kind != vm.KindFunction {
+		return vm.RuntimeErrorf(rt, "tryCall expects function, got %s", vm.TypeName(fn))
+	}
+	if args.Kind != vm.KindArray {
+		return vm.RuntimeErrorf(rt, "tryCall expects array of arguments, got %s", vm.TypeName(args))
+	}
+	res, err := rt.CallFunction(fn, append([]vm.Value(nil), args.Arr...))
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, vm.ErrInterrupted) {
+			return vm.Value{}, err
+		}
+		var re *vm.RuntimeError
+		if errors.As(err, &re) {
+			res = vm.ErrorVal(re.Message)
+		} else {
+			res = vm.ErrorVal(err.Error())
+		}
+	}
+	rt.Push(res)
+	return vm.Value{}, nil
+}
@@ -12,14 +12,18 @@ type Prototype struct {
 	Name      string
 	Source    string
 	NumParams int
+	Params    []string // parameter names in declaration order
 	Chunk     *Chunk
 	Upvalues  []Upvalue
 	MaxLocals int
+	Receiver  bool // declared as func $self(...): local slot 0 holds the receiver and params follow
+	Generator bool // body contains yield: calls return an iterator instead of running the body
 }
 
 // Module is the compiled form of a program: a set of function prototypes.
 type Module struct {
 	Functions map[string]*Prototype
+	Imports   []string // module names from top-level import statements, in source order
 }
 
 // Upvalue describes a captured variable.
@@ -28,8 +32,24 @@ type Upvalue struct {
 	Index   uint8
 }
 
-// LineInfo maps bytecode offsets to source lines (start-inclusive).
+// LineInfo maps bytecode offsets to source positions (start-inclusive).
 type LineInfo struct {
 	Offset int
 	Line   int
+	Column int
+}
+
+// PositionForOffset returns the source line and column recorded for the instruction at offset.
+// ok is false when the offset lies outside the chunk or no position was recorded for it.
+func (c *Chunk) PositionForOffset(offset int) (line, column int, ok bool) {
+	if c == nil || offset < 0 || offset >= len(c.Code) {
+		return 0, 0, false
+	}
+	for _, info := range c.Lines {
+		if info.Offset > offset {
+			break
+		}
+		line, column = info.Line, info.Column
+	}
+	return line, column, line > 0
 }
@@ -125,82 +125,166 @@ func (d *Disassembler) disassembleChunk(chunk *Chunk) error {
 }
 
 func (d *Disassembler) decodeOperands(op byte, chunk *Chunk, ip *int) (string, error) {
-	code := chunk.Code
+	vals, err := readOperands(op, chunk.Code, ip)
+	if err != nil {
+		return "", err
+	}
 	switch op {
-	case OP_CONST:
-		idx, err := readU16(code, ip)
-		if err != nil {
-			return "", err
-		}
-		if int(idx) >= len(chunk.Consts) {
+	case OP_CONST, OP_CONST_LONG:
+		idx := vals[0]
+		if idx >= len(chunk.Consts) {
 			return "", fmt.Errorf("const index out of range: %d", idx)
 		}
 		return fmt.Sprintf("%d ; const[%d]=%s", idx, idx, formatConst(chunk.Consts[idx])), nil
-	case OP_GET_GLOBAL, OP_SET_GLOBAL, OP_DEFINE_GLOBAL:
-		idx, err := readU16(code, ip)
-		if err != nil {
-			return "", err
-		}
-		return fmt.Sprintf("%d ; name=%s", idx, formatConstRef(chunk, idx)), nil
-	case OP_GET_PROP, OP_SET_PROP:
-		idx, err := readU16(code, ip)
-		if err != nil {
-			return "", err
+	case OP_GET_GLOBAL, OP_SET_GLOBAL, OP_DEFINE_GLOBAL, OP_GET_GLOBAL_LONG, OP_SET_GLOBAL_LONG, OP_DEFINE_GLOBAL_LONG:
+		return fmt.Sprintf("%d ; name=%s", vals[0], formatConstRef(chunk, vals[0])), nil
+	case OP_GET_PROP, OP_SET_PROP, OP_GET_PROP_LONG, OP_SET_PROP_LONG:
+		return fmt.Sprintf("%d ; prop=%s", vals[0], formatConstRef(chunk, vals[0])), nil
+	case OP_CLOSURE, OP_CLOSURE_LONG:
+		upvals := make([]string, 0, vals[1])
+		for i := 2; i+1 < len(vals); i += 2 {
+			if vals[i] == 1 {
+				upvals = append(upvals, fmt.Sprintf("local %d", vals[i+1]))
+			} else {
+				upvals = append(upvals, fmt.Sprintf("upvalue %d", vals[i+1]))
+			}
 		}
-		return fmt.Sprintf("%d ; prop=%s", idx, formatConstRef(chunk, idx)), nil
-	case OP_GET_LOCAL, OP_SET_LOCAL, OP_GET_UPVALUE, OP_SET_UPVALUE, OP_CALL:
-		slot, err := readU8(code, ip)
-		if err != nil {
-			return "", err
+		operand := fmt.Sprintf("%d %d", vals[0], vals[1])
+		if len(upvals) > 0 {
+			operand = operand + " [" + strings.Join(upvals, ", ") + "]"
 		}
-		return fmt.Sprintf("%d", slot), nil
-	case OP_ARRAY, OP_OBJECT:
-		count, err := readU16(code, ip)
-		if err != nil {
-			return "", err
+		return operand, nil
+	default:
+		if len(vals) == 0 {
+			return "", nil
 		}
-		return fmt.Sprintf("%d", count), nil
-	case OP_JUMP, OP_JUMP_IF_FALSE, OP_JUMP_IF_TRUE, OP_ITER_NEXT:
-		off, err := readU16(code, ip)
+		return strconv.Itoa(vals[0]), nil
+	}
+}
+
+// readOperands decodes the inline operands that follow op, advancing ip past them.
+// OP_CLOSURE yields the prototype index, the upvalue count, then an (isLocal, index) pair per upvalue.
+func readOperands(op byte, code []byte, ip *int) ([]int, error) {
+	switch op {
+	case OP_CONST, OP_GET_GLOBAL, OP_SET_GLOBAL, OP_DEFINE_GLOBAL, OP_GET_PROP, OP_SET_PROP,
+		OP_ARRAY, OP_OBJECT, OP_JUMP, OP_JUMP_IF_FALSE, OP_JUMP_IF_TRUE, OP_ITER_NEXT:
+		v, err := readU16(code, ip)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-		return fmt.Sprintf("%d", off), nil
-	case OP_CLOSURE:
-		idx, err := readU16(code, ip)
+		return []int{int(v)}, nil
+	case OP_CONST_LONG, OP_GET_GLOBAL_LONG, OP_SET_GLOBAL_LONG, OP_DEFINE_GLOBAL_LONG, OP_GET_PROP_LONG, OP_SET_PROP_LONG:
+		v, err := readU24(code, ip)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-		upcount, err := readU8(code, ip)
+		return []int{v}, nil
+	case OP_GET_LOCAL, OP_SET_LOCAL, OP_GET_UPVALUE, OP_SET_UPVALUE, OP_CALL, OP_CALL_METHOD, OP_POP_N:
+		v, err := readU8(code, ip)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-		upvals := make([]string, 0, upcount)
-		for i := 0; i < int(upcount); i++ {
-			isLocal, err := readU8(code, ip)
+		return []int{int(v)}, nil
+	case OP_CLOSURE, OP_CLOSURE_LONG:
+		var idx int
+		if op == OP_CLOSURE_LONG {
+			v, err := readU24(code, ip)
 			if err != nil {
-				return "", err
+				return nil, err
 			}
-			slot, err := readU8(code, ip)
+			idx = v
+		} else {
+			v, err := readU16(code, ip)
 			if err != nil {
-				return "", err
-			}
-			if isLocal == 1 {
-				upvals = append(upvals, fmt.Sprintf("local %d", slot))
-			} else {
-				upvals = append(upvals, fmt.Sprintf("upvalue %d", slot))
+				return nil, err
 			}
+			idx = int(v)
 		}
-		operand := fmt.Sprintf("%d %d", idx, upcount)
-		if len(upvals) > 0 {
-			operand = operand + " [" + strings.Join(upvals, ", ") + "]"
+		upcount, err := readU8(code, ip)
+		if err != nil {
+			return nil, err
 		}
-		return operand, nil
+		out := make([]int, 0, 2+2*int(upcount))
+		out = append(out, int(idx), int(upcount))
+		for i := 0; i < 2*int(upcount); i++ {
+			b, err := readU8(code, ip)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, int(b))
+		}
+		return out, nil
 	default:
-		return "", nil
+		return nil, nil
 	}
 }
 
+// OperandWidth returns how many operand bytes follow op when its operands start at ip in code,
+// or -1 when they would run past the end of code. It does not allocate, so the VM can check
+// every instruction before decoding it.
+func OperandWidth(op byte, code []byte, ip int) int {
+	width := 0
+	switch op {
+	case OP_CONST, OP_GET_GLOBAL, OP_SET_GLOBAL, OP_DEFINE_GLOBAL, OP_GET_PROP, OP_SET_PROP,
+		OP_ARRAY, OP_OBJECT, OP_JUMP, OP_JUMP_IF_FALSE, OP_JUMP_IF_TRUE, OP_ITER_NEXT:
+		width = 2
+	case OP_CONST_LONG, OP_GET_GLOBAL_LONG, OP_SET_GLOBAL_LONG, OP_DEFINE_GLOBAL_LONG, OP_GET_PROP_LONG, OP_SET_PROP_LONG:
+		width = 3
+	case OP_GET_LOCAL, OP_SET_LOCAL, OP_GET_UPVALUE, OP_SET_UPVALUE, OP_CALL, OP_CALL_METHOD, OP_POP_N:
+		width = 1
+	case OP_CLOSURE:
+		if ip+3 > len(code) {
+			return -1
+		}
+		width = 3 + 2*int(code[ip+2])
+	case OP_CLOSURE_LONG:
+		if ip+4 > len(code) {
+			return -1
+		}
+		width = 4 + 2*int(code[ip+3])
+	}
+	if ip+width > len(code) {
+		return -1
+	}
+	return width
+}
+
+// Instruction is a decoded bytecode instruction, for tools that want structured output.
+type Instruction struct {
+	Offset   int
+	Op       byte
+	Name     string
+	Operands []int
+	Line     int
+}
+
+// Decode returns the instructions of chunk in offset order.
+func Decode(chunk *Chunk) ([]Instruction, error) {
+	if chunk == nil {
+		return nil, fmt.Errorf("nil chunk")
+	}
+	var out []Instruction
+	code := chunk.Code
+	for ip := 0; ip < len(code); {
+		offset := ip
+		op := code[ip]
+		ip++
+		operands, err := readOperands(op, code, &ip)
+		if err != nil {
+			return out, err
+		}
+		name, _ := opName(op)
+		out = append(out, Instruction{
+			Offset:   offset,
+			Op:       op,
+			Name:     name,
+			Operands: operands,
+			Line:     lineForOffset(chunk.Lines, offset),
+		})
+	}
+	return out, nil
+}
+
 func opName(op byte) (string, string) {
 	if info, ok := LookupBuiltinInfo(op); ok {
 		return "OP_BUILTIN_" + info.Name, fmt.Sprintf("arity=%d", info.Arity)
@@ -219,6 +303,12 @@ func opName(op byte) (string, string) {
 		return "OP_FALSE", ""
 	case OP_POP:
 		return "OP_POP", ""
+	case OP_DUP:
+		return "OP_DUP", ""
+	case OP_DUP2:
+		return "OP_DUP2", ""
+	case OP_SWAP:
+		return "OP_SWAP", ""
 	case OP_ADD:
 		return "OP_ADD", ""
 	case OP_SUB:
@@ -231,6 +321,10 @@ func opName(op byte) (string, string) {
 		return "OP_NEG", ""
 	case OP_NOT:
 		return "OP_NOT", ""
+	case OP_POS:
+		return "OP_POS", ""
+	case OP_MOD:
+		return "OP_MOD", ""
 	case OP_EQ:
 		return "OP_EQ", ""
 	case OP_NEQ:
@@ -253,6 +347,12 @@ func opName(op byte) (string, string) {
 		return "OP_SET_GLOBAL", ""
 	case OP_DEFINE_GLOBAL:
 		return "OP_DEFINE_GLOBAL", ""
+	case OP_GET_GLOBAL_LONG:
+		return "OP_GET_GLOBAL_LONG", ""
+	case OP_SET_GLOBAL_LONG:
+		return "OP_SET_GLOBAL_LONG", ""
+	case OP_DEFINE_GLOBAL_LONG:
+		return "OP_DEFINE_GLOBAL_LONG", ""
 	case OP_GET_LOCAL:
 		return "OP_GET_LOCAL", ""
 	case OP_SET_LOCAL:
@@ -275,6 +375,12 @@ func opName(op byte) (string, string) {
 		return "OP_GET_PROP", ""
 	case OP_SET_PROP:
 		return "OP_SET_PROP", ""
+	case OP_GET_PROP_LONG:
+		return "OP_GET_PROP_LONG", ""
+	case OP_SET_PROP_LONG:
+		return "OP_SET_PROP_LONG", ""
+	case OP_SLICE:
+		return "OP_SLICE", ""
 	case OP_JUMP:
 		return "OP_JUMP", ""
 	case OP_JUMP_IF_FALSE:
@@ -283,14 +389,26 @@ func opName(op byte) (string, string) {
 		return "OP_JUMP_IF_TRUE", ""
 	case OP_CALL:
 		return "OP_CALL", ""
+	case OP_CALL_METHOD:
+		return "OP_CALL_METHOD", ""
+	case OP_DEFER:
+		return "OP_DEFER", ""
+	case OP_YIELD:
+		return "OP_YIELD", ""
 	case OP_RETURN:
 		return "OP_RETURN", ""
 	case OP_CLOSURE:
 		return "OP_CLOSURE", ""
+	case OP_CLOSURE_LONG:
+		return "OP_CLOSURE_LONG", ""
 	case OP_NOP:
 		return "OP_NOP", ""
 	case OP_DEBUG:
 		return "OP_DEBUG", ""
+	case OP_CONST_LONG:
+		return "OP_CONST_LONG", ""
+	case OP_POP_N:
+		return "OP_POP_N", ""
 	case OP_ITER_PREP:
 		return "OP_ITER_PREP", ""
 	case OP_ITER_NEXT:
@@ -330,8 +448,17 @@ func readU16(code []byte, ip *int) (uint16, error) {
 	return uint16(hi)<<8 | uint16(lo), nil
 }
 
-func formatConstRef(chunk *Chunk, idx uint16) string {
-	if chunk == nil || int(idx) >= len(chunk.Consts) {
+func readU24(code []byte, ip *int) (int, error) {
+	if *ip+2 >= len(code) {
+		return 0, fmt.Errorf("unexpected end of bytecode")
+	}
+	v := int(code[*ip])<<16 | int(code[*ip+1])<<8 | int(code[*ip+2])
+	*ip += 3
+	return v, nil
+}
+
+func formatConstRef(chunk *Chunk, idx int) string {
+	if chunk == nil || idx >= len(chunk.Consts) {
 		return "<invalid>"
 	}
 	return formatConst(chunk.Consts[idx])
@@ -31,3 +31,41 @@ func TestDisassembleBuiltinName(t *testing.T) {
 		t.Fatalf("expected arity, got:\n%s", out)
 	}
 }
+
+func TestDisassembleStackOps(t *testing.T) {
+	proto := &Prototype{
+		Name:  "test",
+		Chunk: &Chunk{Code: []byte{OP_NULL, OP_DUP, OP_DUP2, OP_SWAP, OP_POP_N, 4}},
+	}
+	var buf bytes.Buffer
+	if err := NewDisassembler(&buf).DisassemblePrototype("test", proto); err != nil {
+		t.Fatalf("disassemble: %v", err)
+	}
+	for _, name := range []string{"OP_DUP", "OP_DUP2", "OP_SWAP", "OP_POP_N"} {
+		if !strings.Contains(buf.String(), name) {
+			t.Fatalf("expected %s in output:\n%s", name, buf.String())
+		}
+	}
+	if !strings.HasSuffix(buf.String(), " 4\n") {
+		t.Fatalf("expected OP_POP_N count operand in output:\n%s", buf.String())
+	}
+}
+
+func TestDisassembleConstLong(t *testing.T) {
+	consts := make([]interface{}, 0x10001)
+	consts[0x10000] = "far"
+	proto := &Prototype{
+		Name:  "test",
+		Chunk: &Chunk{Code: []byte{OP_CONST_LONG, 0x01, 0x00, 0x00, OP_RETURN}, Consts: consts},
+	}
+	var buf bytes.Buffer
+	if err := NewDisassembler(&buf).DisassemblePrototype("test", proto); err != nil {
+		t.Fatalf("disassemble: %v", err)
+	}
+	if !strings.Contains(buf.String(), `OP_CONST_LONG    65536 ; const[65536]="far"`) {
+		t.Fatalf("unexpected output:\n%s", buf.String())
+	}
+	if OperandWidth(OP_CONST_LONG, proto.Chunk.Code, 1) != 3 || OperandWidth(OP_CONST_LONG, proto.Chunk.Code[:3], 1) != -1 {
+		t.Fatalf("unexpected OP_CONST_LONG operand width")
+	}
+}
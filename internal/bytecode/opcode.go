@@ -8,9 +8,9 @@ const (
 	OP_TRUE
 	OP_FALSE
 	OP_POP
-	_ // reserved
-	_ // reserved
-	_ // reserved
+	OP_DUP
+	OP_DUP2
+	OP_SWAP
 
 	OP_ADD
 	OP_SUB
@@ -18,8 +18,8 @@ const (
 	OP_DIV
 	OP_NEG
 	OP_NOT
-	_ // reserved
-	_ // reserved
+	OP_POS
+	OP_MOD
 
 	OP_EQ
 	OP_NEQ
@@ -33,9 +33,9 @@ const (
 	OP_GET_GLOBAL
 	OP_SET_GLOBAL
 	OP_DEFINE_GLOBAL
-	_ // reserved
-	_ // reserved
-	_ // reserved
+	OP_GET_GLOBAL_LONG
+	OP_SET_GLOBAL_LONG
+	OP_DEFINE_GLOBAL_LONG
 	_ // reserved
 	_ // reserved
 
@@ -55,7 +55,7 @@ const (
 	OP_INDEX_SET
 	OP_GET_PROP
 	OP_SET_PROP
-	_ // reserved
+	OP_SLICE
 
 	OP_JUMP
 	OP_JUMP_IF_FALSE
@@ -69,16 +69,20 @@ const (
 	OP_CALL
 	OP_RETURN
 	OP_CLOSURE
-	_ // reserved
-	_ // reserved
-	_ // reserved
-	_ // reserved
+	OP_CALL_METHOD
+	OP_DEFER
+	OP_YIELD
+	OP_CLOSURE_LONG
 	_ // reserved
 )
 
 const (
-	OP_NOP   byte = 0x40
-	OP_DEBUG      = 0x41
+	OP_NOP           byte = 0x40
+	OP_DEBUG              = 0x41
+	OP_CONST_LONG         = 0x42
+	OP_POP_N              = 0x43
+	OP_GET_PROP_LONG      = 0x44
+	OP_SET_PROP_LONG      = 0x45
 
 	OP_ITER_PREP byte = 0x48
 	OP_ITER_NEXT      = 0x49
@@ -0,0 +1,129 @@
+package bytecode
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Verify checks that every prototype in mod is well-formed enough to execute without panicking:
+// opcodes are known, operands fit in the chunk, jumps land on instruction boundaries, and
+// constant, local, and upvalue references are in range. Nested closure prototypes are checked too.
+func Verify(mod *Module) error {
+	if mod == nil {
+		return fmt.Errorf("nil module")
+	}
+	names := make([]string, 0, len(mod.Functions))
+	for name := range mod.Functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	visited := make(map[*Prototype]bool)
+	for _, name := range names {
+		if err := verifyPrototype(name, mod.Functions[name], visited); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func verifyPrototype(label string, proto *Prototype, visited map[*Prototype]bool) error {
+	if proto == nil || proto.Chunk == nil {
+		return fmt.Errorf("verify %s: missing prototype", label)
+	}
+	if visited[proto] {
+		return nil
+	}
+	visited[proto] = true
+	fail := func(offset int, format string, args ...any) error {
+		return fmt.Errorf("verify %s: offset %d: %s", label, offset, fmt.Sprintf(format, args...))
+	}
+
+	chunk := proto.Chunk
+	code := chunk.Code
+	starts := make(map[int]bool)
+	var jumps [][2]int // offset, target
+	for ip := 0; ip < len(code); {
+		offset := ip
+		op := code[ip]
+		ip++
+		starts[offset] = true
+		if name, _ := opName(op); strings.HasPrefix(name, "OP_0x") || strings.HasPrefix(name, "OP_BUILTIN_0x") {
+			return fail(offset, "unknown opcode 0x%02X", op)
+		}
+		if OperandWidth(op, code, ip) < 0 {
+			return fail(offset, "operands run past end of bytecode")
+		}
+		operands, err := readOperands(op, code, &ip)
+		if err != nil {
+			return fail(offset, "%v", err)
+		}
+		switch op {
+		case OP_CONST, OP_CONST_LONG:
+			if operands[0] >= len(chunk.Consts) {
+				return fail(offset, "const index %d out of range (%d consts)", operands[0], len(chunk.Consts))
+			}
+		case OP_GET_GLOBAL, OP_SET_GLOBAL, OP_DEFINE_GLOBAL, OP_GET_PROP, OP_SET_PROP,
+			OP_GET_GLOBAL_LONG, OP_SET_GLOBAL_LONG, OP_DEFINE_GLOBAL_LONG, OP_GET_PROP_LONG, OP_SET_PROP_LONG:
+			if operands[0] >= len(chunk.Consts) {
+				return fail(offset, "name index %d out of range (%d consts)", operands[0], len(chunk.Consts))
+			}
+			if _, ok := chunk.Consts[operands[0]].(string); !ok {
+				return fail(offset, "name constant %d is not a string", operands[0])
+			}
+		case OP_GET_LOCAL, OP_SET_LOCAL:
+			if operands[0] >= proto.MaxLocals {
+				return fail(offset, "local slot %d out of range (%d locals)", operands[0], proto.MaxLocals)
+			}
+		case OP_GET_UPVALUE, OP_SET_UPVALUE:
+			if operands[0] >= len(proto.Upvalues) {
+				return fail(offset, "upvalue %d out of range (%d upvalues)", operands[0], len(proto.Upvalues))
+			}
+		case OP_JUMP, OP_JUMP_IF_FALSE, OP_JUMP_IF_TRUE, OP_ITER_NEXT:
+			jumps = append(jumps, [2]int{offset, operands[0]})
+		case OP_YIELD:
+			if !proto.Generator {
+				return fail(offset, "yield in a prototype not marked as generator")
+			}
+		case OP_CLOSURE, OP_CLOSURE_LONG:
+			if operands[0] >= len(chunk.Consts) {
+				return fail(offset, "closure index %d out of range (%d consts)", operands[0], len(chunk.Consts))
+			}
+			child, ok := chunk.Consts[operands[0]].(*Prototype)
+			if !ok {
+				return fail(offset, "closure constant %d is not a prototype", operands[0])
+			}
+			if operands[1] != len(child.Upvalues) {
+				return fail(offset, "closure captures %d upvalues, prototype declares %d", operands[1], len(child.Upvalues))
+			}
+			for i := 2; i+1 < len(operands); i += 2 {
+				if operands[i] == 1 && operands[i+1] >= proto.MaxLocals {
+					return fail(offset, "captured local slot %d out of range (%d locals)", operands[i+1], proto.MaxLocals)
+				}
+				if operands[i] != 1 && operands[i+1] >= len(proto.Upvalues) {
+					return fail(offset, "captured upvalue %d out of range (%d upvalues)", operands[i+1], len(proto.Upvalues))
+				}
+			}
+		}
+	}
+	for _, j := range jumps {
+		if j[1] != len(code) && !starts[j[1]] {
+			return fail(j[0], "jump target %d is not an instruction boundary", j[1])
+		}
+	}
+
+	for idx, c := range chunk.Consts {
+		child, ok := c.(*Prototype)
+		if !ok {
+			continue
+		}
+		childName := child.Name
+		if childName == "" {
+			childName = fmt.Sprintf("%s/<closure@const:%d>", label, idx)
+		}
+		if err := verifyPrototype(childName, child, visited); err != nil {
+			return err
+		}
+	}
+	return nil
+}
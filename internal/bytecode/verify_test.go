@@ -0,0 +1,61 @@
+package bytecode
+
+import (
+	"strings"
+	"testing"
+)
+
+func verifyProto(code []byte, consts []any) error {
+	proto := &Prototype{Name: "main", Chunk: &Chunk{Code: code, Consts: consts}}
+	return Verify(&Module{Functions: map[string]*Prototype{"main": proto}})
+}
+
+func TestVerifyAcceptsValidChunk(t *testing.T) {
+	code := []byte{OP_CONST, 0, 0, OP_JUMP, 0, 7, OP_NULL, OP_RETURN}
+	if err := verifyProto(code, []any{int64(1)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestVerifyRejectsJumpOutOfRange(t *testing.T) {
+	code := []byte{OP_JUMP, 0x01, 0x00, OP_NULL, OP_RETURN}
+	err := verifyProto(code, nil)
+	if err == nil || !strings.Contains(err.Error(), "jump target 256") {
+		t.Fatalf("expected jump target error, got %v", err)
+	}
+}
+
+func TestVerifyRejectsJumpIntoOperand(t *testing.T) {
+	code := []byte{OP_CONST, 0, 0, OP_JUMP, 0, 1, OP_RETURN}
+	err := verifyProto(code, []any{int64(1)})
+	if err == nil || !strings.Contains(err.Error(), "not an instruction boundary") {
+		t.Fatalf("expected boundary error, got %v", err)
+	}
+}
+
+func TestVerifyRejectsConstIndexOutOfRange(t *testing.T) {
+	code := []byte{OP_CONST, 0, 3, OP_RETURN}
+	err := verifyProto(code, []any{int64(1)})
+	if err == nil || !strings.Contains(err.Error(), "const index 3 out of range") {
+		t.Fatalf("expected const index error, got %v", err)
+	}
+}
+
+func TestVerifyRejectsYieldOutsideGenerator(t *testing.T) {
+	code := []byte{OP_NULL, OP_YIELD, OP_NULL, OP_RETURN}
+	err := verifyProto(code, nil)
+	if err == nil || !strings.Contains(err.Error(), "not marked as generator") {
+		t.Fatalf("expected generator error, got %v", err)
+	}
+	proto := &Prototype{Name: "gen", Generator: true, Chunk: &Chunk{Code: code}}
+	if err := Verify(&Module{Functions: map[string]*Prototype{"gen": proto}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestVerifyRejectsTruncatedOperands(t *testing.T) {
+	err := verifyProto([]byte{OP_CONST, 0}, []any{int64(1)})
+	if err == nil || !strings.Contains(err.Error(), "past end of bytecode") {
+		t.Fatalf("expected truncation error, got %v", err)
+	}
+}
@@ -16,6 +16,15 @@ func builtinName(expr ast.Expression) (string, bool) {
 	return "", false
 }
 
+// checkShadowsBuiltin rejects a function declaration named after a registered builtin. Calls by
+// that name always resolve to the builtin, so the declaration could never be reached.
+func checkShadowsBuiltin(fn *ast.FuncDecl) error {
+	if _, exists := runtime.LookupByName(fn.Name); exists {
+		return withLine(fn.Pos().Line, fmt.Errorf("function %s shadows builtin %s", fn.Name, fn.Name))
+	}
+	return nil
+}
+
 func (fc *funcCompiler) emitBuiltin(name string, argc int) error {
 	spec, ok := runtime.LookupByName(name)
 	if !ok {
@@ -28,6 +37,18 @@ func (fc *funcCompiler) emitBuiltin(name string, argc int) error {
 	return nil
 }
 
+// checkArity validates a call to a known top-level function when arity checking is enabled.
+func (fc *funcCompiler) checkArity(call *ast.CallExpr) error {
+	ident, ok := call.Callee.(*ast.Identifier)
+	if !ok || fc.arity == nil || fc.scope.declares(ident.Name) {
+		return nil
+	}
+	if want, ok := fc.arity[ident.Name]; ok && want != len(call.Arguments) {
+		return fmt.Errorf("function %s expects %d args, got %d", ident.Name, want, len(call.Arguments))
+	}
+	return nil
+}
+
 func errArgs(name string, want, got int) error {
 	return fmt.Errorf("builtin %s expects %d args, got %d", name, want, got)
 }
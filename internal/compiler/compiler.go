@@ -8,56 +8,136 @@ import (
 	"github.com/xirelogy/go-flux/internal/token"
 )
 
+// CompileOptions enables optional compile-time checks.
+type CompileOptions struct {
+	// CheckArity rejects calls to a top-level function, by its plain name, whose argument
+	// count differs from the declared parameter count. Calls through variables, properties,
+	// or nested declarations stay unchecked.
+	CheckArity bool
+	// Arity adds parameter counts for functions declared outside the program, such as those
+	// already loaded into a VM. The program's own declarations take precedence.
+	Arity map[string]int
+}
+
 // Compile parses a program AST into a Module of function prototypes.
 func Compile(prog *ast.Program, source string) (*Module, error) {
+	return CompileWithOptions(prog, source, CompileOptions{})
+}
+
+// CompileWithOptions is Compile with optional checks enabled.
+func CompileWithOptions(prog *ast.Program, source string, opts CompileOptions) (*Module, error) {
 	c := &compiler{
 		module: &Module{Functions: make(map[string]*Prototype)},
 		source: source,
 	}
+	if opts.CheckArity {
+		c.arity = make(map[string]int, len(opts.Arity))
+		for name, n := range opts.Arity {
+			c.arity[name] = n
+		}
+		for _, stmt := range prog.Statements {
+			if fn, ok := stmt.(*ast.FuncDecl); ok {
+				c.arity[fn.Name] = len(fn.Params)
+			}
+		}
+	}
 
 	for _, stmt := range prog.Statements {
 		switch fn := stmt.(type) {
 		case *ast.FuncDecl:
+			if err := checkShadowsBuiltin(fn); err != nil {
+				return nil, err
+			}
 			proto, err := c.compileFunction(fn)
 			if err != nil {
 				return nil, err
 			}
 			c.module.Functions[fn.Name] = proto
+		case *ast.ImportStmt:
+			c.module.Imports = append(c.module.Imports, fn.Name)
+		case *ast.ReturnStmt:
+			return nil, withLine(fn.Pos().Line, fmt.Errorf("return outside function"))
 		default:
-			return nil, fmt.Errorf("top-level statements other than func are not supported")
+			return nil, withLine(stmt.Pos().Line, fmt.Errorf("top-level statements other than func are not supported"))
 		}
 	}
 
 	return c.module, nil
 }
 
+// CompileEval compiles top-level statements as the body of an anonymous function that returns
+// the value of the final expression statement (null if there is none), for REPL-style evaluation.
+// Function declarations are rejected: the body is discarded after the run, so they would vanish.
+func CompileEval(prog *ast.Program, source string, opts CompileOptions) (*Prototype, error) {
+	for _, stmt := range prog.Statements {
+		if fn, ok := stmt.(*ast.FuncDecl); ok {
+			return nil, withPos(fn.Pos(), fmt.Errorf("function declarations are not allowed in eval: func %s", fn.Name))
+		}
+	}
+	stmts := append([]ast.Statement(nil), prog.Statements...)
+	if n := len(stmts); n > 0 {
+		if es, ok := stmts[n-1].(*ast.ExprStmt); ok {
+			if _, isAssign := es.Expression.(*ast.AssignExpr); !isAssign {
+				stmts[n-1] = &ast.ReturnStmt{Return: es.Pos(), Value: es.Expression, StmtSpan: es.Span()}
+			}
+		}
+	}
+	c := &compiler{source: source}
+	if opts.CheckArity {
+		c.arity = opts.Arity
+	}
+	return c.compileFunction(&ast.FuncDecl{Body: &ast.BlockStmt{Statements: stmts}})
+}
+
 type compiler struct {
 	module *Module
 	source string
 	errors []error
+	arity  map[string]int // top-level parameter counts when CheckArity is on
 }
 
 type funcCompiler struct {
 	chunk  *Chunk
 	scope  *scope
 	line   int
+	column int
 	temp   int
 	source string
+	arity  map[string]int
+	// constErr records the first constant index that does not fit its operand.
+	constErr error
+	// loops holds the enclosing loops of the statement being compiled, innermost last.
+	loops []*loopScope
+	// generator is set once the body compiles a yield.
+	generator bool
 }
 
+// loopScope tracks where a loop's continue statements jump and which break jumps await its exit.
+type loopScope struct {
+	continueTarget int
+	breaks         []int
+}
+
+// maxConstLong is the largest constant index the _LONG opcode forms can address.
+const maxConstLong = 1<<24 - 1
+
 func (c *compiler) compileFunction(fn *ast.FuncDecl) (*Prototype, error) {
 	fc := newFuncCompiler(c.source)
+	fc.arity = c.arity
 
 	// parameters as locals
-	for i, p := range fn.Params {
-		if i >= 255 {
-			return nil, fmt.Errorf("too many parameters")
-		}
+	if err := checkParams(fn.Name, fn.Pos(), fn.Params); err != nil {
+		return nil, err
+	}
+	for _, p := range fn.Params {
 		fc.scope.addLocal(p.Name)
 	}
 
 	if err := fc.compileBlock(fn.Body); err != nil {
-		return nil, err
+		return nil, withLine(fc.line, err)
+	}
+	if fc.constErr != nil {
+		return nil, fc.constErr
 	}
 
 	// ensure function returns null if no explicit return
@@ -70,12 +150,29 @@ func (c *compiler) compileFunction(fn *ast.FuncDecl) (*Prototype, error) {
 		Name:      fn.Name,
 		Source:    c.source,
 		NumParams: len(fn.Params),
+		Params:    paramNames(fn.Params),
 		Chunk:     fc.chunk,
 		Upvalues:  fc.scope.upvalues,
 		MaxLocals: int(fc.scope.nextLoc),
+		Generator: fc.generator,
 	}, nil
 }
 
+// maxParams is the most parameters a function may declare: locals are addressed by a single byte.
+const maxParams = 255
+
+// checkParams rejects a function declaring more parameters than local slots can address,
+// positioned at the function itself so the declaration is easy to find.
+func checkParams(name string, pos token.Position, params []ast.Param) error {
+	if len(params) <= maxParams {
+		return nil
+	}
+	if name == "" {
+		name = "<anon>"
+	}
+	return withPos(pos, fmt.Errorf("too many parameters: function %s declares %d, at most %d allowed", name, len(params), maxParams))
+}
+
 func newFuncCompiler(source string) *funcCompiler {
 	return &funcCompiler{
 		chunk:  &Chunk{},
@@ -114,13 +211,18 @@ func (fc *funcCompiler) lastOp() byte {
 
 func (fc *funcCompiler) compileBlock(block *ast.BlockStmt) error {
 	for _, stmt := range block.Statements {
-		fc.setLine(stmt.Pos().Line)
+		fc.setPos(stmt.Pos())
 		switch s := stmt.(type) {
 		case *ast.ExprStmt:
-			if err := fc.compileExpr(s.Expression); err != nil {
-				return err
-			}
-			if _, ok := s.Expression.(*ast.AssignExpr); !ok {
+			// Assignments consume their value (net stack effect 0); every other expression pushes exactly one.
+			if assign, ok := s.Expression.(*ast.AssignExpr); ok {
+				if err := fc.compileAssign(assign); err != nil {
+					return err
+				}
+			} else {
+				if err := fc.compileExpr(s.Expression); err != nil {
+					return err
+				}
 				fc.emitByte(OP_POP)
 			}
 		case *ast.ReturnStmt:
@@ -148,6 +250,34 @@ func (fc *funcCompiler) compileBlock(block *ast.BlockStmt) error {
 			if err := fc.compileNestedFuncDecl(s); err != nil {
 				return err
 			}
+		case *ast.DeferStmt:
+			if err := fc.compileDefer(s); err != nil {
+				return err
+			}
+		case *ast.YieldStmt:
+			if err := fc.compileExpr(s.Value); err != nil {
+				return err
+			}
+			fc.setPos(s.Pos())
+			fc.emitByte(OP_YIELD)
+			fc.generator = true
+		case *ast.IterateStmt:
+			if err := fc.compileIterate(s); err != nil {
+				return err
+			}
+		case *ast.BreakStmt:
+			if len(fc.loops) == 0 {
+				return fmt.Errorf("break outside loop")
+			}
+			loop := fc.loops[len(fc.loops)-1]
+			loop.breaks = append(loop.breaks, fc.emitJump(OP_JUMP))
+		case *ast.ContinueStmt:
+			if len(fc.loops) == 0 {
+				return fmt.Errorf("continue outside loop")
+			}
+			fc.emitLoop(fc.loops[len(fc.loops)-1].continueTarget)
+		case *ast.ImportStmt:
+			return fmt.Errorf("import is only allowed at top level")
 		default:
 			return fmt.Errorf("unsupported statement type %T", stmt)
 		}
@@ -203,15 +333,33 @@ func (fc *funcCompiler) compileWhile(stmt *ast.WhileStmt) error {
 	// jump out if false
 	exitJump := fc.emitJump(OP_JUMP_IF_FALSE)
 	fc.emitByte(OP_POP)
-	if err := fc.compileBlock(stmt.Body); err != nil {
+	loop, err := fc.compileLoopBody(stmt.Body, loopStart)
+	if err != nil {
 		return err
 	}
 	fc.emitLoop(loopStart)
 	fc.patchJump(exitJump)
 	fc.emitByte(OP_POP)
+	// break leaves after the condition was popped, so it lands past the exit path's pop.
+	fc.patchBreaks(loop)
 	return nil
 }
 
+// compileLoopBody compiles a loop body with break/continue bound to the loop.
+func (fc *funcCompiler) compileLoopBody(body *ast.BlockStmt, continueTarget int) (*loopScope, error) {
+	loop := &loopScope{continueTarget: continueTarget}
+	fc.loops = append(fc.loops, loop)
+	err := fc.compileBlock(body)
+	fc.loops = fc.loops[:len(fc.loops)-1]
+	return loop, err
+}
+
+func (fc *funcCompiler) patchBreaks(loop *loopScope) {
+	for _, pos := range loop.breaks {
+		fc.patchJump(pos)
+	}
+}
+
 func (fc *funcCompiler) compileForIn(stmt *ast.ForStmt) error {
 	// iterator preparation
 	if err := fc.compileExpr(stmt.Iterable); err != nil {
@@ -222,30 +370,81 @@ func (fc *funcCompiler) compileForIn(stmt *ast.ForStmt) error {
 	loopStart := len(fc.chunk.Code)
 	iterNextPos := fc.emitJump(OP_ITER_NEXT) // jump target patched to exit; opcode consumes iterator?
 
+	for _, name := range []string{stmt.Binding.Key, stmt.Binding.ValueName} {
+		if name != "" && name != discardName && fc.scope.isConst(name) {
+			return errConstAssign(name)
+		}
+	}
+
 	// When OP_ITER_NEXT succeeds, it should push key/value or value. We assign to bindings.
-	if stmt.Binding.Key != "" {
-		keySlot := fc.ensureLocal(stmt.Binding.Key)
-		valSlot := fc.ensureLocal(stmt.Binding.ValueName)
-		// stack: ... key value
-		fc.emitBytes(OP_SET_LOCAL, valSlot)
-		fc.emitBytes(OP_SET_LOCAL, keySlot)
-	} else {
-		valSlot := fc.ensureLocal(stmt.Binding.ValueName)
-		fc.emitBytes(OP_SET_LOCAL, valSlot)
-		fc.emitByte(OP_POP) // discard key
+	// stack: ... key value; a missing key or $_ discards its value, and adjacent discards pop together.
+	pops := 0
+	for _, name := range []string{stmt.Binding.ValueName, stmt.Binding.Key} {
+		if name == "" || name == discardName {
+			pops++
+			continue
+		}
+		fc.emitPops(pops)
+		pops = 0
+		fc.emitBytes(OP_SET_LOCAL, fc.ensureLocal(name))
 	}
+	fc.emitPops(pops)
 
-	if err := fc.compileBlock(stmt.Body); err != nil {
+	loop, err := fc.compileLoopBody(stmt.Body, loopStart)
+	if err != nil {
 		return err
 	}
 	fc.emitLoop(loopStart)
 	fc.patchJump(iterNextPos)
+	// break shares the exit path, which still has the iterator to pop.
+	fc.patchBreaks(loop)
+	fc.emitByte(OP_POP) // pop iterator
+	return nil
+}
+
+// compileIterate calls the callback with each value of the iterable: a for loop whose body is
+// the call. The callback is evaluated once, after the iterable, and kept in a hidden local.
+func (fc *funcCompiler) compileIterate(stmt *ast.IterateStmt) error {
+	if err := fc.compileExpr(stmt.Iterable); err != nil {
+		return err
+	}
+	fc.emitByte(OP_ITER_PREP)
+	if err := fc.compileExpr(stmt.Callback); err != nil {
+		return err
+	}
+	fc.setPos(stmt.Pos())
+	callback := fc.newTemp()
+	fc.emitBytes(OP_SET_LOCAL, callback)
+
+	loopStart := len(fc.chunk.Code)
+	exit := fc.emitJump(OP_ITER_NEXT)
+	// stack: iterator key value -> iterator callback value
+	fc.emitByte(OP_SWAP)
+	fc.emitByte(OP_POP)
+	fc.emitBytes(OP_GET_LOCAL, callback)
+	fc.emitByte(OP_SWAP)
+	fc.emitBytes(OP_CALL, 1)
+	fc.emitByte(OP_POP)
+	fc.emitLoop(loopStart)
+	fc.patchJump(exit)
 	fc.emitByte(OP_POP) // pop iterator
 	return nil
 }
 
+// emitPops discards the top n stack values, as one OP_POP_N where a run of OP_POPs would do.
+func (fc *funcCompiler) emitPops(n int) {
+	for n > 1 {
+		count := min(n, 255)
+		fc.emitBytes(OP_POP_N, byte(count))
+		n -= count
+	}
+	if n == 1 {
+		fc.emitByte(OP_POP)
+	}
+}
+
 func (fc *funcCompiler) compileExpr(expr ast.Expression) error {
-	fc.setLine(expr.Pos().Line)
+	fc.setPos(expr.Pos())
 	switch e := expr.(type) {
 	case *ast.NumberLiteral:
 		num, err := strconv.ParseFloat(e.Value, 64)
@@ -291,6 +490,9 @@ func (fc *funcCompiler) compileExpr(expr ast.Expression) error {
 	case *ast.Identifier:
 		fc.emitGlobalGet(e.Name)
 	case *ast.Variable:
+		if e.Name == discardName {
+			return fmt.Errorf("cannot read discard variable $_")
+		}
 		if slot, ok := fc.scope.resolveLocal(e.Name); ok {
 			fc.emitBytes(OP_GET_LOCAL, slot)
 		} else if up, ok := fc.scope.resolveUpvalue(e.Name); ok {
@@ -308,7 +510,7 @@ func (fc *funcCompiler) compileExpr(expr ast.Expression) error {
 		case token.Bang:
 			fc.emitByte(OP_NOT)
 		case token.Plus:
-			// unary plus is a no-op
+			fc.emitByte(OP_POS)
 		default:
 			return fmt.Errorf("unsupported unary op %s", e.Operator)
 		}
@@ -331,6 +533,8 @@ func (fc *funcCompiler) compileExpr(expr ast.Expression) error {
 			fc.emitByte(OP_MUL)
 		case token.Slash:
 			fc.emitByte(OP_DIV)
+		case token.Percent:
+			fc.emitByte(OP_MOD)
 		case token.Equal:
 			fc.emitByte(OP_EQ)
 		case token.NotEqual:
@@ -347,7 +551,8 @@ func (fc *funcCompiler) compileExpr(expr ast.Expression) error {
 			return fmt.Errorf("unsupported binary op %s", e.Operator)
 		}
 	case *ast.AssignExpr:
-		return fc.compileAssign(e)
+		// Assignment leaves nothing on the stack, so it is only valid as a statement.
+		return fmt.Errorf("assignment cannot be used as a value")
 	case *ast.CallExpr:
 		if name, ok := builtinName(e.Callee); ok {
 			for _, arg := range e.Arguments {
@@ -359,22 +564,39 @@ func (fc *funcCompiler) compileExpr(expr ast.Expression) error {
 				return err
 			}
 		} else {
-			if err := fc.compileExpr(e.Callee); err != nil {
-				return err
+			callOp := byte(OP_CALL)
+			if member, ok := e.Callee.(*ast.MemberExpr); ok {
+				// Method call: leave [callee, receiver] so the VM can bind the receiver.
+				if err := fc.compileExpr(member.Left); err != nil {
+					return err
+				}
+				fc.setPos(member.Pos())
+				fc.emitByte(OP_DUP)
+				idx := fc.addConst(member.Property)
+				fc.emitIndexed(OP_GET_PROP, OP_GET_PROP_LONG, idx)
+				fc.emitByte(OP_SWAP)
+				callOp = OP_CALL_METHOD
+			} else {
+				if err := fc.checkArity(e); err != nil {
+					return err
+				}
+				if err := fc.compileExpr(e.Callee); err != nil {
+					return err
+				}
 			}
 			for _, arg := range e.Arguments {
 				if err := fc.compileExpr(arg); err != nil {
 					return err
 				}
 			}
-			fc.emitBytes(OP_CALL, byte(len(e.Arguments)))
+			fc.emitBytes(callOp, byte(len(e.Arguments)))
 		}
 	case *ast.MemberExpr:
 		if err := fc.compileExpr(e.Left); err != nil {
 			return err
 		}
 		idx := fc.addConst(e.Property)
-		fc.emitBytes(OP_GET_PROP, byte(idx>>8), byte(idx))
+		fc.emitIndexed(OP_GET_PROP, OP_GET_PROP_LONG, idx)
 	case *ast.IndexExpr:
 		if err := fc.compileExpr(e.Left); err != nil {
 			return err
@@ -383,6 +605,20 @@ func (fc *funcCompiler) compileExpr(expr ast.Expression) error {
 			return err
 		}
 		fc.emitByte(OP_INDEX_GET)
+	case *ast.SliceExpr:
+		if err := fc.compileExpr(e.Left); err != nil {
+			return err
+		}
+		for _, bound := range []ast.Expression{e.Low, e.High} {
+			if bound == nil {
+				fc.emitByte(OP_NULL)
+				continue
+			}
+			if err := fc.compileExpr(bound); err != nil {
+				return err
+			}
+		}
+		fc.emitByte(OP_SLICE)
 	case *ast.FuncExpr:
 		return fc.compileFuncExpr(e)
 	default:
@@ -420,33 +656,81 @@ func (fc *funcCompiler) compileLogical(e *ast.BinaryExpr) error {
 	}
 }
 
+// compoundOps maps compound assignment operators to the arithmetic they apply.
+var compoundOps = map[token.Type]byte{
+	token.PlusAssign:    OP_ADD,
+	token.MinusAssign:   OP_SUB,
+	token.StarAssign:    OP_MUL,
+	token.SlashAssign:   OP_DIV,
+	token.PercentAssign: OP_MOD,
+}
+
 func (fc *funcCompiler) compileAssign(e *ast.AssignExpr) error {
+	if op, ok := compoundOps[e.Operator]; ok {
+		return fc.compileCompoundAssign(e, op)
+	}
 	switch lhs := e.Left.(type) {
 	case *ast.Variable:
-		if e.Operator == token.Define {
-			if _, exists := fc.scope.locals[lhs.Name]; !exists {
-				fc.scope.addLocal(lhs.Name)
-			}
+		return fc.storeVariable(lhs.Name, e.Operator == token.Define, e.Const, func() error {
+			return fc.compileExpr(e.Value)
+		})
+	case *ast.ArrayLiteral:
+		return fc.compileDestructure(lhs, e)
+	case *ast.MemberExpr:
+		if err := fc.compileExpr(lhs.Left); err != nil {
+			return err
 		}
+		idx := fc.addConst(lhs.Property)
 		if err := fc.compileExpr(e.Value); err != nil {
 			return err
 		}
-		if slot, ok := fc.scope.resolveLocal(lhs.Name); ok {
-			fc.emitBytes(OP_SET_LOCAL, slot)
-		} else if up, ok := fc.scope.resolveUpvalue(lhs.Name); ok {
-			fc.emitBytes(OP_SET_UPVALUE, up.Index)
-		} else {
-			fc.emitGlobalSet(lhs.Name, e.Operator == token.Define)
+		fc.emitIndexed(OP_SET_PROP, OP_SET_PROP_LONG, idx)
+	case *ast.IndexExpr:
+		if err := fc.compileExpr(lhs.Left); err != nil {
+			return err
+		}
+		if err := fc.compileExpr(lhs.Index); err != nil {
+			return err
+		}
+		if err := fc.compileExpr(e.Value); err != nil {
+			return err
 		}
+		fc.emitByte(OP_INDEX_SET)
+	default:
+		return fmt.Errorf("invalid assignment target %T", e.Left)
+	}
+	return nil
+}
+
+// compileCompoundAssign compiles `target op= value`. Member and index targets evaluate their object
+// (and index) once, duplicating them to read the current value before the store.
+func (fc *funcCompiler) compileCompoundAssign(e *ast.AssignExpr, op byte) error {
+	applyOp := func() error {
+		if err := fc.compileExpr(e.Value); err != nil {
+			return err
+		}
+		fc.emitByte(op)
+		return nil
+	}
+	switch lhs := e.Left.(type) {
+	case *ast.Variable:
+		return fc.storeVariable(lhs.Name, false, false, func() error {
+			if err := fc.compileExpr(lhs); err != nil {
+				return err
+			}
+			return applyOp()
+		})
 	case *ast.MemberExpr:
 		if err := fc.compileExpr(lhs.Left); err != nil {
 			return err
 		}
 		idx := fc.addConst(lhs.Property)
-		if err := fc.compileExpr(e.Value); err != nil {
+		fc.emitByte(OP_DUP)
+		fc.emitIndexed(OP_GET_PROP, OP_GET_PROP_LONG, idx)
+		if err := applyOp(); err != nil {
 			return err
 		}
-		fc.emitBytes(OP_SET_PROP, byte(idx>>8), byte(idx))
+		fc.emitIndexed(OP_SET_PROP, OP_SET_PROP_LONG, idx)
 	case *ast.IndexExpr:
 		if err := fc.compileExpr(lhs.Left); err != nil {
 			return err
@@ -454,38 +738,111 @@ func (fc *funcCompiler) compileAssign(e *ast.AssignExpr) error {
 		if err := fc.compileExpr(lhs.Index); err != nil {
 			return err
 		}
-		if err := fc.compileExpr(e.Value); err != nil {
+		fc.emitByte(OP_DUP2)
+		fc.emitByte(OP_INDEX_GET)
+		if err := applyOp(); err != nil {
 			return err
 		}
 		fc.emitByte(OP_INDEX_SET)
 	default:
-		return fmt.Errorf("invalid assignment target %T", e.Left)
+		return fmt.Errorf("invalid compound assignment target %T", e.Left)
 	}
 	return nil
 }
 
-func (fc *funcCompiler) compileFuncExpr(fn *ast.FuncExpr) error {
-	idx, upvalues, err := fc.compilePrototype("", fn.Params, fn.Body)
-	if err != nil {
+// storeVariable assigns the value pushed by emitValue to a variable, declaring it first for `:=`.
+func (fc *funcCompiler) storeVariable(name string, define, isConst bool, emitValue func() error) error {
+	if name == discardName {
+		if err := emitValue(); err != nil {
+			return err
+		}
+		fc.emitByte(OP_POP)
+		return nil
+	}
+	if define {
+		if _, exists := fc.scope.locals[name]; exists && fc.scope.consts[name] {
+			return errConstAssign(name)
+		}
+	} else if fc.scope.isConst(name) {
+		return errConstAssign(name)
+	}
+	if define {
+		if _, exists := fc.scope.locals[name]; !exists {
+			fc.scope.addLocal(name)
+		}
+		if isConst {
+			fc.scope.markConst(name)
+		}
+	}
+	if err := emitValue(); err != nil {
 		return err
 	}
-	fc.emitBytes(OP_CLOSURE, byte(idx>>8), byte(idx), byte(len(upvalues)))
-	for _, uv := range upvalues {
-		isLocal := byte(0)
-		if uv.IsLocal {
-			isLocal = 1
+	if slot, ok := fc.scope.resolveLocal(name); ok {
+		fc.emitBytes(OP_SET_LOCAL, slot)
+	} else if up, ok := fc.scope.resolveUpvalue(name); ok {
+		fc.emitBytes(OP_SET_UPVALUE, up.Index)
+	} else {
+		fc.emitGlobalSet(name, define)
+	}
+	return nil
+}
+
+// compileDestructure assigns `[$a, $b] := value` element by element. The value is evaluated once
+// into a temporary; a missing element fails like an out-of-bounds index.
+func (fc *funcCompiler) compileDestructure(lhs *ast.ArrayLiteral, e *ast.AssignExpr) error {
+	targets := make([]*ast.Variable, len(lhs.Elements))
+	for i, el := range lhs.Elements {
+		v, ok := el.(*ast.Variable)
+		if !ok {
+			return fmt.Errorf("destructuring target must be a variable")
+		}
+		targets[i] = v
+	}
+	if err := fc.compileExpr(e.Value); err != nil {
+		return err
+	}
+	tmp := fc.newTemp()
+	fc.emitBytes(OP_SET_LOCAL, tmp)
+	for i, v := range targets {
+		err := fc.storeVariable(v.Name, e.Operator == token.Define, e.Const, func() error {
+			fc.emitBytes(OP_GET_LOCAL, tmp)
+			fc.emitConst(float64(i))
+			fc.emitByte(OP_INDEX_GET)
+			return nil
+		})
+		if err != nil {
+			return err
 		}
-		fc.emitBytes(isLocal, uv.Index)
 	}
 	return nil
 }
 
-func (fc *funcCompiler) compileNestedFuncDecl(fn *ast.FuncDecl) error {
-	idx, upvalues, err := fc.compilePrototype(fn.Name, fn.Params, fn.Body)
+func (fc *funcCompiler) compileFuncExpr(fn *ast.FuncExpr) error {
+	idx, upvalues, err := fc.compilePrototype("", fn.Pos(), fn.Receiver, fn.Params, fn.Body)
 	if err != nil {
 		return err
 	}
-	fc.emitBytes(OP_CLOSURE, byte(idx>>8), byte(idx), byte(len(upvalues)))
+	fc.emitClosure(idx, upvalues)
+	return nil
+}
+
+// compileDefer wraps the deferred call in a parameterless closure and registers it with OP_DEFER,
+// so the call (arguments included) is evaluated when the function exits.
+func (fc *funcCompiler) compileDefer(stmt *ast.DeferStmt) error {
+	call := &ast.ExprStmt{Expression: stmt.Call, Start: stmt.Call.Pos(), StmtSpan: stmt.Call.Span()}
+	body := &ast.BlockStmt{LBrace: stmt.Defer, Statements: []ast.Statement{call}, BlockSpan: stmt.StmtSpan}
+	if err := fc.compileFuncExpr(&ast.FuncExpr{FuncPos: stmt.Defer, Body: body, Sp: stmt.StmtSpan}); err != nil {
+		return err
+	}
+	fc.setPos(stmt.Pos())
+	fc.emitByte(OP_DEFER)
+	return nil
+}
+
+// emitClosure instantiates the prototype at constant idx, capturing the given upvalues.
+func (fc *funcCompiler) emitClosure(idx int, upvalues []Upvalue) {
+	fc.emitIndexed(OP_CLOSURE, OP_CLOSURE_LONG, idx)
+	fc.emitByte(byte(len(upvalues)))
 	for _, uv := range upvalues {
 		isLocal := byte(0)
 		if uv.IsLocal {
@@ -493,21 +850,40 @@ func (fc *funcCompiler) compileNestedFuncDecl(fn *ast.FuncDecl) error {
 		}
 		fc.emitBytes(isLocal, uv.Index)
 	}
+}
+
+func (fc *funcCompiler) compileNestedFuncDecl(fn *ast.FuncDecl) error {
+	if err := checkShadowsBuiltin(fn); err != nil {
+		return err
+	}
+	idx, upvalues, err := fc.compilePrototype(fn.Name, fn.Pos(), nil, fn.Params, fn.Body)
+	if err != nil {
+		return err
+	}
+	fc.emitClosure(idx, upvalues)
 	slot := fc.ensureLocal(fn.Name)
 	fc.emitBytes(OP_SET_LOCAL, slot)
 	return nil
 }
 
-func (fc *funcCompiler) compilePrototype(name string, params []ast.Param, body *ast.BlockStmt) (uint16, []Upvalue, error) {
+// compilePrototype compiles a nested function. A non-nil receiver occupies local slot 0, ahead of params.
+func (fc *funcCompiler) compilePrototype(name string, pos token.Position, receiver *ast.Param, params []ast.Param, body *ast.BlockStmt) (int, []Upvalue, error) {
+	if err := checkParams(name, pos, params); err != nil {
+		return 0, nil, err
+	}
 	child := newFuncCompilerWithScope(fc.scope, fc.source)
-	for i, p := range params {
-		if i >= 255 {
-			return 0, nil, fmt.Errorf("too many parameters")
-		}
+	child.arity = fc.arity
+	if receiver != nil {
+		child.scope.addLocal(receiver.Name)
+	}
+	for _, p := range params {
 		child.scope.addLocal(p.Name)
 	}
 	if err := child.compileBlock(body); err != nil {
-		return 0, nil, err
+		return 0, nil, withLine(child.line, err)
+	}
+	if child.constErr != nil {
+		return 0, nil, child.constErr
 	}
 	if len(body.Statements) == 0 || child.lastOp() != OP_RETURN {
 		child.emitByte(OP_NULL)
@@ -517,40 +893,58 @@ func (fc *funcCompiler) compilePrototype(name string, params []ast.Param, body *
 		Name:      name,
 		Source:    fc.source,
 		NumParams: len(params),
+		Params:    paramNames(params),
 		Chunk:     child.chunk,
 		Upvalues:  child.scope.upvalues,
 		MaxLocals: int(child.scope.nextLoc),
+		Receiver:  receiver != nil,
+		Generator: child.generator,
 	}
 	idx := fc.addConst(proto)
 	return idx, proto.Upvalues, nil
 }
 
+// emitConst loads a literal, switching to OP_CONST_LONG once the pool outgrows 16-bit indices.
 func (fc *funcCompiler) emitConst(v interface{}) {
-	idx := fc.addConst(v)
-	fc.emitBytes(OP_CONST, byte(idx>>8), byte(idx))
+	fc.emitIndexed(OP_CONST, OP_CONST_LONG, fc.addConst(v))
 }
 
-func (fc *funcCompiler) addConst(v interface{}) uint16 {
+// emitIndexed emits op with a 16-bit constant index, or its _LONG form with a 24-bit index once
+// the pool outgrows 16 bits.
+func (fc *funcCompiler) emitIndexed(op, long byte, idx int) {
+	if idx <= 0xFFFF {
+		fc.emitBytes(op, byte(idx>>8), byte(idx))
+		return
+	}
+	fc.emitBytes(long, byte(idx>>16), byte(idx>>8), byte(idx))
+}
+
+// addConst appends v to the constant pool and returns its index.
+func (fc *funcCompiler) addConst(v interface{}) int {
 	fc.chunk.Consts = append(fc.chunk.Consts, v)
-	return uint16(len(fc.chunk.Consts) - 1)
+	idx := len(fc.chunk.Consts) - 1
+	if idx > maxConstLong {
+		fc.constOverflow()
+	}
+	return idx
 }
 
-func (fc *funcCompiler) lastConstIndexBytes() []byte {
-	idx := uint16(len(fc.chunk.Consts) - 1)
-	return []byte{byte(idx >> 8), byte(idx)}
+func (fc *funcCompiler) constOverflow() {
+	if fc.constErr == nil {
+		fc.constErr = withLine(fc.line, fmt.Errorf("too many constants in function"))
+	}
 }
 
 func (fc *funcCompiler) emitGlobalGet(name string) {
-	idx := fc.addConst(name)
-	fc.emitBytes(OP_GET_GLOBAL, byte(idx>>8), byte(idx))
+	fc.emitIndexed(OP_GET_GLOBAL, OP_GET_GLOBAL_LONG, fc.addConst(name))
 }
 
 func (fc *funcCompiler) emitGlobalSet(name string, define bool) {
 	idx := fc.addConst(name)
 	if define {
-		fc.emitBytes(OP_DEFINE_GLOBAL, byte(idx>>8), byte(idx))
+		fc.emitIndexed(OP_DEFINE_GLOBAL, OP_DEFINE_GLOBAL_LONG, idx)
 	} else {
-		fc.emitBytes(OP_SET_GLOBAL, byte(idx>>8), byte(idx))
+		fc.emitIndexed(OP_SET_GLOBAL, OP_SET_GLOBAL_LONG, idx)
 	}
 }
 
@@ -585,9 +979,10 @@ func (fc *funcCompiler) emitLoop(start int) {
 	fc.emitByte(byte(offset))
 }
 
-func (fc *funcCompiler) setLine(line int) {
-	if line > 0 {
-		fc.line = line
+func (fc *funcCompiler) setPos(pos token.Position) {
+	if pos.Line > 0 {
+		fc.line = pos.Line
+		fc.column = pos.Column
 	}
 }
 
@@ -597,10 +992,14 @@ func (fc *funcCompiler) recordLine() {
 	}
 	off := len(fc.chunk.Code)
 	if len(fc.chunk.Lines) == 0 || fc.chunk.Lines[len(fc.chunk.Lines)-1].Offset != off {
-		fc.chunk.Lines = append(fc.chunk.Lines, LineInfo{Offset: off, Line: fc.line})
+		fc.chunk.Lines = append(fc.chunk.Lines, LineInfo{Offset: off, Line: fc.line, Column: fc.column})
 	}
 }
 
+func errConstAssign(name string) error {
+	return fmt.Errorf("cannot assign to constant $%s", name)
+}
+
 func objectKeyToString(k ast.ObjectKey) string {
 	if k.Ident != "" {
 		return k.Ident
@@ -613,3 +1012,11 @@ func objectKeyToString(k ast.ObjectKey) string {
 	}
 	return ""
 }
+
+func paramNames(params []ast.Param) []string {
+	names := make([]string, len(params))
+	for i, p := range params {
+		names[i] = p.Name
+	}
+	return names
+}
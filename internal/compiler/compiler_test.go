@@ -1,8 +1,12 @@
 package compiler
 
 import (
+	"errors"
+	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/xirelogy/go-flux/internal/bytecode"
 	"github.com/xirelogy/go-flux/internal/lexer"
 	"github.com/xirelogy/go-flux/internal/parser"
 	"github.com/xirelogy/go-flux/internal/runtime"
@@ -142,3 +146,202 @@ func TestCompileErrorBuiltin(t *testing.T) {
 		t.Fatalf("function demo not found")
 	}
 }
+
+func TestCompileTopLevelReturn(t *testing.T) {
+	p := parser.New(lexer.New(`return 1`))
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	_, err := Compile(prog, "test")
+	if err == nil {
+		t.Fatalf("expected compile error")
+	}
+	if err.Error() != "return outside function" {
+		t.Fatalf("unexpected error %q", err.Error())
+	}
+}
+
+func TestCompileConstRead(t *testing.T) {
+	src := `func area($r) {
+  const $PI := 3.14159
+  return $PI * $r * $r
+}`
+	mod := compileSource(t, src)
+	if mod.Functions["area"] == nil {
+		t.Fatalf("function area not found")
+	}
+}
+
+func TestCompileConstReassignment(t *testing.T) {
+	cases := map[string]string{
+		"assign": `func demo() {
+  const $PI := 3.14159
+  $PI = 3
+}`,
+		"redefine": `func demo() {
+  const $PI := 3.14159
+  $PI := 3
+}`,
+		"closure": `func demo() {
+  const $PI := 3.14159
+  $f := func() { $PI = 3 }
+}`,
+		"loop binding": `func demo() {
+  const $v := 1
+  for ($v in [1, 2]) { }
+}`,
+	}
+	for name, src := range cases {
+		t.Run(name, func(t *testing.T) {
+			p := parser.New(lexer.New(src))
+			prog := p.ParseProgram()
+			if len(p.Errors()) != 0 {
+				t.Fatalf("parser errors: %v", p.Errors())
+			}
+			_, err := Compile(prog, "test")
+			if err == nil {
+				t.Fatalf("expected compile error")
+			}
+			if err.Error() != "cannot assign to constant $PI" && err.Error() != "cannot assign to constant $v" {
+				t.Fatalf("unexpected error %q", err.Error())
+			}
+		})
+	}
+}
+
+func TestCompileAssignmentAsValue(t *testing.T) {
+	cases := map[string]string{
+		"call argument": `func demo($a) { return id($a = 5) }`,
+		"return value":  `func demo($a) { return $a = 5 }`,
+		"chained":       `func demo($a, $b) { $a = $b = 1 }`,
+		"array element": `func demo($a) { $x := [$a = 1] }`,
+	}
+	for name, src := range cases {
+		t.Run(name, func(t *testing.T) {
+			p := parser.New(lexer.New(src))
+			prog := p.ParseProgram()
+			if len(p.Errors()) != 0 {
+				t.Fatalf("parser errors: %v", p.Errors())
+			}
+			_, err := Compile(prog, "test")
+			if err == nil {
+				t.Fatalf("expected compile error")
+			}
+			if err.Error() != "assignment cannot be used as a value" {
+				t.Fatalf("unexpected error %q", err.Error())
+			}
+		})
+	}
+}
+
+func TestCompileArityCheck(t *testing.T) {
+	cases := []struct {
+		src  string
+		line int // 0 when the program must compile
+	}{
+		{"func two($a, $b) { return 1 }\nfunc f() {\n  return two(1)\n}", 3},
+		{"func two($a, $b) { return 1 }\nfunc f() {\n  $g := func () { return two(1, 2, 3) }\n}", 3},
+		{"func two($a, $b) { return 1 }\nfunc f() { return two(1, 2) }", 0},
+		{"func two($a, $b) { return 1 }\nfunc f() {\n  func two($x) { return $x }\n  return two(1)\n}", 0},
+		{"func f() { return missing(1) }", 0},
+	}
+	for _, tc := range cases {
+		p := parser.New(lexer.New(tc.src))
+		prog := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("parser errors: %v", p.Errors())
+		}
+		if _, err := Compile(prog, "test"); err != nil {
+			t.Fatalf("%q: arity must not be checked by default: %v", tc.src, err)
+		}
+		_, err := CompileWithOptions(prog, "test", CompileOptions{CheckArity: true})
+		if tc.line == 0 {
+			if err != nil {
+				t.Fatalf("%q: unexpected error %v", tc.src, err)
+			}
+			continue
+		}
+		cerr, ok := err.(*Error)
+		if !ok || cerr.Line != tc.line {
+			t.Fatalf("%q: expected compile error on line %d, got %v", tc.src, tc.line, err)
+		}
+	}
+}
+
+func TestCompileTooManyParameters(t *testing.T) {
+	params := make([]string, 256)
+	for i := range params {
+		params[i] = "$p" + strconv.Itoa(i)
+	}
+	list := strings.Join(params, ", ")
+	cases := []struct {
+		name, src, fn string
+		line, column  int
+	}{
+		{"declaration", "\nfunc wide(" + list + ") { return 1 }", "wide", 2, 1},
+		{"literal", "func outer() {\n  $f := func (" + list + ") { return 1 }\n}", "<anon>", 2, 9},
+		{"nested", "func outer() {\n  func inner(" + list + ") { return 1 }\n}", "inner", 2, 3},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := parser.New(lexer.New(tc.src))
+			prog := p.ParseProgram()
+			if len(p.Errors()) != 0 {
+				t.Fatalf("parser errors: %v", p.Errors())
+			}
+			_, err := Compile(prog, "test")
+			var cerr *Error
+			if !errors.As(err, &cerr) {
+				t.Fatalf("expected compile error, got %v", err)
+			}
+			want := "too many parameters: function " + tc.fn + " declares 256, at most 255 allowed"
+			if err.Error() != want {
+				t.Fatalf("expected %q, got %q", want, err.Error())
+			}
+			if cerr.Line != tc.line || cerr.Column != tc.column {
+				t.Fatalf("expected position %d:%d, got %d:%d", tc.line, tc.column, cerr.Line, cerr.Column)
+			}
+		})
+	}
+
+	p := parser.New(lexer.New("func wide(" + strings.Join(params[:255], ", ") + ") { return 1 }"))
+	if _, err := Compile(p.ParseProgram(), "test"); err != nil {
+		t.Fatalf("255 parameters should compile: %v", err)
+	}
+}
+
+func TestCompileDiscardBindingAllocatesNoSlot(t *testing.T) {
+	mod := compileSource(t, `func f($o) {
+  for ([$_, $v] in $o) { }
+  for ([$_, $_] in $o) { }
+}`)
+	// $o and $v only: discards are popped rather than stored.
+	if got := mod.Functions["f"].MaxLocals; got != 2 {
+		t.Fatalf("expected 2 locals, got %d", got)
+	}
+}
+
+func TestCompileConstLongNames(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("func many($o) {\n  $v := 0\n")
+	for i := 0; i < 0x10000; i++ {
+		b.WriteString("  $v = " + strconv.Itoa(i) + "\n")
+	}
+	b.WriteString("  $o.count = $o.count + 1\n  $f := func () { return $v }\n  return helper($f())\n}\n")
+	mod := compileSource(t, b.String())
+	// Literals fill the 16-bit range, so names and the closure prototype land past it.
+	seen := map[byte]bool{}
+	code := mod.Functions["many"].Chunk.Code
+	for ip := 0; ip < len(code); ip += 1 + bytecode.OperandWidth(code[ip], code, ip+1) {
+		seen[code[ip]] = true
+	}
+	for _, op := range []byte{OP_GET_PROP_LONG, OP_SET_PROP_LONG, OP_CLOSURE_LONG, OP_GET_GLOBAL_LONG} {
+		if !seen[op] {
+			t.Fatalf("expected long opcode 0x%02X in compiled code", op)
+		}
+	}
+	if err := bytecode.Verify(mod); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+}
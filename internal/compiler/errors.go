@@ -0,0 +1,41 @@
+package compiler
+
+import "github.com/xirelogy/go-flux/internal/token"
+
+// Error is a compile failure annotated with the source position where it was detected.
+// Column is 0 when only the line is known.
+type Error struct {
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap exposes the underlying failure.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func withLine(line int, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	return &Error{Line: line, Err: err}
+}
+
+// withPos is withLine for failures tied to a specific node, keeping its column too.
+func withPos(pos token.Position, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	return &Error{Line: pos.Line, Column: pos.Column, Err: err}
+}
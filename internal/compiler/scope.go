@@ -1,10 +1,14 @@
 package compiler
 
+// discardName is the variable $_, which may be assigned or bound any number of times but never read.
+const discardName = "_"
+
 // scope tracks locals and upvalues for nested functions.
 type scope struct {
 	enclosing *scope
 	locals    map[string]uint8
 	upvalues  []Upvalue
+	consts    map[string]bool
 	nextLoc   uint8
 }
 
@@ -13,6 +17,7 @@ func newScope(enclosing *scope) *scope {
 		enclosing: enclosing,
 		locals:    make(map[string]uint8),
 		upvalues:  []Upvalue{},
+		consts:    make(map[string]bool),
 		nextLoc:   0,
 	}
 }
@@ -31,6 +36,32 @@ func (s *scope) resolveLocal(name string) (uint8, bool) {
 	return slot, ok
 }
 
+// declares reports whether name is a local here or in any enclosing scope, without capturing it.
+func (s *scope) declares(name string) bool {
+	for sc := s; sc != nil; sc = sc.enclosing {
+		if _, ok := sc.locals[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// markConst flags a local in this scope as immutable.
+func (s *scope) markConst(name string) {
+	s.consts[name] = true
+}
+
+// isConst reports whether name resolves to a constant, following local then enclosing scopes.
+func (s *scope) isConst(name string) bool {
+	if _, ok := s.locals[name]; ok {
+		return s.consts[name]
+	}
+	if s.enclosing != nil {
+		return s.enclosing.isConst(name)
+	}
+	return false
+}
+
 // resolveUpvalue walks enclosing scopes to find a name, capturing it if needed.
 func (s *scope) resolveUpvalue(name string) (Upvalue, bool) {
 	if s.enclosing == nil {
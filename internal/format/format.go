@@ -0,0 +1,402 @@
+package format
+
+import (
+	"strings"
+
+	"github.com/xirelogy/go-flux/internal/ast"
+	"github.com/xirelogy/go-flux/internal/token"
+)
+
+// Binding strength of expressions, mirroring the parser's precedence table.
+const (
+	precLowest = iota
+	precAssign
+	precOr
+	precAnd
+	precEqual
+	precCompare
+	precSum
+	precProduct
+	precPrefix
+	precPostfix
+	precPrimary
+)
+
+var binaryPrec = map[token.Type]int{
+	token.OrOr:         precOr,
+	token.AndAnd:       precAnd,
+	token.Equal:        precEqual,
+	token.NotEqual:     precEqual,
+	token.Less:         precCompare,
+	token.LessEqual:    precCompare,
+	token.Greater:      precCompare,
+	token.GreaterEqual: precCompare,
+	token.Plus:         precSum,
+	token.Minus:        precSum,
+	token.Star:         precProduct,
+	token.Slash:        precProduct,
+	token.Percent:      precProduct,
+}
+
+var operatorText = map[token.Type]string{
+	token.Assign:        "=",
+	token.Define:        ":=",
+	token.PlusAssign:    "+=",
+	token.MinusAssign:   "-=",
+	token.StarAssign:    "*=",
+	token.SlashAssign:   "/=",
+	token.PercentAssign: "%=",
+	token.Plus:          "+",
+	token.Minus:         "-",
+	token.Star:          "*",
+	token.Slash:         "/",
+	token.Percent:       "%",
+	token.Bang:          "!",
+	token.Equal:         "==",
+	token.NotEqual:      "!=",
+	token.Less:          "<",
+	token.LessEqual:     "<=",
+	token.Greater:       ">",
+	token.GreaterEqual:  ">=",
+	token.AndAnd:        "&&",
+	token.OrOr:          "||",
+}
+
+// Program renders a parsed program as canonically formatted source.
+// Statements are indented with tabs and top-level function declarations are
+// separated by a blank line. Comments are not preserved.
+func Program(prog *ast.Program) string {
+	p := &printer{}
+	for i, stmt := range prog.Statements {
+		if i > 0 {
+			_, prevFunc := prog.Statements[i-1].(*ast.FuncDecl)
+			_, curFunc := stmt.(*ast.FuncDecl)
+			if prevFunc || curFunc {
+				p.sb.WriteString("\n")
+			}
+		}
+		p.stmt(stmt)
+	}
+	return p.sb.String()
+}
+
+type printer struct {
+	sb     strings.Builder
+	indent int
+}
+
+func (p *printer) write(s string) {
+	p.sb.WriteString(s)
+}
+
+func (p *printer) writeIndent() {
+	p.sb.WriteString(strings.Repeat("\t", p.indent))
+}
+
+func (p *printer) stmt(stmt ast.Statement) {
+	p.writeIndent()
+	p.stmtBody(stmt)
+	p.write("\n")
+}
+
+func (p *printer) stmtBody(stmt ast.Statement) {
+	switch s := stmt.(type) {
+	case *ast.BlockStmt:
+		p.block(s)
+	case *ast.ExprStmt:
+		if a, ok := s.Expression.(*ast.AssignExpr); ok && a.Const {
+			p.write("const ")
+		}
+		if startsWithBrace(s.Expression) {
+			// A leading '{' would parse as a block; keep it an expression.
+			p.write("(")
+			p.expr(s.Expression, precLowest)
+			p.write(")")
+			return
+		}
+		p.expr(s.Expression, precLowest)
+	case *ast.ReturnStmt:
+		p.write("return")
+		if s.Value != nil {
+			p.write(" ")
+			p.expr(s.Value, precLowest)
+		}
+	case *ast.ImportStmt:
+		p.write("import " + quote(s.Name))
+	case *ast.BreakStmt:
+		p.write("break")
+	case *ast.ContinueStmt:
+		p.write("continue")
+	case *ast.DeferStmt:
+		p.write("defer ")
+		p.expr(s.Call, precLowest)
+	case *ast.YieldStmt:
+		p.write("yield ")
+		p.expr(s.Value, precLowest)
+	case *ast.IterateStmt:
+		p.write("iterate ")
+		p.expr(s.Iterable, precLowest)
+		p.write(" using ")
+		p.expr(s.Callback, precLowest)
+	case *ast.IfStmt:
+		p.write("if (")
+		p.expr(s.Condition, precLowest)
+		p.write(") ")
+		p.block(s.Conseq)
+		for _, clause := range s.ElseIfs {
+			p.write(" elseif (")
+			p.expr(clause.Condition, precLowest)
+			p.write(") ")
+			p.block(clause.Conseq)
+		}
+		if s.Alt != nil {
+			p.write(" else ")
+			p.block(s.Alt)
+		}
+	case *ast.WhileStmt:
+		p.write("while (")
+		p.expr(s.Condition, precLowest)
+		p.write(") ")
+		p.block(s.Body)
+	case *ast.ForStmt:
+		p.write("for (")
+		if s.Binding.Key != "" {
+			p.write("[$" + s.Binding.Key + ", $" + s.Binding.ValueName + "]")
+		} else {
+			p.write("$" + s.Binding.ValueName)
+		}
+		p.write(" in ")
+		p.expr(s.Iterable, precLowest)
+		p.write(") ")
+		p.block(s.Body)
+	case *ast.FuncDecl:
+		p.write("func " + s.Name)
+		p.params(s.Params)
+		p.write(" ")
+		p.block(s.Body)
+	}
+}
+
+func (p *printer) block(b *ast.BlockStmt) {
+	if b == nil || len(b.Statements) == 0 {
+		p.write("{}")
+		return
+	}
+	p.write("{\n")
+	p.indent++
+	for _, stmt := range b.Statements {
+		p.stmt(stmt)
+	}
+	p.indent--
+	p.writeIndent()
+	p.write("}")
+}
+
+func (p *printer) params(params []ast.Param) {
+	p.write("(")
+	for i, param := range params {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.write("$" + param.Name)
+	}
+	p.write(")")
+}
+
+// expr renders e, parenthesizing it when it binds more loosely than the context requires.
+func (p *printer) expr(e ast.Expression, min int) {
+	if e == nil {
+		return
+	}
+	if precOf(e) < min {
+		p.write("(")
+		p.exprBody(e)
+		p.write(")")
+		return
+	}
+	p.exprBody(e)
+}
+
+func (p *printer) exprBody(e ast.Expression) {
+	switch x := e.(type) {
+	case *ast.Identifier:
+		p.write(x.Name)
+	case *ast.Variable:
+		p.write("$" + x.Name)
+	case *ast.NumberLiteral:
+		p.write(x.Value)
+	case *ast.StringLiteral:
+		p.write(quote(x.Value))
+	case *ast.BoolLiteral:
+		if x.Value {
+			p.write("true")
+		} else {
+			p.write("false")
+		}
+	case *ast.NullLiteral:
+		p.write("null")
+	case *ast.ArrayLiteral:
+		p.write("[")
+		for i, el := range x.Elements {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.expr(el, precAssign)
+		}
+		p.write("]")
+	case *ast.RangeLiteral:
+		p.write("[")
+		p.expr(x.Start, precAssign)
+		p.write(" .. ")
+		p.expr(x.End, precAssign)
+		p.write("]")
+	case *ast.ObjectLiteral:
+		if len(x.Fields) == 0 {
+			p.write("{}")
+			return
+		}
+		p.write("{ ")
+		for i, f := range x.Fields {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.objectKey(f.Key)
+			p.write(": ")
+			p.expr(f.Value, precAssign)
+		}
+		p.write(" }")
+	case *ast.UnaryExpr:
+		p.write(operatorText[x.Operator])
+		p.expr(x.Right, precPrefix)
+	case *ast.BinaryExpr:
+		prec := binaryPrec[x.Operator]
+		p.expr(x.Left, prec)
+		p.write(" " + operatorText[x.Operator] + " ")
+		p.expr(x.Right, prec+1)
+	case *ast.AssignExpr:
+		p.expr(x.Left, precPostfix)
+		p.write(" " + operatorText[x.Operator] + " ")
+		p.expr(x.Value, precAssign)
+	case *ast.CallExpr:
+		p.expr(x.Callee, precPostfix)
+		p.write("(")
+		for i, arg := range x.Arguments {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.expr(arg, precAssign)
+		}
+		p.write(")")
+	case *ast.MemberExpr:
+		p.expr(x.Left, precPostfix)
+		p.write("." + x.Property)
+	case *ast.IndexExpr:
+		p.expr(x.Left, precPostfix)
+		p.write("[")
+		p.expr(x.Index, precLowest)
+		p.write("]")
+	case *ast.SliceExpr:
+		p.expr(x.Left, precPostfix)
+		p.write("[")
+		if x.Low != nil {
+			p.expr(x.Low, precLowest)
+		}
+		p.write(":")
+		if x.High != nil {
+			p.expr(x.High, precLowest)
+		}
+		p.write("]")
+	case *ast.FuncExpr:
+		p.write("func")
+		if x.Receiver != nil {
+			p.write(" $" + x.Receiver.Name)
+		}
+		p.params(x.Params)
+		p.write(" ")
+		p.block(x.Body)
+	}
+}
+
+func (p *printer) objectKey(k ast.ObjectKey) {
+	switch {
+	case k.Ident != "":
+		p.write(k.Ident)
+	case k.Str != nil:
+		p.write(quote(*k.Str))
+	case k.Num != nil:
+		p.write(*k.Num)
+	}
+}
+
+func precOf(e ast.Expression) int {
+	switch x := e.(type) {
+	case *ast.AssignExpr:
+		return precAssign
+	case *ast.BinaryExpr:
+		return binaryPrec[x.Operator]
+	case *ast.UnaryExpr:
+		return precPrefix
+	case *ast.CallExpr, *ast.MemberExpr, *ast.IndexExpr, *ast.SliceExpr:
+		return precPostfix
+	case *ast.FuncExpr:
+		// Function literals end in a block, so they are only safe as standalone operands.
+		return precAssign
+	default:
+		return precPrimary
+	}
+}
+
+// startsWithBrace reports whether the rendered expression begins with an object literal.
+func startsWithBrace(e ast.Expression) bool {
+	for {
+		switch x := e.(type) {
+		case *ast.ObjectLiteral:
+			return true
+		case *ast.AssignExpr:
+			e = x.Left
+		case *ast.BinaryExpr:
+			if precOf(x.Left) < binaryPrec[x.Operator] {
+				return false
+			}
+			e = x.Left
+		case *ast.CallExpr:
+			e = x.Callee
+		case *ast.MemberExpr:
+			e = x.Left
+		case *ast.IndexExpr:
+			e = x.Left
+		case *ast.SliceExpr:
+			e = x.Left
+		default:
+			return false
+		}
+	}
+}
+
+// quote renders a string literal using only the escapes the lexer understands.
+func quote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\b':
+			sb.WriteString(`\b`)
+		case '\f':
+			sb.WriteString(`\f`)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
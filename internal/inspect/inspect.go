@@ -0,0 +1,222 @@
+package inspect
+
+import (
+	"sort"
+
+	"github.com/xirelogy/go-flux/internal/ast"
+	"github.com/xirelogy/go-flux/internal/runtime"
+	"github.com/xirelogy/go-flux/internal/token"
+)
+
+// Info summarizes the names a program declares and depends on.
+type Info struct {
+	Functions []string // top-level function declarations
+	Globals   []string // globals read or written but not declared by the program
+	Builtins  []string // builtins invoked or referenced as values
+}
+
+// Program walks a parsed program and collects declared functions, referenced globals, and builtins.
+// Variable resolution mirrors the compiler: names introduced by parameters, `:=`, for bindings,
+// or nested function declarations are locals (visible to nested closures); anything else is global.
+func Program(prog *ast.Program) Info {
+	w := &walker{
+		functions: map[string]bool{},
+		globals:   map[string]bool{},
+		builtins:  map[string]bool{},
+	}
+	for _, stmt := range prog.Statements {
+		if fn, ok := stmt.(*ast.FuncDecl); ok {
+			w.functions[fn.Name] = true
+		}
+	}
+	for _, stmt := range prog.Statements {
+		if fn, ok := stmt.(*ast.FuncDecl); ok {
+			w.function(nil, fn.Params, fn.Body)
+			continue
+		}
+		w.stmt(newScope(nil), stmt)
+	}
+	globals := map[string]bool{}
+	for name := range w.globals {
+		if !w.functions[name] {
+			globals[name] = true
+		}
+	}
+	return Info{
+		Functions: sortedKeys(w.functions),
+		Globals:   sortedKeys(globals),
+		Builtins:  sortedKeys(w.builtins),
+	}
+}
+
+type scope struct {
+	enclosing *scope
+	names     map[string]bool
+}
+
+func newScope(enclosing *scope) *scope {
+	return &scope{enclosing: enclosing, names: map[string]bool{}}
+}
+
+func (s *scope) resolve(name string) bool {
+	for cur := s; cur != nil; cur = cur.enclosing {
+		if cur.names[name] {
+			return true
+		}
+	}
+	return false
+}
+
+type walker struct {
+	functions map[string]bool
+	globals   map[string]bool
+	builtins  map[string]bool
+}
+
+func (w *walker) function(enclosing *scope, params []ast.Param, body *ast.BlockStmt) {
+	sc := newScope(enclosing)
+	for _, p := range params {
+		sc.names[p.Name] = true
+	}
+	w.block(sc, body)
+}
+
+func (w *walker) block(sc *scope, b *ast.BlockStmt) {
+	if b == nil {
+		return
+	}
+	for _, stmt := range b.Statements {
+		w.stmt(sc, stmt)
+	}
+}
+
+func (w *walker) stmt(sc *scope, stmt ast.Statement) {
+	switch s := stmt.(type) {
+	case *ast.BlockStmt:
+		w.block(sc, s)
+	case *ast.ExprStmt:
+		w.expr(sc, s.Expression)
+	case *ast.ReturnStmt:
+		w.expr(sc, s.Value)
+	case *ast.DeferStmt:
+		w.expr(sc, s.Call)
+	case *ast.YieldStmt:
+		w.expr(sc, s.Value)
+	case *ast.IterateStmt:
+		w.expr(sc, s.Iterable)
+		w.expr(sc, s.Callback)
+	case *ast.IfStmt:
+		w.expr(sc, s.Condition)
+		w.block(sc, s.Conseq)
+		for _, clause := range s.ElseIfs {
+			w.expr(sc, clause.Condition)
+			w.block(sc, clause.Conseq)
+		}
+		w.block(sc, s.Alt)
+	case *ast.WhileStmt:
+		w.expr(sc, s.Condition)
+		w.block(sc, s.Body)
+	case *ast.ForStmt:
+		w.expr(sc, s.Iterable)
+		if s.Binding.Key != "" {
+			sc.names[s.Binding.Key] = true
+		}
+		sc.names[s.Binding.ValueName] = true
+		w.block(sc, s.Body)
+	case *ast.FuncDecl:
+		w.function(sc, s.Params, s.Body)
+		sc.names[s.Name] = true
+	}
+}
+
+func (w *walker) expr(sc *scope, e ast.Expression) {
+	switch x := e.(type) {
+	case *ast.Identifier:
+		if _, isBuiltin := runtime.LookupByName(x.Name); isBuiltin {
+			w.builtins[x.Name] = true
+		} else {
+			w.globals[x.Name] = true
+		}
+	case *ast.Variable:
+		if !sc.resolve(x.Name) {
+			w.globals[x.Name] = true
+		}
+	case *ast.ArrayLiteral:
+		for _, el := range x.Elements {
+			w.expr(sc, el)
+		}
+	case *ast.RangeLiteral:
+		w.expr(sc, x.Start)
+		w.expr(sc, x.End)
+	case *ast.ObjectLiteral:
+		for _, f := range x.Fields {
+			w.expr(sc, f.Value)
+		}
+	case *ast.UnaryExpr:
+		w.expr(sc, x.Right)
+	case *ast.BinaryExpr:
+		w.expr(sc, x.Left)
+		w.expr(sc, x.Right)
+	case *ast.AssignExpr:
+		w.assign(sc, x)
+	case *ast.CallExpr:
+		w.expr(sc, x.Callee)
+		for _, arg := range x.Arguments {
+			w.expr(sc, arg)
+		}
+	case *ast.MemberExpr:
+		w.expr(sc, x.Left)
+	case *ast.IndexExpr:
+		w.expr(sc, x.Left)
+		w.expr(sc, x.Index)
+	case *ast.SliceExpr:
+		w.expr(sc, x.Left)
+		if x.Low != nil {
+			w.expr(sc, x.Low)
+		}
+		if x.High != nil {
+			w.expr(sc, x.High)
+		}
+	case *ast.FuncExpr:
+		params := x.Params
+		if x.Receiver != nil {
+			params = append([]ast.Param{*x.Receiver}, x.Params...)
+		}
+		w.function(sc, params, x.Body)
+	}
+}
+
+func (w *walker) assign(sc *scope, a *ast.AssignExpr) {
+	switch lhs := a.Left.(type) {
+	case *ast.Variable:
+		w.assignVariable(sc, lhs, a.Operator)
+		w.expr(sc, a.Value)
+	case *ast.ArrayLiteral:
+		w.expr(sc, a.Value)
+		for _, el := range lhs.Elements {
+			if v, ok := el.(*ast.Variable); ok {
+				w.assignVariable(sc, v, a.Operator)
+			}
+		}
+	default:
+		w.expr(sc, a.Left)
+		w.expr(sc, a.Value)
+	}
+}
+
+func (w *walker) assignVariable(sc *scope, v *ast.Variable, op token.Type) {
+	if op == token.Define {
+		sc.names[v.Name] = true
+	} else if !sc.resolve(v.Name) {
+		w.globals[v.Name] = true
+	}
+}
+
+func sortedKeys(m map[string]bool) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -17,6 +17,7 @@ type Lexer struct {
 	parenDepth   int
 	bracketDepth int
 	lastToken    token.Type
+	comments     bool
 }
 
 // New creates a lexer for the provided source text.
@@ -31,6 +32,13 @@ func New(input string) *Lexer {
 	return l
 }
 
+// SetEmitComments enables or disables comment-preserving mode. When enabled, line and block
+// comments are returned as token.Comment tokens (literal includes the delimiters) instead of
+// being skipped; they do not affect newline handling, so the remaining token stream is unchanged.
+func (l *Lexer) SetEmitComments(enabled bool) {
+	l.comments = enabled
+}
+
 // NextToken returns the next token from the input.
 func (l *Lexer) NextToken() token.Token {
 	for {
@@ -49,11 +57,21 @@ func (l *Lexer) NextToken() token.Token {
 
 		if l.ch == '/' {
 			if l.peekChar() == '/' {
+				tok := l.makeToken(token.Comment, "")
 				l.skipLineComment()
+				if l.comments {
+					tok.Literal = l.input[tok.Pos.Offset:l.pos]
+					return l.withEnd(tok)
+				}
 				continue
 			}
 			if l.peekChar() == '*' {
+				tok := l.makeToken(token.Comment, "")
 				l.skipBlockComment()
+				if l.comments {
+					tok.Literal = l.input[tok.Pos.Offset:l.pos]
+					return l.withEnd(tok)
+				}
 				continue
 			}
 		}
@@ -61,9 +79,8 @@ func (l *Lexer) NextToken() token.Token {
 		switch l.ch {
 		case '=':
 			if l.peekChar() == '=' {
-				ch := l.ch
+				tok := l.makeToken(token.Equal, string(l.ch)+string(l.peekChar()))
 				l.readChar()
-				tok := l.makeToken(token.Equal, string(ch)+string(l.ch))
 				l.readChar()
 				return l.finishToken(tok)
 			}
@@ -72,9 +89,8 @@ func (l *Lexer) NextToken() token.Token {
 			return l.finishToken(tok)
 		case ':':
 			if l.peekChar() == '=' {
-				ch := l.ch
+				tok := l.makeToken(token.Define, string(l.ch)+string(l.peekChar()))
 				l.readChar()
-				tok := l.makeToken(token.Define, string(ch)+string(l.ch))
 				l.readChar()
 				return l.finishToken(tok)
 			}
@@ -82,26 +98,59 @@ func (l *Lexer) NextToken() token.Token {
 			l.readChar()
 			return l.finishToken(tok)
 		case '+':
+			if l.peekChar() == '=' {
+				tok := l.makeToken(token.PlusAssign, string(l.ch)+string(l.peekChar()))
+				l.readChar()
+				l.readChar()
+				return l.finishToken(tok)
+			}
 			tok := l.makeToken(token.Plus, string(l.ch))
 			l.readChar()
 			return l.finishToken(tok)
 		case '-':
+			if l.peekChar() == '=' {
+				tok := l.makeToken(token.MinusAssign, string(l.ch)+string(l.peekChar()))
+				l.readChar()
+				l.readChar()
+				return l.finishToken(tok)
+			}
 			tok := l.makeToken(token.Minus, string(l.ch))
 			l.readChar()
 			return l.finishToken(tok)
 		case '*':
+			if l.peekChar() == '=' {
+				tok := l.makeToken(token.StarAssign, string(l.ch)+string(l.peekChar()))
+				l.readChar()
+				l.readChar()
+				return l.finishToken(tok)
+			}
 			tok := l.makeToken(token.Star, string(l.ch))
 			l.readChar()
 			return l.finishToken(tok)
+		case '%':
+			if l.peekChar() == '=' {
+				tok := l.makeToken(token.PercentAssign, string(l.ch)+string(l.peekChar()))
+				l.readChar()
+				l.readChar()
+				return l.finishToken(tok)
+			}
+			tok := l.makeToken(token.Percent, string(l.ch))
+			l.readChar()
+			return l.finishToken(tok)
 		case '/':
+			if l.peekChar() == '=' {
+				tok := l.makeToken(token.SlashAssign, string(l.ch)+string(l.peekChar()))
+				l.readChar()
+				l.readChar()
+				return l.finishToken(tok)
+			}
 			tok := l.makeToken(token.Slash, string(l.ch))
 			l.readChar()
 			return l.finishToken(tok)
 		case '!':
 			if l.peekChar() == '=' {
-				ch := l.ch
+				tok := l.makeToken(token.NotEqual, string(l.ch)+string(l.peekChar()))
 				l.readChar()
-				tok := l.makeToken(token.NotEqual, string(ch)+string(l.ch))
 				l.readChar()
 				return l.finishToken(tok)
 			}
@@ -110,9 +159,8 @@ func (l *Lexer) NextToken() token.Token {
 			return l.finishToken(tok)
 		case '<':
 			if l.peekChar() == '=' {
-				ch := l.ch
+				tok := l.makeToken(token.LessEqual, string(l.ch)+string(l.peekChar()))
 				l.readChar()
-				tok := l.makeToken(token.LessEqual, string(ch)+string(l.ch))
 				l.readChar()
 				return l.finishToken(tok)
 			}
@@ -121,9 +169,8 @@ func (l *Lexer) NextToken() token.Token {
 			return l.finishToken(tok)
 		case '>':
 			if l.peekChar() == '=' {
-				ch := l.ch
+				tok := l.makeToken(token.GreaterEqual, string(l.ch)+string(l.peekChar()))
 				l.readChar()
-				tok := l.makeToken(token.GreaterEqual, string(ch)+string(l.ch))
 				l.readChar()
 				return l.finishToken(tok)
 			}
@@ -132,9 +179,8 @@ func (l *Lexer) NextToken() token.Token {
 			return l.finishToken(tok)
 		case '&':
 			if l.peekChar() == '&' {
-				ch := l.ch
+				tok := l.makeToken(token.AndAnd, string(l.ch)+string(l.peekChar()))
 				l.readChar()
-				tok := l.makeToken(token.AndAnd, string(ch)+string(l.ch))
 				l.readChar()
 				return l.finishToken(tok)
 			}
@@ -143,9 +189,8 @@ func (l *Lexer) NextToken() token.Token {
 			return l.finishToken(tok)
 		case '|':
 			if l.peekChar() == '|' {
-				ch := l.ch
+				tok := l.makeToken(token.OrOr, string(l.ch)+string(l.peekChar()))
 				l.readChar()
-				tok := l.makeToken(token.OrOr, string(ch)+string(l.ch))
 				l.readChar()
 				return l.finishToken(tok)
 			}
@@ -154,9 +199,8 @@ func (l *Lexer) NextToken() token.Token {
 			return l.finishToken(tok)
 		case '.':
 			if l.peekChar() == '.' {
-				ch := l.ch
+				tok := l.makeToken(token.Range, string(l.ch)+string(l.peekChar()))
 				l.readChar()
-				tok := l.makeToken(token.Range, string(ch)+string(l.ch))
 				l.readChar()
 				return l.finishToken(tok)
 			}
@@ -167,6 +211,10 @@ func (l *Lexer) NextToken() token.Token {
 			tok := l.makeToken(token.Comma, string(l.ch))
 			l.readChar()
 			return l.finishToken(tok)
+		case ';':
+			tok := l.makeToken(token.Semicolon, string(l.ch))
+			l.readChar()
+			return l.finishToken(tok)
 		case '(':
 			tok := l.makeToken(token.LParen, string(l.ch))
 			l.readChar()
@@ -232,6 +280,18 @@ func (l *Lexer) makeToken(t token.Type, lit string) token.Token {
 
 func (l *Lexer) finishToken(tok token.Token) token.Token {
 	l.lastToken = tok.Type
+	return l.withEnd(tok)
+}
+
+// withEnd sets tok.End to the position just past the source consumed since tok started.
+// It is derived from the source span rather than the literal, which drops quotes, escapes and '$'.
+func (l *Lexer) withEnd(tok token.Token) token.Token {
+	src := l.input[tok.Pos.Offset:l.pos]
+	tok.End = token.Position{Offset: l.pos, Line: tok.Pos.Line, Column: tok.Pos.Column + len(src)}
+	if nl := strings.Count(src, "\n"); nl > 0 {
+		tok.End.Line += nl
+		tok.End.Column = len(src) - strings.LastIndexByte(src, '\n')
+	}
 	return tok
 }
 
@@ -244,6 +304,7 @@ func (l *Lexer) skipWhitespace() {
 func (l *Lexer) consumeNewline() (token.Token, bool) {
 	pos := l.makeToken(token.Newline, "")
 	l.readChar()
+	pos = l.withEnd(pos)
 
 	if l.parenDepth == 0 && l.bracketDepth == 0 && newlineEligible(l.lastToken) {
 		l.lastToken = token.Newline
@@ -373,7 +434,7 @@ func newlineEligible(t token.Type) bool {
 	case token.Ident, token.Variable, token.Number, token.String,
 		token.True, token.False, token.Null,
 		token.RParen, token.RBracket, token.RBrace,
-		token.Return:
+		token.Return, token.Break, token.Continue:
 		return true
 	default:
 		return false
@@ -396,7 +457,15 @@ func (l *Lexer) peekChar() byte {
 }
 
 func (l *Lexer) readChar() {
+	// Lines advance when moving past a newline, so the newline itself belongs to the line it ends.
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
 	if l.readPos >= len(l.input) {
+		if l.ch != 0 {
+			l.column++ // EOF sits just past the last character
+		}
 		l.pos = l.readPos
 		l.ch = 0
 		return
@@ -405,11 +474,5 @@ func (l *Lexer) readChar() {
 	l.ch = l.input[l.readPos]
 	l.pos = l.readPos
 	l.readPos++
-
-	if l.ch == '\n' {
-		l.line++
-		l.column = 0
-	} else {
-		l.column++
-	}
+	l.column++
 }
@@ -126,3 +126,60 @@ $b := 2`
 		}
 	}
 }
+
+func TestLexerEmitComments(t *testing.T) {
+	input := `// line comment
+$a := 1 /* block
+comment */
+$b := 2`
+
+	expected := []struct {
+		typ token.Type
+		lit string
+	}{
+		{token.Comment, "// line comment"},
+		{token.Variable, "a"},
+		{token.Define, ":="},
+		{token.Number, "1"},
+		{token.Comment, "/* block\ncomment */"},
+		{token.Newline, ""},
+		{token.Variable, "b"},
+		{token.Define, ":="},
+		{token.Number, "2"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	l.SetEmitComments(true)
+	for i, want := range expected {
+		tok := l.NextToken()
+		if tok.Type != want.typ || tok.Literal != want.lit {
+			t.Fatalf("token %d: expected %v %q, got %v %q", i, want.typ, want.lit, tok.Type, tok.Literal)
+		}
+		if tok.Type == token.Comment && input[tok.Pos.Offset:tok.Pos.Offset+len(tok.Literal)] != tok.Literal {
+			t.Fatalf("token %d: comment position %d does not match its span", i, tok.Pos.Offset)
+		}
+	}
+}
+
+func TestLexerTokenEnd(t *testing.T) {
+	input := "$a >= \"x\\ty\"\n$b"
+	l := New(input)
+	tests := []struct {
+		typ        token.Type
+		start, end token.Position
+	}{
+		{token.Variable, token.Position{Offset: 0, Line: 1, Column: 1}, token.Position{Offset: 2, Line: 1, Column: 3}},
+		{token.GreaterEqual, token.Position{Offset: 3, Line: 1, Column: 4}, token.Position{Offset: 5, Line: 1, Column: 6}},
+		// The literal is x<TAB>y, but the span covers the quotes and the escape sequence.
+		{token.String, token.Position{Offset: 6, Line: 1, Column: 7}, token.Position{Offset: 12, Line: 1, Column: 13}},
+		{token.Newline, token.Position{Offset: 12, Line: 1, Column: 13}, token.Position{Offset: 13, Line: 2, Column: 1}},
+		{token.Variable, token.Position{Offset: 13, Line: 2, Column: 1}, token.Position{Offset: 15, Line: 2, Column: 3}},
+	}
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.typ || tok.Pos != tt.start || tok.End != tt.end {
+			t.Fatalf("token %d: expected %v %+v-%+v, got %v %+v-%+v", i, tt.typ, tt.start, tt.end, tok.Type, tok.Pos, tok.End)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package lint
+
+import (
+	"sort"
+
+	"github.com/xirelogy/go-flux/internal/ast"
+	"github.com/xirelogy/go-flux/internal/token"
+)
+
+// Warning codes reported by Program.
+const (
+	CodeUnreachable = "unreachable-code"
+	CodeUnused      = "unused-variable"
+)
+
+// Warning is an advisory finding: the program still compiles and runs, but probably not as intended.
+type Warning struct {
+	Line    int
+	Column  int
+	Code    string
+	Message string
+}
+
+// Program walks a parsed program and reports advisory warnings in source order.
+// Scoping mirrors the compiler: `:=` declares a function-wide local that nested closures can read.
+func Program(prog *ast.Program) []Warning {
+	w := &walker{}
+	for _, stmt := range prog.Statements {
+		if fn, ok := stmt.(*ast.FuncDecl); ok {
+			w.function(nil, fn.Params, fn.Body)
+			continue
+		}
+		w.stmt(newScope(nil), stmt)
+	}
+	sort.SliceStable(w.warnings, func(i, j int) bool {
+		a, b := w.warnings[i], w.warnings[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return w.warnings
+}
+
+// variable is a local binding; decl is nil for bindings that are never reported (parameters, loop bindings).
+type variable struct {
+	decl *ast.Variable
+	used bool
+}
+
+type scope struct {
+	enclosing *scope
+	vars      map[string]*variable
+	order     []*variable
+}
+
+func newScope(enclosing *scope) *scope {
+	return &scope{enclosing: enclosing, vars: map[string]*variable{}}
+}
+
+func (s *scope) declare(name string, decl *ast.Variable) {
+	if _, exists := s.vars[name]; exists {
+		return
+	}
+	v := &variable{decl: decl}
+	s.vars[name] = v
+	s.order = append(s.order, v)
+}
+
+func (s *scope) resolve(name string) *variable {
+	for cur := s; cur != nil; cur = cur.enclosing {
+		if v, ok := cur.vars[name]; ok {
+			return v
+		}
+	}
+	return nil
+}
+
+type walker struct {
+	warnings []Warning
+}
+
+func (w *walker) function(enclosing *scope, params []ast.Param, body *ast.BlockStmt) {
+	sc := newScope(enclosing)
+	for _, p := range params {
+		sc.declare(p.Name, nil)
+	}
+	w.block(sc, body)
+	for _, v := range sc.order {
+		if v.decl != nil && !v.used && v.decl.Name != "_" {
+			w.warn(v.decl, CodeUnused, "variable $"+v.decl.Name+" is declared but never read")
+		}
+	}
+}
+
+func (w *walker) warn(node ast.Node, code, message string) {
+	pos := node.Pos()
+	w.warnings = append(w.warnings, Warning{Line: pos.Line, Column: pos.Column, Code: code, Message: message})
+}
+
+func (w *walker) block(sc *scope, b *ast.BlockStmt) {
+	if b == nil {
+		return
+	}
+	exit := ""
+	for _, stmt := range b.Statements {
+		if exit != "" {
+			// One warning per block: everything after the first dead statement is dead too.
+			w.warn(stmt, CodeUnreachable, "unreachable code after "+exit)
+			return
+		}
+		w.stmt(sc, stmt)
+		switch stmt.(type) {
+		case *ast.ReturnStmt:
+			exit = "return"
+		case *ast.BreakStmt:
+			exit = "break"
+		case *ast.ContinueStmt:
+			exit = "continue"
+		}
+	}
+}
+
+func (w *walker) stmt(sc *scope, stmt ast.Statement) {
+	switch s := stmt.(type) {
+	case *ast.BlockStmt:
+		w.block(sc, s)
+	case *ast.ExprStmt:
+		w.expr(sc, s.Expression)
+	case *ast.ReturnStmt:
+		w.expr(sc, s.Value)
+	case *ast.DeferStmt:
+		w.expr(sc, s.Call)
+	case *ast.YieldStmt:
+		w.expr(sc, s.Value)
+	case *ast.IterateStmt:
+		w.expr(sc, s.Iterable)
+		w.expr(sc, s.Callback)
+	case *ast.IfStmt:
+		w.expr(sc, s.Condition)
+		w.block(sc, s.Conseq)
+		for _, clause := range s.ElseIfs {
+			w.expr(sc, clause.Condition)
+			w.block(sc, clause.Conseq)
+		}
+		w.block(sc, s.Alt)
+	case *ast.WhileStmt:
+		w.expr(sc, s.Condition)
+		w.block(sc, s.Body)
+	case *ast.ForStmt:
+		w.expr(sc, s.Iterable)
+		if s.Binding.Key != "" {
+			sc.declare(s.Binding.Key, nil)
+		}
+		sc.declare(s.Binding.ValueName, nil)
+		w.block(sc, s.Body)
+	case *ast.FuncDecl:
+		sc.declare(s.Name, nil)
+		w.function(sc, s.Params, s.Body)
+	}
+}
+
+func (w *walker) expr(sc *scope, e ast.Expression) {
+	switch x := e.(type) {
+	case *ast.Variable:
+		if v := sc.resolve(x.Name); v != nil {
+			v.used = true
+		}
+	case *ast.ArrayLiteral:
+		for _, el := range x.Elements {
+			w.expr(sc, el)
+		}
+	case *ast.RangeLiteral:
+		w.expr(sc, x.Start)
+		w.expr(sc, x.End)
+	case *ast.ObjectLiteral:
+		for _, f := range x.Fields {
+			w.expr(sc, f.Value)
+		}
+	case *ast.UnaryExpr:
+		w.expr(sc, x.Right)
+	case *ast.BinaryExpr:
+		w.expr(sc, x.Left)
+		w.expr(sc, x.Right)
+	case *ast.AssignExpr:
+		w.assign(sc, x)
+	case *ast.CallExpr:
+		w.expr(sc, x.Callee)
+		for _, arg := range x.Arguments {
+			w.expr(sc, arg)
+		}
+	case *ast.MemberExpr:
+		w.expr(sc, x.Left)
+	case *ast.IndexExpr:
+		w.expr(sc, x.Left)
+		w.expr(sc, x.Index)
+	case *ast.SliceExpr:
+		w.expr(sc, x.Left)
+		if x.Low != nil {
+			w.expr(sc, x.Low)
+		}
+		if x.High != nil {
+			w.expr(sc, x.High)
+		}
+	case *ast.FuncExpr:
+		params := x.Params
+		if x.Receiver != nil {
+			params = append([]ast.Param{*x.Receiver}, x.Params...)
+		}
+		w.function(sc, params, x.Body)
+	}
+}
+
+// assign records `:=` declarations; storing into a variable does not count as reading it.
+func (w *walker) assign(sc *scope, a *ast.AssignExpr) {
+	switch lhs := a.Left.(type) {
+	case *ast.Variable:
+		if a.Operator == token.Define {
+			sc.declare(lhs.Name, lhs)
+		}
+	case *ast.ArrayLiteral:
+		for _, el := range lhs.Elements {
+			if v, ok := el.(*ast.Variable); ok && a.Operator == token.Define {
+				sc.declare(v.Name, v)
+			}
+		}
+	default:
+		w.expr(sc, a.Left)
+	}
+	w.expr(sc, a.Value)
+}
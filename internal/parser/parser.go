@@ -12,14 +12,24 @@ type Parser struct {
 	l         *lexer.Lexer
 	curToken  token.Token
 	peekToken token.Token
-	errors    []string
+	errors    []Error
 	prevToken token.Token
 }
 
+// Error is a parse failure at a source position.
+type Error struct {
+	Pos     token.Position
+	Message string
+}
+
+func (e Error) String() string {
+	return fmt.Sprintf("%d:%d: %s", e.Pos.Line, e.Pos.Column, e.Message)
+}
+
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
 		l:      l,
-		errors: []string{},
+		errors: []Error{},
 	}
 	// Read two tokens, so curToken and peekToken are set
 	p.nextToken()
@@ -28,6 +38,15 @@ func New(l *lexer.Lexer) *Parser {
 }
 
 func (p *Parser) Errors() []string {
+	out := make([]string, len(p.errors))
+	for i, e := range p.errors {
+		out[i] = e.String()
+	}
+	return out
+}
+
+// ErrorList returns parse failures with their structured positions.
+func (p *Parser) ErrorList() []Error {
 	return p.errors
 }
 
@@ -45,10 +64,12 @@ func (p *Parser) ParseProgram() *ast.Program {
 		if p.curToken.Type == token.EOF {
 			break
 		}
+		start := p.curToken.Pos
 		stmt := p.parseStatement()
 		if stmt != nil {
 			prog.Statements = append(prog.Statements, stmt)
 		}
+		p.skipPastStall(start)
 		p.skipNewlines()
 	}
 	if len(prog.Statements) > 0 {
@@ -60,9 +81,24 @@ func (p *Parser) ParseProgram() *ast.Program {
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
 	case token.Func:
+		if p.peekToken.Type == token.LParen || p.peekToken.Type == token.Variable {
+			return p.parseExprStatement()
+		}
 		return p.parseFuncDecl()
 	case token.Return:
 		return p.parseReturn()
+	case token.Defer:
+		return p.parseDefer()
+	case token.Yield:
+		return p.parseYield()
+	case token.Iterate:
+		return p.parseIterate()
+	case token.Break, token.Continue:
+		return p.parseBranch()
+	case token.Import:
+		return p.parseImport()
+	case token.Const:
+		return p.parseConst()
 	case token.If:
 		return p.parseIf()
 	case token.While:
@@ -77,20 +113,39 @@ func (p *Parser) parseStatement() ast.Statement {
 }
 
 func (p *Parser) parseBlock() ast.Statement {
+	block := p.parseBlockBody()
+	if p.curToken.Type == token.RBrace {
+		p.nextToken()
+	}
+	return block
+}
+
+// skipPastStall advances one token when a statement failed without consuming anything,
+// so malformed input cannot loop forever.
+func (p *Parser) skipPastStall(start token.Position) {
+	if p.curToken.Pos == start && p.curToken.Type != token.EOF && p.curToken.Type != token.RBrace {
+		p.nextToken()
+	}
+}
+
+// parseBlockBody parses a block but leaves the closing '}' as the current token,
+// as expression parsers expect.
+func (p *Parser) parseBlockBody() *ast.BlockStmt {
 	block := &ast.BlockStmt{LBrace: p.curToken.Pos}
 	p.nextToken()
 	p.skipNewlines()
 	for p.curToken.Type != token.RBrace && p.curToken.Type != token.EOF {
+		start := p.curToken.Pos
 		stmt := p.parseStatement()
 		if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
+		p.skipPastStall(start)
 		p.skipNewlines()
 	}
 	end := block.LBrace
 	if p.curToken.Type == token.RBrace {
-		end = p.curToken.Pos
-		p.nextToken()
+		end = p.curToken.End
 	} else if len(block.Statements) > 0 {
 		end = block.Statements[len(block.Statements)-1].Span().End
 	}
@@ -118,6 +173,118 @@ func (p *Parser) parseReturn() ast.Statement {
 	return ret
 }
 
+// parseBranch parses a bare `break` or `continue`, leaving the current token on the terminator.
+func (p *Parser) parseBranch() ast.Statement {
+	tok := p.curToken
+	span := token.Span{Start: tok.Pos, End: tok.End}
+	p.nextToken()
+	if !p.isEndOfStatement(p.curToken.Type) {
+		p.errorf(p.curToken.Pos, "unexpected %s after %s", p.curToken.Type, tok.Literal)
+		return nil
+	}
+	if tok.Type == token.Break {
+		return &ast.BreakStmt{Break: tok.Pos, StmtSpan: span}
+	}
+	return &ast.ContinueStmt{Continue: tok.Pos, StmtSpan: span}
+}
+
+func (p *Parser) parseDefer() ast.Statement {
+	stmt := &ast.DeferStmt{Defer: p.curToken.Pos}
+	p.nextToken()
+	if p.isEndOfStatement(p.curToken.Type) {
+		p.errorf(stmt.Defer, "defer requires a function call")
+		return nil
+	}
+	expr := p.parseExpression(assignPrecedence - 1)
+	call, ok := expr.(*ast.CallExpr)
+	if !ok {
+		p.errorf(stmt.Defer, "defer requires a function call")
+		return nil
+	}
+	stmt.Call = call
+	stmt.StmtSpan = token.Span{Start: stmt.Defer, End: call.Span().End}
+	if p.curToken.Type != token.EOF {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseYield() ast.Statement {
+	stmt := &ast.YieldStmt{Yield: p.curToken.Pos}
+	p.nextToken()
+	if p.isEndOfStatement(p.curToken.Type) {
+		p.errorf(stmt.Yield, "yield requires a value")
+		return nil
+	}
+	stmt.Value = p.parseExpression(assignPrecedence - 1)
+	stmt.StmtSpan = token.Span{Start: stmt.Yield, End: stmt.Value.Span().End}
+	if p.curToken.Type != token.EOF {
+		p.nextToken()
+	}
+	return stmt
+}
+
+// parseIterate parses `iterate <iterable> using <callback>`.
+func (p *Parser) parseIterate() ast.Statement {
+	stmt := &ast.IterateStmt{Iterate: p.curToken.Pos}
+	p.nextToken()
+	if p.isEndOfStatement(p.curToken.Type) {
+		p.errorf(stmt.Iterate, "iterate requires a value to iterate")
+		return nil
+	}
+	stmt.Iterable = p.parseExpression(assignPrecedence - 1)
+	if !p.expectPeek(token.Using) {
+		return nil
+	}
+	p.nextToken()
+	p.nextToken()
+	if p.isEndOfStatement(p.curToken.Type) {
+		p.errorf(stmt.Iterate, "iterate requires a callback after using")
+		return nil
+	}
+	stmt.Callback = p.parseExpression(assignPrecedence - 1)
+	stmt.StmtSpan = token.Span{Start: stmt.Iterate, End: stmt.Callback.Span().End}
+	if p.curToken.Type != token.EOF {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseImport() ast.Statement {
+	stmt := &ast.ImportStmt{Import: p.curToken.Pos}
+	if !p.expectPeek(token.String) {
+		p.nextToken()
+		return nil
+	}
+	p.nextToken()
+	stmt.Name = p.curToken.Literal
+	stmt.StmtSpan = token.Span{Start: stmt.Import, End: p.curToken.End}
+	p.nextToken()
+	return stmt
+}
+
+func (p *Parser) parseConst() ast.Statement {
+	constPos := p.curToken.Pos
+	p.nextToken()
+	stmt := p.parseExprStatement().(*ast.ExprStmt)
+	if stmt.Expression == nil {
+		return stmt
+	}
+	assign, ok := stmt.Expression.(*ast.AssignExpr)
+	if !ok || assign.Operator != token.Define {
+		p.errorf(constPos, "const declaration requires $name := value")
+		return stmt
+	}
+	if _, ok := assign.Left.(*ast.Variable); !ok {
+		p.errorf(constPos, "const declaration requires $name := value")
+		return stmt
+	}
+	assign.Const = true
+	stmt.Start = constPos
+	stmt.StmtSpan.Start = constPos
+	return stmt
+}
+
 func (p *Parser) parseIf() ast.Statement {
 	stmt := &ast.IfStmt{IfPos: p.curToken.Pos}
 	if !p.expectPeek(token.LParen) {
@@ -224,7 +391,7 @@ func (p *Parser) parseFor() ast.Statement {
 		return stmt
 	}
 	p.nextToken() // move past 'in' to iterable expression
-	stmt.Iterable = p.parseExpression(lowest)
+	stmt.Iterable = p.parseExpression(assignPrecedence - 1)
 	p.consumeRParen()
 	p.skipNewlines()
 	if p.curToken.Type != token.LBrace && p.peekToken.Type == token.LBrace {
@@ -325,19 +492,19 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 
 	switch p.curToken.Type {
 	case token.Ident:
-		left = &ast.Identifier{Name: p.curToken.Literal, PosT: p.curToken.Pos, Sp: token.Span{Start: p.curToken.Pos, End: p.curToken.Pos}}
+		left = &ast.Identifier{Name: p.curToken.Literal, PosT: p.curToken.Pos, Sp: token.Span{Start: p.curToken.Pos, End: p.curToken.End}}
 	case token.Variable:
-		left = &ast.Variable{Name: p.curToken.Literal, PosT: p.curToken.Pos, Sp: token.Span{Start: p.curToken.Pos, End: p.curToken.Pos}}
+		left = &ast.Variable{Name: p.curToken.Literal, PosT: p.curToken.Pos, Sp: token.Span{Start: p.curToken.Pos, End: p.curToken.End}}
 	case token.Number:
-		left = &ast.NumberLiteral{Value: p.curToken.Literal, PosT: p.curToken.Pos, Sp: token.Span{Start: p.curToken.Pos, End: p.curToken.Pos}}
+		left = &ast.NumberLiteral{Value: p.curToken.Literal, PosT: p.curToken.Pos, Sp: token.Span{Start: p.curToken.Pos, End: p.curToken.End}}
 	case token.String:
-		left = &ast.StringLiteral{Value: p.curToken.Literal, PosT: p.curToken.Pos, Sp: token.Span{Start: p.curToken.Pos, End: p.curToken.Pos}}
+		left = &ast.StringLiteral{Value: p.curToken.Literal, PosT: p.curToken.Pos, Sp: token.Span{Start: p.curToken.Pos, End: p.curToken.End}}
 	case token.True:
-		left = &ast.BoolLiteral{Value: true, PosT: p.curToken.Pos, Sp: token.Span{Start: p.curToken.Pos, End: p.curToken.Pos}}
+		left = &ast.BoolLiteral{Value: true, PosT: p.curToken.Pos, Sp: token.Span{Start: p.curToken.Pos, End: p.curToken.End}}
 	case token.False:
-		left = &ast.BoolLiteral{Value: false, PosT: p.curToken.Pos, Sp: token.Span{Start: p.curToken.Pos, End: p.curToken.Pos}}
+		left = &ast.BoolLiteral{Value: false, PosT: p.curToken.Pos, Sp: token.Span{Start: p.curToken.Pos, End: p.curToken.End}}
 	case token.Null:
-		left = &ast.NullLiteral{PosT: p.curToken.Pos, Sp: token.Span{Start: p.curToken.Pos, End: p.curToken.Pos}}
+		left = &ast.NullLiteral{PosT: p.curToken.Pos, Sp: token.Span{Start: p.curToken.Pos, End: p.curToken.End}}
 	case token.Func:
 		left = p.parseFuncExpr()
 	case token.LParen:
@@ -366,9 +533,10 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 		op := p.peekToken.Type
 		p.nextToken()
 		switch op {
-		case token.Assign, token.Define:
+		case token.Assign, token.Define,
+			token.PlusAssign, token.MinusAssign, token.StarAssign, token.SlashAssign, token.PercentAssign:
 			left = p.parseAssignExpression(left)
-		case token.Plus, token.Minus, token.Star, token.Slash,
+		case token.Plus, token.Minus, token.Star, token.Slash, token.Percent,
 			token.Equal, token.NotEqual,
 			token.Less, token.LessEqual, token.Greater, token.GreaterEqual,
 			token.AndAnd, token.OrOr:
@@ -442,12 +610,12 @@ func (p *Parser) parseCallExpression(callee ast.Expression) ast.Expression {
 	p.nextToken()
 	expr.Arguments = p.parseExpressionList(token.RParen)
 	end := expr.PosT
-	if len(expr.Arguments) > 0 {
+	if p.curToken.Type == token.RParen {
+		end = p.curToken.End
+	} else if len(expr.Arguments) > 0 {
 		end = expr.Arguments[len(expr.Arguments)-1].Span().End
-	} else if p.curToken.Type == token.RParen {
-		end = p.curToken.Pos
 	} else {
-		end = p.prevToken.Pos
+		end = p.prevToken.End
 	}
 	expr.Sp = token.Span{Start: callee.Span().Start, End: end}
 	return expr
@@ -464,14 +632,21 @@ func (p *Parser) parseMemberExpression(left ast.Expression) ast.Expression {
 		Left:     left,
 		Property: prop,
 		PosT:     pos,
-		Sp:       token.Span{Start: left.Span().Start, End: p.curToken.Pos},
+		Sp:       token.Span{Start: left.Span().Start, End: p.curToken.End},
 	}
 }
 
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	pos := p.curToken.Pos
 	p.nextToken()
+	if p.curToken.Type == token.Colon {
+		return p.parseSliceExpression(left, pos, nil)
+	}
 	index := p.parseExpression(lowest)
+	if p.peekToken.Type == token.Colon {
+		p.nextToken()
+		return p.parseSliceExpression(left, pos, index)
+	}
 	if !p.expectPeek(token.RBracket) {
 		return nil
 	}
@@ -480,8 +655,23 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 		Left:  left,
 		Index: index,
 		PosT:  pos,
-		Sp:    token.Span{Start: left.Span().Start, End: pos},
+		Sp:    token.Span{Start: left.Span().Start, End: p.curToken.End},
+	}
+}
+
+// parseSliceExpression finishes `left[low:high]` with the current token on the colon.
+func (p *Parser) parseSliceExpression(left ast.Expression, pos token.Position, low ast.Expression) ast.Expression {
+	expr := &ast.SliceExpr{Left: left, Low: low, PosT: pos}
+	if p.peekToken.Type != token.RBracket {
+		p.nextToken()
+		expr.High = p.parseExpression(lowest)
+	}
+	if !p.expectPeek(token.RBracket) {
+		return nil
 	}
+	p.nextToken()
+	expr.Sp = token.Span{Start: left.Span().Start, End: p.curToken.End}
+	return expr
 }
 
 func (p *Parser) parseArrayOrRange() ast.Expression {
@@ -489,8 +679,7 @@ func (p *Parser) parseArrayOrRange() ast.Expression {
 	p.nextToken()
 	// Empty array
 	if p.curToken.Type == token.RBracket {
-		p.nextToken()
-		return &ast.ArrayLiteral{PosT: startPos}
+		return &ast.ArrayLiteral{PosT: startPos, Sp: token.Span{Start: startPos, End: p.curToken.End}}
 	}
 
 	first := p.parseExpression(lowest)
@@ -504,23 +693,24 @@ func (p *Parser) parseArrayOrRange() ast.Expression {
 			return &ast.RangeLiteral{Start: first, End: end, PosT: startPos}
 		}
 		p.nextToken() // move to ']'
-		spanEnd := p.curToken.Pos
+		spanEnd := p.curToken.End
 		return &ast.RangeLiteral{Start: first, End: end, PosT: startPos, Sp: token.Span{Start: startPos, End: spanEnd}}
 	}
 
 	elements := []ast.Expression{first}
+	trailingComma := false
 	for p.peekToken.Type == token.Comma {
 		p.nextToken() // move to comma
 		p.nextToken() // move to next element
 		if p.curToken.Type == token.RBracket {
+			trailingComma = true
 			break
 		}
 		elem := p.parseExpression(lowest)
 		elements = append(elements, elem)
 	}
-	if p.curToken.Type == token.RBracket {
-		spanEnd := p.curToken.Pos
-		p.nextToken()
+	if trailingComma {
+		spanEnd := p.curToken.End
 		return &ast.ArrayLiteral{Elements: elements, PosT: startPos, Sp: token.Span{Start: startPos, End: spanEnd}}
 	}
 	if p.peekToken.Type != token.RBracket {
@@ -528,7 +718,7 @@ func (p *Parser) parseArrayOrRange() ast.Expression {
 		return &ast.ArrayLiteral{Elements: elements, PosT: startPos}
 	}
 	p.nextToken() // move to ']'
-	spanEnd := p.curToken.Pos
+	spanEnd := p.curToken.End
 	return &ast.ArrayLiteral{Elements: elements, PosT: startPos, Sp: token.Span{Start: startPos, End: spanEnd}}
 }
 
@@ -536,8 +726,7 @@ func (p *Parser) parseObjectLiteral() ast.Expression {
 	obj := &ast.ObjectLiteral{PosT: p.curToken.Pos}
 	p.nextToken()
 	if p.curToken.Type == token.RBrace {
-		p.nextToken()
-		obj.Sp = token.Span{Start: obj.PosT, End: p.prevToken.Pos}
+		obj.Sp = token.Span{Start: obj.PosT, End: p.curToken.End}
 		return obj
 	}
 	p.skipNewlines()
@@ -573,24 +762,24 @@ func (p *Parser) parseObjectLiteral() ast.Expression {
 			break
 		}
 	}
-	obj.Sp = token.Span{Start: obj.PosT, End: p.prevToken.Pos}
+	obj.Sp = token.Span{Start: obj.PosT, End: p.curToken.End}
 	return obj
 }
 
 func (p *Parser) parseObjectKey() ast.ObjectKey {
 	switch p.curToken.Type {
 	case token.Ident:
-		key := ast.ObjectKey{Ident: p.curToken.Literal, PosT: p.curToken.Pos, Sp: token.Span{Start: p.curToken.Pos, End: p.curToken.Pos}}
+		key := ast.ObjectKey{Ident: p.curToken.Literal, PosT: p.curToken.Pos, Sp: token.Span{Start: p.curToken.Pos, End: p.curToken.End}}
 		return key
 	case token.String:
 		val := p.curToken.Literal
-		return ast.ObjectKey{Str: &val, PosT: p.curToken.Pos, Sp: token.Span{Start: p.curToken.Pos, End: p.curToken.Pos}}
+		return ast.ObjectKey{Str: &val, PosT: p.curToken.Pos, Sp: token.Span{Start: p.curToken.Pos, End: p.curToken.End}}
 	case token.Number:
 		val := p.curToken.Literal
-		return ast.ObjectKey{Num: &val, PosT: p.curToken.Pos, Sp: token.Span{Start: p.curToken.Pos, End: p.curToken.Pos}}
+		return ast.ObjectKey{Num: &val, PosT: p.curToken.Pos, Sp: token.Span{Start: p.curToken.Pos, End: p.curToken.End}}
 	default:
 		p.errorf(p.curToken.Pos, "invalid object key")
-		return ast.ObjectKey{PosT: p.curToken.Pos, Sp: token.Span{Start: p.curToken.Pos, End: p.curToken.Pos}}
+		return ast.ObjectKey{PosT: p.curToken.Pos, Sp: token.Span{Start: p.curToken.Pos, End: p.curToken.End}}
 	}
 }
 
@@ -634,7 +823,7 @@ func (p *Parser) parseParamList() []ast.Param {
 		p.errorf(p.curToken.Pos, "expected parameter")
 		return params
 	}
-	params = append(params, ast.Param{Name: p.curToken.Literal, Pos: p.curToken.Pos, Sp: token.Span{Start: p.curToken.Pos, End: p.curToken.Pos}})
+	params = append(params, ast.Param{Name: p.curToken.Literal, Pos: p.curToken.Pos, Sp: token.Span{Start: p.curToken.Pos, End: p.curToken.End}})
 	for p.peekToken.Type == token.Comma {
 		p.nextToken()
 		p.nextToken()
@@ -642,7 +831,7 @@ func (p *Parser) parseParamList() []ast.Param {
 			p.errorf(p.curToken.Pos, "expected parameter")
 			return params
 		}
-		params = append(params, ast.Param{Name: p.curToken.Literal, Pos: p.curToken.Pos, Sp: token.Span{Start: p.curToken.Pos, End: p.curToken.Pos}})
+		params = append(params, ast.Param{Name: p.curToken.Literal, Pos: p.curToken.Pos, Sp: token.Span{Start: p.curToken.Pos, End: p.curToken.End}})
 	}
 	return params
 }
@@ -662,6 +851,10 @@ func (p *Parser) consumeRParen() {
 
 func (p *Parser) parseFuncExpr() ast.Expression {
 	fn := &ast.FuncExpr{FuncPos: p.curToken.Pos}
+	if p.peekToken.Type == token.Variable {
+		p.nextToken()
+		fn.Receiver = &ast.Param{Name: p.curToken.Literal, Pos: p.curToken.Pos, Sp: token.Span{Start: p.curToken.Pos, End: p.curToken.End}}
+	}
 	if !p.expectPeek(token.LParen) {
 		return nil
 	}
@@ -673,10 +866,7 @@ func (p *Parser) parseFuncExpr() ast.Expression {
 	if p.curToken.Type != token.LBrace && p.peekToken.Type == token.LBrace {
 		p.nextToken()
 	}
-	body := p.parseBlock()
-	if blk, ok := body.(*ast.BlockStmt); ok {
-		fn.Body = blk
-	}
+	fn.Body = p.parseBlockBody()
 	end := fn.FuncPos
 	if fn.Body != nil {
 		end = fn.Body.Span().End
@@ -707,21 +897,26 @@ func (p *Parser) curPrecedence() int {
 	return lowest
 }
 
+// skipNewlines skips statement terminators: newlines and semicolons.
 func (p *Parser) skipNewlines() {
-	for p.curToken.Type == token.Newline {
+	for isTerminator(p.curToken.Type) {
 		p.nextToken()
 	}
 }
 
 func (p *Parser) skipPeekNewlines() {
-	for p.peekToken.Type == token.Newline {
+	for isTerminator(p.peekToken.Type) {
 		p.nextToken()
 	}
 }
 
+func isTerminator(t token.Type) bool {
+	return t == token.Newline || t == token.Semicolon
+}
+
 func (p *Parser) isEndOfExpression(t token.Type) bool {
 	switch t {
-	case token.Newline, token.RBrace, token.EOF, token.Comma, token.RParen, token.RBracket:
+	case token.Newline, token.Semicolon, token.RBrace, token.EOF, token.Comma, token.RParen, token.RBracket:
 		return true
 	default:
 		return false
@@ -730,7 +925,7 @@ func (p *Parser) isEndOfExpression(t token.Type) bool {
 
 func (p *Parser) isEndOfStatement(t token.Type) bool {
 	switch t {
-	case token.Newline, token.RBrace, token.EOF:
+	case token.Newline, token.Semicolon, token.RBrace, token.EOF:
 		return true
 	default:
 		return false
@@ -738,8 +933,7 @@ func (p *Parser) isEndOfStatement(t token.Type) bool {
 }
 
 func (p *Parser) errorf(pos token.Position, format string, args ...any) {
-	msg := fmt.Sprintf(format, args...)
-	p.errors = append(p.errors, fmt.Sprintf("%d:%d: %s", pos.Line, pos.Column, msg))
+	p.errors = append(p.errors, Error{Pos: pos, Message: fmt.Sprintf(format, args...)})
 }
 
 const (
@@ -756,21 +950,27 @@ const (
 )
 
 var precedences = map[token.Type]int{
-	token.Assign:       assignPrecedence,
-	token.Define:       assignPrecedence,
-	token.OrOr:         orPrecedence,
-	token.AndAnd:       andPrecedence,
-	token.Equal:        equalPrecedence,
-	token.NotEqual:     equalPrecedence,
-	token.Less:         lessGreaterPrecedence,
-	token.LessEqual:    lessGreaterPrecedence,
-	token.Greater:      lessGreaterPrecedence,
-	token.GreaterEqual: lessGreaterPrecedence,
-	token.Plus:         sumPrecedence,
-	token.Minus:        sumPrecedence,
-	token.Star:         productPrecedence,
-	token.Slash:        productPrecedence,
-	token.LParen:       callPrecedence,
-	token.LBracket:     callPrecedence,
-	token.Dot:          callPrecedence,
+	token.Assign:        assignPrecedence,
+	token.Define:        assignPrecedence,
+	token.PlusAssign:    assignPrecedence,
+	token.MinusAssign:   assignPrecedence,
+	token.StarAssign:    assignPrecedence,
+	token.SlashAssign:   assignPrecedence,
+	token.PercentAssign: assignPrecedence,
+	token.OrOr:          orPrecedence,
+	token.AndAnd:        andPrecedence,
+	token.Equal:         equalPrecedence,
+	token.NotEqual:      equalPrecedence,
+	token.Less:          lessGreaterPrecedence,
+	token.LessEqual:     lessGreaterPrecedence,
+	token.Greater:       lessGreaterPrecedence,
+	token.GreaterEqual:  lessGreaterPrecedence,
+	token.Plus:          sumPrecedence,
+	token.Minus:         sumPrecedence,
+	token.Star:          productPrecedence,
+	token.Slash:         productPrecedence,
+	token.Percent:       productPrecedence,
+	token.LParen:        callPrecedence,
+	token.LBracket:      callPrecedence,
+	token.Dot:           callPrecedence,
 }
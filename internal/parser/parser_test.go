@@ -1,6 +1,8 @@
 package parser
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/xirelogy/go-flux/internal/ast"
@@ -180,3 +182,328 @@ func TestParseObjectLiteralWithTrailingNewline(t *testing.T) {
 		t.Fatalf("parser errors: %v", p.Errors())
 	}
 }
+
+func TestParseConstDeclaration(t *testing.T) {
+	p := New(lexer.New(`const $PI := 3.14159`))
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	stmt, ok := prog.Statements[0].(*ast.ExprStmt)
+	if !ok {
+		t.Fatalf("expected ExprStmt, got %T", prog.Statements[0])
+	}
+	assign, ok := stmt.Expression.(*ast.AssignExpr)
+	if !ok || !assign.Const || assign.Operator != token.Define {
+		t.Fatalf("expected const define, got %#v", stmt.Expression)
+	}
+
+	p = New(lexer.New(`const $PI = 3`))
+	_ = p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Fatalf("expected parser errors for const without :=")
+	}
+}
+
+func TestParseExpressionSpanEnds(t *testing.T) {
+	input := `$x := f("ab", $o.name, $a[1])`
+	p := New(lexer.New(input))
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	assign := prog.Statements[0].(*ast.ExprStmt).Expression.(*ast.AssignExpr)
+	call := assign.Value.(*ast.CallExpr)
+	if got := call.Span().End.Offset; got != len(input) {
+		t.Fatalf("expected call span to end past ')' at %d, got %d", len(input), got)
+	}
+	str := call.Arguments[0].Span()
+	if input[str.Start.Offset:str.End.Offset] != `"ab"` {
+		t.Fatalf("unexpected string span %q", input[str.Start.Offset:str.End.Offset])
+	}
+	member := call.Arguments[1].Span()
+	if input[member.Start.Offset:member.End.Offset] != `$o.name` {
+		t.Fatalf("unexpected member span %q", input[member.Start.Offset:member.End.Offset])
+	}
+	index := call.Arguments[2].Span()
+	if input[index.Start.Offset:index.End.Offset] != `$a[1]` {
+		t.Fatalf("unexpected index span %q", input[index.Start.Offset:index.End.Offset])
+	}
+}
+
+func TestParseLineContinuationAfterOperators(t *testing.T) {
+	ops := []string{"+", "-", "*", "/", "%", "==", "!=", "<", "<=", ">", ">=", "&&", "||"}
+	for _, op := range ops {
+		input := "$r := $a " + op + "\n  $b\n$next := 1"
+		p := New(lexer.New(input))
+		prog := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("%s: parser errors: %v", op, p.Errors())
+		}
+		if len(prog.Statements) != 2 {
+			t.Fatalf("%s: expected 2 statements, got %d", op, len(prog.Statements))
+		}
+		assign := prog.Statements[0].(*ast.ExprStmt).Expression.(*ast.AssignExpr)
+		bin, ok := assign.Value.(*ast.BinaryExpr)
+		if !ok {
+			t.Fatalf("%s: expected binary value, got %T", op, assign.Value)
+		}
+		if right, ok := bin.Right.(*ast.Variable); !ok || right.Name != "b" {
+			t.Fatalf("%s: expected right operand $b from the next line, got %#v", op, bin.Right)
+		}
+	}
+
+	for _, op := range []string{"=", ":=", "+=", "-=", "*=", "/=", "%="} {
+		input := "$a " + op + "\n  $b\n$next := 1"
+		p := New(lexer.New(input))
+		prog := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("%s: parser errors: %v", op, p.Errors())
+		}
+		if len(prog.Statements) != 2 {
+			t.Fatalf("%s: expected 2 statements, got %d", op, len(prog.Statements))
+		}
+		assign := prog.Statements[0].(*ast.ExprStmt).Expression.(*ast.AssignExpr)
+		if v, ok := assign.Value.(*ast.Variable); !ok || v.Name != "b" {
+			t.Fatalf("%s: expected value $b from the next line, got %#v", op, assign.Value)
+		}
+	}
+}
+
+func TestParseSemicolonSeparatedStatements(t *testing.T) {
+	input := `$a := 1; $b := 2;; $c := $a + $b
+func f() { $x := 1; return $x }`
+	p := New(lexer.New(input))
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	if len(prog.Statements) != 4 {
+		t.Fatalf("expected 4 statements, got %d", len(prog.Statements))
+	}
+	for i, name := range []string{"a", "b", "c"} {
+		assign := prog.Statements[i].(*ast.ExprStmt).Expression.(*ast.AssignExpr)
+		if v := assign.Left.(*ast.Variable); v.Name != name {
+			t.Fatalf("statement %d: expected $%s, got $%s", i, name, v.Name)
+		}
+	}
+	fn := prog.Statements[3].(*ast.FuncDecl)
+	if len(fn.Body.Statements) != 2 {
+		t.Fatalf("expected 2 statements in function body, got %d", len(fn.Body.Statements))
+	}
+	if _, ok := fn.Body.Statements[1].(*ast.ReturnStmt); !ok {
+		t.Fatalf("expected return after semicolon, got %T", fn.Body.Statements[1])
+	}
+}
+
+func TestParsePostfixAfterCollectionLiterals(t *testing.T) {
+	cases := []struct {
+		src  string
+		left string
+	}{
+		{`$v := [].length`, "*ast.ArrayLiteral"},
+		{`$v := [1, 2,].length`, "*ast.ArrayLiteral"},
+		{`$v := [$a[0]].length`, "*ast.ArrayLiteral"},
+		{`$v := {}.length`, "*ast.ObjectLiteral"},
+		{`$v := {a: 1,}.length`, "*ast.ObjectLiteral"},
+	}
+	for _, tc := range cases {
+		p := New(lexer.New(tc.src))
+		prog := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("%s: parser errors: %v", tc.src, p.Errors())
+		}
+		assign := prog.Statements[0].(*ast.ExprStmt).Expression.(*ast.AssignExpr)
+		member, ok := assign.Value.(*ast.MemberExpr)
+		if !ok {
+			t.Fatalf("%s: expected member expression, got %T", tc.src, assign.Value)
+		}
+		if got := fmt.Sprintf("%T", member.Left); got != tc.left || member.Property != "length" {
+			t.Fatalf("%s: unexpected member %s.%s", tc.src, got, member.Property)
+		}
+	}
+}
+
+func TestParseFuncExprReceiver(t *testing.T) {
+	input := `$o := {
+  a: func $self($n) { return $self.b + $n },
+  b: func ($x) { return $x }
+}`
+	p := New(lexer.New(input))
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	obj := prog.Statements[0].(*ast.ExprStmt).Expression.(*ast.AssignExpr).Value.(*ast.ObjectLiteral)
+	if len(obj.Fields) != 2 {
+		t.Fatalf("expected 2 fields, got %d", len(obj.Fields))
+	}
+	method := obj.Fields[0].Value.(*ast.FuncExpr)
+	if method.Receiver == nil || method.Receiver.Name != "self" || len(method.Params) != 1 {
+		t.Fatalf("unexpected method receiver %+v params %+v", method.Receiver, method.Params)
+	}
+	if plain := obj.Fields[1].Value.(*ast.FuncExpr); plain.Receiver != nil {
+		t.Fatalf("expected plain function without receiver, got %+v", plain.Receiver)
+	}
+}
+
+func TestParseMalformedFuncTerminates(t *testing.T) {
+	for _, input := range []string{"func", "func (", ": func () {}", "b: func ($a) { return $a },", "func f() { func }"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Fatalf("%q: expected parse errors", input)
+		}
+	}
+}
+
+func TestParseUnaryPlusSpan(t *testing.T) {
+	p := New(lexer.New(`$y := +$x`))
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	unary := prog.Statements[0].(*ast.ExprStmt).Expression.(*ast.AssignExpr).Value.(*ast.UnaryExpr)
+	if unary.Operator != token.Plus {
+		t.Fatalf("expected unary plus, got %s", unary.Operator)
+	}
+	sp := unary.Span()
+	if sp.Start.Column != 7 || sp.End.Column != 10 {
+		t.Fatalf("unexpected span %+v", sp)
+	}
+}
+
+func TestParseDeferStatement(t *testing.T) {
+	p := New(lexer.New("func f() {\n  defer $h.close(1)\n  return 2\n}"))
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	body := prog.Statements[0].(*ast.FuncDecl).Body.Statements
+	if len(body) != 2 {
+		t.Fatalf("expected 2 statements, got %d", len(body))
+	}
+	stmt, ok := body[0].(*ast.DeferStmt)
+	if !ok {
+		t.Fatalf("expected defer statement, got %T", body[0])
+	}
+	if _, ok := stmt.Call.Callee.(*ast.MemberExpr); !ok || len(stmt.Call.Arguments) != 1 {
+		t.Fatalf("unexpected deferred call %+v", stmt.Call)
+	}
+	if stmt.Pos().Line != 2 || stmt.Pos().Column != 3 {
+		t.Fatalf("unexpected position %+v", stmt.Pos())
+	}
+
+	for _, input := range []string{"func f() { defer }", "func f() { defer $x }", "func f() { defer 1 + 2 }"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if errs := p.Errors(); len(errs) == 0 || !strings.Contains(errs[0], "defer requires a function call") {
+			t.Fatalf("%q: expected defer error, got %v", input, errs)
+		}
+	}
+}
+
+func TestParseSliceExpression(t *testing.T) {
+	cases := []struct {
+		input         string
+		hasLow, hasHi bool
+	}{
+		{`$a[1:3]`, true, true},
+		{`$a[:2]`, false, true},
+		{`$a[2:]`, true, false},
+		{`$a[:]`, false, false},
+	}
+	for _, tc := range cases {
+		p := New(lexer.New(tc.input))
+		prog := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("%s: parser errors: %v", tc.input, p.Errors())
+		}
+		expr := prog.Statements[0].(*ast.ExprStmt).Expression
+		slice, ok := expr.(*ast.SliceExpr)
+		if !ok {
+			t.Fatalf("%s: expected SliceExpr, got %T", tc.input, expr)
+		}
+		if (slice.Low != nil) != tc.hasLow || (slice.High != nil) != tc.hasHi {
+			t.Fatalf("%s: unexpected bounds low=%v high=%v", tc.input, slice.Low, slice.High)
+		}
+		if slice.Span().End.Offset != len(tc.input) {
+			t.Fatalf("%s: expected span to end at %d, got %d", tc.input, len(tc.input), slice.Span().End.Offset)
+		}
+	}
+
+	p := New(lexer.New(`$a[1:3][0]`))
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	index, ok := prog.Statements[0].(*ast.ExprStmt).Expression.(*ast.IndexExpr)
+	if !ok {
+		t.Fatalf("expected index of a slice, got %T", prog.Statements[0].(*ast.ExprStmt).Expression)
+	}
+	if _, ok := index.Left.(*ast.SliceExpr); !ok {
+		t.Fatalf("expected slice operand, got %T", index.Left)
+	}
+
+	p = New(lexer.New(`$a[1:2:3]`))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Fatalf("expected error for a three-part slice")
+	}
+}
+
+func TestParseBreakContinue(t *testing.T) {
+	p := New(lexer.New("while (true) { break; continue\n}"))
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	loop := prog.Statements[0].(*ast.WhileStmt)
+	if _, ok := loop.Body.Statements[0].(*ast.BreakStmt); !ok {
+		t.Fatalf("expected BreakStmt, got %T", loop.Body.Statements[0])
+	}
+	if _, ok := loop.Body.Statements[1].(*ast.ContinueStmt); !ok {
+		t.Fatalf("expected ContinueStmt, got %T", loop.Body.Statements[1])
+	}
+
+	p = New(lexer.New(`while (true) { break 1 }`))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Fatalf("expected error for break with an operand")
+	}
+}
+
+func TestParseYieldIterate(t *testing.T) {
+	p := New(lexer.New("func gen() {\n  yield 1 + 2\n}\nfunc run() {\n  iterate gen() using func ($v) { log($v) }\n}"))
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	gen := prog.Statements[0].(*ast.FuncDecl)
+	yield, ok := gen.Body.Statements[0].(*ast.YieldStmt)
+	if !ok {
+		t.Fatalf("expected YieldStmt, got %T", gen.Body.Statements[0])
+	}
+	if _, ok := yield.Value.(*ast.BinaryExpr); !ok {
+		t.Fatalf("expected binary yield value, got %T", yield.Value)
+	}
+	run := prog.Statements[1].(*ast.FuncDecl)
+	iterate, ok := run.Body.Statements[0].(*ast.IterateStmt)
+	if !ok {
+		t.Fatalf("expected IterateStmt, got %T", run.Body.Statements[0])
+	}
+	if _, ok := iterate.Iterable.(*ast.CallExpr); !ok {
+		t.Fatalf("expected call iterable, got %T", iterate.Iterable)
+	}
+	if _, ok := iterate.Callback.(*ast.FuncExpr); !ok {
+		t.Fatalf("expected function callback, got %T", iterate.Callback)
+	}
+
+	for _, input := range []string{"yield", "iterate $a", "iterate $a using"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Fatalf("%q: expected parser error", input)
+		}
+	}
+}
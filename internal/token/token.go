@@ -8,6 +8,7 @@ type Token struct {
 	Type    Type
 	Literal string
 	Pos     Position
+	End     Position // position just past the token's last source byte
 }
 
 // Position describes a byte offset and 1-based line/column.
@@ -17,7 +18,8 @@ type Position struct {
 	Column int
 }
 
-// Span represents an inclusive start and end position for a node.
+// Span represents a node's source range: Start is its first position and End the position
+// just past its last token (taken from Token.End).
 type Span struct {
 	Start Position
 	End   Position
@@ -27,6 +29,7 @@ const (
 	Illegal Type = "ILLEGAL"
 	EOF     Type = "EOF"
 	Newline Type = "NEWLINE"
+	Comment Type = "COMMENT" // only produced when the lexer preserves comments
 
 	// identifiers and literals
 	Ident    Type = "IDENT"
@@ -35,66 +38,83 @@ const (
 	String   Type = "STRING"
 
 	// keywords
-	If      Type = "IF"
-	ElseIf  Type = "ELSEIF"
-	Else    Type = "ELSE"
-	While   Type = "WHILE"
-	For     Type = "FOR"
-	In      Type = "IN"
-	Func    Type = "FUNC"
-	Return  Type = "RETURN"
-	True    Type = "TRUE"
-	False   Type = "FALSE"
-	Null    Type = "NULL"
-	Yield   Type = "YIELD"
-	Iterate Type = "ITERATE"
-	Using   Type = "USING"
+	If       Type = "IF"
+	ElseIf   Type = "ELSEIF"
+	Else     Type = "ELSE"
+	While    Type = "WHILE"
+	For      Type = "FOR"
+	In       Type = "IN"
+	Func     Type = "FUNC"
+	Return   Type = "RETURN"
+	Defer    Type = "DEFER"
+	Break    Type = "BREAK"
+	Continue Type = "CONTINUE"
+	Import   Type = "IMPORT"
+	Const    Type = "CONST"
+	True     Type = "TRUE"
+	False    Type = "FALSE"
+	Null     Type = "NULL"
+	Yield    Type = "YIELD"
+	Iterate  Type = "ITERATE"
+	Using    Type = "USING"
 
 	// operators
-	Assign       Type = "ASSIGN"       // =
-	Define       Type = "DEFINE"       // :=
-	Plus         Type = "PLUS"         // +
-	Minus        Type = "MINUS"        // -
-	Star         Type = "STAR"         // *
-	Slash        Type = "SLASH"        // /
-	Bang         Type = "BANG"         // !
-	Equal        Type = "EQUAL"        // ==
-	NotEqual     Type = "NOTEQUAL"     // !=
-	Less         Type = "LESS"         // <
-	LessEqual    Type = "LESSEQUAL"    // <=
-	Greater      Type = "GREATER"      // >
-	GreaterEqual Type = "GREATEREQUAL" // >=
-	AndAnd       Type = "ANDAND"       // &&
-	OrOr         Type = "OROR"         // ||
-	Range        Type = "RANGE"        // ..
+	Assign        Type = "ASSIGN"         // =
+	Define        Type = "DEFINE"         // :=
+	PlusAssign    Type = "PLUS_ASSIGN"    // +=
+	MinusAssign   Type = "MINUS_ASSIGN"   // -=
+	StarAssign    Type = "STAR_ASSIGN"    // *=
+	SlashAssign   Type = "SLASH_ASSIGN"   // /=
+	PercentAssign Type = "PERCENT_ASSIGN" // %=
+	Plus          Type = "PLUS"           // +
+	Minus         Type = "MINUS"          // -
+	Star          Type = "STAR"           // *
+	Slash         Type = "SLASH"          // /
+	Percent       Type = "PERCENT"        // %
+	Bang          Type = "BANG"           // !
+	Equal         Type = "EQUAL"          // ==
+	NotEqual      Type = "NOTEQUAL"       // !=
+	Less          Type = "LESS"           // <
+	LessEqual     Type = "LESSEQUAL"      // <=
+	Greater       Type = "GREATER"        // >
+	GreaterEqual  Type = "GREATEREQUAL"   // >=
+	AndAnd        Type = "ANDAND"         // &&
+	OrOr          Type = "OROR"           // ||
+	Range         Type = "RANGE"          // ..
 
 	// delimiters
-	Comma    Type = "COMMA"
-	Colon    Type = "COLON"
-	Dot      Type = "DOT"
-	LParen   Type = "LPAREN"
-	RParen   Type = "RPAREN"
-	LBrace   Type = "LBRACE"
-	RBrace   Type = "RBRACE"
-	LBracket Type = "LBRACKET"
-	RBracket Type = "RBRACKET"
+	Comma     Type = "COMMA"
+	Semicolon Type = "SEMICOLON" // statement terminator, equivalent to a newline
+	Colon     Type = "COLON"
+	Dot       Type = "DOT"
+	LParen    Type = "LPAREN"
+	RParen    Type = "RPAREN"
+	LBrace    Type = "LBRACE"
+	RBrace    Type = "RBRACE"
+	LBracket  Type = "LBRACKET"
+	RBracket  Type = "RBRACKET"
 )
 
 var keywords = map[string]Type{
-	"if":      If,
-	"elseif":  ElseIf,
-	"else":    Else,
-	"while":   While,
-	"for":     For,
-	"in":      In,
-	"func":    Func,
-	"return":  Return,
-	"true":    True,
-	"false":   False,
-	"null":    Null,
-	"yield":   Yield,
-	"iterate": Iterate,
-	"using":   Using,
+	"if":       If,
+	"elseif":   ElseIf,
+	"else":     Else,
+	"while":    While,
+	"for":      For,
+	"in":       In,
+	"func":     Func,
+	"return":   Return,
+	"defer":    Defer,
+	"break":    Break,
+	"continue": Continue,
+	"import":   Import,
+	"const":    Const,
+	"true":     True,
+	"false":    False,
+	"null":     Null,
+	"yield":    Yield,
+	"iterate":  Iterate,
+	"using":    Using,
 }
 
 // LookupIdent returns the keyword token type or Ident.
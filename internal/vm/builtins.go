@@ -16,6 +16,10 @@ type builtinEntry struct {
 
 var builtinRegistry = map[byte]builtinEntry{}
 
+// builtinValues holds each builtin wrapped as a native function, keyed by name, for scripts that
+// use a builtin as a value rather than calling it directly.
+var builtinValues = map[string]Value{}
+
 // RegisterBuiltin installs a built-in handler for a given opcode.
 func RegisterBuiltin(name string, opcode byte, arity int, handler BuiltinHandler) {
 	if handler == nil {
@@ -24,12 +28,38 @@ func RegisterBuiltin(name string, opcode byte, arity int, handler BuiltinHandler
 	if _, exists := builtinRegistry[opcode]; exists {
 		panic(fmt.Sprintf("builtin opcode 0x%X already registered", opcode))
 	}
-	builtinRegistry[opcode] = builtinEntry{
+	entry := builtinEntry{
 		name:    name,
 		opcode:  opcode,
 		arity:   arity,
 		handler: handler,
 	}
+	builtinRegistry[opcode] = entry
+	builtinValues[name] = Value{Kind: KindFunction, Func: &Function{Name: name, Native: entry.native}}
+}
+
+// native runs the builtin as a host function: args go on the stack as a direct call would leave
+// them, and the handler's result is popped off again.
+func (entry builtinEntry) native(vm *VM, args []Value) (Value, error) {
+	if vm.disabled[entry.opcode] {
+		return Null(), fmt.Errorf("builtin %s is disabled", entry.name)
+	}
+	if len(args) != entry.arity {
+		return Null(), fmt.Errorf("builtin %s expects %d args, got %d", entry.name, entry.arity, len(args))
+	}
+	base := len(vm.stack)
+	vm.stack = append(vm.stack, args...)
+	val, err := vm.callBuiltin(entry)
+	if err != nil {
+		vm.unwind(len(vm.frames), base)
+		return val, err
+	}
+	res := Null()
+	if len(vm.stack) > base {
+		res = vm.pop()
+	}
+	vm.stack = vm.stack[:base]
+	return res, nil
 }
 
 func lookupBuiltin(op byte) (builtinEntry, bool) {
@@ -37,13 +67,47 @@ func lookupBuiltin(op byte) (builtinEntry, bool) {
 	return entry, ok
 }
 
+// SetBuiltinEnabled allows or forbids a builtin on this VM only; executing a disabled builtin is a runtime error.
+// It reports false when no builtin with the given name is registered.
+func (vm *VM) SetBuiltinEnabled(name string, enabled bool) bool {
+	for op, entry := range builtinRegistry {
+		if entry.name != name {
+			continue
+		}
+		if enabled {
+			delete(vm.disabled, op)
+			return true
+		}
+		if vm.disabled == nil {
+			vm.disabled = make(map[byte]bool)
+		}
+		vm.disabled[op] = true
+		return true
+	}
+	return false
+}
+
 func (vm *VM) runBuiltin(entry builtinEntry, fr *frame) (Value, error) {
 	if len(vm.stack) < entry.arity {
 		return vm.errorf(fr, "builtin %s expects %d args, stack has %d", entry.name, entry.arity, len(vm.stack))
 	}
-	val, err := entry.handler(vm)
+	val, err := vm.callBuiltin(entry)
 	if err != nil {
 		return vm.wrapError(fr, val, err)
 	}
 	return Value{}, nil
 }
+
+// callBuiltin runs a builtin handler, converting a panic into an error naming the builtin.
+// Frames pushed by callbacks the handler made are discarded along with its arguments.
+func (vm *VM) callBuiltin(entry builtinEntry) (val Value, err error) {
+	depth, base := len(vm.frames), len(vm.stack)-entry.arity
+	defer func() {
+		if r := recover(); r != nil {
+			vm.unwind(depth, base)
+			err = fmt.Errorf("panic in builtin %s: %v", entry.name, r)
+			val = ErrorVal(err.Error())
+		}
+	}()
+	return entry.handler(vm)
+}
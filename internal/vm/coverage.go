@@ -0,0 +1,42 @@
+package vm
+
+import "sort"
+
+// EnableCoverage starts recording which source lines execute; previously collected data is discarded.
+func (vm *VM) EnableCoverage() {
+	vm.coverage = make(map[string]map[int]bool)
+}
+
+// Coverage returns executed lines per source, sorted ascending. Returns nil when coverage is disabled.
+func (vm *VM) Coverage() map[string][]int {
+	if vm.coverage == nil {
+		return nil
+	}
+	out := make(map[string][]int, len(vm.coverage))
+	for src, lines := range vm.coverage {
+		list := make([]int, 0, len(lines))
+		for line := range lines {
+			list = append(list, line)
+		}
+		sort.Ints(list)
+		out[src] = list
+	}
+	return out
+}
+
+func (vm *VM) recordCoverage(fr *frame) {
+	line := lineForOffset(fr.fn.Proto.Chunk, fr.lastOp)
+	if line == 0 {
+		return
+	}
+	src := fr.fn.Source
+	if src == "" {
+		src = fr.fn.Proto.Source
+	}
+	lines := vm.coverage[src]
+	if lines == nil {
+		lines = make(map[int]bool)
+		vm.coverage[src] = lines
+	}
+	lines[line] = true
+}
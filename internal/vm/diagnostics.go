@@ -9,16 +9,20 @@ import (
 
 // TraceInfo describes a single instruction dispatch for debugging/tracing.
 type TraceInfo struct {
-	Op       byte
-	Function string
-	Source   string
-	Line     int
-	IP       int
+	Op         byte
+	Function   string
+	Source     string
+	Line       int
+	IP         int
+	StackDepth int // operand stack size across all frames, before the instruction runs
 }
 
 // TraceHook observes instruction dispatch for debugging/profiling.
 type TraceHook func(TraceInfo)
 
+// ValueTraceHook observes instruction dispatch along with the current frame's top-of-stack value.
+type ValueTraceHook func(TraceInfo, Value)
+
 // FrameInfo captures the call frame at the time of an error or trace event.
 type FrameInfo struct {
 	Function string
@@ -92,17 +96,28 @@ func (vm *VM) newRuntimeError(fr *frame, offset int, msg string, cause error) *R
 }
 
 func (vm *VM) trace(fr *frame, op byte) {
-	if vm.traceHook == nil {
+	if vm.traceHook == nil && vm.valueTraceHook == nil {
 		return
 	}
 	info := vm.frameInfo(fr, vm.offsetForFrame(fr))
-	vm.traceHook(TraceInfo{
-		Op:       op,
-		Function: info.Function,
-		Source:   info.Source,
-		Line:     info.Line,
-		IP:       info.IP,
-	})
+	tr := TraceInfo{
+		Op:         op,
+		Function:   info.Function,
+		Source:     info.Source,
+		Line:       info.Line,
+		IP:         info.IP,
+		StackDepth: len(vm.stack),
+	}
+	if vm.traceHook != nil {
+		vm.traceHook(tr)
+	}
+	if vm.valueTraceHook != nil {
+		top := Null()
+		if len(vm.stack) > fr.base {
+			top = vm.stack[len(vm.stack)-1]
+		}
+		vm.valueTraceHook(tr, top)
+	}
 }
 
 func (vm *VM) stackTrace(current *frame, offset int) []FrameInfo {
@@ -43,3 +43,28 @@ func (vm *VM) Disassemble(w io.Writer) error {
 	}
 	return nil
 }
+
+// DisassembleFunction emits bytecode for a single compiled global function and its nested closures.
+func (vm *VM) DisassembleFunction(name string, w io.Writer) error {
+	if vm == nil {
+		return fmt.Errorf("nil VM")
+	}
+	if w == nil {
+		return fmt.Errorf("nil writer")
+	}
+	val, ok := vm.globals[name]
+	if !ok {
+		return fmt.Errorf("global %s not found", name)
+	}
+	if val.Kind != KindFunction || val.Func == nil || val.Func.Proto == nil {
+		return fmt.Errorf("global %q is not a compiled function (it is a %s)", name, describeGlobal(val))
+	}
+	return bytecode.NewDisassembler(w).DisassemblePrototype(name, val.Func.Proto)
+}
+
+func describeGlobal(v Value) string {
+	if v.Kind == KindFunction && v.Func != nil && v.Func.Native != nil {
+		return "host function"
+	}
+	return typeName(v)
+}
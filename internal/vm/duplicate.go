@@ -12,35 +12,73 @@ func (vm *VM) Duplicate() *VM {
 	dup.maxStack = vm.maxStack
 	dup.maxFrames = vm.maxFrames
 	dup.traceHook = vm.traceHook
+	dup.valueTraceHook = vm.valueTraceHook
+	dup.logSink = vm.logSink
+	dup.emptyFalsy = vm.emptyFalsy
+	dup.nullProp = vm.nullProp
 	dup.instLimit = vm.instLimit
+	for op := range vm.disabled {
+		dup.SetBuiltinEnabled(builtinRegistry[op].name, false)
+	}
+
+	dup.globals = cloneGlobals(vm.globals)
+	return dup
+}
+
+// SnapshotGlobals returns a deep copy of the global table, preserving aliasing between values.
+func (vm *VM) SnapshotGlobals() map[string]Value {
+	return cloneGlobals(vm.globals)
+}
+
+// RestoreGlobals replaces the global table with a deep copy of snap, so the same snapshot can be restored repeatedly.
+func (vm *VM) RestoreGlobals(snap map[string]Value) {
+	vm.globals = cloneGlobals(snap)
+}
 
+func cloneGlobals(globals map[string]Value) map[string]Value {
 	clone := newCloneState()
-	dup.globals = make(map[string]Value, len(vm.globals))
-	for name, val := range vm.globals {
-		dup.globals[name] = clone.cloneValue(val)
+	out := make(map[string]Value, len(globals))
+	for name, val := range globals {
+		out[name] = clone.cloneValue(val)
 	}
-	return dup
+	return out
 }
 
+// cloneState deep-copies values without recursing: containers are allocated (and registered in the
+// dedup maps) immediately, while filling their children is deferred to an explicit work stack.
+// This keeps shared references shared and lets arbitrarily deep graphs clone without exhausting the Go stack.
 type cloneState struct {
 	arrays    map[uintptr][]Value
-	objects   map[uintptr]map[string]Value
+	objects   map[uintptr]Value // cloned object shells, carrying the copied key order
 	functions map[*Function]*Function
 	upvalues  map[*upvalue]*upvalue
 	iterators map[*Iterator]*Iterator
+	pending   []func()
 }
 
 func newCloneState() *cloneState {
 	return &cloneState{
 		arrays:    make(map[uintptr][]Value),
-		objects:   make(map[uintptr]map[string]Value),
+		objects:   make(map[uintptr]Value),
 		functions: make(map[*Function]*Function),
 		upvalues:  make(map[*upvalue]*upvalue),
 		iterators: make(map[*Iterator]*Iterator),
 	}
 }
 
+// cloneValue returns a fully populated deep copy of v.
 func (cs *cloneState) cloneValue(v Value) Value {
+	out := cs.shell(v)
+	for len(cs.pending) > 0 {
+		job := cs.pending[len(cs.pending)-1]
+		cs.pending = cs.pending[:len(cs.pending)-1]
+		job()
+	}
+	return out
+}
+
+// shell returns the copy of v, queueing work to populate any newly allocated container.
+func (cs *cloneState) shell(v Value) Value {
 	switch v.Kind {
 	case KindArray:
 		if v.Arr == nil {
@@ -52,13 +90,16 @@ func (cs *cloneState) cloneValue(v Value) Value {
 				return Value{Kind: KindArray, Arr: arr, ReadOnly: v.ReadOnly}
 			}
 		}
-		out := make([]Value, len(v.Arr))
+		src := v.Arr
+		out := make([]Value, len(src))
 		if key != 0 {
 			cs.arrays[key] = out
 		}
-		for i := range v.Arr {
-			out[i] = cs.cloneValue(v.Arr[i])
-		}
+		cs.pending = append(cs.pending, func() {
+			for i := range src {
+				out[i] = cs.shell(src[i])
+			}
+		})
 		return Value{Kind: KindArray, Arr: out, ReadOnly: v.ReadOnly}
 	case KindObject:
 		if v.Obj == nil {
@@ -67,17 +108,26 @@ func (cs *cloneState) cloneValue(v Value) Value {
 		key := mapKey(v.Obj)
 		if key != 0 {
 			if obj, ok := cs.objects[key]; ok {
-				return Value{Kind: KindObject, Obj: obj, ReadOnly: v.ReadOnly}
+				obj.ReadOnly = v.ReadOnly
+				return obj
 			}
 		}
-		out := make(map[string]Value, len(v.Obj))
-		if key != 0 {
-			cs.objects[key] = out
+		src := v.Obj
+		out := make(map[string]Value, len(src))
+		shell := Value{Kind: KindObject, Obj: out}
+		if v.Order != nil {
+			shell.Order = &KeyOrder{Keys: append([]string(nil), v.Order.Keys...)}
 		}
-		for k, val := range v.Obj {
-			out[k] = cs.cloneValue(val)
+		if key != 0 {
+			cs.objects[key] = shell
 		}
-		return Value{Kind: KindObject, Obj: out, ReadOnly: v.ReadOnly}
+		cs.pending = append(cs.pending, func() {
+			for k, val := range src {
+				out[k] = cs.shell(val)
+			}
+		})
+		shell.ReadOnly = v.ReadOnly
+		return shell
 	case KindFunction:
 		if v.Func == nil {
 			return v
@@ -107,6 +157,14 @@ func (cs *cloneState) cloneFunction(fn *Function) *Function {
 		Source: fn.Source,
 	}
 	cs.functions[fn] = out
+	if fn.build != nil {
+		out.captured = make([]Value, len(fn.captured))
+		for i, v := range fn.captured {
+			out.captured[i] = cs.cloneValue(v)
+		}
+		out.build = fn.build
+		out.Native = fn.build(out.captured)
+	}
 	if fn.Upvalues != nil {
 		out.Upvalues = make([]*upvalue, len(fn.Upvalues))
 		for i, uv := range fn.Upvalues {
@@ -125,11 +183,13 @@ func (cs *cloneState) cloneUpvalue(uv *upvalue) *upvalue {
 	}
 	out := &upvalue{}
 	cs.upvalues[uv] = out
+	src := uv.closed
 	if uv.location != nil {
-		out.closed = cs.cloneValue(*uv.location)
-	} else {
-		out.closed = cs.cloneValue(uv.closed)
+		src = *uv.location
 	}
+	cs.pending = append(cs.pending, func() {
+		out.closed = cs.shell(src)
+	})
 	return out
 }
 
@@ -140,15 +200,17 @@ func (cs *cloneState) cloneIterator(it *Iterator) *Iterator {
 	if cloned, ok := cs.iterators[it]; ok {
 		return cloned
 	}
-	out := &Iterator{index: it.index}
+	// Function-backed iterators hold host state that cannot be copied; the clone shares it.
+	out := &Iterator{index: it.index, next: it.next, err: it.err}
 	cs.iterators[it] = out
+	if it.gen != nil {
+		out.gen = cs.cloneGenerator(it.gen)
+	}
 	if it.arr != nil {
-		arr := cs.cloneValue(Value{Kind: KindArray, Arr: it.arr})
-		out.arr = arr.Arr
+		out.arr = cs.shell(Value{Kind: KindArray, Arr: it.arr}).Arr
 	}
 	if it.obj != nil {
-		obj := cs.cloneValue(Value{Kind: KindObject, Obj: it.obj})
-		out.obj = obj.Obj
+		out.obj = cs.shell(Value{Kind: KindObject, Obj: it.obj}).Obj
 		if it.keys != nil {
 			out.keys = make([]string, len(it.keys))
 			copy(out.keys, it.keys)
@@ -157,6 +219,42 @@ func (cs *cloneState) cloneIterator(it *Iterator) *Iterator {
 	return out
 }
 
+// cloneGenerator copies a suspended generator. Its open upvalues are re-pointed at the copied
+// locals, unless a closure sharing them was cloned first and already holds a detached copy.
+func (cs *cloneState) cloneGenerator(g *generator) *generator {
+	out := &generator{frame: g.frame, index: g.index, running: g.running, yielded: g.yielded, done: g.done}
+	out.frame.fn = cs.cloneFunction(g.frame.fn)
+	out.frame.locals = make([]Value, len(g.frame.locals))
+	for _, uv := range g.upvalues {
+		for i := range g.frame.locals {
+			if uv.location != &g.frame.locals[i] {
+				continue
+			}
+			if _, cloned := cs.upvalues[uv]; !cloned {
+				copied := newUpvalue(&out.frame.locals[i])
+				cs.upvalues[uv] = copied
+				out.upvalues = append(out.upvalues, copied)
+			}
+			break
+		}
+	}
+	out.stack = make([]Value, len(g.stack))
+	out.frame.deferred = make([]Value, len(g.frame.deferred))
+	src := g
+	cs.pending = append(cs.pending, func() {
+		for i, v := range src.frame.locals {
+			out.frame.locals[i] = cs.shell(v)
+		}
+		for i, v := range src.stack {
+			out.stack[i] = cs.shell(v)
+		}
+		for i, v := range src.frame.deferred {
+			out.frame.deferred[i] = cs.shell(v)
+		}
+	})
+	return out
+}
+
 func sliceKey(arr []Value) uintptr {
 	if arr == nil {
 		return 0
@@ -45,7 +45,21 @@ func IndexGet(target Value, index Value) (Value, error) {
 	return indexGet(target, index)
 }
 
+// Compare orders two numbers or two strings, returning -1, 0, or 1.
+func Compare(a, b Value) (int, error) {
+	return compareValues(a, b)
+}
+
 // ValueExists checks whether the array contains the given value.
 func ValueExists(arr Value, val Value) bool {
 	return valueExists(arr, val)
 }
+
+// NativeClosure returns a native function value closing over script values. build receives
+// captured and returns the implementation; keeping the values separate lets Duplicate give the
+// copy its own clones instead of sharing the originals.
+func NativeClosure(name string, captured []Value, build func(captured []Value) NativeFunc) Value {
+	fn := &Function{Name: name, Source: "builtin", captured: captured, build: build}
+	fn.Native = build(captured)
+	return Value{Kind: KindFunction, Func: fn}
+}
@@ -0,0 +1,10 @@
+package vm
+
+// UnregisterBuiltin removes the builtin at opcode, so a test can register a temporary one
+// without leaking it into later tests or clashing when run again.
+func UnregisterBuiltin(opcode byte) {
+	if entry, ok := builtinRegistry[opcode]; ok {
+		delete(builtinValues, entry.name)
+		delete(builtinRegistry, opcode)
+	}
+}
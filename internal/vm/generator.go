@@ -0,0 +1,117 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errGeneratorNeedsVM is recorded by Iterator.Next on a generator, which can only run inside a VM.
+var errGeneratorNeedsVM = errors.New("generator iterators must be advanced with VM.IterNext")
+
+// generator is the suspended call of a generator function. Between resumptions it owns the
+// frame, the operands the frame had pushed, and the open upvalues pointing into its locals.
+type generator struct {
+	frame    frame
+	stack    []Value
+	upvalues []*upvalue
+	index    int // values yielded so far; the next one is reported under this key
+	running  bool
+	yielded  bool
+	done     bool
+}
+
+// newGenerator binds args for a call to a generator function without running its body.
+func (vm *VM) newGenerator(fn *Function, receiver Value, args []Value) Value {
+	locals := make([]Value, fn.maxLocals())
+	bindArgs(fn, locals, receiver, args)
+	gen := &generator{frame: frame{fn: fn, locals: locals, lastOp: -1}}
+	return IteratorVal(&Iterator{gen: gen})
+}
+
+// isGenerator reports whether calling fn produces a generator rather than running its body.
+func isGenerator(fn *Function) bool {
+	return fn.Native == nil && fn.Proto != nil && fn.Proto.Generator
+}
+
+// IterNext advances it by one entry. Generator iterators resume on this VM, running until their
+// next yield (ok) or return (!ok); a runtime error inside the generator ends it and is returned.
+// Other iterators behave as Iterator.Next. Called while the VM is idle, it starts a fresh
+// execution like Run: counters reset and the instruction limit applies to this step alone.
+func (vm *VM) IterNext(it *Iterator) (string, Value, bool, error) {
+	if it == nil {
+		return "", Value{}, false, fmt.Errorf("iterator is nil")
+	}
+	if it.gen == nil {
+		key, val, ok := it.Next()
+		return key, val, ok, nil
+	}
+	if len(vm.frames) == 0 {
+		vm.ResetState()
+		vm.interrupted.Store(false)
+	}
+	return vm.resume(it.gen)
+}
+
+// resume re-enters g's frame on top of the current stack and runs it until it yields or returns.
+func (vm *VM) resume(g *generator) (string, Value, bool, error) {
+	if g.done {
+		return "", Value{}, false, nil
+	}
+	if g.running {
+		return "", Value{}, false, fmt.Errorf("generator is already running")
+	}
+	depth := len(vm.frames)
+	fr, err := vm.enterFrame(g.frame)
+	if err != nil {
+		return "", Value{}, false, err
+	}
+	fr.gen = g
+	base := fr.base
+	vm.stack = append(vm.stack, g.stack...)
+	vm.openUpvalues = append(vm.openUpvalues, g.upvalues...)
+	g.stack, g.upvalues = nil, nil
+	g.running, g.yielded = true, false
+	val, err := vm.execute(depth)
+	g.running = false
+	if err != nil {
+		vm.unwind(depth, base)
+		g.done = true
+		return "", Value{}, false, err
+	}
+	if !g.yielded {
+		g.done = true
+		return "", Value{}, false, nil
+	}
+	key := stringIndex(g.index)
+	g.index++
+	return key, val, true, nil
+}
+
+// suspend saves the generator frame on top of the VM and pops it, leaving val as the yielded value.
+func (vm *VM) suspend(fr *frame) {
+	g := fr.gen
+	g.frame = *fr
+	g.frame.gen, g.frame.stat = nil, nil
+	g.stack = append([]Value(nil), vm.stack[fr.base:]...)
+	g.upvalues = vm.detachUpvalues(fr.locals)
+	g.yielded = true
+	profileExit(fr)
+	vm.frames = vm.frames[:len(vm.frames)-1]
+	vm.stack = vm.stack[:fr.base]
+}
+
+// detachUpvalues removes and returns the open upvalues pointing into locals, which stay open
+// while a suspended generator keeps the locals alive.
+func (vm *VM) detachUpvalues(locals []Value) []*upvalue {
+	var detached []*upvalue
+	filtered := vm.openUpvalues[:0]
+	for _, uv := range vm.openUpvalues {
+		if containsSlot(locals, uv.location) {
+			detached = append(detached, uv)
+			continue
+		}
+		filtered = append(filtered, uv)
+	}
+	vm.openUpvalues = filtered
+	return detached
+}
@@ -0,0 +1,52 @@
+package vm
+
+import "time"
+
+// ProfileStat accumulates execution cost for one function name.
+// Time is inclusive of callees, so recursive functions count nested time more than once.
+type ProfileStat struct {
+	Calls        int
+	Instructions int
+	Time         time.Duration
+}
+
+// EnableProfiling starts accumulating per-function statistics; previously collected data is discarded.
+func (vm *VM) EnableProfiling() {
+	vm.profile = make(map[string]*ProfileStat)
+}
+
+// Profile returns a copy of the statistics collected so far, keyed by function name.
+// Returns nil when profiling is disabled.
+func (vm *VM) Profile() map[string]ProfileStat {
+	if vm.profile == nil {
+		return nil
+	}
+	out := make(map[string]ProfileStat, len(vm.profile))
+	for name, stat := range vm.profile {
+		out[name] = *stat
+	}
+	return out
+}
+
+// profileEnter attaches a stat record to a freshly pushed frame and counts the call.
+func (vm *VM) profileEnter(fr *frame) {
+	name := fr.fn.Name
+	if name == "" {
+		name = "<anon>"
+	}
+	stat := vm.profile[name]
+	if stat == nil {
+		stat = &ProfileStat{}
+		vm.profile[name] = stat
+	}
+	stat.Calls++
+	fr.stat = stat
+	fr.start = time.Now()
+}
+
+// profileExit charges elapsed wall time to a frame being popped.
+func profileExit(fr *frame) {
+	if fr.stat != nil {
+		fr.stat.Time += time.Since(fr.start)
+	}
+}
@@ -1,6 +1,9 @@
 package vm
 
-import "fmt"
+import (
+	"sort"
+	"strconv"
+)
 
 type Kind int
 
@@ -28,6 +31,13 @@ type Value struct {
 	B    bool
 	// ReadOnly marks array/object containers as immutable from script code.
 	ReadOnly bool
+	// Order records object key insertion order; nil when the object was built without it (e.g. from a Go map).
+	Order *KeyOrder
+}
+
+// KeyOrder lists object keys in the order they were first stored. It is shared by every copy of the value.
+type KeyOrder struct {
+	Keys []string
 }
 
 func Null() Value { return Value{Kind: KindNull} }
@@ -46,6 +56,43 @@ func Array(v []Value) Value {
 func Object(m map[string]Value) Value {
 	return Value{Kind: KindObject, Obj: m}
 }
+
+// OrderedObject is Object with keys recording their insertion order. Repeated keys keep their first position.
+func OrderedObject(m map[string]Value, keys []string) Value {
+	return Value{Kind: KindObject, Obj: m, Order: &KeyOrder{Keys: keys}}
+}
+
+// SetKey stores val under k on an object, appending k to the insertion order when it is new.
+func (v Value) SetKey(k string, val Value) {
+	if _, exists := v.Obj[k]; !exists && v.Order != nil {
+		v.Order.Keys = append(v.Order.Keys, k)
+	}
+	v.Obj[k] = val
+}
+
+// OrderedKeys returns the object's keys in insertion order. Keys stored without order tracking
+// (by the host writing to the map directly) follow in sorted order.
+func (v Value) OrderedKeys() []string {
+	keys := make([]string, 0, len(v.Obj))
+	seen := make(map[string]bool, len(v.Obj))
+	if v.Order != nil {
+		for _, k := range v.Order.Keys {
+			if _, ok := v.Obj[k]; ok && !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	rest := len(keys)
+	for k := range v.Obj {
+		if !seen[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys[rest:])
+	return keys
+}
+
 func ErrorVal(s string) Value {
 	return Value{Kind: KindError, Err: s}
 }
@@ -53,6 +100,7 @@ func IteratorVal(it *Iterator) Value {
 	return Value{Kind: KindIterator, It: it}
 }
 
+// Truthy reports the default truthiness of v: only null and false are falsy.
 func Truthy(v Value) bool {
 	switch v.Kind {
 	case KindNull:
@@ -90,22 +138,35 @@ type Iterator struct {
 	obj   map[string]Value
 	keys  []string
 	index int
+	next  func() (string, Value, bool)
+	gen   *generator
+	err   error
 }
 
 func NewArrayIterator(arr []Value) *Iterator {
 	return &Iterator{arr: arr, index: 0}
 }
 
-func NewObjectIterator(obj map[string]Value) *Iterator {
-	keys := make([]string, 0, len(obj))
-	for k := range obj {
-		keys = append(keys, k)
-	}
-	return &Iterator{obj: obj, keys: keys, index: 0}
+// NewObjectIterator iterates the entries of object v in insertion order (see OrderedKeys).
+func NewObjectIterator(v Value) *Iterator {
+	return &Iterator{obj: v.Obj, keys: v.OrderedKeys(), index: 0}
 }
 
-// Next returns key,value and ok.
+// NewFuncIterator creates an iterator that pulls each entry from next until it reports !ok.
+func NewFuncIterator(next func() (string, Value, bool)) *Iterator {
+	return &Iterator{next: next}
+}
+
+// Next returns key,value and ok. A generator iterator needs a VM to run, so Next stops it with an
+// error (see Err); advance it with VM.IterNext instead.
 func (it *Iterator) Next() (string, Value, bool) {
+	if it.gen != nil {
+		it.err = errGeneratorNeedsVM
+		return "", Value{}, false
+	}
+	if it.next != nil {
+		return it.next()
+	}
 	if it.arr != nil {
 		if it.index >= len(it.arr) {
 			return "", Value{}, false
@@ -127,6 +188,25 @@ func (it *Iterator) Next() (string, Value, bool) {
 	return "", Value{}, false
 }
 
+// Err returns the error that stopped Next early, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
 func stringIndex(i int) string {
-	return fmt.Sprintf("%d", i)
+	if i >= 0 && i < len(indexKeys) {
+		return indexKeys[i]
+	}
+	return strconv.Itoa(i)
 }
+
+// indexKeys holds the decimal key strings for small array indexes, which array iteration would
+// otherwise format on every step. Value is a plain struct, so numbers need no such cache, and the
+// empty string never allocates.
+var indexKeys = func() []string {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	return keys
+}()
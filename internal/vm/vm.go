@@ -1,8 +1,13 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"strconv"
+	"sync/atomic"
+	"time"
 
 	"github.com/xirelogy/go-flux/internal/bytecode"
 )
@@ -17,6 +22,10 @@ type Function struct {
 	Native   NativeFunc
 	Name     string
 	Source   string
+	// captured and build describe natives made by NativeClosure, so Duplicate can clone the
+	// captured values and rebuild Native around the copies.
+	captured []Value
+	build    func(captured []Value) NativeFunc
 }
 
 type frame struct {
@@ -25,24 +34,50 @@ type frame struct {
 	locals []Value
 	base   int
 	lastOp int
+	stat   *ProfileStat // non-nil while profiling
+	start  time.Time
+	// deferred holds functions registered by OP_DEFER, called in reverse order when the frame exits.
+	deferred []Value
+	// gen is the generator that owns this frame while it is resumed; OP_YIELD suspends into it.
+	gen *generator
 }
 
 // VM is a simple stack-based bytecode interpreter.
 type VM struct {
-	stack        []Value
-	frames       []frame
-	globals      map[string]Value
-	openUpvalues []*upvalue
-	maxStack     int
-	maxFrames    int
-	traceHook    TraceHook
-	instLimit    int
-	instCount    int
+	stack          []Value
+	frames         []frame
+	globals        map[string]Value
+	openUpvalues   []*upvalue
+	maxStack       int
+	maxFrames      int
+	traceHook      TraceHook
+	valueTraceHook ValueTraceHook
+	logSink        func(Value)
+	emptyFalsy     bool
+	nullProp       bool
+	instLimit      int
+	instCount      int
+	peakStack      int
+	peakFrames     int
+	interrupted    atomic.Bool
+	disabled       map[byte]bool
+	coverage       map[string]map[int]bool
+	profile        map[string]*ProfileStat
+	ctx            context.Context
+	host           any
 }
 
+// ErrInterrupted is the cause of the runtime error that ends a call stopped by Interrupt.
+var ErrInterrupted = errors.New("interrupted")
+
 const (
 	defaultMaxStack  = 1024
 	defaultMaxFrames = 256
+	// cancelCheckInterval is how many instructions run between context cancellation checks.
+	cancelCheckInterval = 1024
+	// unwindBudget is how many instructions deferred calls may use while unwinding a failure, on
+	// top of the instruction limit, so cleanup still runs after the limit is hit.
+	unwindBudget = 10000
 )
 
 // New constructs an empty VM instance.
@@ -62,6 +97,63 @@ func (vm *VM) SetTraceHook(h TraceHook) {
 	vm.traceHook = h
 }
 
+// SetValueTraceHook registers a callback that also receives the top-of-stack value before each instruction.
+// It is slower than a plain trace hook and intended for debuggers.
+func (vm *VM) SetValueTraceHook(h ValueTraceHook) {
+	vm.valueTraceHook = h
+}
+
+// SetLogSink registers the receiver of values passed to the log builtin (nil discards them).
+func (vm *VM) SetLogSink(sink func(Value)) {
+	vm.logSink = sink
+}
+
+// SetHost records the embedding wrapper that owns this VM, so handles holding only the VM can
+// reach it again. Duplicate does not copy it.
+func (vm *VM) SetHost(h any) {
+	vm.host = h
+}
+
+// Host returns the value recorded with SetHost, or nil.
+func (vm *VM) Host() any {
+	return vm.host
+}
+
+// LogSink returns the sink registered with SetLogSink, or nil.
+func (vm *VM) LogSink() func(Value) {
+	return vm.logSink
+}
+
+// SetEmptyCollectionsFalsy makes conditions, !, && and || treat empty strings, arrays, objects,
+// and the number 0 as false, in addition to null and false.
+func (vm *VM) SetEmptyCollectionsFalsy(enable bool) {
+	vm.emptyFalsy = enable
+}
+
+// SetNullPropagation makes property and index reads on null yield null instead of a runtime error.
+func (vm *VM) SetNullPropagation(enable bool) {
+	vm.nullProp = enable
+}
+
+// Truthy reports v's truthiness under this VM's configuration (see SetEmptyCollectionsFalsy).
+func (vm *VM) Truthy(v Value) bool {
+	if !vm.emptyFalsy {
+		return Truthy(v)
+	}
+	switch v.Kind {
+	case KindNumber:
+		return v.Num != 0
+	case KindString:
+		return v.Str != ""
+	case KindArray:
+		return len(v.Arr) != 0
+	case KindObject:
+		return len(v.Obj) != 0
+	default:
+		return Truthy(v)
+	}
+}
+
 // SetInstructionLimit caps the number of instructions executed per Run/Call (0 for unlimited).
 func (vm *VM) SetInstructionLimit(limit int) {
 	if limit < 0 {
@@ -70,12 +162,39 @@ func (vm *VM) SetInstructionLimit(limit int) {
 	vm.instLimit = limit
 }
 
+// Interrupt asks the running Run/Call to stop before its next instruction with an ErrInterrupted
+// runtime error. It is safe to call from any goroutine; a request made while idle is discarded
+// when the next Run starts.
+func (vm *VM) Interrupt() {
+	vm.interrupted.Store(true)
+}
+
+// SetContext attaches a context that the dispatch loop polls; once it is done, execution
+// stops with an error wrapping ctx.Err(). Pass nil to detach.
+func (vm *VM) SetContext(ctx context.Context) {
+	vm.ctx = ctx
+}
+
 // ResetState clears transient execution state (stack, frames, open upvalues).
 func (vm *VM) ResetState() {
 	vm.stack = vm.stack[:0]
 	vm.frames = vm.frames[:0]
 	vm.openUpvalues = vm.openUpvalues[:0]
 	vm.instCount = 0
+	vm.peakStack = 0
+	vm.peakFrames = 0
+}
+
+// Stats holds execution counters for the most recent Run/Call.
+type Stats struct {
+	Instructions int // instructions dispatched, including those of nested calls
+	PeakStack    int // deepest value stack observed, sampled before each instruction
+	PeakFrames   int // deepest call frame nesting
+}
+
+// Stats returns the counters gathered since the last Run/Call started.
+func (vm *VM) Stats() Stats {
+	return Stats{Instructions: vm.instCount, PeakStack: vm.peakStack, PeakFrames: vm.peakFrames}
 }
 
 // LoadModule registers compiled functions as globals for invocation.
@@ -84,15 +203,17 @@ func (vm *VM) LoadModule(mod *bytecode.Module) {
 		return
 	}
 	for name, proto := range mod.Functions {
-		vm.globals[name] = Value{
-			Kind: KindFunction,
-			Func: &Function{
-				Proto:    proto,
-				Name:     name,
-				Source:   proto.Source,
-				Upvalues: make([]*upvalue, len(proto.Upvalues)),
-			},
-		}
+		vm.globals[name] = Value{Kind: KindFunction, Func: NewScriptFunction(name, proto)}
+	}
+}
+
+// NewScriptFunction wraps a compiled top-level prototype as a callable function.
+func NewScriptFunction(name string, proto *bytecode.Prototype) *Function {
+	return &Function{
+		Proto:    proto,
+		Name:     name,
+		Source:   proto.Source,
+		Upvalues: make([]*upvalue, len(proto.Upvalues)),
 	}
 }
 
@@ -113,6 +234,17 @@ func (vm *VM) HasFunction(name string) bool {
 	return val.Kind == KindFunction && val.Func != nil
 }
 
+// Functions returns the globals bound to functions, by name.
+func (vm *VM) Functions() map[string]*Function {
+	out := make(map[string]*Function)
+	for name, val := range vm.globals {
+		if val.Kind == KindFunction && val.Func != nil {
+			out[name] = val.Func
+		}
+	}
+	return out
+}
+
 // Call invokes a global function by name.
 func (vm *VM) Call(name string, args []Value) (Value, error) {
 	val, ok := vm.globals[name]
@@ -121,7 +253,7 @@ func (vm *VM) Call(name string, args []Value) (Value, error) {
 	}
 	fn, err := toFunction(val)
 	if err != nil {
-		return vm.wrapError(nil, ErrorVal(err.Error()), err)
+		return vm.errorf(nil, "global %q is not callable (it is a %s)", name, typeName(val))
 	}
 	return vm.Run(fn, args)
 }
@@ -129,34 +261,77 @@ func (vm *VM) Call(name string, args []Value) (Value, error) {
 // Run executes the given function with arguments on a fresh stack.
 func (vm *VM) Run(fn *Function, args []Value) (Value, error) {
 	vm.ResetState()
+	vm.interrupted.Store(false)
 	vm.instCount = 0
 	if fn == nil {
 		return vm.errorf(nil, "invalid function")
 	}
 	if fn.Native != nil {
-		val, err := fn.Native(vm, args)
+		val, err := vm.callNative(fn, args)
 		if err != nil {
 			return vm.wrapError(nil, ErrorVal(err.Error()), err)
 		}
 		return val, nil
 	}
-	if _, err := vm.pushFrame(fn); err != nil {
+	if isGenerator(fn) {
+		return vm.newGenerator(fn, Null(), args), nil
+	}
+	if err := vm.enterFunction(fn, args); err != nil {
 		return vm.errorf(nil, "%s", err.Error())
 	}
-	fr := vm.currentFrame()
-	for i := 0; i < len(args) && i < len(fr.locals); i++ {
-		fr.locals[i] = args[i]
+	return vm.execute(0)
+}
+
+// CallFunction invokes a function value from within a running builtin or native handler.
+// It reuses the current stack and returns once the callee returns; on error, any frames
+// pushed by the callee are discarded so the caller can continue or propagate the error.
+func (vm *VM) CallFunction(callee Value, args []Value) (Value, error) {
+	fn, err := toFunction(callee)
+	if err != nil {
+		return Null(), err
+	}
+	if fn.Native != nil {
+		return vm.callNative(fn, args)
+	}
+	if isGenerator(fn) {
+		return vm.newGenerator(fn, Null(), args), nil
+	}
+	depth := len(vm.frames)
+	base := len(vm.stack)
+	if err := vm.enterFunction(fn, args); err != nil {
+		return Null(), err
+	}
+	val, err := vm.execute(depth)
+	if err != nil {
+		vm.unwind(depth, base)
+		return val, err
+	}
+	return val, nil
+}
+
+// execute runs the dispatch loop until the frame count drops back to depth. When it fails,
+// the deferred calls of the abandoned frames still run, innermost frame first.
+func (vm *VM) execute(depth int) (Value, error) {
+	val, err := vm.dispatch(depth)
+	if err != nil {
+		vm.unwindDeferred(depth)
 	}
+	return val, err
+}
 
-	for len(vm.frames) > 0 {
-		fr = vm.currentFrame()
+func (vm *VM) dispatch(depth int) (Value, error) {
+	for len(vm.frames) > depth {
+		fr := vm.currentFrame()
 		fr.lastOp = fr.ip
 		if fr.fn.Proto == nil || fr.fn.Proto.Chunk == nil {
 			return vm.errorf(fr, "function missing prototype")
 		}
 		code := fr.fn.Proto.Chunk.Code
 		if fr.ip >= len(code) {
-			ret, done := vm.finishFrame(Null())
+			if err := vm.runDeferred(fr); err != nil {
+				return Null(), err
+			}
+			ret, done := vm.finishFrame(Null(), depth)
 			if done {
 				return ret, nil
 			}
@@ -164,12 +339,36 @@ func (vm *VM) Run(fn *Function, args []Value) (Value, error) {
 		}
 		op := code[fr.ip]
 		fr.ip++
+		if bytecode.OperandWidth(op, code, fr.ip) < 0 {
+			return vm.errorf(fr, "unexpected end of bytecode")
+		}
 		vm.instCount++
+		if n := len(vm.stack); n > vm.peakStack {
+			vm.peakStack = n
+		}
+		if vm.interrupted.Load() {
+			vm.interrupted.Store(false)
+			return vm.wrapError(fr, ErrorVal(ErrInterrupted.Error()), ErrInterrupted)
+		}
 		if vm.instLimit > 0 && vm.instCount > vm.instLimit {
 			return vm.errorf(fr, "instruction limit exceeded")
 		}
+		if vm.ctx != nil && vm.instCount%cancelCheckInterval == 0 {
+			if err := vm.ctx.Err(); err != nil {
+				return vm.wrapError(fr, ErrorVal(err.Error()), err)
+			}
+		}
 		vm.trace(fr, op)
+		if fr.stat != nil {
+			fr.stat.Instructions++
+		}
+		if vm.coverage != nil {
+			vm.recordCoverage(fr)
+		}
 		if entry, ok := lookupBuiltin(op); ok {
+			if vm.disabled[op] {
+				return vm.errorf(fr, "builtin %s is disabled", entry.name)
+			}
 			if val, err := vm.runBuiltin(entry, fr); err != nil {
 				return val, err
 			}
@@ -181,6 +380,9 @@ func (vm *VM) Run(fn *Function, args []Value) (Value, error) {
 		case bytecode.OP_CONST:
 			idx := vm.readU16(fr)
 			vm.push(constToValue(fr.fn.Proto.Chunk.Consts[idx]))
+		case bytecode.OP_CONST_LONG:
+			idx := vm.readU24(fr)
+			vm.push(constToValue(fr.fn.Proto.Chunk.Consts[idx]))
 		case bytecode.OP_NULL:
 			vm.push(Null())
 		case bytecode.OP_TRUE:
@@ -189,7 +391,31 @@ func (vm *VM) Run(fn *Function, args []Value) (Value, error) {
 			vm.push(Bool(false))
 		case bytecode.OP_POP:
 			vm.pop()
-		case bytecode.OP_ADD, bytecode.OP_SUB, bytecode.OP_MUL, bytecode.OP_DIV,
+		case bytecode.OP_POP_N:
+			n := int(vm.readU8(fr))
+			if len(vm.stack)-fr.base < n {
+				return vm.errorf(fr, "stack underflow on pop")
+			}
+			vm.stack = vm.stack[:len(vm.stack)-n]
+		case bytecode.OP_DUP:
+			if len(vm.stack)-fr.base < 1 {
+				return vm.errorf(fr, "stack underflow on dup")
+			}
+			vm.push(vm.peek())
+		case bytecode.OP_DUP2:
+			n := len(vm.stack)
+			if n-fr.base < 2 {
+				return vm.errorf(fr, "stack underflow on dup2")
+			}
+			vm.push(vm.stack[n-2])
+			vm.push(vm.stack[n-1])
+		case bytecode.OP_SWAP:
+			n := len(vm.stack)
+			if n-fr.base < 2 {
+				return vm.errorf(fr, "stack underflow on swap")
+			}
+			vm.stack[n-2], vm.stack[n-1] = vm.stack[n-1], vm.stack[n-2]
+		case bytecode.OP_ADD, bytecode.OP_SUB, bytecode.OP_MUL, bytecode.OP_DIV, bytecode.OP_MOD,
 			bytecode.OP_EQ, bytecode.OP_NEQ, bytecode.OP_LT, bytecode.OP_LTE, bytecode.OP_GT, bytecode.OP_GTE:
 			b := vm.pop()
 			a := vm.pop()
@@ -204,17 +430,21 @@ func (vm *VM) Run(fn *Function, args []Value) (Value, error) {
 				return vm.errorf(fr, "operand must be number")
 			}
 			vm.push(Number(-v.Num))
+		case bytecode.OP_POS:
+			if vm.peek().Kind != KindNumber {
+				return vm.errorf(fr, "operand must be number")
+			}
 		case bytecode.OP_NOT:
 			v := vm.pop()
-			vm.push(Bool(!Truthy(v)))
+			vm.push(Bool(!vm.Truthy(v)))
 		case bytecode.OP_AND:
 			b := vm.pop()
 			a := vm.pop()
-			vm.push(Bool(Truthy(a) && Truthy(b)))
+			vm.push(Bool(vm.Truthy(a) && vm.Truthy(b)))
 		case bytecode.OP_OR:
 			b := vm.pop()
 			a := vm.pop()
-			vm.push(Bool(Truthy(a) || Truthy(b)))
+			vm.push(Bool(vm.Truthy(a) || vm.Truthy(b)))
 		case bytecode.OP_GET_LOCAL:
 			slot := vm.readU8(fr)
 			if int(slot) >= len(fr.locals) {
@@ -241,27 +471,30 @@ func (vm *VM) Run(fn *Function, args []Value) (Value, error) {
 			}
 			val := vm.pop()
 			fr.fn.Upvalues[int(slot)].set(val)
-		case bytecode.OP_GET_GLOBAL:
-			idx := vm.readU16(fr)
+		case bytecode.OP_GET_GLOBAL, bytecode.OP_GET_GLOBAL_LONG:
+			idx := vm.readConstIndex(fr, op == bytecode.OP_GET_GLOBAL_LONG)
 			name, ok := fr.fn.Proto.Chunk.Consts[idx].(string)
 			if !ok {
 				return vm.errorf(fr, "global name constant is not string")
 			}
 			v, exists := vm.globals[name]
 			if !exists {
-				return vm.errorf(fr, "global %s not found", name)
+				// A builtin named without being called is a function value.
+				if v, exists = builtinValues[name]; !exists {
+					return vm.errorf(fr, "global %s not found", name)
+				}
 			}
 			vm.push(v)
-		case bytecode.OP_SET_GLOBAL:
-			idx := vm.readU16(fr)
+		case bytecode.OP_SET_GLOBAL, bytecode.OP_SET_GLOBAL_LONG:
+			idx := vm.readConstIndex(fr, op == bytecode.OP_SET_GLOBAL_LONG)
 			name, ok := fr.fn.Proto.Chunk.Consts[idx].(string)
 			if !ok {
 				return vm.errorf(fr, "global name constant is not string")
 			}
 			val := vm.pop()
 			vm.globals[name] = val
-		case bytecode.OP_DEFINE_GLOBAL:
-			idx := vm.readU16(fr)
+		case bytecode.OP_DEFINE_GLOBAL, bytecode.OP_DEFINE_GLOBAL_LONG:
+			idx := vm.readConstIndex(fr, op == bytecode.OP_DEFINE_GLOBAL_LONG)
 			name, ok := fr.fn.Proto.Chunk.Consts[idx].(string)
 			if !ok {
 				return vm.errorf(fr, "global name constant is not string")
@@ -278,6 +511,7 @@ func (vm *VM) Run(fn *Function, args []Value) (Value, error) {
 		case bytecode.OP_OBJECT:
 			count := vm.readU16(fr)
 			obj := make(map[string]Value, count)
+			keys := make([]string, count)
 			for i := count - 1; i >= 0; i-- {
 				val := vm.pop()
 				key := vm.pop()
@@ -285,9 +519,10 @@ func (vm *VM) Run(fn *Function, args []Value) (Value, error) {
 				if err != nil {
 					return vm.wrapError(fr, ErrorVal(err.Error()), err)
 				}
+				keys[i] = keyStr
 				obj[keyStr] = val
 			}
-			vm.push(Object(obj))
+			vm.push(OrderedObject(obj, keys))
 		case bytecode.OP_RANGE:
 			end := vm.pop()
 			start := vm.pop()
@@ -304,6 +539,10 @@ func (vm *VM) Run(fn *Function, args []Value) (Value, error) {
 		case bytecode.OP_INDEX_GET:
 			index := vm.pop()
 			target := vm.pop()
+			if target.Kind == KindNull && vm.nullProp {
+				vm.push(Null())
+				continue
+			}
 			val, err := indexGet(target, index)
 			if err != nil {
 				return vm.wrapError(fr, ErrorVal(err.Error()), err)
@@ -316,13 +555,39 @@ func (vm *VM) Run(fn *Function, args []Value) (Value, error) {
 			if err := indexSet(target, index, val); err != nil {
 				return vm.wrapError(fr, ErrorVal(err.Error()), err)
 			}
-		case bytecode.OP_GET_PROP:
-			idx := vm.readU16(fr)
+		case bytecode.OP_SLICE:
+			high := vm.pop()
+			low := vm.pop()
+			target := vm.pop()
+			if target.Kind == KindNull && vm.nullProp {
+				vm.push(Null())
+				continue
+			}
+			val, err := sliceValue(target, low, high)
+			if err != nil {
+				return vm.wrapError(fr, ErrorVal(err.Error()), err)
+			}
+			vm.push(val)
+		case bytecode.OP_GET_PROP, bytecode.OP_GET_PROP_LONG:
+			idx := vm.readConstIndex(fr, op == bytecode.OP_GET_PROP_LONG)
 			prop, ok := fr.fn.Proto.Chunk.Consts[idx].(string)
 			if !ok {
 				return vm.errorf(fr, "property name constant is not string")
 			}
 			obj := vm.pop()
+			if prop == "length" && (obj.Kind == KindArray || obj.Kind == KindString) {
+				// Arrays and strings expose a read-only length pseudo-property (strings count bytes).
+				if obj.Kind == KindArray {
+					vm.push(Number(float64(len(obj.Arr))))
+				} else {
+					vm.push(Number(float64(len(obj.Str))))
+				}
+				continue
+			}
+			if obj.Kind == KindNull && vm.nullProp {
+				vm.push(Null())
+				continue
+			}
 			if obj.Kind != KindObject || obj.Obj == nil {
 				return vm.errorf(fr, "property access on non-object")
 			}
@@ -331,8 +596,8 @@ func (vm *VM) Run(fn *Function, args []Value) (Value, error) {
 				return vm.errorf(fr, "missing property %s", prop)
 			}
 			vm.push(val)
-		case bytecode.OP_SET_PROP:
-			idx := vm.readU16(fr)
+		case bytecode.OP_SET_PROP, bytecode.OP_SET_PROP_LONG:
+			idx := vm.readConstIndex(fr, op == bytecode.OP_SET_PROP_LONG)
 			prop, ok := fr.fn.Proto.Chunk.Consts[idx].(string)
 			if !ok {
 				return vm.errorf(fr, "property name constant is not string")
@@ -345,65 +610,88 @@ func (vm *VM) Run(fn *Function, args []Value) (Value, error) {
 			if obj.ReadOnly {
 				return vm.errorf(fr, "cannot modify read-only value")
 			}
-			obj.Obj[prop] = val
+			obj.SetKey(prop, val)
 		case bytecode.OP_JUMP:
 			off := vm.readU16(fr)
 			fr.ip = off
 		case bytecode.OP_JUMP_IF_FALSE:
 			off := vm.readU16(fr)
 			cond := vm.peek()
-			if !Truthy(cond) {
+			if !vm.Truthy(cond) {
 				fr.ip = off
 			}
 		case bytecode.OP_JUMP_IF_TRUE:
 			off := vm.readU16(fr)
 			cond := vm.peek()
-			if Truthy(cond) {
+			if vm.Truthy(cond) {
 				fr.ip = off
 			}
-		case bytecode.OP_CALL:
+		case bytecode.OP_CALL, bytecode.OP_CALL_METHOD:
 			argc := int(vm.readU8(fr))
-			if len(vm.stack) < argc+1 {
-				return vm.errorf(fr, "stack underflow on call: argc=%d stack=%d", argc, len(vm.stack))
+			needed := argc + 1
+			if op == bytecode.OP_CALL_METHOD {
+				needed++
+			}
+			if len(vm.stack)-fr.base < needed {
+				return vm.errorf(fr, "stack underflow on call: argc=%d stack=%d", argc, len(vm.stack)-fr.base)
 			}
 			args := make([]Value, argc)
 			for i := argc - 1; i >= 0; i-- {
-				if i >= len(args) {
-					return vm.errorf(fr, "call arg index overflow i=%d argc=%d len=%d", i, argc, len(args))
-				}
 				args[i] = vm.pop()
 			}
+			receiver := Null()
+			if op == bytecode.OP_CALL_METHOD {
+				receiver = vm.pop()
+			}
 			callee := vm.pop()
 			fn, err := toFunction(callee)
 			if err != nil {
 				return vm.wrapError(fr, ErrorVal(err.Error()), err)
 			}
 			if fn.Native != nil {
-				res, err := fn.Native(vm, args)
+				res, err := vm.callNative(fn, args)
 				if err != nil {
 					return vm.wrapError(fr, ErrorVal(err.Error()), err)
 				}
 				vm.push(res)
+			} else if isGenerator(fn) {
+				vm.push(vm.newGenerator(fn, receiver, args))
 			} else {
-				if _, err := vm.pushFrame(fn); err != nil {
+				if err := vm.enterMethod(fn, receiver, args); err != nil {
 					return vm.wrapError(fr, ErrorVal(err.Error()), err)
 				}
-				newFr := vm.currentFrame()
-				for i := 0; i < len(args) && i < len(newFr.locals); i++ {
-					newFr.locals[i] = args[i]
-				}
 			}
 		case bytecode.OP_RETURN:
 			ret := Null()
 			if len(vm.stack) > fr.base {
 				ret = vm.pop()
 			}
-			result, done := vm.finishFrame(ret)
+			if err := vm.runDeferred(fr); err != nil {
+				return Null(), err
+			}
+			result, done := vm.finishFrame(ret, depth)
 			if done {
 				return result, nil
 			}
-		case bytecode.OP_CLOSURE:
-			idx := vm.readU16(fr)
+		case bytecode.OP_DEFER:
+			if len(vm.stack)-fr.base < 1 {
+				return vm.errorf(fr, "stack underflow")
+			}
+			fn := vm.pop()
+			if fn.Kind != KindFunction {
+				return vm.errorf(fr, "defer expects function, got %s", typeName(fn))
+			}
+			fr.deferred = append(fr.deferred, fn)
+		case bytecode.OP_YIELD:
+			if fr.gen == nil {
+				return vm.errorf(fr, "yield outside generator")
+			}
+			val := vm.pop()
+			vm.suspend(fr)
+			// The generator frame is always the one resume pushed, so control returns to it.
+			return val, nil
+		case bytecode.OP_CLOSURE, bytecode.OP_CLOSURE_LONG:
+			idx := vm.readConstIndex(fr, op == bytecode.OP_CLOSURE_LONG)
 			upcount := int(vm.readU8(fr))
 			proto, ok := fr.fn.Proto.Chunk.Consts[idx].(*bytecode.Prototype)
 			if !ok {
@@ -444,7 +732,10 @@ func (vm *VM) Run(fn *Function, args []Value) (Value, error) {
 			if iter.Kind != KindIterator || iter.It == nil {
 				return vm.errorf(fr, "not an iterator")
 			}
-			key, val, ok := iter.It.Next()
+			key, val, ok, err := vm.IterNext(iter.It)
+			if err != nil {
+				return vm.wrapError(fr, ErrorVal(err.Error()), err)
+			}
 			if !ok {
 				fr.ip = jump
 				continue
@@ -463,32 +754,162 @@ func (vm *VM) pushFrame(fn *Function) (*frame, error) {
 	if fn == nil || fn.Proto == nil {
 		return nil, fmt.Errorf("invalid function")
 	}
-	if len(vm.frames) >= vm.maxFrames {
-		return nil, fmt.Errorf("call stack overflow")
-	}
-	locals := make([]Value, fn.maxLocals())
-	vm.frames = append(vm.frames, frame{
+	return vm.enterFrame(frame{
 		fn:     fn,
 		ip:     0,
-		locals: locals,
-		base:   len(vm.stack),
+		locals: make([]Value, fn.maxLocals()),
 		lastOp: -1,
 	})
-	return &vm.frames[len(vm.frames)-1], nil
 }
 
-func (vm *VM) finishFrame(ret Value) (Value, bool) {
+// enterFrame pushes fr with its base at the current stack top, enforcing the frame limit.
+func (vm *VM) enterFrame(fr frame) (*frame, error) {
+	if len(vm.frames) >= vm.maxFrames {
+		return nil, vm.overflowError(fr.fn)
+	}
+	fr.base = len(vm.stack)
+	vm.frames = append(vm.frames, fr)
+	if len(vm.frames) > vm.peakFrames {
+		vm.peakFrames = len(vm.frames)
+	}
+	top := &vm.frames[len(vm.frames)-1]
+	if vm.profile != nil {
+		vm.profileEnter(top)
+	}
+	return top, nil
+}
+
+// overflowError describes a frame-limit overflow, naming the callee and flagging direct self-recursion.
+func (vm *VM) overflowError(fn *Function) error {
+	name := fn.Name
+	if name == "" {
+		name = "<anon>"
+	}
+	run := 0
+	for i := len(vm.frames) - 1; i >= 0 && vm.frames[i].fn.Proto == fn.Proto; i-- {
+		run++
+	}
+	if run > 1 && run*2 >= len(vm.frames) {
+		return fmt.Errorf("call stack overflow calling %s at depth %d (%s recurses into itself)", name, len(vm.frames), name)
+	}
+	return fmt.Errorf("call stack overflow calling %s at depth %d", name, len(vm.frames))
+}
+
+// enterFunction pushes a frame for fn and binds args to its leading locals.
+// A function declared with a receiver sees null as its receiver.
+func (vm *VM) enterFunction(fn *Function, args []Value) error {
+	return vm.enterMethod(fn, Null(), args)
+}
+
+// enterMethod is enterFunction for a member call: when fn declares a receiver, receiver
+// fills local slot 0 and args follow; otherwise receiver is ignored.
+func (vm *VM) enterMethod(fn *Function, receiver Value, args []Value) error {
+	fr, err := vm.pushFrame(fn)
+	if err != nil {
+		return err
+	}
+	bindArgs(fn, fr.locals, receiver, args)
+	return nil
+}
+
+// bindArgs fills fn's leading locals with the receiver (when fn declares one) and then args.
+func bindArgs(fn *Function, locals []Value, receiver Value, args []Value) {
+	if fn.Proto.Receiver && len(locals) > 0 {
+		locals[0] = receiver
+		locals = locals[1:]
+	}
+	for i := 0; i < len(args) && i < len(locals); i++ {
+		locals[i] = args[i]
+	}
+}
+
+func (vm *VM) finishFrame(ret Value, depth int) (Value, bool) {
 	fr := vm.currentFrame()
 	vm.closeUpvalues(fr.locals)
+	profileExit(fr)
 	vm.frames = vm.frames[:len(vm.frames)-1]
 	vm.stack = vm.stack[:fr.base]
-	if len(vm.frames) == 0 {
+	if len(vm.frames) == depth {
 		return ret, true
 	}
 	vm.push(ret)
 	return ret, false
 }
 
+// runDeferred calls fr's deferred functions, last registered first. All of them run even if one
+// fails; the first failure is returned.
+func (vm *VM) runDeferred(fr *frame) error {
+	calls := fr.deferred
+	fr.deferred = nil
+	var first error
+	for i := len(calls) - 1; i >= 0; i-- {
+		if _, err := vm.CallFunction(calls[i], nil); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// unwindDeferred pops the frames above depth after a failure, running each frame's deferred calls
+// once it is gone. Errors from those calls are dropped in favor of the original failure. Without
+// pending deferred calls the frames are left for the caller to discard, as before. The calls run
+// detached from the context and with unwindBudget extra instructions, so cleanup still happens
+// after a cancellation or an exceeded instruction limit.
+func (vm *VM) unwindDeferred(depth int) {
+	pending := false
+	for i := depth; i < len(vm.frames); i++ {
+		pending = pending || len(vm.frames[i].deferred) > 0
+	}
+	if !pending {
+		return
+	}
+	ctx, limit := vm.ctx, vm.instLimit
+	vm.ctx = nil
+	if limit > 0 {
+		vm.instLimit = max(limit, vm.instCount) + unwindBudget
+	}
+	defer func() { vm.ctx, vm.instLimit = ctx, limit }()
+	for len(vm.frames) > depth {
+		fr := vm.currentFrame()
+		calls := fr.deferred
+		base := fr.base
+		vm.unwind(len(vm.frames)-1, base)
+		for i := len(calls) - 1; i >= 0; i-- {
+			vm.CallFunction(calls[i], nil)
+		}
+	}
+}
+
+// callNative invokes a host function, converting a panic into an error so a faulty handler
+// cannot crash the embedding program. Frames pushed by callbacks inside the handler are discarded.
+func (vm *VM) callNative(fn *Function, args []Value) (val Value, err error) {
+	depth, base := len(vm.frames), len(vm.stack)
+	defer func() {
+		if r := recover(); r != nil {
+			vm.unwind(depth, base)
+			name := fn.Name
+			if name == "" {
+				name = "<anon>"
+			}
+			val, err = Null(), fmt.Errorf("panic in host function %s: %v", name, r)
+		}
+	}()
+	return fn.Native(vm, args)
+}
+
+// unwind discards frames above depth and truncates the stack to base after a failed nested call.
+func (vm *VM) unwind(depth, base int) {
+	for len(vm.frames) > depth {
+		fr := vm.currentFrame()
+		vm.closeUpvalues(fr.locals)
+		profileExit(fr)
+		vm.frames = vm.frames[:len(vm.frames)-1]
+	}
+	if len(vm.stack) > base {
+		vm.stack = vm.stack[:base]
+	}
+}
+
 func (vm *VM) currentFrame() *frame {
 	return &vm.frames[len(vm.frames)-1]
 }
@@ -513,6 +934,8 @@ func (vm *VM) peek() Value {
 	return vm.stack[len(vm.stack)-1]
 }
 
+// readU16, readU24, and readU8 do not bounds-check: execute verifies with bytecode.OperandWidth that an
+// instruction's operands fit in the chunk before dispatching it.
 func (vm *VM) readU16(fr *frame) int {
 	hi := fr.fn.Proto.Chunk.Code[fr.ip]
 	lo := fr.fn.Proto.Chunk.Code[fr.ip+1]
@@ -520,6 +943,21 @@ func (vm *VM) readU16(fr *frame) int {
 	return int(hi)<<8 | int(lo)
 }
 
+func (vm *VM) readU24(fr *frame) int {
+	code := fr.fn.Proto.Chunk.Code
+	v := int(code[fr.ip])<<16 | int(code[fr.ip+1])<<8 | int(code[fr.ip+2])
+	fr.ip += 3
+	return v
+}
+
+// readConstIndex reads a constant pool operand: 24-bit for the _LONG opcode forms, 16-bit otherwise.
+func (vm *VM) readConstIndex(fr *frame, long bool) int {
+	if long {
+		return vm.readU24(fr)
+	}
+	return vm.readU16(fr)
+}
+
 func (vm *VM) readU8(fr *frame) byte {
 	b := fr.fn.Proto.Chunk.Code[fr.ip]
 	fr.ip++
@@ -585,7 +1023,7 @@ func constToValue(v interface{}) Value {
 
 func binaryOp(op byte, a, b Value) (Value, error) {
 	switch op {
-	case bytecode.OP_ADD, bytecode.OP_SUB, bytecode.OP_MUL, bytecode.OP_DIV:
+	case bytecode.OP_ADD, bytecode.OP_SUB, bytecode.OP_MUL, bytecode.OP_DIV, bytecode.OP_MOD:
 		if a.Kind != KindNumber || b.Kind != KindNumber {
 			return Null(), fmt.Errorf("operands must be numbers")
 		}
@@ -598,12 +1036,30 @@ func binaryOp(op byte, a, b Value) (Value, error) {
 			return Number(a.Num * b.Num), nil
 		case bytecode.OP_DIV:
 			return Number(a.Num / b.Num), nil
+		case bytecode.OP_MOD:
+			if b.Num == 0 {
+				return Null(), fmt.Errorf("modulo by zero")
+			}
+			return Number(math.Mod(a.Num, b.Num)), nil
 		}
 	case bytecode.OP_EQ:
 		return Bool(Equal(a, b)), nil
 	case bytecode.OP_NEQ:
 		return Bool(!Equal(a, b)), nil
 	case bytecode.OP_LT, bytecode.OP_LTE, bytecode.OP_GT, bytecode.OP_GTE:
+		if a.Kind == KindString && b.Kind == KindString {
+			// Strings order lexicographically by byte, as Go compares them.
+			switch op {
+			case bytecode.OP_LT:
+				return Bool(a.Str < b.Str), nil
+			case bytecode.OP_LTE:
+				return Bool(a.Str <= b.Str), nil
+			case bytecode.OP_GT:
+				return Bool(a.Str > b.Str), nil
+			case bytecode.OP_GTE:
+				return Bool(a.Str >= b.Str), nil
+			}
+		}
 		if a.Kind != KindNumber || b.Kind != KindNumber {
 			return Null(), fmt.Errorf("operands must be numbers")
 		}
@@ -621,6 +1077,30 @@ func binaryOp(op byte, a, b Value) (Value, error) {
 	return Null(), fmt.Errorf("unsupported op")
 }
 
+// compareValues orders two numbers or two strings, returning -1, 0, or 1.
+func compareValues(a, b Value) (int, error) {
+	switch {
+	case a.Kind == KindNumber && b.Kind == KindNumber:
+		switch {
+		case a.Num < b.Num:
+			return -1, nil
+		case a.Num > b.Num:
+			return 1, nil
+		}
+		return 0, nil
+	case a.Kind == KindString && b.Kind == KindString:
+		switch {
+		case a.Str < b.Str:
+			return -1, nil
+		case a.Str > b.Str:
+			return 1, nil
+		}
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("cannot compare %s with %s", typeName(a), typeName(b))
+	}
+}
+
 func (fn *Function) maxLocals() int {
 	if fn.Proto == nil {
 		return 0
@@ -685,7 +1165,7 @@ func indexSet(target Value, index Value, val Value) error {
 		if target.Obj == nil {
 			return fmt.Errorf("not indexable")
 		}
-		target.Obj[k] = val
+		target.SetKey(k, val)
 		return nil
 	default:
 		return fmt.Errorf("not indexable")
@@ -726,7 +1206,7 @@ func toIterator(v Value) (*Iterator, error) {
 	case KindArray:
 		return NewArrayIterator(v.Arr), nil
 	case KindObject:
-		return NewObjectIterator(v.Obj), nil
+		return NewObjectIterator(v), nil
 	case KindIterator:
 		if v.It == nil {
 			return nil, fmt.Errorf("iterator is nil")
@@ -809,6 +1289,50 @@ func expectIndex(index Value, length int) (int, error) {
 	return i, nil
 }
 
+// sliceValue returns target[low:high] for an array (as a new array) or a string (by byte offset).
+// A null bound selects the start or end.
+func sliceValue(target, low, high Value) (Value, error) {
+	var length int
+	switch target.Kind {
+	case KindArray:
+		length = len(target.Arr)
+	case KindString:
+		length = len(target.Str)
+	default:
+		return Null(), fmt.Errorf("cannot slice %s", typeName(target))
+	}
+	bound := func(v Value, def int) (int, error) {
+		if v.Kind == KindNull {
+			return def, nil
+		}
+		i, err := expectIndex(v, -1)
+		if err != nil {
+			return 0, fmt.Errorf("slice bound must be integer")
+		}
+		if i < 0 || i > length {
+			return 0, fmt.Errorf("slice bounds out of range [%d] with length %d", i, length)
+		}
+		return i, nil
+	}
+	lo, err := bound(low, 0)
+	if err != nil {
+		return Null(), err
+	}
+	hi, err := bound(high, length)
+	if err != nil {
+		return Null(), err
+	}
+	if lo > hi {
+		return Null(), fmt.Errorf("invalid slice indices %d > %d", lo, hi)
+	}
+	if target.Kind == KindString {
+		return String(target.Str[lo:hi]), nil
+	}
+	out := make([]Value, hi-lo)
+	copy(out, target.Arr[lo:hi])
+	return Array(out), nil
+}
+
 func expectKeyString(index Value) (string, error) {
 	switch index.Kind {
 	case KindString:
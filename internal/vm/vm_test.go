@@ -1,17 +1,22 @@
 package vm_test
 
 import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/xirelogy/go-flux/internal/ast"
 	_ "github.com/xirelogy/go-flux/internal/builtins"
+	"github.com/xirelogy/go-flux/internal/bytecode"
 	"github.com/xirelogy/go-flux/internal/compiler"
 	"github.com/xirelogy/go-flux/internal/lexer"
 	"github.com/xirelogy/go-flux/internal/parser"
 	"github.com/xirelogy/go-flux/internal/vm"
 )
 
-func compileModule(t *testing.T, src string) *compiler.Module {
+func compileModule(t testing.TB, src string) *compiler.Module {
 	t.Helper()
 	p := parser.New(lexer.New(src))
 	prog := p.ParseProgram()
@@ -102,6 +107,52 @@ func check($a, $b) {
 	}
 }
 
+func TestVMLogicalAndIfConditionStackBalance(t *testing.T) {
+	src := `
+func side($o) {
+  $o.calls = $o.calls + 1
+  return true
+}
+func check($a) {
+  $o := { calls: 0 }
+  $hits := 0
+  $i := 0
+  while ($i < 100) {
+    if ($a && side($o)) { $hits = $hits + 1 } else { $hits = $hits - 1 }
+    $i = $i + 1
+  }
+  return [$hits, $o.calls]
+}`
+	mod := compileModule(t, src)
+	cases := []struct {
+		a           bool
+		hits, calls float64
+	}{
+		{a: false, hits: -100, calls: 0},
+		{a: true, hits: 100, calls: 100},
+	}
+	for _, tc := range cases {
+		machine := vm.New()
+		machine.LoadModule(mod)
+		returnDepth := -1
+		machine.SetTraceHook(func(info vm.TraceInfo) {
+			if info.Op == bytecode.OP_RETURN && info.Function == "check" {
+				returnDepth = info.StackDepth
+			}
+		})
+		v, err := machine.Call("check", []vm.Value{vm.Bool(tc.a)})
+		if err != nil {
+			t.Fatalf("vm call error: %v", err)
+		}
+		if v.Kind != vm.KindArray || len(v.Arr) != 2 || v.Arr[0].Num != tc.hits || v.Arr[1].Num != tc.calls {
+			t.Fatalf("a=%v: expected [%v, %v], got %#v", tc.a, tc.hits, tc.calls, v)
+		}
+		if returnDepth != 1 {
+			t.Fatalf("a=%v: expected only the return value on the stack, got depth %d", tc.a, returnDepth)
+		}
+	}
+}
+
 func TestVMLogicalAndValue(t *testing.T) {
 	src := `
 func expr($a, $b) { return $a == 1 && $b == 2 }`
@@ -165,6 +216,27 @@ func copyObj() {
 	}
 }
 
+func TestVMObjectLoopInsertionOrder(t *testing.T) {
+	src := `
+func positions() {
+  $o := { z: 1, a: 2, m: 3 }
+  $o.b = 4
+  $out := {}
+  $i := 0
+  for ([$k, $_] in $o) {
+    $out[$k] = $i
+    $i = $i + 1
+  }
+  return $out
+}`
+	v := runFunction(t, src, "positions", nil)
+	for i, k := range []string{"z", "a", "m", "b"} {
+		if got := v.Obj[k]; got.Kind != vm.KindNumber || got.Num != float64(i) {
+			t.Fatalf("expected %s at position %d, got %#v", k, i, got)
+		}
+	}
+}
+
 func TestVMClosureUpvalue(t *testing.T) {
 	src := `
 func makeAdder($x) {
@@ -314,6 +386,182 @@ func TestVMBuiltinsIndividual(t *testing.T) {
 	}
 }
 
+func TestVMSortBuiltinNatural(t *testing.T) {
+	src := `func demo() { return sort([3, 1, 2], null) }`
+	v := runFunction(t, src, "demo", nil)
+	if v.Kind != vm.KindArray || len(v.Arr) != 3 {
+		t.Fatalf("expected array of 3, got %#v", v)
+	}
+	for i, want := range []float64{1, 2, 3} {
+		if v.Arr[i].Num != want {
+			t.Fatalf("index %d: expected %v, got %#v", i, want, v.Arr[i])
+		}
+	}
+}
+
+func TestVMSortBuiltinStable(t *testing.T) {
+	src := `
+func demo() {
+  $records := [
+    { key: 2, name: "a" },
+    { key: 1, name: "b" },
+    { key: 2, name: "c" },
+    { key: 1, name: "d" },
+    { key: 2, name: "e" },
+  ]
+  return sort($records, func($x, $y) { return $x.key < $y.key })
+}`
+	v := runFunction(t, src, "demo", nil)
+	if v.Kind != vm.KindArray || len(v.Arr) != 5 {
+		t.Fatalf("expected array of 5, got %#v", v)
+	}
+	want := []string{"b", "d", "a", "c", "e"}
+	for i, name := range want {
+		if got := v.Arr[i].Obj["name"].Str; got != name {
+			t.Fatalf("index %d: expected %s, got %s", i, name, got)
+		}
+	}
+}
+
+func TestVMSortBuiltinMixedKinds(t *testing.T) {
+	mod := compileModule(t, `func demo() { return sort([1, "a"], null) }`)
+	machine := vm.New()
+	machine.LoadModule(mod)
+	if _, err := machine.Call("demo", nil); err == nil {
+		t.Fatalf("expected error sorting mixed kinds")
+	}
+}
+
+func TestVMUnaryPlusRequiresNumber(t *testing.T) {
+	val := runFunction(t, `func demo($x) { return [+5, +$x, -+$x] }`, "demo", []vm.Value{vm.Number(3)})
+	want := []float64{5, 3, -3}
+	for i, w := range want {
+		if val.Arr[i].Num != w {
+			t.Fatalf("element %d: expected %v, got %#v", i, w, val.Arr[i])
+		}
+	}
+	mod := compileModule(t, `
+func plus($s) { return +$s }
+func minus($s) { return -$s }
+`)
+	machine := vm.New()
+	machine.LoadModule(mod)
+	for _, entry := range []string{"plus", "minus"} {
+		_, err := machine.Call(entry, []vm.Value{vm.String("x")})
+		if err == nil || !strings.Contains(err.Error(), "operand must be number") {
+			t.Fatalf("%s: expected operand error, got %v", entry, err)
+		}
+	}
+}
+
+func TestVMStringOrdering(t *testing.T) {
+	mod := compileModule(t, `
+func lt($a, $b) { return $a < $b }
+func lte($a, $b) { return $a <= $b }
+func gt($a, $b) { return $a > $b }
+func gte($a, $b) { return $a >= $b }
+`)
+	machine := vm.New()
+	machine.LoadModule(mod)
+	cases := []struct {
+		entry string
+		a, b  string
+		want  bool
+	}{
+		{"lt", "apple", "banana", true},
+		{"lt", "banana", "apple", false},
+		{"lt", "same", "same", false},
+		{"lt", "", "a", true},
+		{"lt", "Z", "a", true}, // byte order: uppercase sorts first
+		{"lte", "same", "same", true},
+		{"lte", "ab", "a", false},
+		{"lte", "a", "ab", true},
+		{"gt", "b", "a", true},
+		{"gt", "same", "same", false},
+		{"gt", "é", "z", true}, // multi-byte UTF-8 sorts after ASCII
+		{"gte", "same", "same", true},
+		{"gte", "a", "b", false},
+		{"gte", "b", "a", true},
+	}
+	for _, tc := range cases {
+		got, err := machine.Call(tc.entry, []vm.Value{vm.String(tc.a), vm.String(tc.b)})
+		if err != nil {
+			t.Fatalf("%s(%q, %q): %v", tc.entry, tc.a, tc.b, err)
+		}
+		if got.Kind != vm.KindBool || got.B != tc.want {
+			t.Fatalf("%s(%q, %q): expected %v, got %#v", tc.entry, tc.a, tc.b, tc.want, got)
+		}
+	}
+
+	for _, entry := range []string{"lt", "lte", "gt", "gte"} {
+		for _, args := range [][]vm.Value{
+			{vm.String("1"), vm.Number(2)},
+			{vm.Number(1), vm.String("2")},
+			{vm.String("a"), vm.Null()},
+		} {
+			_, err := machine.Call(entry, args)
+			if err == nil || !strings.Contains(err.Error(), "operands must be numbers") {
+				t.Fatalf("%s(%v, %v): expected operand error, got %v", entry, args[0], args[1], err)
+			}
+		}
+	}
+}
+
+func TestVMSliceExpression(t *testing.T) {
+	src := `
+func slices($a, $s) {
+  return [$a[1:3], $a[:2], $a[2:], $a[:], $a[4:], $s[1:4], $s[:0], $a[1:3][0]]
+}
+`
+	arr := vm.Array([]vm.Value{vm.Number(10), vm.Number(20), vm.Number(30), vm.Number(40)})
+	val := runFunction(t, src, "slices", []vm.Value{arr, vm.String("hello")})
+	nums := func(v vm.Value) []float64 {
+		out := []float64{}
+		for _, el := range v.Arr {
+			out = append(out, el.Num)
+		}
+		return out
+	}
+	for i, want := range [][]float64{{20, 30}, {10, 20}, {30, 40}, {10, 20, 30, 40}, {}} {
+		if got := nums(val.Arr[i]); !reflect.DeepEqual(got, want) {
+			t.Fatalf("slice %d: expected %v, got %v", i, want, got)
+		}
+	}
+	if val.Arr[5].Str != "ell" || val.Arr[6].Kind != vm.KindString || val.Arr[6].Str != "" {
+		t.Fatalf("unexpected string slices %#v %#v", val.Arr[5], val.Arr[6])
+	}
+	if val.Arr[7].Num != 20 {
+		t.Fatalf("expected indexing a slice to work, got %#v", val.Arr[7])
+	}
+
+	// Slices copy, so writing to one leaves the source alone.
+	copied := runFunction(t, `func f($a) { $b := $a[0:1]; $b[0] = 99; return $a[0] }`, "f", []vm.Value{arr})
+	if copied.Num != 10 {
+		t.Fatalf("expected the source array to be unchanged, got %#v", copied)
+	}
+
+	mod := compileModule(t, `
+func over($a) { return $a[1:5] }
+func inverted($a) { return $a[3:1] }
+func fraction($a) { return $a[0.5:] }
+func object($o) { return $o[0:1] }
+`)
+	machine := vm.New()
+	machine.LoadModule(mod)
+	for entry, msg := range map[string]string{
+		"over":     "slice bounds out of range [5] with length 4",
+		"inverted": "invalid slice indices 3 > 1",
+		"fraction": "slice bound must be integer",
+	} {
+		if _, err := machine.Call(entry, []vm.Value{arr}); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Fatalf("%s: expected %q, got %v", entry, msg, err)
+		}
+	}
+	if _, err := machine.Call("object", []vm.Value{vm.Object(map[string]vm.Value{})}); err == nil || !strings.Contains(err.Error(), "cannot slice object") {
+		t.Fatalf("expected object slice error, got %v", err)
+	}
+}
+
 func TestVMReadonlyBuiltinTrue(t *testing.T) {
 	src := `func demo($o) { return readonly($o) }`
 	obj := vm.Object(map[string]vm.Value{"a": vm.Number(1)})
@@ -342,6 +590,80 @@ func mutate($o, $a) {
 	}
 }
 
+func TestVMStatementStackBalance(t *testing.T) {
+	src := `
+func touch($o) {
+  $o.n = $o.n + 1
+  return $o
+}
+func run() {
+  $o := { n: 0 }
+  $arr := [0]
+  $i := 0
+  while ($i < 500) {
+    touch($o)
+    $o.n
+    $arr[0] = $i
+    $o["n"] = $o.n
+    typeof($i)
+    $i = $i + 1
+  }
+  return $o.n
+}`
+	mod := compileModule(t, src)
+	machine := vm.New()
+	machine.LoadModule(mod)
+	maxDepth := 0
+	machine.SetTraceHook(func(info vm.TraceInfo) {
+		if info.StackDepth > maxDepth {
+			maxDepth = info.StackDepth
+		}
+	})
+	val, err := machine.Call("run", nil)
+	if err != nil {
+		t.Fatalf("vm call error: %v", err)
+	}
+	if val.Kind != vm.KindNumber || val.Num != 500 {
+		t.Fatalf("expected 500, got %#v", val)
+	}
+	if maxDepth > 8 {
+		t.Fatalf("stack grew across iterations: max depth %d", maxDepth)
+	}
+}
+
+func TestVMDuplicateDeepNesting(t *testing.T) {
+	const depth = 50000
+	shared := vm.Value{Kind: vm.KindObject, Obj: map[string]vm.Value{"v": vm.Number(1)}}
+	head := vm.Null()
+	for i := 0; i < depth; i++ {
+		head = vm.Value{Kind: vm.KindObject, Obj: map[string]vm.Value{"next": head, "leaf": shared}}
+	}
+	machine := vm.New()
+	machine.DefineGlobal("list", head)
+	dup := machine.Duplicate()
+
+	cur := dup.SnapshotGlobals()["list"]
+	var firstLeaf map[string]vm.Value
+	n := 0
+	for cur.Kind == vm.KindObject {
+		leaf := cur.Obj["leaf"].Obj
+		if firstLeaf == nil {
+			firstLeaf = leaf
+		} else if reflect.ValueOf(leaf).Pointer() != reflect.ValueOf(firstLeaf).Pointer() {
+			t.Fatalf("shared leaf was copied more than once at depth %d", n)
+		}
+		cur = cur.Obj["next"]
+		n++
+	}
+	if n != depth {
+		t.Fatalf("expected depth %d, got %d", depth, n)
+	}
+	firstLeaf["v"] = vm.Number(2)
+	if shared.Obj["v"].Num != 1 {
+		t.Fatalf("duplicate shares storage with the original")
+	}
+}
+
 func TestVMHandlesNop(t *testing.T) {
 	src := `func demo() { return 42 }`
 	mod := compileModule(t, src)
@@ -363,6 +685,296 @@ func TestVMHandlesNop(t *testing.T) {
 	}
 }
 
+func TestVMDuplicateClonesBoundClosure(t *testing.T) {
+	mod := compileModule(t, `
+func counter() {
+  $n := 0
+  return func ($step) {
+    $n = $n + $step
+    return $n
+  }
+}
+func mk($c) { return bind($c, 1) }
+func tick() { return inc() }
+`)
+	machine := vm.New()
+	machine.LoadModule(mod)
+	c, err := machine.Call("counter", nil)
+	if err != nil {
+		t.Fatalf("counter: %v", err)
+	}
+	bound, err := machine.Call("mk", []vm.Value{c})
+	if err != nil {
+		t.Fatalf("mk: %v", err)
+	}
+	machine.DefineGlobal("inc", bound)
+	dup := machine.Duplicate()
+	for i, step := range []struct {
+		m    *vm.VM
+		want float64
+	}{{machine, 1}, {dup, 1}, {machine, 2}, {dup, 2}, {dup, 3}, {machine, 3}} {
+		got, err := step.m.Call("tick", nil)
+		if err != nil {
+			t.Fatalf("tick %d: %v", i, err)
+		}
+		if got.Num != step.want {
+			t.Fatalf("tick %d: expected %v, got %#v", i, step.want, got)
+		}
+	}
+}
+
+func TestVMDuplicateClonesSuspendedGenerator(t *testing.T) {
+	mod := compileModule(t, `
+func nums() {
+  $n := 0
+  $inc := func () { $n += 1 }
+  while (true) {
+    $inc()
+    yield $n
+  }
+}
+func step() {
+  for ($v in g) { return $v }
+}
+`)
+	machine := vm.New()
+	machine.LoadModule(mod)
+	g, err := machine.Call("nums", nil)
+	if err != nil {
+		t.Fatalf("nums: %v", err)
+	}
+	if g.Kind != vm.KindIterator {
+		t.Fatalf("expected generator iterator, got %#v", g)
+	}
+	machine.DefineGlobal("g", g)
+	if got, err := machine.Call("step", nil); err != nil || got.Num != 1 {
+		t.Fatalf("first step: %v (%v)", got, err)
+	}
+	// The copy resumes from the same point but advances its own locals, closures included.
+	dup := machine.Duplicate()
+	for i, step := range []struct {
+		m    *vm.VM
+		want float64
+	}{{machine, 2}, {dup, 2}, {dup, 3}, {dup, 4}, {machine, 3}} {
+		got, err := step.m.Call("step", nil)
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if got.Num != step.want {
+			t.Fatalf("step %d: expected %v, got %#v", i, step.want, got)
+		}
+	}
+
+	if _, _, ok := g.It.Next(); ok || g.It.Err() == nil {
+		t.Fatalf("expected Next without a VM to stop with an error")
+	}
+}
+
+func TestVMDupEvaluatesTargetOnce(t *testing.T) {
+	// Hand-assembled `side().count = side().count + 1` with the target evaluated once and duplicated,
+	// which is how compound assignment on member targets compiles.
+	code := []byte{
+		compiler.OP_GET_GLOBAL, 0, 0,
+		compiler.OP_CALL, 0,
+		compiler.OP_DUP,
+		compiler.OP_GET_PROP, 0, 1,
+		compiler.OP_CONST, 0, 2,
+		compiler.OP_ADD,
+		compiler.OP_SET_PROP, 0, 1,
+		compiler.OP_NULL,
+		compiler.OP_RETURN,
+	}
+	proto := &compiler.Prototype{
+		Name:  "bump",
+		Chunk: &compiler.Chunk{Code: code, Consts: []interface{}{"side", "count", float64(1)}},
+	}
+	obj := vm.Object(map[string]vm.Value{"count": vm.Number(41)})
+	calls := 0
+	machine := vm.New()
+	machine.LoadModule(&compiler.Module{Functions: map[string]*compiler.Prototype{"bump": proto}})
+	machine.DefineGlobal("side", vm.Value{Kind: vm.KindFunction, Func: &vm.Function{Native: func(*vm.VM, []vm.Value) (vm.Value, error) {
+		calls++
+		return obj, nil
+	}}})
+	if _, err := machine.Call("bump", nil); err != nil {
+		t.Fatalf("call error: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected target to be evaluated once, got %d calls", calls)
+	}
+	if got := obj.Obj["count"]; got.Kind != vm.KindNumber || got.Num != 42 {
+		t.Fatalf("expected count 42, got %#v", got)
+	}
+}
+
+func TestVMSwapIndexTargetOnce(t *testing.T) {
+	// Hand-assembled `$a[f()] += 1` where the index is computed before the target: SWAP restores
+	// target/index order and DUP2 feeds both the read and the write without re-running f().
+	code := []byte{
+		compiler.OP_GET_GLOBAL, 0, 0,
+		compiler.OP_CALL, 0,
+		compiler.OP_GET_GLOBAL, 0, 1,
+		compiler.OP_SWAP,
+		compiler.OP_DUP2,
+		compiler.OP_INDEX_GET,
+		compiler.OP_CONST, 0, 2,
+		compiler.OP_ADD,
+		compiler.OP_INDEX_SET,
+		compiler.OP_NULL,
+		compiler.OP_RETURN,
+	}
+	proto := &compiler.Prototype{
+		Name:  "bump",
+		Chunk: &compiler.Chunk{Code: code, Consts: []interface{}{"f", "arr", float64(1)}},
+	}
+	arr := vm.Array([]vm.Value{vm.Number(10), vm.Number(20)})
+	calls := 0
+	machine := vm.New()
+	machine.LoadModule(&compiler.Module{Functions: map[string]*compiler.Prototype{"bump": proto}})
+	machine.DefineGlobal("arr", arr)
+	machine.DefineGlobal("f", vm.Value{Kind: vm.KindFunction, Func: &vm.Function{Native: func(*vm.VM, []vm.Value) (vm.Value, error) {
+		calls++
+		return vm.Number(1), nil
+	}}})
+	if _, err := machine.Call("bump", nil); err != nil {
+		t.Fatalf("call error: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected index expression to run once, got %d calls", calls)
+	}
+	if got := arr.Arr[1]; got.Kind != vm.KindNumber || got.Num != 21 {
+		t.Fatalf("expected arr[1] == 21, got %#v", got)
+	}
+}
+
+func TestVMBuiltinPanicRecovered(t *testing.T) {
+	const opcode byte = 0x9F
+	vm.RegisterBuiltin("testPanic", opcode, 1, func(*vm.VM) (vm.Value, error) {
+		var arr []vm.Value
+		return arr[3], nil
+	})
+	defer vm.UnregisterBuiltin(opcode)
+	proto := &compiler.Prototype{
+		Name:  "bad",
+		Chunk: &compiler.Chunk{Code: []byte{compiler.OP_NULL, opcode, compiler.OP_NULL, compiler.OP_RETURN}},
+	}
+	machine := vm.New()
+	machine.LoadModule(&compiler.Module{Functions: map[string]*compiler.Prototype{"bad": proto}})
+	_, err := machine.Call("bad", nil)
+	var rte *vm.RuntimeError
+	if !errors.As(err, &rte) {
+		t.Fatalf("expected runtime error, got %T %v", err, err)
+	}
+	if !strings.Contains(rte.Message, "panic in builtin testPanic") || rte.Frame.Function != "bad" {
+		t.Fatalf("unexpected error %q in frame %+v", rte.Message, rte.Frame)
+	}
+
+	// The machine remains usable.
+	machine.LoadModule(compileModule(t, `func ok() { return 1 }`))
+	if v, err := machine.Call("ok", nil); err != nil || v.Num != 1 {
+		t.Fatalf("expected machine to remain usable, got %#v %v", v, err)
+	}
+}
+
+func TestVMTruncatedBytecode(t *testing.T) {
+	tests := map[string][]byte{
+		"const":   {compiler.OP_CONST, 0},
+		"call":    {compiler.OP_NULL, compiler.OP_CALL},
+		"closure": {compiler.OP_CLOSURE, 0, 0, 2, 1},
+	}
+	for name, code := range tests {
+		proto := &compiler.Prototype{Name: name, Chunk: &compiler.Chunk{Code: code, Consts: []interface{}{float64(1)}}}
+		machine := vm.New()
+		machine.LoadModule(&compiler.Module{Functions: map[string]*compiler.Prototype{name: proto}})
+		_, err := machine.Call(name, nil)
+		var rte *vm.RuntimeError
+		if !errors.As(err, &rte) || !strings.Contains(rte.Message, "unexpected end of bytecode") {
+			t.Fatalf("%s: expected truncated bytecode error, got %v", name, err)
+		}
+	}
+}
+
+func TestVMDupUnderflow(t *testing.T) {
+	proto := &compiler.Prototype{Name: "bad", Chunk: &compiler.Chunk{Code: []byte{compiler.OP_NULL, compiler.OP_DUP2}}}
+	machine := vm.New()
+	machine.LoadModule(&compiler.Module{Functions: map[string]*compiler.Prototype{"bad": proto}})
+	if _, err := machine.Call("bad", nil); err == nil || !strings.Contains(err.Error(), "stack underflow on dup2") {
+		t.Fatalf("expected dup2 underflow error, got %v", err)
+	}
+}
+
+func TestVMPopN(t *testing.T) {
+	// Hand-assembled: push 1 2 3 4, drop the top three at once, and build an array from what remains
+	// below a fresh value, so any miscounted pop shows up in the result.
+	code := []byte{
+		compiler.OP_CONST, 0, 0,
+		compiler.OP_CONST, 0, 1,
+		compiler.OP_CONST, 0, 2,
+		compiler.OP_CONST, 0, 3,
+		compiler.OP_POP_N, 3,
+		compiler.OP_CONST, 0, 3,
+		compiler.OP_ARRAY, 0, 2,
+		compiler.OP_RETURN,
+	}
+	proto := &compiler.Prototype{
+		Name:  "drop",
+		Chunk: &compiler.Chunk{Code: code, Consts: []interface{}{float64(1), float64(2), float64(3), float64(4)}},
+	}
+	machine := vm.New()
+	machine.LoadModule(&compiler.Module{Functions: map[string]*compiler.Prototype{"drop": proto}})
+	got, err := machine.Call("drop", nil)
+	if err != nil {
+		t.Fatalf("call error: %v", err)
+	}
+	if !reflect.DeepEqual(got, vm.Array([]vm.Value{vm.Number(1), vm.Number(4)})) {
+		t.Fatalf("expected [1, 4], got %#v", got)
+	}
+
+	// Popping more values than the frame pushed fails instead of eating the caller's operands.
+	bad := &compiler.Prototype{Name: "bad", Chunk: &compiler.Chunk{Code: []byte{compiler.OP_NULL, compiler.OP_POP_N, 2}}}
+	machine.LoadModule(&compiler.Module{Functions: map[string]*compiler.Prototype{"bad": bad}})
+	if _, err := machine.Call("bad", nil); err == nil || !strings.Contains(err.Error(), "stack underflow on pop") {
+		t.Fatalf("expected pop underflow error, got %v", err)
+	}
+}
+
+func TestVMForInDiscardsPopTogether(t *testing.T) {
+	src := `
+func count($o) {
+  $n := 0
+  for ([$_, $_] in $o) { $n = $n + 1 }
+  for ($_ in $o) { $n = $n + 1 }
+  return $n
+}`
+	mod := compileModule(t, src)
+	insts, err := bytecode.Decode(mod.Functions["count"].Chunk)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	popN := 0
+	for _, in := range insts {
+		if in.Op == bytecode.OP_POP_N {
+			if in.Operands[0] != 2 {
+				t.Fatalf("expected OP_POP_N 2, got %v", in.Operands)
+			}
+			popN++
+		}
+	}
+	// Both loops discard key and value, so each drops them with one instruction.
+	if popN != 2 {
+		t.Fatalf("expected 2 OP_POP_N instructions, got %d", popN)
+	}
+	machine := vm.New()
+	machine.LoadModule(mod)
+	got, err := machine.Call("count", []vm.Value{vm.Array([]vm.Value{vm.Number(1), vm.Number(2), vm.Number(3)})})
+	if err != nil {
+		t.Fatalf("call error: %v", err)
+	}
+	if got.Kind != vm.KindNumber || got.Num != 6 {
+		t.Fatalf("expected 6, got %#v", got)
+	}
+}
+
 func keys(m map[string]*compiler.Prototype) []string {
 	out := make([]string, 0, len(m))
 	for k := range m {
@@ -370,3 +982,87 @@ func keys(m map[string]*compiler.Prototype) []string {
 	}
 	return out
 }
+
+func TestVMConstLong(t *testing.T) {
+	const n = 70000 // more distinct constants than a 16-bit index can address
+	var b strings.Builder
+	b.WriteString("func many() {\n  $v := 0\n")
+	for i := 1; i <= n; i++ {
+		b.WriteString("  $v = " + strconv.Itoa(i) + "\n")
+	}
+	b.WriteString("  return [$v, \"last\"]\n}\n")
+	mod := compileModule(t, b.String())
+	fn := mod.Functions["many"]
+	if len(fn.Chunk.Consts) <= 0xFFFF {
+		t.Fatalf("expected more than 65535 constants, got %d", len(fn.Chunk.Consts))
+	}
+	long := 0
+	code := fn.Chunk.Code
+	for ip := 0; ip < len(code); ip += 1 + bytecode.OperandWidth(code[ip], code, ip+1) {
+		if code[ip] == bytecode.OP_CONST_LONG {
+			long++
+			if idx := int(code[ip+1])<<16 | int(code[ip+2])<<8 | int(code[ip+3]); idx <= 0xFFFF {
+				t.Fatalf("OP_CONST_LONG used for small index %d", idx)
+			}
+		}
+	}
+	if long != len(fn.Chunk.Consts)-0x10000 {
+		t.Fatalf("expected %d OP_CONST_LONG loads, got %d", len(fn.Chunk.Consts)-0x10000, long)
+	}
+	if err := bytecode.Verify(mod); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	got := runFunction(t, b.String(), "many", nil)
+	if len(got.Arr) != 2 || got.Arr[0].Num != n || got.Arr[1].Str != "last" {
+		t.Fatalf("unexpected result %+v", got)
+	}
+}
+
+func TestVMConstLongNames(t *testing.T) {
+	// Past 65535 literals, globals, properties and closures are addressed by the _LONG opcodes.
+	var b strings.Builder
+	b.WriteString("func helper($x) { return $x * 2 }\nfunc many($o) {\n  $v := 0\n")
+	for i := 1; i <= 0x10000; i++ {
+		b.WriteString("  $v = " + strconv.Itoa(i) + "\n")
+	}
+	b.WriteString("  $o.count += 1\n  $o.added = $v\n  $f := func () { return $o.count }\n  return helper($f())\n}\n")
+	obj := vm.Object(map[string]vm.Value{"count": vm.Number(20)})
+	got := runFunction(t, b.String(), "many", []vm.Value{obj})
+	if got.Kind != vm.KindNumber || got.Num != 42 {
+		t.Fatalf("expected 42, got %#v", got)
+	}
+	if added := obj.Obj["added"]; added.Num != 0x10000 {
+		t.Fatalf("expected added property %d, got %#v", 0x10000, added)
+	}
+}
+
+func TestVMArrayIteratorKeys(t *testing.T) {
+	it := vm.NewArrayIterator(make([]vm.Value, 1026))
+	for i := 0; i < 1026; i++ {
+		key, _, _ := it.Next()
+		if key != strconv.Itoa(i) {
+			t.Fatalf("expected key %d, got %q", i, key)
+		}
+	}
+}
+
+func BenchmarkVMCountingLoop(b *testing.B) {
+	mod := compileModule(b, `
+func count() {
+  $total := 0
+  for ($i in [0 .. 999]) {
+    $total = $total + $i
+  }
+  return $total
+}
+`)
+	machine := vm.New()
+	machine.LoadModule(mod)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := machine.Call("count", nil); err != nil {
+			b.Fatalf("call: %v", err)
+		}
+	}
+}